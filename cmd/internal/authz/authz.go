@@ -0,0 +1,119 @@
+// Package authz decides what staff users may do in the admin tool, based on their access level.
+package authz
+
+import "github.com/maslow123/bookings/cmd/internal/models"
+
+// Permission is an action in the admin tool that not every role may take
+type Permission string
+
+// Permissions checked by the admin handlers
+const (
+	ViewReservations   Permission = "view-reservations"
+	EditReservations   Permission = "edit-reservations"
+	DeleteReservations Permission = "delete-reservations"
+	ManageRooms        Permission = "manage-rooms"
+	ManagePromoCodes   Permission = "manage-promo-codes"
+	ManageUsers        Permission = "manage-users"
+	ViewAuditLog       Permission = "view-audit-log"
+	ManageEmails       Permission = "manage-emails"
+)
+
+// minimumLevel is the lowest access level having each permission
+var minimumLevel = map[Permission]int{
+	ViewReservations:   models.AccessReadOnly,
+	EditReservations:   models.AccessFrontDesk,
+	DeleteReservations: models.AccessManager,
+	ManageRooms:        models.AccessManager,
+	ManagePromoCodes:   models.AccessManager,
+	ManageUsers:        models.AccessOwner,
+	ViewAuditLog:       models.AccessOwner,
+	ManageEmails:       models.AccessOwner,
+}
+
+// permissions lists all permissions, in the order they are shown to users
+var permissions = []Permission{
+	ViewReservations,
+	EditReservations,
+	DeleteReservations,
+	ManageRooms,
+	ManagePromoCodes,
+	ManageUsers,
+	ViewAuditLog,
+	ManageEmails,
+}
+
+var roleNames = map[int]string{
+	models.AccessReadOnly:  "Read-only",
+	models.AccessFrontDesk: "Front desk",
+	models.AccessManager:   "Manager",
+	models.AccessOwner:     "Owner",
+}
+
+// Role is a named access level
+type Role struct {
+	AccessLevel int
+	Name        string
+}
+
+// Roles returns all roles, from the least to the most allowed
+func Roles() []Role {
+	return []Role{
+		{models.AccessReadOnly, roleNames[models.AccessReadOnly]},
+		{models.AccessFrontDesk, roleNames[models.AccessFrontDesk]},
+		{models.AccessManager, roleNames[models.AccessManager]},
+		{models.AccessOwner, roleNames[models.AccessOwner]},
+	}
+}
+
+// Can reports whether a user with accessLevel has permission p
+func Can(accessLevel int, p Permission) bool {
+	level, ok := minimumLevel[p]
+	if !ok {
+		return false
+	}
+
+	return IsRole(accessLevel) && accessLevel >= level
+}
+
+// Permissions returns the permissions a user with accessLevel has, which are the scopes they can give an API token
+func Permissions(accessLevel int) []Permission {
+	var granted []Permission
+	for _, p := range permissions {
+		if Can(accessLevel, p) {
+			granted = append(granted, p)
+		}
+	}
+
+	return granted
+}
+
+// Granted reports whether an API token with scopes, belonging to a user with accessLevel, has permission p.
+// The token never has more permissions than its user, even if the user lost a role after creating it.
+func Granted(accessLevel int, scopes []string, p Permission) bool {
+	if !Can(accessLevel, p) {
+		return false
+	}
+
+	for _, scope := range scopes {
+		if Permission(scope) == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsRole reports whether accessLevel is one of the known roles
+func IsRole(accessLevel int) bool {
+	_, ok := roleNames[accessLevel]
+	return ok
+}
+
+// RoleName returns the name of the role with accessLevel
+func RoleName(accessLevel int) string {
+	if name, ok := roleNames[accessLevel]; ok {
+		return name
+	}
+
+	return "Unknown"
+}
@@ -0,0 +1,81 @@
+package authz
+
+import (
+	"testing"
+
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+func TestCan(t *testing.T) {
+	var tests = []struct {
+		name        string
+		accessLevel int
+		permission  Permission
+		expected    bool
+	}{
+		{"read-only-views", models.AccessReadOnly, ViewReservations, true},
+		{"read-only-edits", models.AccessReadOnly, EditReservations, false},
+		{"front-desk-edits", models.AccessFrontDesk, EditReservations, true},
+		{"front-desk-deletes", models.AccessFrontDesk, DeleteReservations, false},
+		{"manager-deletes", models.AccessManager, DeleteReservations, true},
+		{"manager-rooms", models.AccessManager, ManageRooms, true},
+		{"manager-users", models.AccessManager, ManageUsers, false},
+		{"owner-users", models.AccessOwner, ManageUsers, true},
+		{"manager-emails", models.AccessManager, ManageEmails, false},
+		{"owner-emails", models.AccessOwner, ManageEmails, true},
+		{"no-role", 0, ViewReservations, false},
+		{"unknown-role", 99, ManageUsers, false},
+		{"unknown-permission", models.AccessOwner, Permission("fly"), false},
+	}
+
+	for _, e := range tests {
+		if result := Can(e.accessLevel, e.permission); result != e.expected {
+			t.Errorf("%s: expected %t but got %t", e.name, e.expected, result)
+		}
+	}
+}
+
+func TestRoleName(t *testing.T) {
+	if name := RoleName(models.AccessFrontDesk); name != "Front desk" {
+		t.Errorf("expected Front desk but got %s", name)
+	}
+
+	if name := RoleName(0); name != "Unknown" {
+		t.Errorf("expected Unknown but got %s", name)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	if granted := Permissions(models.AccessReadOnly); len(granted) != 1 || granted[0] != ViewReservations {
+		t.Errorf("expected read-only to only view reservations, but got %v", granted)
+	}
+
+	if granted := Permissions(models.AccessOwner); len(granted) != len(permissions) {
+		t.Errorf("expected owner to have all %d permissions, but got %v", len(permissions), granted)
+	}
+
+	if granted := Permissions(0); len(granted) != 0 {
+		t.Errorf("expected no permissions without a role, but got %v", granted)
+	}
+}
+
+func TestGranted(t *testing.T) {
+	var tests = []struct {
+		name        string
+		accessLevel int
+		scopes      []string
+		permission  Permission
+		expected    bool
+	}{
+		{"in-scope", models.AccessManager, []string{"view-reservations", "delete-reservations"}, DeleteReservations, true},
+		{"out-of-scope", models.AccessManager, []string{"view-reservations"}, DeleteReservations, false},
+		{"no-scopes", models.AccessOwner, nil, ViewReservations, false},
+		{"user-lost-role", models.AccessFrontDesk, []string{"delete-reservations"}, DeleteReservations, false},
+	}
+
+	for _, e := range tests {
+		if result := Granted(e.accessLevel, e.scopes, e.permission); result != e.expected {
+			t.Errorf("%s: expected %t but got %t", e.name, e.expected, result)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Package calsync imports the calendars of rooms on other booking platforms as external restrictions,
+// so that nights booked elsewhere can't be booked here too.
+package calsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/ical"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// MaxCalendarSize is the largest calendar that is imported, in bytes
+const MaxCalendarSize = 5 << 20
+
+// ErrFilesNotAllowed is returned for file:// urls when config.AppConfig.CalendarFiles is off
+var ErrFilesNotAllowed = errors.New("calendars can't be read from files")
+
+// Syncer imports external calendars into the database
+type Syncer struct {
+	App    *config.AppConfig
+	DB     repository.DatabaseRepo
+	Client *http.Client
+}
+
+// Result counts the changes made by a sync
+type Result struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Conflicts []string // events that overlap another booking of the room, and could not be imported
+}
+
+// New creates a Syncer
+func New(db repository.DatabaseRepo, a *config.AppConfig) *Syncer {
+	return &Syncer{
+		App:    a,
+		DB:     db,
+		Client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// CheckURL reports whether calendars can be fetched from rawURL: http and https urls,
+// and file urls when allowFiles is set
+func CheckURL(rawURL string, allowFiles bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return errors.New("the url has no host")
+		}
+		return nil
+	case "file":
+		if !allowFiles {
+			return ErrFilesNotAllowed
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported url scheme %q", u.Scheme)
+}
+
+// Run syncs every calendar source with a url each interval, until stop is closed
+func (s *Syncer) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+
+	for {
+		s.SyncAll(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// SyncAll syncs every calendar source with a url. Sources that are only uploaded are left alone.
+func (s *Syncer) SyncAll(ctx context.Context) {
+	sources, err := s.DB.AllCalendarSources(ctx)
+	if err != nil {
+		s.App.ErrorLog.Println(err)
+		return
+	}
+
+	for _, source := range sources {
+		if source.URL == "" {
+			continue
+		}
+
+		result, err := s.Sync(ctx, source)
+		if err != nil {
+			s.App.ErrorLog.Printf("calendar source %d: %s", source.ID, err)
+			continue
+		}
+
+		if result.Added+result.Updated+result.Removed > 0 || len(result.Conflicts) > 0 {
+			s.App.InfoLog.Printf("calendar source %d: %d added, %d updated, %d removed, %d conflicts",
+				source.ID, result.Added, result.Updated, result.Removed, len(result.Conflicts))
+		}
+	}
+}
+
+// Sync fetches the calendar of source and imports it
+func (s *Syncer) Sync(ctx context.Context, source models.CalendarSource) (Result, error) {
+	data, err := s.fetch(ctx, source.URL)
+	if err != nil {
+		s.recordStatus(ctx, source, err.Error())
+		return Result{}, err
+	}
+
+	return s.SyncData(ctx, source, bytes.NewReader(data))
+}
+
+// SyncData imports the calendar read from r into source, adding, moving and removing its
+// restrictions to match the events of the calendar. Events that overlap another booking of the
+// room are reported as conflicts rather than failing the sync.
+func (s *Syncer) SyncData(ctx context.Context, source models.CalendarSource, r io.Reader) (Result, error) {
+	var result Result
+
+	cal, err := ical.Parse(r)
+	if err != nil {
+		s.recordStatus(ctx, source, err.Error())
+		return result, err
+	}
+
+	existing, err := s.DB.GetRestrictionsForSource(ctx, source.ID)
+	if err != nil {
+		return result, err
+	}
+
+	now := time.Now()
+	add, update, remove := Reconcile(source, existing, cal.Events, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+
+	// removals go first, so that events moved onto the nights they freed fit
+	for _, id := range remove {
+		err := s.DB.DeleteExternalRestriction(ctx, id)
+		if err != nil {
+			s.recordStatus(ctx, source, err.Error())
+			return result, err
+		}
+		result.Removed++
+	}
+
+	for _, rr := range update {
+		err := s.DB.UpdateExternalRestriction(ctx, rr)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			result.Conflicts = append(result.Conflicts, conflict(rr))
+			continue
+		}
+		if err != nil {
+			s.recordStatus(ctx, source, err.Error())
+			return result, err
+		}
+		result.Updated++
+	}
+
+	for _, rr := range add {
+		err := s.DB.InsertExternalRestriction(ctx, rr)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			result.Conflicts = append(result.Conflicts, conflict(rr))
+			continue
+		}
+		if err != nil {
+			s.recordStatus(ctx, source, err.Error())
+			return result, err
+		}
+		result.Added++
+	}
+
+	status := ""
+	if len(result.Conflicts) > 0 {
+		status = fmt.Sprintf("%d events overlap other bookings of the room: %s", len(result.Conflicts), strings.Join(result.Conflicts, ", "))
+	}
+	s.recordStatus(ctx, source, status)
+
+	return result, nil
+}
+
+// Reconcile compares the restrictions imported from source with the events of its calendar, matching them
+// by uid. It returns the restrictions to add and to move, and the ids of the restrictions to remove.
+// Cancelled events are left out, and so are the events and restrictions that ended before since,
+// so that the past is not rewritten when a platform stops listing old bookings.
+func Reconcile(source models.CalendarSource, existing []models.RoomRestriction, events []ical.Event, since time.Time) (add, update []models.RoomRestriction, remove []int) {
+	wanted := make(map[string]ical.Event)
+	var order []string
+	for _, e := range events {
+		if e.Status == "CANCELLED" || !e.End.After(since) {
+			continue
+		}
+
+		// recurring events repeat their uid, and are told apart by their start
+		uid := e.UID
+		if _, ok := wanted[uid]; ok {
+			uid = fmt.Sprintf("%s/%s", e.UID, e.Start.Format("2006-01-02"))
+		}
+		if _, ok := wanted[uid]; ok {
+			continue
+		}
+
+		wanted[uid] = e
+		order = append(order, uid)
+	}
+
+	seen := make(map[string]bool)
+	for _, rr := range existing {
+		if !rr.EndDate.After(since) {
+			continue
+		}
+
+		e, ok := wanted[rr.ExternalUID]
+		if !ok || seen[rr.ExternalUID] {
+			remove = append(remove, rr.ID)
+			continue
+		}
+		seen[rr.ExternalUID] = true
+
+		if !sameDay(rr.StartDate, e.Start) || !sameDay(rr.EndDate, e.End) {
+			rr.StartDate = e.Start
+			rr.EndDate = e.End
+			update = append(update, rr)
+		}
+	}
+
+	for _, uid := range order {
+		if seen[uid] {
+			continue
+		}
+
+		e := wanted[uid]
+		add = append(add, models.RoomRestriction{
+			RoomID:        source.RoomID,
+			RestrictionID: models.RestrictionExternal,
+			SourceID:      source.ID,
+			ExternalUID:   uid,
+			StartDate:     e.Start,
+			EndDate:       e.End,
+		})
+	}
+
+	return add, update, remove
+}
+
+// fetch reads the calendar at rawURL
+func (s *Syncer) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	err := CheckURL(rawURL, s.App.CalendarFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	u, _ := url.Parse(rawURL)
+
+	var body io.ReadCloser
+	if u.Scheme == "file" {
+		// file://testdata/room.ics is relative to the working directory, file:///srv/room.ics is absolute
+		path := u.Opaque
+		if path == "" {
+			path = u.Host + u.Path
+		}
+		body, err = os.Open(filepath.FromSlash(path))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("fetching the calendar failed with status %s", resp.Status)
+		}
+		body = resp.Body
+	}
+	defer body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, MaxCalendarSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxCalendarSize {
+		return nil, fmt.Errorf("the calendar is larger than %d bytes", MaxCalendarSize)
+	}
+
+	return data, nil
+}
+
+// recordStatus saves the time of the sync of source and its error, logging when that fails
+func (s *Syncer) recordStatus(ctx context.Context, source models.CalendarSource, lastError string) {
+	err := s.DB.UpdateCalendarSourceStatus(ctx, source.ID, time.Now(), lastError)
+	if err != nil {
+		s.App.ErrorLog.Println(err)
+	}
+}
+
+// conflict describes an event that could not be imported
+func conflict(rr models.RoomRestriction) string {
+	return fmt.Sprintf("%s (%s to %s)", rr.ExternalUID, rr.StartDate.Format("2006-01-02"), rr.EndDate.Format("2006-01-02"))
+}
+
+// sameDay reports whether a and b fall on the same date
+func sameDay(a, b time.Time) bool {
+	return a.Format("2006-01-02") == b.Format("2006-01-02")
+}
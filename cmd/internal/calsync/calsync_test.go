@@ -0,0 +1,139 @@
+package calsync
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/ical"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository/dbrepo"
+)
+
+func day(month time.Month, d int) time.Time {
+	return time.Date(2100, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func newTestSyncer(allowFiles bool) *Syncer {
+	app := &config.AppConfig{
+		InfoLog:       log.New(ioutil.Discard, "", 0),
+		ErrorLog:      log.New(ioutil.Discard, "", 0),
+		CalendarFiles: allowFiles,
+	}
+
+	return New(dbrepo.NewTestingsRepo(app), app)
+}
+
+func TestReconcile(t *testing.T) {
+	source := models.CalendarSource{ID: 1, RoomID: 3}
+	since := day(1, 1)
+
+	existing := []models.RoomRestriction{
+		{ID: 1, ExternalUID: "same", StartDate: day(6, 1), EndDate: day(6, 3)},
+		{ID: 2, ExternalUID: "moved", StartDate: day(6, 5), EndDate: day(6, 7)},
+		{ID: 3, ExternalUID: "gone", StartDate: day(6, 10), EndDate: day(6, 12)},
+		{ID: 4, ExternalUID: "past", StartDate: time.Date(2000, 6, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2000, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: 5, ExternalUID: "cancelled", StartDate: day(7, 1), EndDate: day(7, 2)},
+	}
+
+	events := []ical.Event{
+		{UID: "same", Start: day(6, 1), End: day(6, 3)},
+		{UID: "moved", Start: day(6, 6), End: day(6, 8)},
+		{UID: "cancelled", Start: day(7, 1), End: day(7, 2), Status: "CANCELLED"},
+		{UID: "new", Start: day(8, 1), End: day(8, 2)},
+		{UID: "weekly", Start: day(9, 1), End: day(9, 2)},
+		{UID: "weekly", Start: day(9, 8), End: day(9, 9)},
+	}
+
+	add, update, remove := Reconcile(source, existing, events, since)
+
+	if len(update) != 1 || update[0].ID != 2 || !update[0].StartDate.Equal(day(6, 6)) || !update[0].EndDate.Equal(day(6, 8)) {
+		t.Errorf("expected restriction 2 to move to 6 June, but got %+v", update)
+	}
+
+	if len(remove) != 2 || remove[0] != 3 || remove[1] != 5 {
+		t.Errorf("expected restrictions 3 and 5 to be removed, but got %v", remove)
+	}
+
+	var uids []string
+	for _, rr := range add {
+		uids = append(uids, rr.ExternalUID)
+		if rr.RoomID != 3 || rr.SourceID != 1 || rr.RestrictionID != models.RestrictionExternal {
+			t.Errorf("expected an external restriction of room 3 and source 1, but got %+v", rr)
+		}
+	}
+	if strings.Join(uids, " ") != "new weekly weekly/2100-09-08" {
+		t.Errorf("expected new, weekly and weekly/2100-09-08 to be added, but got %v", uids)
+	}
+}
+
+func TestSyncer_SyncFile(t *testing.T) {
+	source := models.CalendarSource{ID: 1, RoomID: 1, URL: "file://testdata/room.ics"}
+
+	result, err := newTestSyncer(true).Sync(context.Background(), source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Added != 1 || result.Updated != 1 || result.Removed != 1 || len(result.Conflicts) != 0 {
+		t.Errorf("expected 1 added, 1 updated and 1 removed, but got %+v", result)
+	}
+}
+
+func TestSyncer_SyncHTTP(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/room.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "testdata/room.ics")
+	}))
+	defer ts.Close()
+
+	s := newTestSyncer(false)
+
+	// the restrictions of source 2 are for room 1001, which is always taken
+	result, err := s.Sync(context.Background(), models.CalendarSource{ID: 2, RoomID: 1001, URL: ts.URL + "/room.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 0 || len(result.Conflicts) != 2 {
+		t.Errorf("expected 2 conflicts, but got %+v", result)
+	}
+
+	_, err = s.Sync(context.Background(), models.CalendarSource{ID: 2, RoomID: 1001, URL: ts.URL + "/missing.ics"})
+	if err == nil {
+		t.Error("expected an error for a missing calendar")
+	}
+}
+
+func TestSyncer_SyncRefused(t *testing.T) {
+	var tests = []struct {
+		name string
+		url  string
+	}{
+		{"file-not-allowed", "file://testdata/room.ics"},
+		{"unsupported-scheme", "ftp://example.com/room.ics"},
+		{"no-host", "https:///room.ics"},
+	}
+
+	for _, e := range tests {
+		_, err := newTestSyncer(false).Sync(context.Background(), models.CalendarSource{ID: 1, RoomID: 1, URL: e.url})
+		if err == nil {
+			t.Errorf("failed %s: expected an error", e.name)
+		}
+	}
+}
+
+func TestSyncer_SyncDataInvalid(t *testing.T) {
+	_, err := newTestSyncer(false).SyncData(context.Background(), models.CalendarSource{ID: 1, RoomID: 1}, strings.NewReader("<html></html>"))
+	if err == nil {
+		t.Error("expected an error for data that is not a calendar")
+	}
+}
@@ -3,10 +3,12 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	scs "github.com/alexedwards/scs/v2"
+	"github.com/maslow123/bookings/cmd/internal/emails"
 	"github.com/maslow123/bookings/cmd/internal/forms"
-	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/mailer"
 )
 
 // AppConfig holds the application config
@@ -17,9 +19,36 @@ type AppConfig struct {
 	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	Mailer        mailer.Mailer
+	Emails        *emails.Renderer
+	BaseURL       string   // public address of the site, used for links in emails
+	LinkSecret    []byte   // key signing the links sent to guests
+	WidgetOrigins []string // origins of the sites booking through the reservation widget, such as https://hotel.example.com
+
+	DBTimeout time.Duration // how long a query may take
+
+	CalendarSyncInterval time.Duration // how often external calendars are imported, 0 to never
+	CalendarFiles        bool          // lets calendar sources read local file:// urls
+
+	MailWorkers     int // how many emails of the outbox are sent at once
+	MailMaxAttempts int // how many times an email is tried before it is given up on
+
+	StaffEmails []string // who is told about new and cancelled reservations
+	StaffNotify string   // how staff hear of new reservations: NotifyEach, NotifyDigest or NotifyOff
+	DigestHour  int      // hour of the day at which the digest of new reservations is sent
+
+	PreArrivalDays int    // days before arrival guests are reminded of their reservation, negative to not remind them
+	PostStayDays   int    // days after departure guests are thanked for their stay, negative to not thank them
+	ReviewURL      string // where guests are asked to review their stay, they are asked to reply when empty
 }
 
+// How staff hear of new reservations, see AppConfig.StaffNotify
+const (
+	NotifyEach   = "each"   // an email for each reservation, as it is made
+	NotifyDigest = "digest" // a daily email listing the reservations that are not processed yet
+	NotifyOff    = "off"
+)
+
 // TemplateData holds data sent from handlers
 type TemplateData struct {
 	StringMap       map[string]string
@@ -32,4 +61,5 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	AccessLevel     int
 }
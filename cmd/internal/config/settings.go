@@ -0,0 +1,507 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Settings are what the site starts with. Load merges them from defaults, a YAML or TOML file,
+// environment variables and flags, each overriding the ones before.
+type Settings struct {
+	HTTP      HTTPSettings     `yaml:"http" toml:"http"`
+	Database  DatabaseSettings `yaml:"database" toml:"database"`
+	Session   SessionSettings  `yaml:"session" toml:"session"`
+	Mail      MailSettings     `yaml:"mail" toml:"mail"`
+	Templates TemplateSettings `yaml:"templates" toml:"templates"`
+	Calendar  CalendarSettings `yaml:"calendar" toml:"calendar"`
+}
+
+// HTTPSettings are about serving the site
+type HTTPSettings struct {
+	Addr          string   `yaml:"addr" toml:"addr"`                     // address the site listens on, such as :8080
+	Production    bool     `yaml:"production" toml:"production"`         // secure cookies and no debug output
+	BaseURL       string   `yaml:"base_url" toml:"base_url"`             // public address of the site, used for links in emails
+	LinkSecret    string   `yaml:"link_secret" toml:"link_secret"`       // key signing the links sent to guests, random when empty outside production
+	WidgetOrigins []string `yaml:"widget_origins" toml:"widget_origins"` // sites allowed to book through the reservation widget
+}
+
+// DatabaseSettings are about where the site keeps its data
+type DatabaseSettings struct {
+	Driver   string        `yaml:"driver" toml:"driver"` // postgres, sqlite or memory
+	File     string        `yaml:"file" toml:"file"`     // of sqlite
+	Host     string        `yaml:"host" toml:"host"`
+	Port     string        `yaml:"port" toml:"port"`
+	Name     string        `yaml:"name" toml:"name"`
+	User     string        `yaml:"user" toml:"user"`
+	Password string        `yaml:"password" toml:"password"`
+	SSL      string        `yaml:"ssl" toml:"ssl"`         // sslmode of postgres
+	Timeout  time.Duration `yaml:"timeout" toml:"timeout"` // how long a query may take
+	Migrate  bool          `yaml:"migrate" toml:"migrate"` // apply the missing migrations on start
+}
+
+// SessionSettings are about the sessions of visitors
+type SessionSettings struct {
+	Lifetime   time.Duration `yaml:"lifetime" toml:"lifetime"`
+	CookieName string        `yaml:"cookie_name" toml:"cookie_name"`
+}
+
+// MailSettings are about the email sent to guests and staff
+type MailSettings struct {
+	Transport      string   `yaml:"transport" toml:"transport"` // smtp, or maildir to keep email in Dir
+	SMTPHost       string   `yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort       int      `yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUser       string   `yaml:"smtp_user" toml:"smtp_user"` // no authentication when empty
+	SMTPPassword   string   `yaml:"smtp_password" toml:"smtp_password"`
+	SMTPEncryption string   `yaml:"smtp_encryption" toml:"smtp_encryption"` // none, ssl or starttls
+	Dir            string   `yaml:"dir" toml:"dir"`
+	Workers        int      `yaml:"workers" toml:"workers"`
+	Attempts       int      `yaml:"attempts" toml:"attempts"`
+	StaffEmails    []string `yaml:"staff_emails" toml:"staff_emails"`
+	StaffNotify    string   `yaml:"staff_notify" toml:"staff_notify"` // NotifyEach, NotifyDigest or NotifyOff
+	DigestHour     int      `yaml:"digest_hour" toml:"digest_hour"`
+	PreArrivalDays int      `yaml:"pre_arrival_days" toml:"pre_arrival_days"` // negative to not remind guests
+	PostStayDays   int      `yaml:"post_stay_days" toml:"post_stay_days"`     // negative to not thank guests
+	ReviewURL      string   `yaml:"review_url" toml:"review_url"`
+}
+
+// TemplateSettings are about the templates of pages and emails
+type TemplateSettings struct {
+	Cache    bool   `yaml:"cache" toml:"cache"` // parse the page templates once, instead of on every request
+	Dir      string `yaml:"dir" toml:"dir"`
+	EmailDir string `yaml:"email_dir" toml:"email_dir"`
+}
+
+// CalendarSettings are about importing external calendars
+type CalendarSettings struct {
+	SyncInterval time.Duration `yaml:"sync_interval" toml:"sync_interval"` // 0 to never import them
+	Files        bool          `yaml:"files" toml:"files"`                 // let them be read from file:// urls
+}
+
+// InvalidError lists every setting that is not valid
+type InvalidError []string
+
+func (e InvalidError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+// option is a setting that can be given in the file, the environment and the flags
+type option struct {
+	key  string // in the file, such as database.port
+	flag string
+	env  string
+	kind string // what the value must be, for errors
+
+	aliasOf string // environment variable of the option this one is another name of, which wins over it
+}
+
+// loader registers the flags of settings, remembering their keys and environment variables
+type loader struct {
+	fs      *flag.FlagSet
+	options []option
+}
+
+// Load returns defaults merged with the file given by -config or CONFIG_FILE, the environment
+// variables of lookupEnv and the flags in args, defining the flags in fs. It returns an
+// InvalidError listing every invalid setting.
+func Load(defaults Settings, fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Settings, error) {
+	s := defaults
+
+	file := configFile(args, lookupEnv)
+	if file != "" {
+		err := s.loadFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l := &loader{fs: fs}
+	fs.String("config", file, "YAML or TOML file of settings, overridden by environment variables and flags (CONFIG_FILE)")
+	l.define(&s)
+
+	// a setting whose environment variable can't be parsed is only reported for that
+	problems := map[string][]string{}
+	badEnv := map[string]bool{}
+
+	for _, o := range l.options {
+		value, ok := lookupEnv(o.env)
+		if !ok {
+			continue
+		}
+
+		if _, set := lookupEnv(o.aliasOf); o.aliasOf != "" && set {
+			continue
+		}
+
+		err := fs.Set(o.flag, value)
+		if err != nil {
+			problems[o.key] = []string{fmt.Sprintf("%s=%q is not %s", o.env, value, o.kind)}
+			badEnv[o.key] = true
+		}
+	}
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range s.validate() {
+		if !badEnv[p.key] {
+			problems[p.key] = append(problems[p.key], p.message)
+		}
+	}
+
+	var invalid InvalidError
+	for _, o := range l.options {
+		if o.aliasOf != "" {
+			continue
+		}
+
+		for _, message := range problems[o.key] {
+			invalid = append(invalid, fmt.Sprintf("%s (-%s, %s): %s", o.key, o.flag, o.env, message))
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, invalid
+	}
+
+	return &s, nil
+}
+
+// configFile returns the file of the -config flag in args, or of CONFIG_FILE
+func configFile(args []string, lookupEnv func(string) (string, bool)) string {
+	file, _ := lookupEnv("CONFIG_FILE")
+
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+
+		if name == "config" && i+1 < len(args) {
+			file = args[i+1]
+		} else if strings.HasPrefix(name, "config=") {
+			file = strings.TrimPrefix(name, "config=")
+		}
+	}
+
+	return file
+}
+
+// loadFile merges the YAML or TOML file into s, refusing settings it doesn't know
+func (s *Settings) loadFile(file string) error {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		decoder := yaml.NewDecoder(f)
+		decoder.KnownFields(true)
+
+		err = decoder.Decode(s)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("config file %s: %w", file, err)
+		}
+	case ".toml":
+		meta, err := toml.DecodeFile(file, s)
+		if err != nil {
+			return fmt.Errorf("config file %s: %w", file, err)
+		}
+
+		if unknown := meta.Undecoded(); len(unknown) > 0 {
+			return fmt.Errorf("config file %s: unknown setting %s", file, unknown[0])
+		}
+	default:
+		return fmt.Errorf("config file %s: expected a .yaml, .yml or .toml file", file)
+	}
+
+	return nil
+}
+
+// define registers the flags of every setting of s, defaulting to its current value
+func (l *loader) define(s *Settings) {
+	l.string(&s.HTTP.Addr, "http.addr", "addr", "HTTP_ADDR", "Address the site listens on")
+	l.bool(&s.HTTP.Production, "http.production", "production", "PRODUCTION", "Application is in production")
+	l.string(&s.HTTP.BaseURL, "http.base_url", "baseurl", "BASE_URL", "Public address of the site, used for links in emails")
+	l.string(&s.HTTP.LinkSecret, "http.link_secret", "linksecret", "LINK_SECRET", "Secret key signing the links sent to guests")
+	l.list(&s.HTTP.WidgetOrigins, "http.widget_origins", "widgetorigins", "WIDGET_ORIGINS", "Comma separated origins of the sites allowed to book through the reservation widget, such as https://hotel.example.com")
+
+	l.string(&s.Database.Driver, "database.driver", "dbdriver", "DB_DRIVER", "Database: postgres, sqlite to keep it in -dbfile, or memory to try the site without one, losing everything on exit")
+	l.alias("dbdriver", "db", "DB")
+	l.string(&s.Database.File, "database.file", "dbfile", "DB_FILE", "SQLite database file")
+	l.string(&s.Database.Host, "database.host", "dbhost", "DB_HOST", "Database host")
+	l.string(&s.Database.Port, "database.port", "dbport", "DB_PORT", "Database port")
+	l.string(&s.Database.Name, "database.name", "dbname", "DB_NAME", "Database name")
+	l.string(&s.Database.User, "database.user", "dbuser", "DB_USER", "Database user")
+	l.string(&s.Database.Password, "database.password", "dbpassword", "DB_PASSWORD", "Database password")
+	l.string(&s.Database.SSL, "database.ssl", "dbssl", "DB_SSL", "Database ssl settings (disable, prefer, require)")
+	l.duration(&s.Database.Timeout, "database.timeout", "dbtimeout", "DB_TIMEOUT", "How long a database query may take")
+	l.bool(&s.Database.Migrate, "database.migrate", "migrate", "AUTO_MIGRATE", "Apply the migrations the database is missing on start")
+
+	l.duration(&s.Session.Lifetime, "session.lifetime", "sessionlifetime", "SESSION_LIFETIME", "How long a session lasts")
+	l.string(&s.Session.CookieName, "session.cookie_name", "sessioncookie", "SESSION_COOKIE", "Name of the session cookie")
+
+	l.string(&s.Mail.Transport, "mail.transport", "mailer", "MAILER", "How email is sent: smtp, or maildir to keep it in -maildir")
+	l.string(&s.Mail.SMTPHost, "mail.smtp_host", "smtphost", "SMTP_HOST", "SMTP server host")
+	l.int(&s.Mail.SMTPPort, "mail.smtp_port", "smtpport", "SMTP_PORT", "SMTP server port")
+	l.string(&s.Mail.SMTPUser, "mail.smtp_user", "smtpuser", "SMTP_USER", "SMTP user, no authentication when empty")
+	l.string(&s.Mail.SMTPPassword, "mail.smtp_password", "smtppassword", "SMTP_PASSWORD", "SMTP password")
+	l.string(&s.Mail.SMTPEncryption, "mail.smtp_encryption", "smtpencryption", "SMTP_ENCRYPTION", "SMTP encryption: none, ssl or starttls")
+	l.string(&s.Mail.Dir, "mail.dir", "maildir", "MAILDIR", "Maildir receiving email with -mailer=maildir")
+	l.int(&s.Mail.Workers, "mail.workers", "mailworkers", "MAIL_WORKERS", "How many emails are sent at once")
+	l.int(&s.Mail.Attempts, "mail.attempts", "mailattempts", "MAIL_ATTEMPTS", "How many times an email is tried before it is given up on")
+	l.list(&s.Mail.StaffEmails, "mail.staff_emails", "staffemails", "STAFF_EMAILS", "Comma separated addresses of the staff told about reservations")
+	l.string(&s.Mail.StaffNotify, "mail.staff_notify", "staffnotify", "STAFF_NOTIFY", "When staff are told about new reservations: each, digest to get them daily at -digesthour, or off")
+	l.int(&s.Mail.DigestHour, "mail.digest_hour", "digesthour", "DIGEST_HOUR", "Hour of the day the digest of new reservations is sent")
+	l.int(&s.Mail.PreArrivalDays, "mail.pre_arrival_days", "prearrivaldays", "PRE_ARRIVAL_DAYS", "Days before arrival guests are reminded of their reservation, -1 to not remind them")
+	l.int(&s.Mail.PostStayDays, "mail.post_stay_days", "poststaydays", "POST_STAY_DAYS", "Days after departure guests are thanked and asked for a review, -1 to not thank them")
+	l.string(&s.Mail.ReviewURL, "mail.review_url", "reviewurl", "REVIEW_URL", "Where guests are asked to review their stay, they are asked to reply when empty")
+
+	l.bool(&s.Templates.Cache, "templates.cache", "cache", "TEMPLATE_CACHE", "Use template cache")
+	l.string(&s.Templates.Dir, "templates.dir", "templates", "TEMPLATES_DIR", "Directory of the page templates")
+	l.string(&s.Templates.EmailDir, "templates.email_dir", "emailtemplates", "EMAIL_TEMPLATES_DIR", "Directory of the email templates")
+
+	l.duration(&s.Calendar.SyncInterval, "calendar.sync_interval", "calendarsync", "CALENDAR_SYNC", "How often external calendars are imported, 0 to never")
+	l.bool(&s.Calendar.Files, "calendar.files", "calendarfiles", "CALENDAR_FILES", "Let external calendars be read from file:// urls")
+}
+
+func (l *loader) add(key, name, env, kind string) string {
+	l.options = append(l.options, option{key: key, flag: name, env: env, kind: kind})
+	return name
+}
+
+// alias defines the flag name and the environment variable env as other names of the flag of,
+// kept for the settings that were renamed
+func (l *loader) alias(of, name, env string) {
+	for _, o := range l.options {
+		if o.flag == of {
+			l.fs.Var(l.fs.Lookup(of).Value, name, "Same as -"+of+" ("+env+")")
+			l.options = append(l.options, option{key: o.key, flag: name, env: env, kind: o.kind, aliasOf: o.env})
+			return
+		}
+	}
+}
+
+func (l *loader) string(p *string, key, name, env, usage string) {
+	l.fs.StringVar(p, l.add(key, name, env, "text"), *p, usage+" ("+env+")")
+}
+
+func (l *loader) bool(p *bool, key, name, env, usage string) {
+	l.fs.BoolVar(p, l.add(key, name, env, "true or false"), *p, usage+" ("+env+")")
+}
+
+func (l *loader) int(p *int, key, name, env, usage string) {
+	l.fs.IntVar(p, l.add(key, name, env, "a whole number"), *p, usage+" ("+env+")")
+}
+
+func (l *loader) duration(p *time.Duration, key, name, env, usage string) {
+	l.fs.DurationVar(p, l.add(key, name, env, "a duration such as 90s or 2h"), *p, usage+" ("+env+")")
+}
+
+func (l *loader) list(p *[]string, key, name, env, usage string) {
+	l.fs.Var((*listValue)(p), l.add(key, name, env, "a comma separated list"), usage+" ("+env+")")
+}
+
+// listValue is a flag of comma separated values
+type listValue []string
+
+func (v *listValue) String() string {
+	if v == nil {
+		return ""
+	}
+	return strings.Join(*v, ",")
+}
+
+func (v *listValue) Set(value string) error {
+	*v = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*v = append(*v, item)
+		}
+	}
+
+	return nil
+}
+
+// problem is why the setting of key is not valid
+type problem struct {
+	key     string
+	message string
+}
+
+// validate returns the problems of every invalid setting, in the order of Settings
+func (s *Settings) validate() []problem {
+	var problems []problem
+	add := func(key, format string, args ...interface{}) {
+		problems = append(problems, problem{key: key, message: fmt.Sprintf(format, args...)})
+	}
+
+	if _, port, err := net.SplitHostPort(s.HTTP.Addr); err != nil || !isPort(port) {
+		add("http.addr", "%q is not an address such as :8080 or 127.0.0.1:8080", s.HTTP.Addr)
+	}
+
+	if !isURL(s.HTTP.BaseURL) {
+		add("http.base_url", "%q is not an http or https url", s.HTTP.BaseURL)
+	}
+
+	if s.HTTP.Production && s.HTTP.LinkSecret == "" {
+		// a random secret would break the links already sent to guests on every restart
+		add("http.link_secret", "is required in production")
+	}
+
+	for _, origin := range s.HTTP.WidgetOrigins {
+		if !isOrigin(origin) {
+			add("http.widget_origins", "%q is not an origin such as https://hotel.example.com", origin)
+		}
+	}
+
+	switch s.Database.Driver {
+	case "postgres":
+		if s.Database.Host == "" {
+			add("database.host", "is required with the postgres driver")
+		}
+		if !isPort(s.Database.Port) {
+			add("database.port", "%q is not a port", s.Database.Port)
+		}
+		if s.Database.Name == "" {
+			add("database.name", "is required with the postgres driver")
+		}
+		if s.Database.User == "" {
+			add("database.user", "is required with the postgres driver")
+		}
+		switch s.Database.SSL {
+		case "", "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		default:
+			add("database.ssl", "%q is not disable, allow, prefer, require, verify-ca or verify-full", s.Database.SSL)
+		}
+	case "sqlite":
+		if s.Database.File == "" {
+			add("database.file", "is required with the sqlite driver")
+		}
+	case "memory":
+	default:
+		add("database.driver", "%q is not postgres, sqlite or memory", s.Database.Driver)
+	}
+
+	if s.Database.Timeout <= 0 {
+		add("database.timeout", "%s must be more than 0", s.Database.Timeout)
+	}
+
+	if s.Session.Lifetime <= 0 {
+		add("session.lifetime", "%s must be more than 0", s.Session.Lifetime)
+	}
+
+	if s.Session.CookieName == "" {
+		add("session.cookie_name", "is required")
+	}
+
+	switch s.Mail.Transport {
+	case "smtp":
+		if s.Mail.SMTPHost == "" {
+			add("mail.smtp_host", "is required with the smtp transport")
+		}
+		if !isPort(strconv.Itoa(s.Mail.SMTPPort)) {
+			add("mail.smtp_port", "%d is not a port", s.Mail.SMTPPort)
+		}
+		switch s.Mail.SMTPEncryption {
+		case "none", "ssl", "starttls":
+		default:
+			add("mail.smtp_encryption", "%q is not none, ssl or starttls", s.Mail.SMTPEncryption)
+		}
+	case "maildir":
+		if s.Mail.Dir == "" {
+			add("mail.dir", "is required with the maildir transport")
+		}
+	default:
+		add("mail.transport", "%q is not smtp or maildir", s.Mail.Transport)
+	}
+
+	if s.Mail.Workers < 1 {
+		add("mail.workers", "%d must be at least 1", s.Mail.Workers)
+	}
+
+	if s.Mail.Attempts < 1 {
+		add("mail.attempts", "%d must be at least 1", s.Mail.Attempts)
+	}
+
+	for _, address := range s.Mail.StaffEmails {
+		if !strings.Contains(address, "@") {
+			add("mail.staff_emails", "%q is not an email address", address)
+		}
+	}
+
+	switch s.Mail.StaffNotify {
+	case NotifyEach, NotifyDigest, NotifyOff:
+	default:
+		add("mail.staff_notify", "%q is not each, digest or off", s.Mail.StaffNotify)
+	}
+
+	if s.Mail.DigestHour < 0 || s.Mail.DigestHour > 23 {
+		add("mail.digest_hour", "%d is not an hour of the day", s.Mail.DigestHour)
+	}
+
+	if s.Mail.ReviewURL != "" && !isURL(s.Mail.ReviewURL) {
+		add("mail.review_url", "%q is not an http or https url", s.Mail.ReviewURL)
+	}
+
+	if !isDir(s.Templates.Dir) {
+		add("templates.dir", "%q is not a directory", s.Templates.Dir)
+	}
+
+	if !isDir(s.Templates.EmailDir) {
+		add("templates.email_dir", "%q is not a directory", s.Templates.EmailDir)
+	}
+
+	if s.Calendar.SyncInterval < 0 {
+		add("calendar.sync_interval", "%s must not be negative", s.Calendar.SyncInterval)
+	}
+
+	return problems
+}
+
+// isPort reports whether port is a number between 1 and 65535
+func isPort(port string) bool {
+	n, err := strconv.Atoi(port)
+
+	return err == nil && n > 0 && n < 65536
+}
+
+// isURL reports whether u is an absolute http or https url
+func isURL(u string) bool {
+	parsed, err := url.Parse(u)
+
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// isOrigin reports whether origin is the scheme and host of an http or https url, without a path
+func isOrigin(origin string) bool {
+	parsed, err := url.Parse(origin)
+
+	return err == nil && isURL(origin) && parsed.Path == "" && parsed.RawQuery == "" && parsed.User == nil
+}
+
+// isDir reports whether dir is an existing directory
+func isDir(dir string) bool {
+	info, err := os.Stat(dir)
+
+	return err == nil && info.IsDir()
+}
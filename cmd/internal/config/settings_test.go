@@ -0,0 +1,239 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testDefaults returns valid settings, with directories that exist
+func testDefaults(t *testing.T) Settings {
+	dir := t.TempDir()
+
+	return Settings{
+		HTTP:      HTTPSettings{Addr: ":8080", Production: true, BaseURL: "http://localhost:8080", LinkSecret: "secret"},
+		Database:  DatabaseSettings{Driver: "memory", Host: "localhost", Port: "5432", Timeout: 3 * time.Second},
+		Session:   SessionSettings{Lifetime: 24 * time.Hour, CookieName: "session"},
+		Mail:      MailSettings{Transport: "smtp", SMTPHost: "localhost", SMTPPort: 1025, SMTPEncryption: "none", Workers: 2, Attempts: 8, StaffNotify: NotifyEach, DigestHour: 8},
+		Templates: TemplateSettings{Cache: true, Dir: dir, EmailDir: dir},
+	}
+}
+
+// env returns a lookup of the environment variables in vars
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, ok := vars[key]
+		return value, ok
+	}
+}
+
+// writeFile writes content in a file named name, returning its path
+func writeFile(t *testing.T, name, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func load(t *testing.T, args []string, vars map[string]string) (*Settings, error) {
+	return Load(testDefaults(t), flag.NewFlagSet("test", flag.ContinueOnError), args, env(vars))
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	s, err := load(t, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.HTTP.Addr != ":8080" || !s.Templates.Cache || s.Session.Lifetime != 24*time.Hour {
+		t.Errorf("expected the defaults, got %+v", s)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := writeFile(t, "bookings.yaml", `
+http:
+  addr: ":9000"
+  base_url: https://bookings.example.com
+database:
+  driver: sqlite
+  file: /tmp/file.db
+  timeout: 5s
+mail:
+  staff_emails: [desk@example.com, owner@example.com]
+  digest_hour: 6
+templates:
+  cache: false
+`)
+
+	s, err := load(t, []string{"-config", file, "-digesthour", "7"}, map[string]string{
+		"DB_FILE":     "/tmp/env.db",
+		"DIGEST_HOUR": "5",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.HTTP.Addr != ":9000" || s.HTTP.BaseURL != "https://bookings.example.com" || s.Templates.Cache {
+		t.Errorf("expected the settings of the file, got %+v", s)
+	}
+	if s.Database.Timeout != 5*time.Second || len(s.Mail.StaffEmails) != 2 {
+		t.Errorf("expected the timeout and staff of the file, got %s and %v", s.Database.Timeout, s.Mail.StaffEmails)
+	}
+	if s.Database.File != "/tmp/env.db" {
+		t.Errorf("expected the environment to override the file, got %s", s.Database.File)
+	}
+	if s.Mail.DigestHour != 7 {
+		t.Errorf("expected the flag to override the environment, got %d", s.Mail.DigestHour)
+	}
+	if s.Database.Port != "5432" {
+		t.Errorf("expected the defaults to stay, got port %s", s.Database.Port)
+	}
+}
+
+func TestLoad_TOML(t *testing.T) {
+	file := writeFile(t, "bookings.toml", `
+[session]
+lifetime = "2h"
+cookie_name = "bookings"
+
+[calendar]
+sync_interval = "1h"
+
+[mail]
+staff_emails = ["desk@example.com"]
+`)
+
+	s, err := load(t, nil, map[string]string{"CONFIG_FILE": file, "STAFF_EMAILS": "a@example.com, b@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Session.Lifetime != 2*time.Hour || s.Session.CookieName != "bookings" || s.Calendar.SyncInterval != time.Hour {
+		t.Errorf("expected the settings of the file, got %+v", s)
+	}
+	if strings.Join(s.Mail.StaffEmails, ",") != "a@example.com,b@example.com" {
+		t.Errorf("expected the staff of the environment, got %v", s.Mail.StaffEmails)
+	}
+}
+
+func TestLoad_BadFile(t *testing.T) {
+	var tests = []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"unknown-yaml", "bookings.yaml", "http:\n  port: 80\n"},
+		{"unknown-toml", "bookings.toml", "[http]\nport = 80\n"},
+		{"broken-yaml", "bookings.yaml", "http: [\n"},
+		{"json", "bookings.json", "{}"},
+	}
+
+	for _, e := range tests {
+		_, err := load(t, []string{"-config=" + writeFile(t, e.file, e.content)}, nil)
+		if err == nil {
+			t.Errorf("failed %s: expected an error", e.name)
+		}
+	}
+
+	if _, err := load(t, []string{"-config", "missing.yaml"}, nil); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	_, err := load(t, []string{"-dbdriver", "postgres", "-addr", "8080", "-staffnotify", "weekly"}, map[string]string{
+		"SMTP_PORT":      "twenty-five",
+		"TEMPLATES_DIR":  "./no-such-templates",
+		"SESSION_COOKIE": "",
+	})
+
+	var invalid InvalidError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected an InvalidError, got %v", err)
+	}
+
+	for _, expected := range []string{
+		"http.addr (-addr, HTTP_ADDR)",
+		"database.name (-dbname, DB_NAME)",
+		"database.user (-dbuser, DB_USER)",
+		"session.cookie_name",
+		"mail.smtp_port (-smtpport, SMTP_PORT): SMTP_PORT=\"twenty-five\" is not a whole number",
+		"mail.staff_notify",
+		"templates.dir",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in:\n%s", expected, err)
+		}
+	}
+
+	if len(invalid) != 7 {
+		t.Errorf("expected 7 invalid settings, got %d:\n%s", len(invalid), err)
+	}
+}
+
+func TestLoad_LinkSecret(t *testing.T) {
+	_, err := load(t, []string{"-linksecret="}, nil)
+	if err == nil || !strings.Contains(err.Error(), "http.link_secret (-linksecret, LINK_SECRET): is required in production") {
+		t.Errorf("expected the link secret to be required in production, got %v", err)
+	}
+
+	_, err = load(t, []string{"-linksecret=", "-production=false"}, nil)
+	if err != nil {
+		t.Errorf("expected a random link secret to be allowed outside production, got %v", err)
+	}
+}
+
+func TestLoad_WidgetOrigins(t *testing.T) {
+	s, err := load(t, nil, map[string]string{"WIDGET_ORIGINS": "https://hotel.example.com, http://localhost:3000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(s.HTTP.WidgetOrigins, ",") != "https://hotel.example.com,http://localhost:3000" {
+		t.Errorf("expected the origins of the environment, got %v", s.HTTP.WidgetOrigins)
+	}
+
+	_, err = load(t, []string{"-widgetorigins", "https://hotel.example.com/book,hotel.example.com"}, nil)
+
+	var invalid InvalidError
+	if !errors.As(err, &invalid) || len(invalid) != 2 || !strings.Contains(err.Error(), "http.widget_origins (-widgetorigins, WIDGET_ORIGINS)") {
+		t.Errorf("expected an origin with a path and one without a scheme to be invalid, got %v", err)
+	}
+}
+
+func TestLoad_DBAlias(t *testing.T) {
+	s, err := load(t, []string{"-db=sqlite", "-dbfile=bookings.db"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Database.Driver != "sqlite" {
+		t.Errorf("expected -db to set the driver, got %s", s.Database.Driver)
+	}
+
+	s, err = load(t, []string{"-dbfile=bookings.db"}, map[string]string{"DB": "sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Database.Driver != "sqlite" {
+		t.Errorf("expected DB to set the driver, got %s", s.Database.Driver)
+	}
+
+	s, err = load(t, []string{"-dbfile=bookings.db"}, map[string]string{"DB": "postgres", "DB_DRIVER": "sqlite"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Database.Driver != "sqlite" {
+		t.Errorf("expected DB_DRIVER to win over DB, got %s", s.Database.Driver)
+	}
+
+	_, err = load(t, []string{"-db=mysql"}, nil)
+	if err == nil || strings.Count(err.Error(), "database.driver") != 1 {
+		t.Errorf("expected the driver to be reported once under its name, got %v", err)
+	}
+}
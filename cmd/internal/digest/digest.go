@@ -0,0 +1,99 @@
+// Package digest sends staff a daily email listing the new reservations they have not processed yet.
+package digest
+
+import (
+	"context"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// Digest puts the digest of new reservations in the outbox
+type Digest struct {
+	App *config.AppConfig
+	DB  repository.DatabaseRepo
+}
+
+// New creates a Digest
+func New(db repository.DatabaseRepo, a *config.AppConfig) *Digest {
+	return &Digest{
+		App: a,
+		DB:  db,
+	}
+}
+
+// Run sends the digest every day at config.AppConfig.DigestHour, until stop is closed
+func (d *Digest) Run(stop <-chan struct{}) {
+	ctx := context.Background()
+
+	for {
+		timer := time.NewTimer(time.Until(Next(time.Now(), d.App.DigestHour)))
+
+		select {
+		case <-timer.C:
+			_, err := d.Send(ctx)
+			if err != nil {
+				d.App.ErrorLog.Println("sending the digest of new reservations:", err)
+			}
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// Send emails the new reservations that are not processed nor cancelled to each of the staff recipients,
+// returning how many reservations were listed. Nothing is sent when there are none.
+func (d *Digest) Send(ctx context.Context) (int, error) {
+	all, err := d.DB.AllNewReservations(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var reservations []models.Reservation
+	for _, res := range all {
+		if res.Cancelled == 0 {
+			reservations = append(reservations, res)
+		}
+	}
+
+	if len(reservations) == 0 || len(d.App.StaffEmails) == 0 {
+		return 0, nil
+	}
+
+	e, err := d.App.Emails.Render(emails.StaffDigest{
+		Reservations: reservations,
+		BaseURL:      d.App.BaseURL,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, to := range d.App.StaffEmails {
+		err = d.DB.EnqueueEmail(ctx, models.MailData{
+			To:      to,
+			From:    "me@here.com",
+			Subject: e.Subject,
+			Content: e.HTML,
+			Text:    e.Text,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(reservations), nil
+}
+
+// Next returns the first time after now at hour o'clock, in the location of now
+func Next(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
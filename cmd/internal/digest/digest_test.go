@@ -0,0 +1,135 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// fakeDB returns reservations as the new ones, and keeps the emails put in the outbox
+type fakeDB struct {
+	repository.DatabaseRepo
+	reservations []models.Reservation
+	err          error
+	enqueued     []models.MailData
+}
+
+func (f *fakeDB) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	return f.reservations, f.err
+}
+
+func (f *fakeDB) EnqueueEmail(ctx context.Context, msg models.MailData) error {
+	f.enqueued = append(f.enqueued, msg)
+	return nil
+}
+
+func newTestDigest(t *testing.T, db repository.DatabaseRepo, staff ...string) *Digest {
+	renderer, err := emails.New("./../../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &config.AppConfig{
+		InfoLog:     log.New(ioutil.Discard, "", 0),
+		ErrorLog:    log.New(ioutil.Discard, "", 0),
+		BaseURL:     "http://localhost:8080",
+		Emails:      renderer,
+		StaffEmails: staff,
+	}
+
+	return New(db, app)
+}
+
+func TestDigest_Send(t *testing.T) {
+	db := &fakeDB{reservations: []models.Reservation{
+		{ID: 1, FirstName: "John", LastName: "Smith", StartDate: time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC), Room: models.Room{RoomName: "General's Quarters"}},
+		{ID: 2, FirstName: "Jane", LastName: "Smith", Cancelled: 1},
+		{ID: 3, FirstName: "Jack", LastName: "Smith"},
+	}}
+	d := newTestDigest(t, db, "owner@here.com", "desk@here.com")
+
+	n, err := d.Send(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Errorf("expected the 2 reservations that are not cancelled, but got %d", n)
+	}
+
+	if len(db.enqueued) != 2 || db.enqueued[0].To != "owner@here.com" || db.enqueued[1].To != "desk@here.com" {
+		t.Fatalf("expected an email to each of the staff, but got %v", db.enqueued)
+	}
+
+	msg := db.enqueued[0]
+	if msg.Subject != "2 new reservation(s) to process" {
+		t.Errorf("unexpected subject %q", msg.Subject)
+	}
+
+	for _, expected := range []string{"http://localhost:8080/admin/reservations/new/1/show", "John Smith, General's Quarters from 2100-06-01", "Jack Smith"} {
+		if !strings.Contains(msg.Text, expected) {
+			t.Errorf("expected to find %q in\n%s", expected, msg.Text)
+		}
+	}
+
+	if strings.Contains(msg.Text, "Jane") {
+		t.Error("expected the cancelled reservation to be left out")
+	}
+}
+
+func TestDigest_SendNothing(t *testing.T) {
+	var tests = []struct {
+		name  string
+		db    *fakeDB
+		staff []string
+	}{
+		{"no-reservations", &fakeDB{}, []string{"owner@here.com"}},
+		{"only-cancelled", &fakeDB{reservations: []models.Reservation{{ID: 2, Cancelled: 1}}}, []string{"owner@here.com"}},
+		{"no-staff", &fakeDB{reservations: []models.Reservation{{ID: 1}}}, nil},
+	}
+
+	for _, e := range tests {
+		d := newTestDigest(t, e.db, e.staff...)
+
+		n, err := d.Send(context.Background())
+		if err != nil || n != 0 || len(e.db.enqueued) != 0 {
+			t.Errorf("failed %s: expected nothing to be sent, but got %d reservations and %d emails (%v)", e.name, n, len(e.db.enqueued), err)
+		}
+	}
+}
+
+func TestDigest_SendError(t *testing.T) {
+	d := newTestDigest(t, &fakeDB{err: errors.New("database down")}, "owner@here.com")
+
+	if _, err := d.Send(context.Background()); err == nil {
+		t.Error("expected the error of the database")
+	}
+}
+
+func TestNext(t *testing.T) {
+	var tests = []struct {
+		name     string
+		now      time.Time
+		expected time.Time
+	}{
+		{"before", time.Date(2100, 6, 1, 7, 30, 0, 0, time.UTC), time.Date(2100, 6, 1, 8, 0, 0, 0, time.UTC)},
+		{"at", time.Date(2100, 6, 1, 8, 0, 0, 0, time.UTC), time.Date(2100, 6, 2, 8, 0, 0, 0, time.UTC)},
+		{"after", time.Date(2100, 6, 1, 9, 0, 0, 0, time.UTC), time.Date(2100, 6, 2, 8, 0, 0, 0, time.UTC)},
+		{"end-of-month", time.Date(2100, 6, 30, 23, 0, 0, 0, time.UTC), time.Date(2100, 7, 1, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, e := range tests {
+		if result := Next(e.now, 8); !result.Equal(e.expected) {
+			t.Errorf("failed %s: expected %s, but got %s", e.name, e.expected, result)
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // DB holds the database connection pool
@@ -25,8 +26,33 @@ const maxDbLifetime = 5 * time.Minute
 func ConnectSQL(dsn string) (*DB, error) {
 	d, err := NewDatabase(dsn)
 	if err != nil {
-		log.Println("Error panic ================")
-		panic(err)
+		return nil, err
+	}
+
+	d.SetMaxOpenConns(maxOpenDbConn)
+	d.SetMaxIdleConns(maxIdleDbConn)
+	d.SetConnMaxLifetime(maxDbLifetime)
+
+	err = testDB(d)
+	if err != nil {
+		return nil, err
+	}
+
+	dbConn.SQL = d
+	return &DB{
+		SQL: d,
+	}, nil
+}
+
+// sqliteOptions turn on foreign keys, which SQLite ignores by default, and make transactions take the
+// write lock when they begin, waiting for it rather than failing while another connection holds it
+const sqliteOptions = "_foreign_keys=on&_txlock=immediate&_busy_timeout=5000&_journal_mode=WAL"
+
+// ConnectSQLite creates database pool for the SQLite database in file
+func ConnectSQLite(file string) (*DB, error) {
+	d, err := sql.Open("sqlite3", "file:"+file+"?"+sqliteOptions)
+	if err != nil {
+		return nil, err
 	}
 
 	d.SetMaxOpenConns(maxOpenDbConn)
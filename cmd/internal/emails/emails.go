@@ -0,0 +1,242 @@
+// Package emails renders the emails sent to guests and staff from the templates in email-templates.
+//
+// Each email is a pair of templates: name.email.htm, rendered with html/template, and its plain text
+// alternative name.email.txt, which also defines the subject. Like the pages, they fill the blocks of
+// the base layout (base.layout.htm and base.layout.txt) and can use the partials (*.partial.htm and
+// *.partial.txt).
+package emails
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+var functions = map[string]interface{}{
+	"humanDate":   humanDate,
+	"formatDate":  formatDate,
+	"formatPrice": formatPrice,
+	"nights":      nights,
+}
+
+// Email is a rendered email
+type Email struct {
+	Subject string
+	HTML    string
+	Text    string // plain text alternative of HTML
+}
+
+// Data is what an email is rendered from
+type Data interface {
+	// Template returns the name of the templates of the email, confirmation for confirmation.email.htm
+	Template() string
+}
+
+// Confirmation is sent to the guest once a reservation is made
+type Confirmation struct {
+	Reservation models.Reservation
+	ManageURL   string // where the guest can change or cancel the reservation
+}
+
+// Template returns the name of the templates of the email
+func (Confirmation) Template() string { return "confirmation" }
+
+// Modification is sent to the guest when the dates of a reservation change
+type Modification struct {
+	Reservation models.Reservation
+	ManageURL   string
+}
+
+// Template returns the name of the templates of the email
+func (Modification) Template() string { return "modification" }
+
+// Cancellation is sent to the guest when a reservation is cancelled
+type Cancellation struct {
+	Reservation models.Reservation
+}
+
+// Template returns the name of the templates of the email
+func (Cancellation) Template() string { return "cancellation" }
+
+// PreArrival reminds the guest of their reservation some days before they arrive
+type PreArrival struct {
+	Reservation models.Reservation
+	ManageURL   string
+}
+
+// Template returns the name of the templates of the email
+func (PreArrival) Template() string { return "pre-arrival" }
+
+// PostStay thanks the guest some days after they leave, and asks them for a review
+type PostStay struct {
+	Reservation models.Reservation
+	ReviewURL   string // where guests can review their stay, they are asked to reply when empty
+}
+
+// Template returns the name of the templates of the email
+func (PostStay) Template() string { return "post-stay" }
+
+// What happened to the reservation of a staff notification
+const (
+	ActionBooked    = "booked"
+	ActionChanged   = "changed"
+	ActionCancelled = "cancelled"
+)
+
+// StaffNotification tells staff that a guest booked, changed or cancelled a reservation
+type StaffNotification struct {
+	Action      string // ActionBooked, ActionChanged or ActionCancelled
+	Reservation models.Reservation
+	AdminURL    string // where staff can see the reservation
+}
+
+// Template returns the name of the templates of the email
+func (StaffNotification) Template() string { return "staff-notification" }
+
+// StaffDigest lists for staff the new reservations they have not processed yet
+type StaffDigest struct {
+	Reservations []models.Reservation
+	BaseURL      string // public address of the site, for the links to the reservations
+}
+
+// Template returns the name of the templates of the email
+func (StaffDigest) Template() string { return "staff-digest" }
+
+// PasswordLink sends a staff user the link to choose a password, when they forgot theirs or are invited
+type PasswordLink struct {
+	User       models.User
+	Invitation bool
+	URL        string
+	ExpiresAt  time.Time
+}
+
+// Template returns the name of the templates of the email
+func (PasswordLink) Template() string { return "password-link" }
+
+// Renderer renders emails from parsed templates
+type Renderer struct {
+	html map[string]*htmltemplate.Template
+	text map[string]*texttemplate.Template
+}
+
+// New parses the email templates in dir. Every email must have both its html and its plain text
+// template, and the plain text one must define the subject.
+func New(dir string) (*Renderer, error) {
+	r := &Renderer{
+		html: make(map[string]*htmltemplate.Template),
+		text: make(map[string]*texttemplate.Template),
+	}
+
+	pages, err := filepath.Glob(filepath.Join(dir, "*.email.htm"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range pages {
+		name := strings.TrimSuffix(filepath.Base(page), ".email.htm")
+
+		ht, err := htmltemplate.New(filepath.Base(page)).Funcs(functions).ParseFiles(page)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range []string{"*.layout.htm", "*.partial.htm"} {
+			files, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return nil, err
+			}
+			if len(files) > 0 {
+				ht, err = ht.ParseFiles(files...)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		textPage := filepath.Join(dir, name+".email.txt")
+		tt, err := texttemplate.New(filepath.Base(textPage)).Funcs(functions).ParseFiles(textPage)
+		if err != nil {
+			return nil, fmt.Errorf("email %s has no plain text template: %w", name, err)
+		}
+		for _, pattern := range []string{"*.layout.txt", "*.partial.txt"} {
+			files, err := filepath.Glob(filepath.Join(dir, pattern))
+			if err != nil {
+				return nil, err
+			}
+			if len(files) > 0 {
+				tt, err = tt.ParseFiles(files...)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if tt.Lookup("subject") == nil {
+			return nil, fmt.Errorf("email %s defines no subject", name)
+		}
+
+		r.html[name] = ht
+		r.text[name] = tt
+	}
+
+	return r, nil
+}
+
+// Render renders the email of d
+func (r *Renderer) Render(d Data) (Email, error) {
+	var e Email
+
+	ht, ok := r.html[d.Template()]
+	if !ok {
+		return e, fmt.Errorf("unknown email %q", d.Template())
+	}
+	tt := r.text[d.Template()]
+
+	var subject, html, text bytes.Buffer
+
+	err := tt.ExecuteTemplate(&subject, "subject", d)
+	if err != nil {
+		return e, err
+	}
+
+	err = tt.Execute(&text, d)
+	if err != nil {
+		return e, err
+	}
+
+	err = ht.Execute(&html, d)
+	if err != nil {
+		return e, err
+	}
+
+	// a guest name can't break the subject onto another header line
+	e.Subject = strings.Join(strings.Fields(subject.String()), " ")
+	e.HTML = html.String()
+	e.Text = strings.TrimSpace(text.String()) + "\n"
+
+	return e, nil
+}
+
+// humanDate returns time in YYYY-MM-DD
+func humanDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+func formatDate(t time.Time, f string) string {
+	return t.Format(f)
+}
+
+// formatPrice formats an amount in cents as 99.50
+func formatPrice(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
+// nights returns how many nights there are from start to end
+func nights(start, end time.Time) int {
+	return int(end.Sub(start).Hours()+12) / 24
+}
@@ -0,0 +1,157 @@
+package emails
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+var pathToTemplates = "./../../../email-templates"
+
+var testReservation = models.Reservation{
+	ID:         7,
+	FirstName:  "<b>John</b>",
+	LastName:   "Smith",
+	Email:      "john@smith.com",
+	Phone:      "555-555-5555",
+	StartDate:  time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC),
+	EndDate:    time.Date(2100, 6, 3, 0, 0, 0, 0, time.UTC),
+	TotalPrice: 12345,
+	Discount:   500,
+	Room:       models.Room{RoomName: "General's Quarters"},
+}
+
+func TestRenderer_Render(t *testing.T) {
+	r, err := New(pathToTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name            string
+		data            Data
+		expectedSubject string
+		expected        []string
+	}{
+		{"confirmation", Confirmation{Reservation: testReservation, ManageURL: "http://localhost/manage/abc"}, "Reservation Confirmation",
+			[]string{"This confirms your reservation", "2100-06-01", "Total price for 2 night(s): 123.45", "discount applied: 5.00", "http://localhost/manage/abc"}},
+		{"modification", Modification{Reservation: testReservation, ManageURL: "http://localhost/manage/def"}, "Reservation Changed",
+			[]string{"has been changed", "2100-06-03", "http://localhost/manage/def"}},
+		{"cancellation", Cancellation{Reservation: testReservation}, "Reservation Cancelled",
+			[]string{"from 2100-06-01 to 2100-06-03 has been cancelled"}},
+		{"pre-arrival", PreArrival{Reservation: testReservation, ManageURL: "http://localhost/manage/xyz"}, "See you soon at Fort Smythe",
+			[]string{"welcoming you on 2100-06-01", "http://localhost/manage/xyz"}},
+		{"post-stay", PostStay{Reservation: testReservation, ReviewURL: "http://localhost/reviews"}, "Thank you for staying at Fort Smythe",
+			[]string{"from 2100-06-01 to 2100-06-03", "http://localhost/reviews"}},
+		{"post-stay-no-review-url", PostStay{Reservation: testReservation}, "Thank you for staying at Fort Smythe",
+			[]string{"reply to this email"}},
+		{"staff-booked", StaffNotification{Action: ActionBooked, Reservation: testReservation, AdminURL: "http://localhost/admin/reservations/new/7/show"}, "New Reservation: <b>John</b> Smith",
+			[]string{"made reservation 7", "555-555-5555", "http://localhost/admin/reservations/new/7/show"}},
+		{"staff-changed", StaffNotification{Action: ActionChanged, Reservation: testReservation, AdminURL: "http://localhost/admin/reservations/all/7/show"}, "Reservation Changed: <b>John</b> Smith",
+			[]string{"changed reservation 7", "Arrival: 2100-06-01", "http://localhost/admin/reservations/all/7/show"}},
+		{"staff-cancelled", StaffNotification{Action: ActionCancelled, Reservation: testReservation}, "Reservation Cancelled: <b>John</b> Smith",
+			[]string{"cancelled reservation 7", "available again"}},
+		{"staff-digest", StaffDigest{Reservations: []models.Reservation{testReservation}, BaseURL: "http://localhost"}, "1 new reservation(s) to process",
+			[]string{"http://localhost/admin/reservations/new/7/show", "http://localhost/admin/reservations-new"}},
+		{"invitation", PasswordLink{User: models.User{FirstName: "Ann"}, Invitation: true, URL: "http://localhost/user/reset-password/xyz", ExpiresAt: testReservation.StartDate}, "Welcome to Fort Smythe",
+			[]string{"invited", "http://localhost/user/reset-password/xyz", "until 2100-06-01 00:00"}},
+		{"password-reset", PasswordLink{User: models.User{FirstName: "Ann"}, URL: "http://localhost/user/reset-password/xyz"}, "Reset your password",
+			[]string{"reset the password", "http://localhost/user/reset-password/xyz"}},
+	}
+
+	for _, e := range tests {
+		email, err := r.Render(e.data)
+		if err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		if email.Subject != e.expectedSubject {
+			t.Errorf("failed %s: expected subject %q, but got %q", e.name, e.expectedSubject, email.Subject)
+		}
+
+		for _, expected := range e.expected {
+			if !strings.Contains(email.Text, expected) {
+				t.Errorf("failed %s: expected to find %q in the text\n%s", e.name, expected, email.Text)
+			}
+			if !strings.Contains(email.HTML, strings.ReplaceAll(expected, "'", "&#39;")) {
+				t.Errorf("failed %s: expected to find %q in the html", e.name, expected)
+			}
+		}
+
+		if strings.Contains(email.Text, "<p>") {
+			t.Errorf("failed %s: expected no html in the text", e.name)
+		}
+	}
+}
+
+func TestRenderer_RenderEscapes(t *testing.T) {
+	r, err := New(pathToTemplates)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email, err := r.Render(Confirmation{Reservation: testReservation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(email.HTML, "<b>John</b>") || !strings.Contains(email.HTML, "&lt;b&gt;John&lt;/b&gt;") {
+		t.Error("expected the name of the guest to be escaped in the html")
+	}
+
+	if !strings.Contains(email.HTML, "Fort Smythe") {
+		t.Error("expected the email to be in the layout")
+	}
+
+	res := testReservation
+	res.FirstName = "John\r\nBcc: everyone@example.com"
+	email, err = r.Render(StaffNotification{Action: ActionBooked, Reservation: res})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.ContainsAny(email.Subject, "\r\n") {
+		t.Errorf("expected the subject to stay on one line, but got %q", email.Subject)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	var tests = []struct {
+		name  string
+		files map[string]string
+	}{
+		{"no-text", map[string]string{"hello.email.htm": "<p>Hello</p>"}},
+		{"no-subject", map[string]string{"hello.email.htm": "<p>Hello</p>", "hello.email.txt": "Hello"}},
+		{"broken", map[string]string{"hello.email.htm": "{{ .Oops", "hello.email.txt": `{{ define "subject" }}Hello{{ end }}`}},
+	}
+
+	for _, e := range tests {
+		dir := t.TempDir()
+		for name, content := range e.files {
+			err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := New(dir); err == nil {
+			t.Errorf("failed %s: expected an error", e.name)
+		}
+	}
+}
+
+func TestRenderer_RenderUnknown(t *testing.T) {
+	r, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Render(Cancellation{}); err == nil {
+		t.Error("expected an error for an email without templates")
+	}
+}
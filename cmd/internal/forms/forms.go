@@ -3,11 +3,17 @@ package forms
 import (
 	"fmt"
 	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/asaskevich/govalidator"
 )
 
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+var priceRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]{1,2})?$`)
+var codeRegexp = regexp.MustCompile(`^[A-Za-z0-9]{3,20}$`)
+
 // Form creates a custom form struct, embeds a url.Values object
 type Form struct {
 	url.Values
@@ -57,9 +63,78 @@ func (f *Form) MinLength(field string, length int) bool {
 	return true
 }
 
+// Matches checks that field has the same value as other, such as a password and its confirmation
+func (f *Form) Matches(field, other string) {
+	if f.Get(field) != f.Get(other) {
+		f.Errors.Add(field, "The values do not match")
+	}
+}
+
 // IsEmail checks for valid email address
 func (f *Form) IsEmail(field string) {
 	if !govalidator.IsEmail(f.Get(field)) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsSlug checks for a lowercase, dash separated url slug
+func (f *Form) IsSlug(field string) {
+	if !slugRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use only lowercase letters, numbers and dashes")
+	}
+}
+
+// IsCode checks for a code of 3 to 20 letters and numbers, such as a promo code
+func (f *Form) IsCode(field string) {
+	if !codeRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Use 3 to 20 letters and numbers")
+	}
+}
+
+// IsInt checks for a whole number of at least min
+func (f *Form) IsInt(field string, min int) {
+	x, err := strconv.Atoi(f.Get(field))
+	if err != nil {
+		f.Errors.Add(field, "This field must be a whole number")
+		return
+	}
+
+	if x < min {
+		f.Errors.Add(field, fmt.Sprintf("This field must be at least %d", min))
+	}
+}
+
+// IsPrice checks for a price with at most two decimals, such as 120 or 99.50
+func (f *Form) IsPrice(field string) {
+	if !priceRegexp.MatchString(f.Get(field)) {
+		f.Errors.Add(field, "Invalid price")
+	}
+}
+
+// ParsePrice converts a price such as 99.50 into cents
+func ParsePrice(s string) (int, error) {
+	if !priceRegexp.MatchString(s) {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+
+	units, cents := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		units, cents = s[:i], s[i+1:]
+	}
+
+	for len(cents) < 2 {
+		cents += "0"
+	}
+
+	u, err := strconv.Atoi(units)
+	if err != nil {
+		return 0, err
+	}
+
+	c, err := strconv.Atoi(cents)
+	if err != nil {
+		return 0, err
+	}
+
+	return u*100 + c, nil
+}
@@ -130,3 +130,133 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("got valid for invalid email address ")
 	}
 }
+
+func TestForm_IsSlug(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("slug", "generals-quarters")
+
+	form := New(postedValues)
+	form.IsSlug("slug")
+	if !form.Valid() {
+		t.Error("got an invalid slug when we shouldn't have")
+	}
+
+	postedValues = url.Values{}
+	postedValues.Add("slug", "Generals Quarters")
+
+	form = New(postedValues)
+	form.IsSlug("slug")
+	if form.Valid() {
+		t.Error("got valid for invalid slug")
+	}
+}
+
+func TestForm_Matches(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("password_confirm", "secret123")
+	postedValues.Add("password", "secret123")
+
+	form := New(postedValues)
+	form.Matches("password_confirm", "password")
+	if !form.Valid() {
+		t.Error("got no match when the values are the same")
+	}
+
+	postedValues.Set("password_confirm", "secret124")
+
+	form = New(postedValues)
+	form.Matches("password_confirm", "password")
+	if form.Valid() {
+		t.Error("got a match when the values differ")
+	}
+}
+
+func TestForm_IsCode(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("code", "Summer21")
+
+	form := New(postedValues)
+	form.IsCode("code")
+	if !form.Valid() {
+		t.Error("got an invalid code when we shouldn't have")
+	}
+
+	postedValues = url.Values{}
+	postedValues.Add("code", "SUMMER-21")
+
+	form = New(postedValues)
+	form.IsCode("code")
+	if form.Valid() {
+		t.Error("got valid for invalid code")
+	}
+}
+
+func TestForm_IsInt(t *testing.T) {
+	postedValues := url.Values{}
+	postedValues.Add("capacity", "2")
+
+	form := New(postedValues)
+	form.IsInt("capacity", 1)
+	if !form.Valid() {
+		t.Error("got an invalid number when we shouldn't have")
+	}
+
+	form = New(postedValues)
+	form.IsInt("capacity", 3)
+	if form.Valid() {
+		t.Error("got valid for a number below the minimum")
+	}
+
+	postedValues = url.Values{}
+	postedValues.Add("capacity", "two")
+
+	form = New(postedValues)
+	form.IsInt("capacity", 1)
+	if form.Valid() {
+		t.Error("got valid for a non-numeric value")
+	}
+}
+
+func TestForm_IsPrice(t *testing.T) {
+	for _, v := range []string{"120", "99.5", "99.50"} {
+		form := New(url.Values{"price": []string{v}})
+		form.IsPrice("price")
+		if !form.Valid() {
+			t.Errorf("got invalid price for %s", v)
+		}
+	}
+
+	for _, v := range []string{"", "-1", "9.999", "abc"} {
+		form := New(url.Values{"price": []string{v}})
+		form.IsPrice("price")
+		if form.Valid() {
+			t.Errorf("got valid price for %s", v)
+		}
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	var tests = []struct {
+		price    string
+		expected int
+	}{
+		{"120", 12000},
+		{"99.5", 9950},
+		{"99.05", 9905},
+		{"0", 0},
+	}
+
+	for _, e := range tests {
+		cents, err := ParsePrice(e.price)
+		if err != nil {
+			t.Errorf("unexpected error parsing %s: %s", e.price, err)
+		}
+		if cents != e.expected {
+			t.Errorf("parsing %s: expected %d but got %d", e.price, e.expected, cents)
+		}
+	}
+
+	if _, err := ParsePrice("abc"); err == nil {
+		t.Error("expected an error parsing an invalid price")
+	}
+}
@@ -0,0 +1,533 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// maxAPIBodySize is the largest request body the API reads
+const maxAPIBodySize = 1 << 20
+
+// apiResponse wraps every successful API response
+type apiResponse struct {
+	Data interface{} `json:"data"`
+}
+
+// apiErrorResponse wraps every failed API response
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// apiError describes what went wrong, with the messages of each invalid field
+type apiError struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+// apiRoom is a room as shown by the API. Prices are in cents.
+type apiRoom struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Slug          string `json:"slug"`
+	Description   string `json:"description"`
+	Capacity      int    `json:"capacity"`
+	BasePrice     int    `json:"base_price"`
+	WeekendUplift int    `json:"weekend_uplift"`
+}
+
+// apiNight is the price of one night of a stay, in cents
+type apiNight struct {
+	Date    string `json:"date"`
+	Price   int    `json:"price"`
+	Season  string `json:"season,omitempty"`
+	Weekend bool   `json:"weekend"`
+}
+
+// apiQuote is the price of a stay, in cents
+type apiQuote struct {
+	Nights []apiNight `json:"nights"`
+	Total  int        `json:"total"`
+}
+
+// apiAvailability tells whether a room is free for a stay, and its price when it is
+type apiAvailability struct {
+	Room      apiRoom   `json:"room"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Available bool      `json:"available"`
+	Quote     *apiQuote `json:"quote,omitempty"`
+}
+
+// apiReservation is a reservation as shown by the API. Prices are in cents.
+type apiReservation struct {
+	ID         int     `json:"id"`
+	Room       apiRoom `json:"room"`
+	FirstName  string  `json:"first_name"`
+	LastName   string  `json:"last_name"`
+	Email      string  `json:"email"`
+	Phone      string  `json:"phone"`
+	StartDate  string  `json:"start_date"`
+	EndDate    string  `json:"end_date"`
+	TotalPrice int     `json:"total_price"`
+	Discount   int     `json:"discount"`
+	Processed  bool    `json:"processed"`
+	Cancelled  bool    `json:"cancelled"`
+	ManageURL  string  `json:"manage_url,omitempty"`
+}
+
+// apiReservationRequest is the body of a request to book a room
+type apiReservationRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	PromoCode string `json:"promo_code"`
+}
+
+// APIRooms lists all rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	out := make([]apiRoom, 0, len(rooms))
+	for _, room := range rooms {
+		out = append(out, toAPIRoom(room))
+	}
+
+	writeJSON(w, http.StatusOK, apiResponse{Data: out})
+}
+
+// APIAvailability tells which rooms are free from start to end, or whether the room given by room is
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	form := forms.New(query)
+	form.Required("start", "end")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, query.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date, use YYYY-MM-DD")
+	}
+
+	endDate, err := time.Parse(layout, query.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Invalid date, use YYYY-MM-DD")
+	} else if !endDate.After(startDate) {
+		form.Errors.Add("end", "Departure must be after arrival")
+	}
+
+	roomID := 0
+	if query.Get("room") != "" {
+		roomID, err = strconv.Atoi(query.Get("room"))
+		if err != nil || roomID < 1 {
+			form.Errors.Add("room", "Invalid room")
+		}
+	}
+
+	if !form.Valid() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "Invalid query", form.Errors)
+		return
+	}
+
+	var rooms []models.Room
+	if roomID > 0 {
+		room, err := m.DB.GetRoomByID(r.Context(), roomID)
+		if err != nil {
+			writeAPIError(w, http.StatusNotFound, "Room not found", nil)
+			return
+		}
+
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+
+		if !available {
+			writeJSON(w, http.StatusOK, apiResponse{Data: []apiAvailability{{
+				Room:      toAPIRoom(room),
+				StartDate: query.Get("start"),
+				EndDate:   query.Get("end"),
+			}}})
+			return
+		}
+
+		rooms = append(rooms, room)
+	} else {
+		rooms, err = m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+	}
+
+	out := make([]apiAvailability, 0, len(rooms))
+	for _, room := range rooms {
+		quote, err := m.DB.QuoteStay(r.Context(), room.ID, startDate, endDate)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+
+		q := toAPIQuote(quote)
+		out = append(out, apiAvailability{
+			Room:      toAPIRoom(room),
+			StartDate: query.Get("start"),
+			EndDate:   query.Get("end"),
+			Available: true,
+			Quote:     &q,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, apiResponse{Data: out})
+}
+
+// APIPostReservation books a room and emails the guest a confirmation
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "Send the reservation as application/json", nil)
+		return
+	}
+
+	var body apiReservationRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&body)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Invalid JSON: %s", err), nil)
+		return
+	}
+
+	// validate the same way as the reservation form
+	form := forms.New(url.Values{
+		"first_name": {body.FirstName},
+		"last_name":  {body.LastName},
+		"email":      {body.Email},
+		"phone":      {body.Phone},
+		"start_date": {body.StartDate},
+		"end_date":   {body.EndDate},
+	})
+	form.Required("first_name", "last_name", "email", "phone", "start_date", "end_date")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, body.StartDate)
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date, use YYYY-MM-DD")
+	} else if startDate.Before(today()) {
+		form.Errors.Add("start_date", "Arrival can't be in the past")
+	}
+
+	endDate, err := time.Parse(layout, body.EndDate)
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date, use YYYY-MM-DD")
+	} else if !endDate.After(startDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), body.RoomID)
+	if body.RoomID < 1 || err != nil {
+		form.Errors.Add("room_id", "Unknown room")
+	}
+
+	if !form.Valid() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "Invalid reservation", form.Errors)
+		return
+	}
+
+	// never trust a price from the client, always quote the stay
+	quote, err := m.DB.QuoteStay(r.Context(), body.RoomID, startDate, endDate)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName:  body.FirstName,
+		LastName:   body.LastName,
+		Phone:      body.Phone,
+		Email:      body.Email,
+		StartDate:  startDate,
+		EndDate:    endDate,
+		RoomID:     body.RoomID,
+		Room:       room,
+		TotalPrice: quote.Total,
+	}
+
+	if code := strings.ToUpper(strings.TrimSpace(body.PromoCode)); code != "" {
+		if err := m.applyPromoCode(r.Context(), &reservation, code, quote.Total); err != nil {
+			form.Errors.Add("promo_code", promoCodeMessage(err))
+			writeAPIError(w, http.StatusUnprocessableEntity, "Invalid reservation", form.Errors)
+			return
+		}
+	}
+
+	reservation.ID, err = m.DB.CreateBooking(r.Context(), reservation, m.confirmationMail(reservation))
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		writeAPIError(w, http.StatusConflict, "The room is not available for these dates", nil)
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeExhausted) {
+		writeAPIError(w, http.StatusConflict, "The promo code has been fully redeemed", nil)
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	m.audit(r, "create-reservation", fmt.Sprintf("reservation %d", reservation.ID))
+
+	out := toAPIReservation(reservation)
+	out.ManageURL = m.ManageURL(reservation)
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", reservation.ID))
+	writeJSON(w, http.StatusCreated, apiResponse{Data: out})
+}
+
+// APIReservations lists all reservations, newest first
+func (m *Repository) APIReservations(w http.ResponseWriter, r *http.Request) {
+	if !m.apiAllowed(w, r, authz.ViewReservations) {
+		return
+	}
+
+	reservations, err := m.DB.AllReservations(r.Context())
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	out := make([]apiReservation, 0, len(reservations))
+	for _, res := range reservations {
+		out = append(out, toAPIReservation(res))
+	}
+
+	writeJSON(w, http.StatusOK, apiResponse{Data: out})
+}
+
+// APIReservation shows a reservation to staff allowed to view reservations
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	if !m.apiAllowed(w, r, authz.ViewReservations) {
+		return
+	}
+
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiResponse{Data: toAPIReservation(res)})
+}
+
+// APIProcessReservation marks a reservation as processed
+func (m *Repository) APIProcessReservation(w http.ResponseWriter, r *http.Request) {
+	if !m.apiAllowed(w, r, authz.EditReservations) {
+		return
+	}
+
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.UpdateProcessedForReservation(r.Context(), res.ID, 1)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	m.audit(r, "process-reservation", fmt.Sprintf("reservation %d", res.ID))
+
+	res.Processed = 1
+	writeJSON(w, http.StatusOK, apiResponse{Data: toAPIReservation(res)})
+}
+
+// APIDeleteReservation deletes a reservation
+func (m *Repository) APIDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	if !m.apiAllowed(w, r, authz.DeleteReservations) {
+		return
+	}
+
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteReservation(r.Context(), res.ID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	m.audit(r, "delete-reservation", fmt.Sprintf("reservation %d of %s %s, %s to %s",
+		res.ID, res.FirstName, res.LastName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIUnauthorized answers API requests without valid credentials
+func (m *Repository) APIUnauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	writeAPIError(w, http.StatusUnauthorized, "Log in or send a valid API token", nil)
+}
+
+// apiAllowed reports whether the request may take permission p, answering with an error when not.
+// Requests made with an API token are limited to the scopes of the token.
+func (m *Repository) apiAllowed(w http.ResponseWriter, r *http.Request, p authz.Permission) bool {
+	if token, ok := helpers.APIToken(r); ok {
+		if !authz.Granted(token.User.AccessLevel, token.Scopes, p) {
+			m.App.InfoLog.Printf("api token %d is not allowed to %s", token.ID, p)
+			writeAPIError(w, http.StatusForbidden, fmt.Sprintf("This token is not allowed to %s", p), nil)
+			return false
+		}
+
+		return true
+	}
+
+	if !helpers.IsAuthenticate(r) {
+		m.APIUnauthorized(w, r)
+		return false
+	}
+
+	if !authz.Can(helpers.AccessLevel(r), p) {
+		m.App.InfoLog.Printf("user %d is not allowed to %s", m.App.Session.GetInt(r.Context(), "user_id"), p)
+		writeAPIError(w, http.StatusForbidden, fmt.Sprintf("You are not allowed to %s", p), nil)
+		return false
+	}
+
+	return true
+}
+
+// apiReservationFromURL returns the reservation with the id of the request, answering 404 when there is none
+func (m *Repository) apiReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "Reservation not found", nil)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "Reservation not found", nil)
+		return res, false
+	}
+
+	return res, true
+}
+
+// APIForgeable answers API requests another site could have made with the cookies of a browser:
+// without an API token, a CSRF token or the origin of a widget
+func (m *Repository) APIForgeable(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusForbidden, "Send an API token or a CSRF token, or book from an allowed site", nil)
+}
+
+// APINotFound answers API requests to unknown paths
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "Not found", nil)
+}
+
+// APIMethodNotAllowed answers API requests with a method the path doesn't support
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+}
+
+// apiServerError logs err and answers with a generic error, so database details don't leak
+func (m *Repository) apiServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+	writeAPIError(w, http.StatusInternalServerError, "Internal server error", nil)
+}
+
+// writeAPIError writes the error envelope with status
+func writeAPIError(w http.ResponseWriter, status int, message string, fields map[string][]string) {
+	writeJSON(w, status, apiErrorResponse{Error: apiError{
+		Status:  status,
+		Message: message,
+		Fields:  fields,
+	}})
+}
+
+// writeJSON writes v as JSON with status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// toAPIRoom converts room for the API
+func toAPIRoom(room models.Room) apiRoom {
+	return apiRoom{
+		ID:            room.ID,
+		Name:          room.RoomName,
+		Slug:          room.Slug,
+		Description:   room.Description,
+		Capacity:      room.Capacity,
+		BasePrice:     room.BasePrice,
+		WeekendUplift: room.WeekendUplift,
+	}
+}
+
+// toAPIQuote converts quote for the API
+func toAPIQuote(quote models.Quote) apiQuote {
+	nights := make([]apiNight, 0, len(quote.Nights))
+	for _, n := range quote.Nights {
+		nights = append(nights, apiNight{
+			Date:    n.Date.Format("2006-01-02"),
+			Price:   n.Price,
+			Season:  n.Season,
+			Weekend: n.Weekend,
+		})
+	}
+
+	return apiQuote{Nights: nights, Total: quote.Total}
+}
+
+// toAPIReservation converts res for the API
+func toAPIReservation(res models.Reservation) apiReservation {
+	room := res.Room
+	room.ID = res.RoomID
+
+	return apiReservation{
+		ID:         res.ID,
+		Room:       toAPIRoom(room),
+		FirstName:  res.FirstName,
+		LastName:   res.LastName,
+		Email:      res.Email,
+		Phone:      res.Phone,
+		StartDate:  res.StartDate.Format("2006-01-02"),
+		EndDate:    res.EndDate.Format("2006-01-02"),
+		TotalPrice: res.TotalPrice,
+		Discount:   res.Discount,
+		Processed:  res.Processed == 1,
+		Cancelled:  res.Cancelled == 1,
+	}
+}
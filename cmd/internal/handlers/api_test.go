@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+func TestAPI_Routes(t *testing.T) {
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		expectedStatusCode int
+	}{
+		{"rooms", "GET", "/api/v1/rooms", http.StatusOK},
+		{"availability", "GET", "/api/v1/availability?start=2100-01-04&end=2100-01-06", http.StatusOK},
+		{"unknown-path", "GET", "/api/v1/nothing-here", http.StatusNotFound},
+		{"wrong-method", "DELETE", "/api/v1/rooms", http.StatusMethodNotAllowed},
+	}
+
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, ts.URL+e.url, nil)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+
+		if resp.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("failed %s: expected a JSON response, but got %s", e.name, resp.Header.Get("Content-Type"))
+		}
+	}
+}
+
+func TestAPI_Availability(t *testing.T) {
+	var tests = []struct {
+		name               string
+		query              string
+		expectedStatusCode int
+		expectedField      string
+	}{
+		{"all-rooms", "start=2100-01-04&end=2100-01-06", http.StatusOK, ""},
+		{"one-room", "start=2100-01-04&end=2100-01-06&room=1", http.StatusOK, ""},
+		{"missing-start", "end=2100-01-06", http.StatusUnprocessableEntity, "start"},
+		{"end-before-start", "start=2100-01-06&end=2100-01-04", http.StatusUnprocessableEntity, "end"},
+		{"invalid-room", "start=2100-01-04&end=2100-01-06&room=one", http.StatusUnprocessableEntity, "room"},
+		{"unknown-room", "start=2100-01-04&end=2100-01-06&room=5", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/availability?"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.APIAvailability).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedField != "" {
+			var resp apiErrorResponse
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("failed %s: %s", e.name, err)
+			}
+
+			if len(resp.Error.Fields[e.expectedField]) == 0 {
+				t.Errorf("failed %s: expected an error for %s, but got %v", e.name, e.expectedField, resp.Error.Fields)
+			}
+		}
+	}
+}
+
+func TestAPI_PostReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		contentType        string
+		body               string
+		expectedStatusCode int
+		expectedField      string
+	}{
+		{"valid", "application/json", `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusCreated, ""},
+		{"valid-with-promo-code", "application/json", `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555","promo_code":"save10"}`, http.StatusCreated, ""},
+		{"json-with-charset", "application/json; charset=utf-8", `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusCreated, ""},
+		{"form-encoded", "application/x-www-form-urlencoded", "room_id=1", http.StatusUnsupportedMediaType, ""},
+		{"not-quite-json", "application/jsonp", `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusUnsupportedMediaType, ""},
+		{"invalid-json", "application/json", `{"room_id":`, http.StatusBadRequest, ""},
+		{"unknown-field", "application/json", `{"room_id":1,"price":1}`, http.StatusBadRequest, ""},
+		{"invalid-email", "application/json", `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama","phone":"555-555-5555"}`, http.StatusUnprocessableEntity, "email"},
+		{"past-dates", "application/json", `{"room_id":1,"start_date":"2000-01-04","end_date":"2000-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusUnprocessableEntity, "start_date"},
+		{"unknown-room", "application/json", `{"room_id":5,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusUnprocessableEntity, "room_id"},
+		{"expired-promo-code", "application/json", `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555","promo_code":"EXPIRED"}`, http.StatusUnprocessableEntity, "promo_code"},
+		{"exhausted-promo-code", "application/json", `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555","promo_code":"LASTONE"}`, http.StatusConflict, ""},
+		{"room-taken", "application/json", `{"room_id":1001,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusConflict, ""},
+		{"database-error", "application/json", `{"room_id":1000,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(e.body))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", e.contentType)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.APIPostReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedStatusCode == http.StatusCreated {
+			var resp struct {
+				Data apiReservation `json:"data"`
+			}
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("failed %s: %s", e.name, err)
+			}
+
+			if resp.Data.TotalPrice <= 0 || resp.Data.ManageURL == "" {
+				t.Errorf("failed %s: expected a priced reservation with a manage link, but got %+v", e.name, resp.Data)
+			}
+
+			if rr.Header().Get("Location") == "" {
+				t.Errorf("failed %s: expected the location of the reservation", e.name)
+			}
+		}
+
+		if e.expectedField != "" {
+			var resp apiErrorResponse
+			err := json.Unmarshal(rr.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatalf("failed %s: %s", e.name, err)
+			}
+
+			if len(resp.Error.Fields[e.expectedField]) == 0 {
+				t.Errorf("failed %s: expected an error for %s, but got %v", e.name, e.expectedField, resp.Error.Fields)
+			}
+		}
+	}
+}
+
+func TestAPI_Reservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		loggedIn           bool
+		accessLevel        int
+		expectedStatusCode int
+	}{
+		{"staff", "1", true, models.AccessReadOnly, http.StatusOK},
+		{"not-found", "1000", true, models.AccessReadOnly, http.StatusNotFound},
+		{"invalid-id", "one", true, models.AccessReadOnly, http.StatusNotFound},
+		{"no-role", "1", true, 0, http.StatusForbidden},
+		{"logged-out", "1", false, 0, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/api/v1/reservations/"+e.id, nil)
+		ctx := getCtx(req)
+		if e.loggedIn {
+			session.Put(ctx, "user_id", 1)
+		}
+		session.Put(ctx, "access_level", e.accessLevel)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.APIReservation).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		var resp apiErrorResponse
+		err := json.Unmarshal(rr.Body.Bytes(), &resp)
+		if err != nil {
+			t.Fatalf("failed %s: %s", e.name, err)
+		}
+
+		if e.expectedStatusCode != http.StatusOK && resp.Error.Status != e.expectedStatusCode {
+			t.Errorf("failed %s: expected status %d in the error, but got %d", e.name, e.expectedStatusCode, resp.Error.Status)
+		}
+	}
+}
+
+func TestAPI_TokenScopes(t *testing.T) {
+	manager := models.APIToken{
+		ID:     1,
+		UserID: 1,
+		Scopes: []string{"view-reservations", "edit-reservations", "delete-reservations"},
+		User:   models.User{ID: 1, AccessLevel: models.AccessManager, Active: 1},
+	}
+	readOnly := models.APIToken{
+		ID:     2,
+		UserID: 2,
+		Scopes: []string{"view-reservations"},
+		User:   models.User{ID: 2, AccessLevel: models.AccessOwner, Active: 1},
+	}
+
+	var tests = []struct {
+		name               string
+		method             string
+		url                string
+		token              models.APIToken
+		handler            http.HandlerFunc
+		expectedStatusCode int
+	}{
+		{"list", "GET", "/api/v1/reservations", readOnly, Repo.APIReservations, http.StatusOK},
+		{"process", "POST", "/api/v1/reservations/1/processed", manager, Repo.APIProcessReservation, http.StatusOK},
+		{"process-out-of-scope", "POST", "/api/v1/reservations/1/processed", readOnly, Repo.APIProcessReservation, http.StatusForbidden},
+		{"delete", "DELETE", "/api/v1/reservations/1", manager, Repo.APIDeleteReservation, http.StatusNoContent},
+		{"delete-out-of-scope", "DELETE", "/api/v1/reservations/1", readOnly, Repo.APIDeleteReservation, http.StatusForbidden},
+		{"delete-unknown", "DELETE", "/api/v1/reservations/1000", manager, Repo.APIDeleteReservation, http.StatusNotFound},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest(e.method, e.url, nil)
+		ctx := getCtx(req)
+		ctx = helpers.WithAPIToken(ctx, e.token)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strings.TrimPrefix(strings.TrimSuffix(e.url, "/processed"), "/api/v1/reservations/"))
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+	}
+}
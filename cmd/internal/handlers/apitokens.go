@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+	"github.com/maslow123/bookings/cmd/internal/tokens"
+)
+
+// AdminAPITokens shows the API tokens of the logged in user, with the form to create one
+func (m *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	m.renderAPITokens(w, r, forms.New(nil))
+}
+
+// AdminPostAPIToken creates an API token for the logged in user. The token is shown once, only its hash is stored.
+func (m *Repository) AdminPostAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	// a token can't be given more than its user may do
+	accessLevel := helpers.AccessLevel(r)
+	scopes := r.Form["scopes"]
+	if len(scopes) == 0 {
+		form.Errors.Add("scopes", "Choose at least one scope")
+	}
+	for _, scope := range scopes {
+		if !authz.Can(accessLevel, authz.Permission(scope)) {
+			form.Errors.Add("scopes", fmt.Sprintf("You are not allowed to %s", scope))
+		}
+	}
+
+	if !form.Valid() {
+		m.renderAPITokens(w, r, form)
+		return
+	}
+
+	token, err := tokens.New()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	apiToken := models.APIToken{
+		UserID:    m.App.Session.GetInt(r.Context(), "user_id"),
+		Name:      form.Get("name"),
+		TokenHash: tokens.Hash(token),
+		Scopes:    scopes,
+	}
+
+	apiToken.ID, err = m.DB.InsertAPIToken(r.Context(), apiToken)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "create-api-token", fmt.Sprintf("token %d %q with scopes %v", apiToken.ID, apiToken.Name, apiToken.Scopes))
+
+	m.App.Session.Put(r.Context(), "new_api_token", token)
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIToken revokes an API token of the logged in user. Users who manage users may revoke anyone's token.
+func (m *Repository) AdminRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	apiToken, err := m.DB.GetAPITokenByID(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "API token not found")
+		http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if apiToken.UserID != m.App.Session.GetInt(r.Context(), "user_id") && !m.allowed(w, r, authz.ManageUsers) {
+		return
+	}
+
+	err = m.DB.RevokeAPIToken(r.Context(), apiToken.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.audit(r, "revoke-api-token", fmt.Sprintf("token %d %q of user %d", apiToken.ID, apiToken.Name, apiToken.UserID))
+
+	m.App.Session.Put(r.Context(), "flash", "API token revoked")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
+}
+
+// renderAPITokens displays the API tokens of the logged in user and the form to create one
+func (m *Repository) renderAPITokens(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	apiTokens, err := m.DB.AllAPITokensForUser(r.Context(), m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["tokens"] = apiTokens
+	data["scopes"] = authz.Permissions(helpers.AccessLevel(r))
+
+	stringMap := make(map[string]string)
+	stringMap["new_token"] = m.App.Session.PopString(r.Context(), "new_api_token")
+
+	render.Template(w, r, "admin-api-tokens.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+func TestRepository_AdminAPITokens(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/api-tokens", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 1)
+	session.Put(ctx, "new_api_token", "shown-once")
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminAPITokens).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	for _, expected := range []string{"Channel manager", "shown-once"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected to find %s but did not", expected)
+		}
+	}
+
+	if session.Exists(ctx, "new_api_token") {
+		t.Error("expected the new token to be shown only once")
+	}
+}
+
+func TestRepository_AdminPostAPIToken(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		accessLevel        int
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid", url.Values{"name": {"Channel manager"}, "scopes": {"view-reservations", "edit-reservations"}}, models.AccessFrontDesk, http.StatusSeeOther, ""},
+		{"missing-name", url.Values{"scopes": {"view-reservations"}}, models.AccessFrontDesk, http.StatusOK, "This field cannot be blank"},
+		{"no-scopes", url.Values{"name": {"Channel manager"}}, models.AccessFrontDesk, http.StatusOK, "Choose at least one scope"},
+		{"scope-above-role", url.Values{"name": {"Channel manager"}, "scopes": {"delete-reservations"}}, models.AccessFrontDesk, http.StatusOK, "You are not allowed to delete-reservations"},
+		{"unknown-scope", url.Values{"name": {"Channel manager"}, "scopes": {"fly"}}, models.AccessOwner, http.StatusOK, "You are not allowed to fly"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/api-tokens", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "access_level", e.accessLevel)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostAPIToken).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+
+		if e.expectedStatusCode == http.StatusSeeOther && len(session.GetString(ctx, "new_api_token")) != 43 {
+			t.Errorf("failed %s: expected the new token in the session", e.name)
+		}
+	}
+}
+
+func TestRepository_AdminRevokeAPIToken(t *testing.T) {
+	var tests = []struct {
+		name        string
+		id          string
+		accessLevel int
+		expected    int
+	}{
+		{"own-token", "1", models.AccessReadOnly, http.StatusSeeOther},
+		{"owner-revokes-other-token", "2", models.AccessOwner, http.StatusSeeOther},
+		{"manager-revokes-other-token", "2", models.AccessManager, http.StatusForbidden},
+		{"unknown-token", "99", models.AccessOwner, http.StatusSeeOther},
+		{"not-a-number", "abc", models.AccessOwner, http.StatusSeeOther},
+		{"database-error", "1000", models.AccessOwner, http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/revoke-api-token/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "access_level", e.accessLevel)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminRevokeAPIToken).ServeHTTP(rr, req)
+
+		if rr.Code != e.expected {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expected, rr.Code)
+		}
+	}
+}
+
+func TestRepository_AdminAuditLog(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/audit-log", nil)
+	req = req.WithContext(getCtx(req))
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminAuditLog).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), "Channel manager") {
+		t.Error("expected to find the token that made the change")
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+)
+
+// auditLogLength is how many of the latest audit log entries are shown
+const auditLogLength = 200
+
+// AdminAuditLog shows the latest changes, with who made them and with which API token
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ViewAuditLog) {
+		return
+	}
+
+	entries, err := m.DB.AllAuditEntries(r.Context(), auditLogLength)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+
+	render.Template(w, r, "admin-audit-log.page.htm", &config.TemplateData{
+		Data: data,
+	})
+}
+
+// audit records that the user of the request made a change, and with which API token if they used one.
+// Changes made by guests are not recorded.
+func (m *Repository) audit(r *http.Request, action, details string) {
+	entry := models.AuditEntry{
+		Action:  action,
+		Details: details,
+	}
+
+	if token, ok := helpers.APIToken(r); ok {
+		entry.UserID = token.UserID
+		entry.APITokenID = token.ID
+	} else {
+		entry.UserID = m.App.Session.GetInt(r.Context(), "user_id")
+	}
+
+	if entry.UserID == 0 {
+		return
+	}
+
+	err := m.DB.InsertAuditEntry(r.Context(), entry)
+	if err != nil {
+		m.App.ErrorLog.Println("can't write audit log:", err)
+	}
+}
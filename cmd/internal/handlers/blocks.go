@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// AdminShowBlock shows an owner block, with the forms to change it and to unblock some of its nights
+func (m *Repository) AdminShowBlock(w http.ResponseWriter, r *http.Request) {
+	block, ok := m.blockFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	form := forms.New(url.Values{
+		"start_date": {block.StartDate.Format("2006-01-02")},
+		"end_date":   {block.EndDate.AddDate(0, 0, -1).Format("2006-01-02")},
+		"note":       {block.Note},
+	})
+
+	renderBlock(w, r, block, form)
+}
+
+// AdminPostShowBlock changes the nights and the note of an owner block
+func (m *Repository) AdminPostShowBlock(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.EditReservations) {
+		return
+	}
+
+	block, ok := m.blockFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	changed, form := blockFromForm(r)
+	if !form.Valid() {
+		renderBlock(w, r, block, form)
+		return
+	}
+
+	block.StartDate = changed.StartDate
+	block.EndDate = changed.EndDate
+	block.Note = changed.Note
+
+	err = m.DB.UpdateBlock(r.Context(), block)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		form.Errors.Add("end_date", "These nights overlap a reservation or another block of the room")
+		renderBlock(w, r, block, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// AdminUnblockNights frees some nights of an owner block, splitting it when they are in its middle
+func (m *Repository) AdminUnblockNights(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.EditReservations) {
+		return
+	}
+
+	block, ok := m.blockFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	start, end := parseNights(form, "unblock_start", "unblock_end")
+	if form.Valid() && (!start.Before(block.EndDate) || !end.After(block.StartDate)) {
+		form.Errors.Add("unblock_start", "These nights are not blocked")
+	}
+
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", firstError(form, "unblock_start", "unblock_end"))
+		http.Redirect(w, r, fmt.Sprintf("/admin/blocks/%d", block.ID), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UnblockNights(r.Context(), block.ID, start, end)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Nights unblocked")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// AdminDeleteBlock deletes an owner block, unblocking all its nights
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.EditReservations) {
+		return
+	}
+
+	block, ok := m.blockFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteBlock(r.Context(), block.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block deleted")
+	http.Redirect(w, r, blockCalendarURL(block), http.StatusSeeOther)
+}
+
+// blockFromURL loads the owner block of the id url parameter, responding with not found when there is none
+func (m *Repository) blockFromURL(w http.ResponseWriter, r *http.Request) (models.RoomRestriction, bool) {
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	block, err := m.DB.GetBlockByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return block, false
+	}
+
+	return block, true
+}
+
+// blockFromForm builds the nights and the note of a block from the posted form and validates them.
+// The form has the first and the last night, the block ends the morning after the last night.
+func blockFromForm(r *http.Request) (models.RoomRestriction, *forms.Form) {
+	form := forms.New(r.PostForm)
+	start, end := parseNights(form, "start_date", "end_date")
+
+	if form.Get("note") == "" {
+		form.Errors.Add("note", "Enter why the nights are blocked")
+	}
+
+	block := models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RestrictionID: models.RestrictionOwnerBlock,
+		Note:          form.Get("note"),
+	}
+
+	return block, form
+}
+
+// parseNights reads the first and the last night of a range from the startField and endField of form,
+// returning the first night and the morning after the last night
+func parseNights(form *forms.Form, startField, endField string) (time.Time, time.Time) {
+	layout := "2006-01-02"
+
+	start, err := time.Parse(layout, form.Get(startField))
+	if err != nil {
+		form.Errors.Add(startField, "Enter the first night")
+	}
+
+	last, err := time.Parse(layout, form.Get(endField))
+	if err != nil {
+		form.Errors.Add(endField, "Enter the last night")
+	} else if form.Errors.Get(startField) == "" && last.Before(start) {
+		form.Errors.Add(endField, "The last night must not be before the first night")
+	}
+
+	return start, last.AddDate(0, 0, 1)
+}
+
+// firstError returns the first error of form, looking at fields in order
+func firstError(form *forms.Form, fields ...string) string {
+	for _, field := range fields {
+		if msg := form.Errors.Get(field); msg != "" {
+			return msg
+		}
+	}
+
+	return ""
+}
+
+// blockCalendarURL returns the reservations calendar of the month in which block starts
+func blockCalendarURL(block models.RoomRestriction) string {
+	return fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", block.StartDate.Year(), block.StartDate.Month())
+}
+
+// renderBlock displays an owner block with its forms
+func renderBlock(w http.ResponseWriter, r *http.Request, block models.RoomRestriction, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["block"] = block
+
+	stringMap := make(map[string]string)
+	stringMap["calendar_url"] = blockCalendarURL(block)
+	stringMap["last_night"] = block.EndDate.AddDate(0, 0, -1).Format("2006-01-02")
+	stringMap["first_night"] = block.StartDate.Format("2006-01-02")
+
+	render.Template(w, r, "admin-block.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRepository_AdminPostReservationsCalendar(t *testing.T) {
+	var tests = []struct {
+		name          string
+		postedData    url.Values
+		expectedFlash string
+		expectedError string
+	}{
+		{"valid", url.Values{"room_id": {"1"}, "start_date": {"2100-06-01"}, "end_date": {"2100-06-30"}, "note": {"Maintenance"}}, "Nights blocked", ""},
+		{"one-night", url.Values{"room_id": {"1"}, "start_date": {"2100-06-01"}, "end_date": {"2100-06-01"}, "note": {"Maintenance"}}, "Nights blocked", ""},
+		{"missing-room", url.Values{"start_date": {"2100-06-01"}, "end_date": {"2100-06-30"}, "note": {"Maintenance"}}, "", "Choose a room"},
+		{"missing-note", url.Values{"room_id": {"1"}, "start_date": {"2100-06-01"}, "end_date": {"2100-06-30"}}, "", "Enter why the nights are blocked"},
+		{"end-before-start", url.Values{"room_id": {"1"}, "start_date": {"2100-06-30"}, "end_date": {"2100-06-01"}, "note": {"Maintenance"}}, "", "The last night must not be before the first night"},
+		{"overlap", url.Values{"room_id": {"1001"}, "start_date": {"2100-06-01"}, "end_date": {"2100-06-30"}, "note": {"Maintenance"}}, "", "These nights overlap a reservation or another block of the room"},
+	}
+
+	for _, e := range tests {
+		e.postedData.Set("y", "2100")
+		e.postedData.Set("m", "6")
+
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostReservationsCalendar).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if location := rr.Header().Get("Location"); location != "/admin/reservations-calendar?y=2100&m=6" {
+			t.Errorf("failed %s: expected to go back to the calendar, but got %s", e.name, location)
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+
+		if errMsg := session.PopString(ctx, "error"); errMsg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, errMsg)
+		}
+	}
+}
+
+func TestRepository_AdminShowBlock(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"found", "2", http.StatusOK, `value="Painting the walls"`},
+		{"not-found", "1000", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/blocks/"+e.id, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminShowBlock).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		for _, expected := range []string{e.expectedHTML, `value="2100-06-07"`, "Admin User"} {
+			if e.expectedStatusCode == http.StatusOK && !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, expected)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminPostShowBlock(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid", url.Values{"start_date": {"2100-06-01"}, "end_date": {"2100-06-10"}, "note": {"Painting the walls"}}, http.StatusSeeOther, ""},
+		{"invalid-date", url.Values{"start_date": {"June"}, "end_date": {"2100-06-10"}, "note": {"Painting the walls"}}, http.StatusOK, "Enter the first night"},
+		{"overlap", url.Values{"start_date": {"2100-06-01"}, "end_date": {"2101-01-10"}, "note": {"Painting the walls"}}, http.StatusOK, "These nights overlap"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/2", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "2")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostShowBlock).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminUnblockNights(t *testing.T) {
+	var tests = []struct {
+		name             string
+		postedData       url.Values
+		expectedLocation string
+		expectedError    string
+	}{
+		{"middle", url.Values{"unblock_start": {"2100-06-03"}, "unblock_end": {"2100-06-04"}}, "/admin/reservations-calendar?y=2100&m=6", ""},
+		{"whole-block", url.Values{"unblock_start": {"2100-06-01"}, "unblock_end": {"2100-06-07"}}, "/admin/reservations-calendar?y=2100&m=6", ""},
+		{"not-blocked", url.Values{"unblock_start": {"2100-07-01"}, "unblock_end": {"2100-07-04"}}, "/admin/blocks/2", "These nights are not blocked"},
+		{"missing-end", url.Values{"unblock_start": {"2100-06-03"}}, "/admin/blocks/2", "Enter the last night"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/2/unblock", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "2")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminUnblockNights).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if location := rr.Header().Get("Location"); location != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location)
+		}
+
+		if errMsg := session.PopString(ctx, "error"); errMsg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, errMsg)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/ical"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/signedlink"
+)
+
+// calendarSubject prefixes the feed in calendar feed links, so tokens signed for anything else can't be used
+const calendarSubject = "calendar:"
+
+// calendarLinkLifetime is how long a calendar feed link works. Calendar apps keep polling the link
+// they subscribed to, so it is long; changing the link secret revokes all links at once.
+const calendarLinkLifetime = 10 * 365 * 24 * time.Hour
+
+// RoomCalendarFeed serves the reservations and blocks of a room as an iCalendar feed
+func (m *Repository) RoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	if !m.calendarFeedAllowed(w, r, fmt.Sprintf("rooms/%d", id)) {
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	events, err := m.calendarEvents(r.Context(), room, false)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.writeCalendar(w, ical.Calendar{
+		Name:   fmt.Sprintf("%s - Fort Smythe", room.RoomName),
+		Events: events,
+	})
+}
+
+// AllRoomsCalendarFeed serves the reservations and owner blocks of every room as one iCalendar feed
+func (m *Repository) AllRoomsCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	if !m.calendarFeedAllowed(w, r, "rooms") {
+		return
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var events []ical.Event
+	for _, room := range rooms {
+		roomEvents, err := m.calendarEvents(r.Context(), room, true)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		events = append(events, roomEvents...)
+	}
+
+	m.writeCalendar(w, ical.Calendar{
+		Name:   "Fort Smythe",
+		Events: events,
+	})
+}
+
+// calendarFeedPath returns the path of the calendar feed, such as "rooms" or "rooms/1", with a token to read it
+func (m *Repository) calendarFeedPath(feed string) string {
+	token := signedlink.Sign(m.App.LinkSecret, calendarSubject+feed, time.Now().Add(calendarLinkLifetime))
+
+	return fmt.Sprintf("/calendar/%s.ics?token=%s", feed, url.QueryEscape(token))
+}
+
+// calendarFeedAllowed reports whether the token of the request was signed for feed, refusing the request when not
+func (m *Repository) calendarFeedAllowed(w http.ResponseWriter, r *http.Request, feed string) bool {
+	subject, err := signedlink.Verify(m.App.LinkSecret, r.URL.Query().Get("token"), time.Now())
+	if err != nil || subject != calendarSubject+feed {
+		helpers.ClientError(w, http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+// calendarEvents returns the reservations and owner blocks of room from a month ago to two years ahead.
+// withRoomName puts the name of the room in the summary, for feeds with several rooms.
+func (m *Repository) calendarEvents(ctx context.Context, room models.Room, withRoomName bool) ([]ical.Event, error) {
+	now := today()
+	restrictions, err := m.DB.GetRestrictionsForRoomByDate(ctx, room.ID, now.AddDate(0, -1, 0), now.AddDate(2, 0, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	host := m.calendarHost()
+
+	events := make([]ical.Event, 0, len(restrictions))
+	for _, rr := range restrictions {
+		e := ical.Event{
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+			Updated: rr.UpdatedAt,
+		}
+
+		// the uid of a reservation follows it when its dates change
+		if rr.ReservationID > 0 {
+			e.UID = fmt.Sprintf("reservation-%d@%s", rr.ReservationID, host)
+			e.Summary = fmt.Sprintf("Reserved: %s %s", rr.Reservation.FirstName, rr.Reservation.LastName)
+			e.URL = fmt.Sprintf("%s/admin/reservations/all/%d/show", m.App.BaseURL, rr.ReservationID)
+		} else if rr.RestrictionID == models.RestrictionExternal {
+			e.UID = fmt.Sprintf("restriction-%d@%s", rr.ID, host)
+			e.Summary = "Booked elsewhere"
+		} else {
+			e.UID = fmt.Sprintf("restriction-%d@%s", rr.ID, host)
+			e.Summary = "Blocked by owner"
+			e.Description = rr.Note
+		}
+
+		if withRoomName {
+			e.Summary = room.RoomName + " - " + e.Summary
+		}
+
+		events = append(events, e)
+	}
+
+	return events, nil
+}
+
+// writeCalendar sends cal as an iCalendar file
+func (m *Repository) writeCalendar(w http.ResponseWriter, cal ical.Calendar) {
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	err := cal.Write(w)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// calendarHost returns the host of the site, which makes the uids of events unique
+func (m *Repository) calendarHost() string {
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+
+	return "localhost"
+}
+
+// stayInvite returns the stay of res as an iCalendar event, attached to the emails giving the guest
+// its dates so that calendar apps can add it. The event keeps its uid when the dates change, so that
+// the calendar entry moves with them.
+func (m *Repository) stayInvite(res models.Reservation, manageURL string) (models.Attachment, error) {
+	cal := ical.Calendar{
+		Events: []ical.Event{
+			{
+				UID:   fmt.Sprintf("stay-%d@%s", res.ID, m.calendarHost()),
+				Start: res.StartDate,
+				// the guest is with us on the day they check out too
+				End:     res.EndDate.AddDate(0, 0, 1),
+				Summary: "Stay at Fort Smythe: " + res.Room.RoomName,
+				Description: fmt.Sprintf("%s at Fort Smythe\nCheck-in: %s\nCheck-out: %s\nChange or cancel your reservation at %s",
+					res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"), manageURL),
+				URL:     manageURL,
+				Status:  "CONFIRMED",
+				Updated: time.Now(),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := cal.Write(&buf)
+	if err != nil {
+		return models.Attachment{}, err
+	}
+
+	return models.Attachment{Name: "reservation.ics", Content: buf.Bytes()}, nil
+}
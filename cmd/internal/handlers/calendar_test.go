@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/signedlink"
+)
+
+func TestRepository_CalendarFeeds(t *testing.T) {
+	expired := signedlink.Sign(app.LinkSecret, calendarSubject+"rooms/1", time.Now().Add(-time.Hour))
+
+	var tests = []struct {
+		name               string
+		url                string
+		expectedStatusCode int
+		expectedICS        []string
+	}{
+		{"room", Repo.calendarFeedPath("rooms/1"), http.StatusOK, []string{
+			"X-WR-CALNAME:",
+			"UID:reservation-7@localhost",
+			"SUMMARY:Reserved: John Smith",
+			"UID:restriction-2@localhost",
+			"SUMMARY:Blocked by owner",
+		}},
+		{"all-rooms", Repo.calendarFeedPath("rooms"), http.StatusOK, []string{"X-WR-CALNAME:Fort Smythe"}},
+		{"token-of-other-room", strings.Replace(Repo.calendarFeedPath("rooms/2"), "rooms/2.ics", "rooms/1.ics", 1), http.StatusForbidden, nil},
+		{"token-of-one-room-for-all-rooms", strings.Replace(Repo.calendarFeedPath("rooms/1"), "rooms/1.ics", "rooms.ics", 1), http.StatusForbidden, nil},
+		{"expired-token", "/calendar/rooms/1.ics?token=" + expired, http.StatusForbidden, nil},
+		{"no-token", "/calendar/rooms/1.ics", http.StatusForbidden, nil},
+		{"database-error", Repo.calendarFeedPath("rooms/1000"), http.StatusInternalServerError, nil},
+	}
+
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	for _, e := range tests {
+		resp, err := ts.Client().Get(ts.URL + e.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+			continue
+		}
+
+		if e.expectedStatusCode != http.StatusOK {
+			continue
+		}
+
+		if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+			t.Errorf("failed %s: expected a calendar, but got %s", e.name, resp.Header.Get("Content-Type"))
+		}
+
+		for _, expected := range e.expectedICS {
+			if !strings.Contains(string(body), expected) {
+				t.Errorf("failed %s: expected to find %s in\n%s", e.name, expected, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/calsync"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+)
+
+// AdminRoomCalendars shows the external calendars imported into a room
+func (m *Repository) AdminRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRoomCalendars(w, r, id, forms.New(nil))
+}
+
+// AdminPostRoomCalendar adds an external calendar to a room and imports it right away.
+// Calendars without a url are imported by uploading their file.
+func (m *Repository) AdminPostRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	source := models.CalendarSource{
+		RoomID: id,
+		Name:   form.Get("name"),
+		URL:    strings.TrimSpace(form.Get("url")),
+	}
+	if source.URL != "" {
+		err = calsync.CheckURL(source.URL, m.App.CalendarFiles)
+		if err != nil {
+			form.Errors.Add("url", "Enter the http or https address of the calendar")
+		}
+	}
+
+	if !form.Valid() {
+		m.renderRoomCalendars(w, r, id, form)
+		return
+	}
+
+	source.ID, err = m.DB.InsertCalendarSource(r.Context(), source)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if source.URL == "" {
+		m.App.Session.Put(r.Context(), "flash", "Calendar added, upload its file to import it")
+	} else {
+		result, err := calsync.New(m.DB, m.App).Sync(r.Context(), source)
+		m.putSyncResult(r, result, err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", id), http.StatusSeeOther)
+}
+
+// AdminSyncRoomCalendar imports an external calendar of a room now, rather than waiting for the next sync
+func (m *Repository) AdminSyncRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	source, ok := m.calendarSourceFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	if source.URL == "" {
+		m.App.Session.Put(r.Context(), "error", "This calendar has no address, upload its file instead")
+	} else {
+		result, err := calsync.New(m.DB, m.App).Sync(r.Context(), source)
+		m.putSyncResult(r, result, err)
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", source.RoomID), http.StatusSeeOther)
+}
+
+// AdminUploadRoomCalendar imports an uploaded .ics file into an external calendar of a room
+func (m *Repository) AdminUploadRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	source, ok := m.calendarSourceFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, calsync.MaxCalendarSize+1024*1024)
+	file, _, err := r.FormFile("ics")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose the .ics file to upload")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", source.RoomID), http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	result, err := calsync.New(m.DB, m.App).SyncData(r.Context(), source, file)
+	m.putSyncResult(r, result, err)
+
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", source.RoomID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomCalendar deletes an external calendar of a room, with the nights imported from it
+func (m *Repository) AdminDeleteRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	source, ok := m.calendarSourceFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteCalendarSource(r.Context(), source.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/calendars", source.RoomID), http.StatusSeeOther)
+}
+
+// calendarSourceFromURL loads the calendar source of the roomID and id url parameters, responding with
+// not found when there is none
+func (m *Repository) calendarSourceFromURL(w http.ResponseWriter, r *http.Request) (models.CalendarSource, bool) {
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "roomID"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	source, err := m.DB.GetCalendarSourceByID(r.Context(), id)
+	if err != nil || source.RoomID != roomID {
+		helpers.ClientError(w, http.StatusNotFound)
+		return source, false
+	}
+
+	return source, true
+}
+
+// putSyncResult tells the user how an import went
+func (m *Repository) putSyncResult(r *http.Request, result calsync.Result, err error) {
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The calendar could not be imported: %s", err))
+		return
+	}
+
+	if len(result.Conflicts) > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("These events overlap other bookings of the room and were not imported: %s",
+			strings.Join(result.Conflicts, ", ")))
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Calendar imported: %d added, %d changed, %d removed",
+		result.Added, result.Updated, result.Removed))
+}
+
+// renderRoomCalendars displays the external calendars of a room and the form to add one
+func (m *Repository) renderRoomCalendars(w http.ResponseWriter, r *http.Request, roomID int, form *forms.Form) {
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	sources, err := m.DB.AllCalendarSourcesForRoom(r.Context(), roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["sources"] = sources
+
+	render.Template(w, r, "admin-room-calendars.page.htm", &config.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+// testCalendar is an external calendar with one booking
+const testCalendar = "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VEVENT\r\nUID:new@example.com\r\n" +
+	"DTSTART;VALUE=DATE:21000620\r\nDTEND;VALUE=DATE:21000622\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+
+func TestRepository_AdminRoomCalendars(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/rooms/1/calendars", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminRoomCalendars).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	for _, expected := range []string{"Airbnb", "Uploads", "/admin/upload-calendar/1/3"} {
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("expected to find %s but did not", expected)
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomCalendar(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte(testCalendar))
+	}))
+	defer ts.Close()
+
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+		expectedFlash      string
+	}{
+		{"valid", url.Values{"name": {"Airbnb"}, "url": {ts.URL + "/room.ics"}}, http.StatusSeeOther, "", "Calendar imported: 1 added, 0 changed, 0 removed"},
+		{"upload-only", url.Values{"name": {"Vrbo"}}, http.StatusSeeOther, "", "Calendar added, upload its file to import it"},
+		{"missing-name", url.Values{"url": {ts.URL + "/room.ics"}}, http.StatusOK, "This field cannot be blank", ""},
+		{"file-url", url.Values{"name": {"Airbnb"}, "url": {"file:///etc/passwd"}}, http.StatusOK, "Enter the http or https address of the calendar", ""},
+		{"other-scheme", url.Values{"name": {"Airbnb"}, "url": {"ftp://example.com/room.ics"}}, http.StatusOK, "Enter the http or https address of the calendar", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/calendars", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostRoomCalendar).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+	}
+}
+
+func TestRepository_AdminUploadRoomCalendar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		id                 string
+		file               string
+		expectedStatusCode int
+		expectedFlash      string
+		expectedError      string
+	}{
+		{"valid", "1", "3", testCalendar, http.StatusSeeOther, "Calendar imported: 1 added, 0 changed, 0 removed", ""},
+		{"conflict", "1001", "2", testCalendar, http.StatusSeeOther, "Calendar imported: 0 added, 0 changed, 0 removed", "overlap other bookings"},
+		{"not-a-calendar", "1", "3", "<html></html>", http.StatusSeeOther, "", "could not be imported"},
+		{"no-file", "1", "3", "", http.StatusSeeOther, "", "Choose the .ics file to upload"},
+		{"other-room", "2", "3", testCalendar, http.StatusNotFound, "", ""},
+		{"unknown-calendar", "1", "99", testCalendar, http.StatusNotFound, "", ""},
+	}
+
+	for _, e := range tests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		if e.file != "" {
+			fw, _ := mw.CreateFormFile("ics", "room.ics")
+			fw.Write([]byte(e.file))
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/upload-calendar/"+e.roomID+"/"+e.id, &body)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("roomID", e.roomID)
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminUploadRoomCalendar).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+
+		if errMsg := session.PopString(ctx, "error"); !strings.Contains(errMsg, e.expectedError) || (e.expectedError == "" && errMsg != "") {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, errMsg)
+		}
+	}
+}
+
+func TestRepository_AdminSyncRoomCalendar(t *testing.T) {
+	var tests = []struct {
+		name               string
+		roomID             string
+		id                 string
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{"unreachable", "1001", "2", http.StatusSeeOther, "could not be imported"},
+		{"upload-only", "1", "3", http.StatusSeeOther, "upload its file instead"},
+		{"other-room", "2", "3", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/sync-calendar/"+e.roomID+"/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("roomID", e.roomID)
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminSyncRoomCalendar).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if errMsg := session.PopString(ctx, "error"); !strings.Contains(errMsg, e.expectedError) {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, errMsg)
+		}
+	}
+}
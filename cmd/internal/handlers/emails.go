@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+)
+
+// emailListLength is how many of the latest emails of the outbox are shown
+const emailListLength = 200
+
+// AdminEmails shows the latest emails of the outbox, the ones given up on unless the status
+// query parameter asks for others
+func (m *Repository) AdminEmails(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageEmails) {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case models.EmailPending, models.EmailSent, "all":
+	default:
+		status = models.EmailDead
+	}
+
+	filter := status
+	if filter == "all" {
+		filter = ""
+	}
+
+	emails, err := m.DB.AllOutboxEmails(r.Context(), filter, emailListLength)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["emails"] = emails
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+
+	render.Template(w, r, "admin-emails.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminResendEmail puts an email that was given up on back in the outbox, to be tried again
+func (m *Repository) AdminResendEmail(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageEmails) {
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.ResendEmail(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "Only emails that were given up on can be resent")
+		http.Redirect(w, r, "/admin/emails", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Email queued to be sent again")
+	http.Redirect(w, r, "/admin/emails", http.StatusSeeOther)
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRepository_AdminEmails(t *testing.T) {
+	var tests = []struct {
+		name        string
+		url         string
+		expected    []string
+		notExpected string
+	}{
+		{"failed", "/admin/emails", []string{"john@smith.com", "connection refused", "/admin/resend-email/1/do"}, "jane@smith.com"},
+		{"sent", "/admin/emails?status=sent", []string{"jane@smith.com", "Sent 2100-06-01"}, "john@smith.com"},
+		{"all", "/admin/emails?status=all", []string{"john@smith.com", "jane@smith.com"}, ""},
+		{"unknown-status", "/admin/emails?status=lost", []string{"john@smith.com"}, "jane@smith.com"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminEmails).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		for _, expected := range e.expected {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, expected)
+			}
+		}
+
+		if e.notExpected != "" && strings.Contains(rr.Body.String(), e.notExpected) {
+			t.Errorf("failed %s: did not expect to find %s", e.name, e.notExpected)
+		}
+	}
+}
+
+func TestRepository_AdminResendEmail(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		expectedFlash string
+		expectedError string
+	}{
+		{"dead", "1", "Email queued to be sent again", ""},
+		{"sent", "2", "", "Only emails that were given up on can be resent"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/resend-email/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminResendEmail).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q, but got %q", e.name, e.expectedFlash, flash)
+		}
+
+		if errMsg := session.PopString(ctx, "error"); errMsg != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, errMsg)
+		}
+	}
+}
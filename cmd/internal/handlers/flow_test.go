@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository/dbrepo"
+)
+
+// flow runs requests against handlers backed by an in-memory database, all in the same session
+type flow struct {
+	t    *testing.T
+	repo *Repository
+	db   *dbrepo.MemoryDBRepo
+	ctx  context.Context // holds the session
+}
+
+func newFlow(t *testing.T) *flow {
+	db := dbrepo.NewMemoryRepo(&app)
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	return &flow{t: t, repo: NewRepoWithDB(&app, db), db: db, ctx: getCtx(req)}
+}
+
+// do runs handler for a request to target posting form, when it is not nil, with the url parameters
+// params given as name and value pairs
+func (f *flow) do(handler http.HandlerFunc, target string, form url.Values, params ...string) *httptest.ResponseRecorder {
+	method := "GET"
+	if form != nil {
+		method = "POST"
+	}
+
+	req, _ := http.NewRequest(method, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rctx := chi.NewRouteContext()
+	for i := 0; i+1 < len(params); i += 2 {
+		rctx.URLParams.Add(params[i], params[i+1])
+	}
+	req = req.WithContext(context.WithValue(f.ctx, chi.RouteCtxKey, rctx))
+
+	// nosurf parses the form of every request in production
+	_ = req.ParseForm()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	return rr
+}
+
+// expectRedirect fails the test unless rr redirects to location
+func (f *flow) expectRedirect(step string, rr *httptest.ResponseRecorder, location string) {
+	f.t.Helper()
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != location {
+		f.t.Fatalf("failed %s: expected a redirect to %s, but got %d to %q", step, location, rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func search(start, end string) url.Values {
+	return url.Values{"start": {start}, "end": {end}}
+}
+
+func booking(roomID, start, end, firstName string) url.Values {
+	return url.Values{
+		"room_id":    {roomID},
+		"start_date": {start},
+		"end_date":   {end},
+		"first_name": {firstName},
+		"last_name":  {"Smith"},
+		"email":      {strings.ToLower(firstName) + "@smith.com"},
+		"phone":      {"555-555-5555"},
+	}
+}
+
+func TestFlow_SearchBookProcessDelete(t *testing.T) {
+	f := newFlow(t)
+	ctx := context.Background()
+
+	rr := f.do(f.repo.PostAvailability, "/search-availability", search("2100-06-01", "2100-06-03"))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "/choose-room/1") || !strings.Contains(rr.Body.String(), "/choose-room/2") {
+		t.Fatalf("expected both rooms to be available, but got %d", rr.Code)
+	}
+
+	f.expectRedirect("choose", f.do(f.repo.ChooseRoom, "/choose-room/1", nil, "id", "1"), "/make-reservation")
+
+	if rr := f.do(f.repo.Reservation, "/make-reservation", nil); rr.Code != http.StatusOK {
+		t.Fatalf("expected the reservation form, but got %d", rr.Code)
+	}
+
+	f.expectRedirect("book", f.do(f.repo.PostReservation, "/make-reservation", booking("1", "2100-06-01", "2100-06-03", "John")), "/reservation-summary")
+
+	reservations, _ := f.db.AllNewReservations(ctx)
+	if len(reservations) != 1 || reservations[0].FirstName != "John" || reservations[0].TotalPrice == 0 {
+		t.Fatalf("expected John's reservation to be new and priced, but got %v", reservations)
+	}
+	id := reservations[0].ID
+
+	// the guest and the staff are told
+	emails, _ := f.db.AllOutboxEmails(ctx, models.EmailPending, 10)
+	if len(emails) != 2 {
+		t.Errorf("expected the confirmation and the staff notification in the outbox, but got %d emails", len(emails))
+	}
+
+	rr = f.do(f.repo.PostAvailability, "/search-availability", search("2100-06-02", "2100-06-04"))
+	if strings.Contains(rr.Body.String(), "/choose-room/1") || !strings.Contains(rr.Body.String(), "/choose-room/2") {
+		t.Error("expected only room 2 to be available once room 1 is booked")
+	}
+
+	// someone who searched before John booked can't take the same nights
+	f.expectRedirect("book-taken", f.do(f.repo.PostReservation, "/make-reservation", booking("1", "2100-06-02", "2100-06-04", "Jane")), "/search-availability")
+
+	if all, _ := f.db.AllReservations(ctx); len(all) != 1 {
+		t.Errorf("expected only John's reservation, but got %v", all)
+	}
+
+	f.expectRedirect("process", f.do(f.repo.AdminProcessReservation, "/admin/process-reservation/new/1/do", nil, "src", "new", "id", "1"), "/admin/reservations-new")
+
+	if res, _ := f.db.GetReservationByID(ctx, id); res.Processed != 1 {
+		t.Error("expected the reservation to be processed")
+	}
+	if reservations, _ := f.db.AllNewReservations(ctx); len(reservations) != 0 {
+		t.Errorf("expected no new reservations left, but got %v", reservations)
+	}
+
+	f.expectRedirect("delete", f.do(f.repo.AdminDeleteReservation, "/admin/delete-reservation/all/1/do", nil, "src", "all", "id", "1"), "/admin/reservations-all")
+
+	if _, err := f.db.GetReservationByID(ctx, id); err == nil {
+		t.Error("expected the reservation to be deleted")
+	}
+
+	rr = f.do(f.repo.PostAvailability, "/search-availability", search("2100-06-01", "2100-06-03"))
+	if !strings.Contains(rr.Body.String(), "/choose-room/1") {
+		t.Error("expected room 1 to be available again once its reservation is deleted")
+	}
+}
+
+func TestFlow_BookChangeDates(t *testing.T) {
+	f := newFlow(t)
+	ctx := context.Background()
+
+	f.do(f.repo.PostAvailability, "/search-availability", search("2100-06-01", "2100-06-03"))
+	f.expectRedirect("choose", f.do(f.repo.ChooseRoom, "/choose-room/1", nil, "id", "1"), "/make-reservation")
+	f.expectRedirect("book", f.do(f.repo.PostReservation, "/make-reservation", booking("1", "2100-06-01", "2100-06-03", "John")), "/reservation-summary")
+
+	reservations, _ := f.db.AllNewReservations(ctx)
+	if len(reservations) != 1 {
+		t.Fatalf("expected John's reservation, but got %v", reservations)
+	}
+	res := reservations[0]
+
+	path := f.repo.managePath(res)
+	token := strings.TrimPrefix(path, "/manage/")
+	dates := url.Values{"start_date": {"2100-06-10"}, "end_date": {"2100-06-12"}}
+
+	rr := f.do(f.repo.PostManageReservation, path, dates, "token", token)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the dates to be changed, but got %d", rr.Code)
+	}
+
+	// the guest and the staff are told of the booking, then of the change
+	emails, _ := f.db.AllOutboxEmails(ctx, models.EmailPending, 10)
+	if len(emails) != 4 {
+		t.Fatalf("expected the booking and the change emails in the outbox, but got %d emails", len(emails))
+	}
+
+	changed := 0
+	for _, e := range emails {
+		if e.To == "me@here.com" && strings.HasPrefix(e.Subject, "Reservation Changed: John Smith") {
+			changed++
+		}
+	}
+	if changed != 1 {
+		t.Errorf("expected the staff to be told of the change, but got %v", emails)
+	}
+}
+
+func TestFlow_Login(t *testing.T) {
+	f := newFlow(t)
+
+	id, err := f.db.SeedUser(models.User{FirstName: "Desk", Email: "desk@here.ca", AccessLevel: models.AccessFrontDesk}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		password string
+		expected string
+	}{
+		{"wrong-password", "secret", "/user/login"},
+		{"valid", "password", "/"},
+	}
+
+	for _, e := range tests {
+		rr := f.do(f.repo.PostShowLogin, "/user/login", url.Values{"email": {"desk@here.ca"}, "password": {e.password}})
+		if loc := rr.Header().Get("Location"); loc != e.expected {
+			t.Errorf("failed %s: expected a redirect to %s, but got %q", e.name, e.expected, loc)
+		}
+	}
+
+	if session.GetInt(f.ctx, "user_id") != id || session.GetInt(f.ctx, "access_level") != models.AccessFrontDesk {
+		t.Error("expected the user and their access level in the session")
+	}
+}
+
+func TestFlow_DatabaseDown(t *testing.T) {
+	f := newFlow(t)
+	down := errors.New("database down")
+
+	f.db.Fail("SearchAvailabilityForAllRooms", down)
+	if rr := f.do(f.repo.PostAvailability, "/search-availability", search("2100-06-01", "2100-06-03")); rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected searching to fail, but got %d", rr.Code)
+	}
+
+	f.db.Fail("CreateBooking", down)
+	f.expectRedirect("book", f.do(f.repo.PostReservation, "/make-reservation", booking("1", "2100-06-01", "2100-06-03", "John")), "/")
+
+	if msg := session.GetString(f.ctx, "error"); msg != "can't save reservation into database!" {
+		t.Errorf("expected the guest to be told, but got %q", msg)
+	}
+
+	if all, _ := f.db.AllReservations(context.Background()); len(all) != 0 {
+		t.Errorf("expected nothing to be booked, but got %v", all)
+	}
+}
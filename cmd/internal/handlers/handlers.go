@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,11 +12,14 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
 	"github.com/maslow123/bookings/cmd/internal/config"
 	"github.com/maslow123/bookings/cmd/internal/driver"
+	"github.com/maslow123/bookings/cmd/internal/emails"
 	"github.com/maslow123/bookings/cmd/internal/forms"
 	"github.com/maslow123/bookings/cmd/internal/helpers"
 	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/pricing"
 	"github.com/maslow123/bookings/cmd/internal/render"
 	"github.com/maslow123/bookings/cmd/internal/repository"
 	"github.com/maslow123/bookings/cmd/internal/repository/dbrepo"
@@ -35,6 +40,14 @@ func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
 	}
 }
 
+// NewRepoWithDB creates a new repository on top of db, such as the in-memory database
+func NewRepoWithDB(a *config.AppConfig, db repository.DatabaseRepo) *Repository {
+	return &Repository{
+		App: a,
+		DB:  db,
+	}
+}
+
 // NewTestRepo creates a new repository
 func NewTestRepo(a *config.AppConfig) *Repository {
 	return &Repository{
@@ -63,7 +76,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		// helpers.ServerError(w, err)
 		m.App.Session.Put(r.Context(), "error", "can't find room!")
@@ -74,6 +87,15 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	quote, err := m.DB.QuoteStay(r.Context(), res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get a price for these dates!")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	res.TotalPrice = quote.Total
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
@@ -85,6 +107,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
 
 	render.Template(w, r, "make-reservation.page.htm", &config.TemplateData{
 		Form:      forms.New(nil),
@@ -112,12 +135,14 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
 	endDate, err := time.Parse(layout, ed)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't parse end date!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
 	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
@@ -127,21 +152,31 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "invalid data!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+
+	// never trust a price from the form, always quote the stay again
+	quote, err := m.DB.QuoteStay(r.Context(), roomID, startDate, endDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get a price for these dates!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
 	reservation := models.Reservation{
-		FirstName: r.Form.Get("first_name"),
-		LastName:  r.Form.Get("last_name"),
-		Phone:     r.FormValue("phone"),
-		Email:     r.Form.Get("email"),
-		StartDate: startDate,
-		EndDate:   endDate,
-		RoomID:    roomID,
-		Room:      room,
+		FirstName:  r.Form.Get("first_name"),
+		LastName:   r.Form.Get("last_name"),
+		Phone:      r.FormValue("phone"),
+		Email:      r.Form.Get("email"),
+		StartDate:  startDate,
+		EndDate:    endDate,
+		RoomID:     roomID,
+		Room:       room,
+		TotalPrice: quote.Total,
 	}
 
 	form := forms.New(r.PostForm)
@@ -149,73 +184,176 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
+	if code := strings.ToUpper(strings.TrimSpace(r.Form.Get("promo_code"))); code != "" {
+		if err := m.applyPromoCode(r.Context(), &reservation, code, quote.Total); err != nil {
+			form.Errors.Add("promo_code", promoCodeMessage(err))
+		}
+	}
+
 	if !form.Valid() {
 		data := make(map[string]interface{})
 
 		data["reservation"] = reservation
+		data["quote"] = quote
+
+		stringMap := make(map[string]string)
+		stringMap["start_date"] = sd
+		stringMap["end_date"] = ed
+
 		http.Error(w, "my own error message", http.StatusSeeOther)
 		render.Template(w, r, "make-reservation.page.htm", &config.TemplateData{
-			Form: form,
-			Data: data,
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
 		})
 
 		return
 	}
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	newReservationID, err := m.DB.CreateBooking(r.Context(), reservation, m.confirmationMail(reservation))
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room was just taken for those dates. Please search again for other dates.")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, repository.ErrPromoCodeExhausted) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this promo code has just been fully redeemed.")
+		http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert reservation into database!")
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't save reservation into database!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
+	reservation.ID = newReservationID
+
 	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+// errUnknownPromoCode is returned by applyPromoCode for a code that can't be found
+var errUnknownPromoCode = errors.New("unknown promo code")
+
+// promoCodeMessages tell the guest why the promo code they gave can't be used
+var promoCodeMessages = []struct {
+	err     error
+	message string
+}{
+	{errUnknownPromoCode, "Unknown promo code"},
+	{pricing.ErrPromoNotYetValid, "This promo code is not valid yet"},
+	{pricing.ErrPromoExpired, "This promo code has expired"},
+	{pricing.ErrPromoWrongRoom, "This promo code is not valid for this room"},
+	{pricing.ErrPromoExhausted, "This promo code has been fully redeemed"},
+}
+
+// promoCodeMessage returns the message of the form telling the guest why the promo code can't be
+// used, for an error of applyPromoCode
+func promoCodeMessage(err error) string {
+	for _, m := range promoCodeMessages {
+		if errors.Is(err, m.err) {
+			return m.message
+		}
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	return "This promo code can't be used"
+}
+
+// applyPromoCode discounts res with the promo code, or returns why the code can't be used
+func (m *Repository) applyPromoCode(ctx context.Context, res *models.Reservation, code string, total int) error {
+	promo, err := m.DB.GetPromoCodeByCode(ctx, code)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert room restriction into database!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		return errUnknownPromoCode
 	}
 
-	htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong><br/>
-		Dear: %s, <br/>
-		This is confirm your reservation from %s to %s.
-	`,
-		reservation.FirstName,
-		reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"),
-	)
-	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.htm",
+	err = pricing.CheckPromoCode(promo, res.RoomID, time.Now())
+	if err != nil {
+		return err
 	}
 
-	m.App.MailChan <- msg
+	res.PromoCodeID = promo.ID
+	res.Discount = pricing.Discount(promo, total)
+	res.TotalPrice = total - res.Discount
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	return nil
 }
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.htm", &config.TemplateData{})
+// confirmationMail returns the email confirming res to the guest, with the link to manage it, and
+// the notification of staff when they want one for each reservation, for CreateBooking to put in the
+// outbox once it knows the id of the reservation
+func (m *Repository) confirmationMail(res models.Reservation) func(id int) ([]models.MailData, error) {
+	return func(id int) ([]models.MailData, error) {
+		res.ID = id
+		link := m.ManageURL(res)
+
+		msg, err := m.renderMail(res.Email, emails.Confirmation{
+			Reservation: res,
+			ManageURL:   link,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		invite, err := m.stayInvite(res, link)
+		if err != nil {
+			return nil, err
+		}
+		msg.Attachments = []models.Attachment{invite}
+
+		mail := []models.MailData{msg}
+
+		if m.App.StaffNotify == config.NotifyEach {
+			staff, err := m.staffMail(emails.ActionBooked, res, fmt.Sprintf("%s/admin/reservations/new/%d/show", m.App.BaseURL, res.ID))
+			if err != nil {
+				return nil, err
+			}
+			mail = append(mail, staff...)
+		}
+
+		return mail, nil
+	}
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.htm", &config.TemplateData{})
+// staffMail returns the notification that a guest did action to res, for each of the staff
+// recipients, unless staff turned notifications off
+func (m *Repository) staffMail(action string, res models.Reservation, adminURL string) ([]models.MailData, error) {
+	if m.App.StaffNotify == config.NotifyOff {
+		return nil, nil
+	}
+
+	var mail []models.MailData
+	for _, to := range m.App.StaffEmails {
+		msg, err := m.renderMail(to, emails.StaffNotification{
+			Action:      action,
+			Reservation: res,
+			AdminURL:    adminURL,
+		})
+		if err != nil {
+			return nil, err
+		}
+		mail = append(mail, msg)
+	}
+
+	return mail, nil
+}
+
+// renderMail renders the email of d, to be sent to the address to
+func (m *Repository) renderMail(to string, d emails.Data) (models.MailData, error) {
+	e, err := m.App.Emails.Render(d)
+	if err != nil {
+		return models.MailData{}, err
+	}
+
+	msg := models.MailData{
+		To:      to,
+		From:    "me@here.com",
+		Subject: e.Subject,
+		Content: e.HTML,
+		Text:    e.Text,
+	}
+
+	return msg, nil
 }
 
 // Availability renders the room page
@@ -241,7 +379,7 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(r.Context(), startDate, endDate)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -298,7 +436,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
 	if err != nil {
 		// can't parse form, so return appropiate json
 		resp := jsonResponse{
@@ -363,6 +501,7 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	stringMap := make(map[string]string)
 	stringMap["start_date"] = sd
 	stringMap["end_date"] = ed
+	stringMap["manage_path"] = m.managePath(reservation)
 
 	render.Template(w, r, "reservation-summary.page.htm", &config.TemplateData{
 		Data:      data,
@@ -404,7 +543,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	endDate, _ := time.Parse(layout, ed)
 
 	var res models.Reservation
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -452,7 +591,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
 		log.Println(err)
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
@@ -460,7 +599,14 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -474,6 +620,23 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
+// Forbidden tells the user they are not allowed to do what they asked
+func (m *Repository) Forbidden(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	render.Template(w, r, "403.page.htm", &config.TemplateData{})
+}
+
+// allowed reports whether the logged in user has permission p, showing them the forbidden page when not
+func (m *Repository) allowed(w http.ResponseWriter, r *http.Request, p authz.Permission) bool {
+	if !authz.Can(helpers.AccessLevel(r), p) {
+		m.App.InfoLog.Printf("user %d is not allowed to %s", m.App.Session.GetInt(r.Context(), "user_id"), p)
+		m.Forbidden(w, r)
+		return false
+	}
+
+	return true
+}
+
 // AdminDashboard
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 	render.Template(w, r, "admin-dashboard.page.htm", &config.TemplateData{})
@@ -481,7 +644,7 @@ func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
 
 // AdminAllReservations shows all reservations in admin tool
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	reservations, err := m.DB.AllReservations(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -498,7 +661,7 @@ func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request
 
 // AdminNewReservations shows all new reservations in admin tool
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllNewReservations(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -532,7 +695,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	stringMap["year"] = year
 
 	// get reservation from database
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -551,6 +714,9 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 
 // AdminPostShowReservation shows the reservation in the admin tool
 func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.EditReservations) {
+		return
+	}
 
 	err := r.ParseForm()
 	if err != nil {
@@ -570,7 +736,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	stringMap := make(map[string]string)
 	stringMap["src"] = src
 
-	res, err := m.DB.GetReservationByID(id)
+	res, err := m.DB.GetReservationByID(r.Context(), id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -581,7 +747,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
-	err = m.DB.UpdateReservation(res)
+	err = m.DB.UpdateReservation(r.Context(), res)
 
 	if err != nil {
 		helpers.ServerError(w, err)
@@ -644,7 +810,7 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 	intMap["days_in_month"] = lastOfMonth.Day()
 
-	rooms, err := m.DB.AllRooms()
+	rooms, err := m.DB.AllRooms(r.Context())
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
@@ -652,18 +818,23 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 	data["rooms"] = rooms
 
+	// the notes of the blocks shown, by block id
+	blockNotes := make(map[int]string)
+
 	for _, x := range rooms {
 		// create maps
 		reservationMap := make(map[string]int)
 		blockMap := make(map[string]int)
+		externalMap := make(map[string]int)
 
 		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
 			reservationMap[d.Format("2006-01-2")] = 0
 			blockMap[d.Format("2006-01-2")] = 0
+			externalMap[d.Format("2006-01-2")] = 0
 		}
 
 		// get all the restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(r.Context(), x.ID, firstOfMonth, lastOfMonth)
 		if err != nil {
 			helpers.ServerError(w, err)
 			return
@@ -675,16 +846,25 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0, 0, 1) {
 					reservationMap[d.Format("2006-01-2")] = y.ReservationID
 				}
+			} else if y.RestrictionID == models.RestrictionExternal {
+				// it's booked on another platform, and can only be changed there
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					externalMap[d.Format("2006-01-2")] = y.ID
+				}
 			} else {
-				// it's a block
-				blockMap[y.StartDate.Format("2006-01-2")] = y.ID
+				// it's a block, of one night or more
+				for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0, 0, 1) {
+					blockMap[d.Format("2006-01-2")] = y.ID
+				}
+				blockNotes[y.ID] = y.Note
 			}
 		}
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
 		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
-
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID), blockMap)
+		data[fmt.Sprintf("external_map_%d", x.ID)] = externalMap
 	}
+	data["block_notes"] = blockNotes
+
 	render.Template(w, r, "admin-reservations-calendar.page.htm", &config.TemplateData{
 		StringMap: stringMap,
 		Data:      data,
@@ -694,10 +874,14 @@ func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Re
 
 // AdminProcessReservation makrs a reservation as processed
 func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.EditReservations) {
+		return
+	}
+
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	_ = m.DB.UpdateProcessedForReservation(r.Context(), id, 1)
 
 	year := r.URL.Query().Get("y")
 	month := r.URL.Query().Get("m")
@@ -713,10 +897,14 @@ func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Requ
 
 // AdminDeleteReservation delete a reservation
 func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.DeleteReservations) {
+		return
+	}
+
 	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
 	src := chi.URLParam(r, "src")
 
-	_ = m.DB.DeleteReservation(id)
+	_ = m.DB.DeleteReservation(r.Context(), id)
 	m.App.Session.Put(r.Context(), "flash", "Reservation deleted ")
 
 	year := r.URL.Query().Get("y")
@@ -729,8 +917,12 @@ func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-// AdminPostReservationsCalendar handles post of reservations calendar
+// AdminPostReservationsCalendar blocks the range of nights selected in the reservations calendar
 func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.EditReservations) {
+		return
+	}
+
 	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
@@ -739,57 +931,32 @@ func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *htt
 
 	year, _ := strconv.Atoi(r.Form.Get("y"))
 	month, _ := strconv.Atoi(r.Form.Get("m"))
+	calendarURL := fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month)
 
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+	block, form := blockFromForm(r)
+	block.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
+		form.Errors.Add("room_id", "Choose a room")
 	}
+	block.CreatedBy = m.App.Session.GetInt(r.Context(), "user_id")
 
-	form := forms.New(r.PostForm)
-
-	for _, x := range rooms {
-		// Get the block map from the session. Loop through entire map, if we have an entry in the map
-		// that does not exist in our posted data, and if the restriction id > 0, then it is a block we need to
-		// remove.
-
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", x.ID)).(map[string]int)
-		for name, value := range curMap {
-			// ok will be false if the value is not in the map
-			if val, ok := curMap[name]; ok {
-				// only pay attention to values > 0, and that are not in the form post
-				// the rest are just placeholders for days without blocks
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, name)) {
-						// delete the restriction by id
-						err := m.DB.DeleteBlockByID(value)
-						if err != nil {
-							log.Println(err)
-							return
-						}
-					}
-				}
-			}
-		}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", firstError(form, "room_id", "start_date", "end_date", "note"))
+		http.Redirect(w, r, calendarURL, http.StatusSeeOther)
+		return
 	}
 
-	// now handle new blocks
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			t, _ := time.Parse("2006-01-2", exploded[3])
-
-			// insert a new block
-			err := m.DB.InsertBlockForRoom(roomID, t)
-			if err != nil {
-				log.Println(err)
-				return
-			}
-		}
+	_, err = m.DB.InsertBlock(r.Context(), block)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "These nights overlap a reservation or another block of the room")
+		http.Redirect(w, r, calendarURL, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "Nights blocked")
+	http.Redirect(w, r, calendarURL, http.StatusSeeOther)
 }
@@ -3,6 +3,7 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,8 +11,11 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/maslow123/bookings/cmd/internal/config"
 	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/pricing"
 )
 
 type postData struct {
@@ -27,18 +31,34 @@ var theTest = []struct {
 }{
 	{"home", "/", "GET", http.StatusOK},
 	{"about", "/about", "GET", http.StatusOK},
-	{"gq", "/generals-quarters", "GET", http.StatusOK},
-	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"gq", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"room-not-exist", "/rooms/no-such-room", "GET", http.StatusNotFound},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
 	{"not-exist", "/omama/olala", "GET", http.StatusNotFound},
 	// new routes
 	{"login", "/user/login", "GET", http.StatusOK},
 	{"logout", "/user/logout", "GET", http.StatusOK},
+	{"forgot-password", "/user/forgot-password", "GET", http.StatusOK},
+	{"reset-password", "/user/reset-password/valid-token", "GET", http.StatusOK},
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
 	{"reservations-new", "/admin/reservations-new", "GET", http.StatusOK},
 	{"reservations-all", "/admin/reservations-all", "GET", http.StatusOK},
 	{"reservations-new with param", "/admin/reservations/new/1/show", "GET", http.StatusOK},
+	{"admin-rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"admin-new-room", "/admin/rooms/new", "GET", http.StatusOK},
+	{"admin-show-room", "/admin/rooms/1", "GET", http.StatusOK},
+	{"admin-room-rates", "/admin/rooms/1/rates", "GET", http.StatusOK},
+	{"admin-room-calendars", "/admin/rooms/1/calendars", "GET", http.StatusOK},
+	{"admin-show-block", "/admin/blocks/2", "GET", http.StatusOK},
+	{"admin-reservations-calendar", "/admin/reservations-calendar?y=2100&m=6", "GET", http.StatusOK},
+	{"admin-promo-codes", "/admin/promo-codes", "GET", http.StatusOK},
+	{"admin-new-promo-code", "/admin/promo-codes/new", "GET", http.StatusOK},
+	{"admin-show-promo-code", "/admin/promo-codes/1", "GET", http.StatusOK},
+	{"admin-users", "/admin/users", "GET", http.StatusForbidden},
+	{"admin-new-user", "/admin/users/new", "GET", http.StatusForbidden},
+	{"admin-show-user", "/admin/users/2", "GET", http.StatusForbidden},
 
 	// {"post-search-availability", "/search-availability", "POST", []postData{
 	// 	{key: "start", value: "2020-01-01"},
@@ -79,7 +99,9 @@ func TestHandlers(t *testing.T) {
 
 func TestRepository_Reservation(t *testing.T) {
 	reservation := models.Reservation{
-		RoomID: 1,
+		RoomID:    1,
+		StartDate: time.Date(2021, 6, 3, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2021, 6, 6, 0, 0, 0, 0, time.UTC),
 		Room: models.Room{
 			ID:       1,
 			RoomName: "General's Quarters",
@@ -100,6 +122,26 @@ func TestRepository_Reservation(t *testing.T) {
 		t.Errorf("Reservation handler return wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
 	}
 
+	// 100.00 on Thursday, 120.00 on Friday and Saturday
+	if !strings.Contains(rr.Body.String(), "340.00") {
+		t.Error("Reservation handler did not show the quoted total")
+	}
+
+	// test with a stay that can't be quoted
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+
+	rr = httptest.NewRecorder()
+	invalidStay := reservation
+	invalidStay.EndDate = invalidStay.StartDate
+	session.Put(ctx, "reservation", invalidStay)
+
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("Reservation handler return wrong response code for invalid stay: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
 	// test case where reservation is not in session (reset everything)
 	req, _ = http.NewRequest("GET", "/make-reservation", nil)
 	ctx = getCtx(req)
@@ -295,6 +337,183 @@ func TestRepository_PostReservation(t *testing.T) {
 	if rr.Code != http.StatusSeeOther {
 		t.Errorf("PostReservation handler failed when trying to fail insert room restriction: got %d, wanted %d", rr.Code, http.StatusSeeOther)
 	}
+
+	// test for room taken before the booking transaction could lock it
+	reqBody = "start_date=2020-01-01"
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "end_date=2020-01-02")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "first_name=Omama")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "last_name=Olala")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "email=omama@getnada.com")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "phone=11111111")
+	reqBody = fmt.Sprintf("%s&%s", reqBody, "room_id=1001")
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(reqBody))
+
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.PostReservation)
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("PostReservation handler failed when room is no longer available: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/search-availability" {
+		t.Errorf("PostReservation handler redirected to %s when room is no longer available, wanted /search-availability", actualLoc.String())
+	}
+
+	if session.GetString(ctx, "error") == "" {
+		t.Error("PostReservation handler did not put an error in the session when room is no longer available")
+	}
+}
+
+var promoCodeReservationTests = []struct {
+	name             string
+	code             string
+	expectedLocation string
+	expectedHTML     string
+	expectedDiscount int
+}{
+	{"valid-code", " save10 ", "/reservation-summary", "", 1000},
+	{"unknown-code", "NOPE", "", "Unknown promo code", 0},
+	{"expired-code", "EXPIRED", "", "This promo code has expired", 0},
+	{"exhausted-while-booking", "LASTONE", "/make-reservation", "", 0},
+}
+
+func TestPromoCodeMessage(t *testing.T) {
+	var tests = []struct {
+		err      error
+		expected string
+	}{
+		{errUnknownPromoCode, "Unknown promo code"},
+		{pricing.ErrPromoNotYetValid, "This promo code is not valid yet"},
+		{pricing.ErrPromoExpired, "This promo code has expired"},
+		{fmt.Errorf("checking code: %w", pricing.ErrPromoWrongRoom), "This promo code is not valid for this room"},
+		{pricing.ErrPromoExhausted, "This promo code has been fully redeemed"},
+		{errors.New("something else"), "This promo code can't be used"},
+	}
+
+	for _, e := range tests {
+		if message := promoCodeMessage(e.err); message != e.expected {
+			t.Errorf("failed %v: expected %q, but got %q", e.err, e.expected, message)
+		}
+	}
+}
+
+func TestRepository_PostReservation_PromoCode(t *testing.T) {
+	for _, e := range promoCodeReservationTests {
+		postedData := url.Values{}
+		postedData.Add("start_date", "2020-01-01")
+		postedData.Add("end_date", "2020-01-02")
+		postedData.Add("first_name", "Omama")
+		postedData.Add("last_name", "Olala")
+		postedData.Add("email", "omama@getnada.com")
+		postedData.Add("phone", "11111111")
+		postedData.Add("room_id", "1")
+		postedData.Add("promo_code", e.code)
+
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc == nil || actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s but got %v", e.name, e.expectedLocation, actualLoc)
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+
+		if e.expectedDiscount > 0 {
+			res, ok := session.Get(ctx, "reservation").(models.Reservation)
+			if !ok {
+				t.Errorf("failed %s: reservation not in session", e.name)
+				continue
+			}
+
+			if res.Discount != e.expectedDiscount || res.TotalPrice != 10000-e.expectedDiscount {
+				t.Errorf("failed %s: expected discount %d but got %d with total %d", e.name, e.expectedDiscount, res.Discount, res.TotalPrice)
+			}
+		}
+	}
+}
+
+func TestRepository_ConfirmationMail(t *testing.T) {
+	defer func(staff []string, notify string) {
+		app.StaffEmails, app.StaffNotify = staff, notify
+	}(app.StaffEmails, app.StaffNotify)
+	app.StaffEmails = []string{"owner@here.com", "desk@here.com"}
+
+	res := models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2100, 6, 3, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	var tests = []struct {
+		name       string
+		notify     string
+		expectedTo []string
+	}{
+		{"each", config.NotifyEach, []string{"john@smith.com", "owner@here.com", "desk@here.com"}},
+		{"digest", config.NotifyDigest, []string{"john@smith.com"}},
+		{"off", config.NotifyOff, []string{"john@smith.com"}},
+	}
+
+	for _, e := range tests {
+		app.StaffNotify = e.notify
+
+		mail, err := Repo.confirmationMail(res)(5)
+		if err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		var to []string
+		for _, msg := range mail {
+			to = append(to, msg.To)
+		}
+		if strings.Join(to, ",") != strings.Join(e.expectedTo, ",") {
+			t.Errorf("failed %s: expected emails to %v, but got %v", e.name, e.expectedTo, to)
+			continue
+		}
+
+		for _, msg := range mail[1:] {
+			if !strings.Contains(msg.Text, "http://localhost:8080/admin/reservations/new/5/show") {
+				t.Errorf("failed %s: expected the link to the new reservation in\n%s", e.name, msg.Text)
+			}
+			if len(msg.Attachments) != 0 {
+				t.Errorf("failed %s: expected no invite for staff", e.name)
+			}
+		}
+
+		if len(mail[0].Attachments) != 1 || mail[0].Attachments[0].Name != "reservation.ics" {
+			t.Errorf("failed %s: expected the invite to be attached for the guest, but got %v", e.name, mail[0].Attachments)
+			continue
+		}
+
+		invite := string(mail[0].Attachments[0].Content)
+		for _, expected := range []string{"UID:stay-5@localhost", "DTSTART;VALUE=DATE:21000601", "DTEND;VALUE=DATE:21000604", "SUMMARY:Stay at Fort Smythe: General's Quarters", "URL:http://localhost:8080/manage/"} {
+			if !strings.Contains(invite, expected) {
+				t.Errorf("failed %s: expected to find %q in the invite\n%s", e.name, expected, invite)
+			}
+		}
+	}
 }
 
 func TestRepository_AvailabilityJSON(t *testing.T) {
@@ -372,6 +591,7 @@ func TestLogin(t *testing.T) {
 		// create request
 		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
+		session.Remove(ctx, "access_level")
 		req = req.WithContext(ctx)
 
 		// set the header
@@ -402,6 +622,52 @@ func TestLogin(t *testing.T) {
 			}
 		}
 
+		if e.expectedLocation == "/" && session.GetInt(ctx, "access_level") != models.AccessOwner {
+			t.Errorf("failed %s: expected access level of the user in the session", e.name)
+		}
+	}
+}
+
+func TestRepository_Forbidden(t *testing.T) {
+	var tests = []struct {
+		name        string
+		url         string
+		accessLevel int
+		handler     http.HandlerFunc
+		expected    int
+	}{
+		{"read-only-deletes-reservation", "/admin/delete-reservation/new/1/do", models.AccessReadOnly, Repo.AdminDeleteReservation, http.StatusForbidden},
+		{"front-desk-deletes-reservation", "/admin/delete-reservation/new/1/do", models.AccessFrontDesk, Repo.AdminDeleteReservation, http.StatusForbidden},
+		{"manager-deletes-reservation", "/admin/delete-reservation/new/1/do", models.AccessManager, Repo.AdminDeleteReservation, http.StatusSeeOther},
+		{"read-only-processes-reservation", "/admin/process-reservation/new/1/do", models.AccessReadOnly, Repo.AdminProcessReservation, http.StatusForbidden},
+		{"front-desk-processes-reservation", "/admin/process-reservation/new/1/do", models.AccessFrontDesk, Repo.AdminProcessReservation, http.StatusSeeOther},
+		{"front-desk-deletes-room", "/admin/delete-room/1/do", models.AccessFrontDesk, Repo.AdminDeleteRoom, http.StatusForbidden},
+		{"front-desk-deletes-promo-code", "/admin/delete-promo-code/1/do", models.AccessFrontDesk, Repo.AdminDeletePromoCode, http.StatusForbidden},
+		{"front-desk-deletes-calendar", "/admin/delete-calendar/1/1/do", models.AccessFrontDesk, Repo.AdminDeleteRoomCalendar, http.StatusForbidden},
+		{"read-only-deletes-block", "/admin/delete-block/2/do", models.AccessReadOnly, Repo.AdminDeleteBlock, http.StatusForbidden},
+		{"manager-deactivates-user", "/admin/deactivate-user/2/do", models.AccessManager, Repo.AdminDeactivateUser, http.StatusForbidden},
+		{"manager-views-audit-log", "/admin/audit-log", models.AccessManager, Repo.AdminAuditLog, http.StatusForbidden},
+		{"manager-views-emails", "/admin/emails", models.AccessManager, Repo.AdminEmails, http.StatusForbidden},
+		{"manager-resends-email", "/admin/resend-email/1/do", models.AccessManager, Repo.AdminResendEmail, http.StatusForbidden},
+		{"logged-out", "/admin/delete-room/1/do", 0, Repo.AdminDeleteRoom, http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "access_level", e.accessLevel)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expected {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expected, rr.Code)
+		}
+
+		if e.expected == http.StatusForbidden && !strings.Contains(rr.Body.String(), "Access Denied") {
+			t.Errorf("failed %s: expected the forbidden page", e.name)
+		}
 	}
 }
 
@@ -412,5 +678,8 @@ func getCtx(req *http.Request) context.Context {
 		log.Println(err)
 	}
 
+	// handlers are tested as the owner, who may do anything
+	session.Put(ctx, "access_level", models.AccessOwner)
+
 	return ctx
 }
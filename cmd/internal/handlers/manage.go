@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/pricing"
+	"github.com/maslow123/bookings/cmd/internal/render"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+	"github.com/maslow123/bookings/cmd/internal/signedlink"
+)
+
+// manageSubject prefixes the reservation id in manage links, so tokens signed for anything else can't be used
+const manageSubject = "reservation:"
+
+// ManageReservation shows a guest their reservation, with forms to change its dates or cancel it
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromLink(w, r)
+	if !ok {
+		return
+	}
+
+	m.renderManageReservation(w, r, res, manageStringMap(res), forms.New(nil))
+}
+
+// PostManageReservation moves a reservation to the dates chosen by the guest, and lets them and staff know
+func (m *Repository) PostManageReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromLink(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	if !canChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be changed.")
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	} else if startDate.Before(today()) {
+		form.Errors.Add("start_date", "Arrival can't be in the past")
+	}
+
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if !endDate.After(startDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	if !form.Valid() {
+		m.renderManageReservation(w, r, res, manageFormStringMap(form), form)
+		return
+	}
+
+	quote, err := m.DB.QuoteStay(r.Context(), res.RoomID, startDate, endDate)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		form.Errors.Add("start_date", "Can't get a price for these dates")
+		m.renderManageReservation(w, r, res, manageFormStringMap(form), form)
+		return
+	}
+
+	changed := res
+	changed.StartDate = startDate
+	changed.EndDate = endDate
+	changed.Discount = 0
+
+	// the promo code keeps applying to the new dates, even if it has expired since
+	if res.PromoCodeID > 0 {
+		promo, err := m.DB.GetPromoCodeByID(r.Context(), res.PromoCodeID)
+		if err == nil {
+			changed.Discount = pricing.Discount(promo, quote.Total)
+		}
+	}
+	changed.TotalPrice = quote.Total - changed.Discount
+
+	// the link expires with the reservation, so the guest needs a new one
+	path := m.managePath(changed)
+	link := m.App.BaseURL + path
+
+	msg, err := m.renderMail(changed.Email, emails.Modification{Reservation: changed, ManageURL: link})
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't change reservation!")
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	invite, err := m.stayInvite(changed, link)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't change reservation!")
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+	msg.Attachments = []models.Attachment{invite}
+
+	staff, err := m.staffMail(emails.ActionChanged, changed, fmt.Sprintf("%s/admin/reservations/all/%d/show", m.App.BaseURL, changed.ID))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't change reservation!")
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	// the room is checked again inside the transaction, ignoring this reservation's own dates
+	err = m.DB.ChangeReservationDates(r.Context(), changed, append([]models.MailData{msg}, staff...))
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		form.Errors.Add("start_date", "Sorry, the room is not available for these dates")
+		m.renderManageReservation(w, r, res, manageFormStringMap(form), form)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't change reservation!")
+		http.Redirect(w, r, r.URL.Path, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been changed")
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
+
+// PostCancelReservation cancels a reservation for the guest, and lets them and staff know
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationFromLink(w, r)
+	if !ok {
+		return
+	}
+
+	back := strings.TrimSuffix(r.URL.Path, "/cancel")
+
+	if !canChange(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can no longer be cancelled.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	mail, err := m.cancellationMail(res)
+	if err == nil {
+		err = m.DB.CancelReservation(r.Context(), res.ID, mail)
+	}
+	if errors.Is(err, repository.ErrReservationCancelled) {
+		m.App.Session.Put(r.Context(), "error", "This reservation is already cancelled.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't cancel reservation!")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// cancellationMail returns the emails telling the guest and staff that res is cancelled
+func (m *Repository) cancellationMail(res models.Reservation) ([]models.MailData, error) {
+	guestMsg, err := m.renderMail(res.Email, emails.Cancellation{Reservation: res})
+	if err != nil {
+		return nil, err
+	}
+
+	staff, err := m.staffMail(emails.ActionCancelled, res, fmt.Sprintf("%s/admin/reservations/all/%d/show", m.App.BaseURL, res.ID))
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]models.MailData{guestMsg}, staff...), nil
+}
+
+// ManageURL returns the link where the guest can manage res, for the emails sent outside of a request
+func (m *Repository) ManageURL(res models.Reservation) string {
+	return m.App.BaseURL + m.managePath(res)
+}
+
+// managePath returns the path where the guest can manage res, valid until the day after departure
+func (m *Repository) managePath(res models.Reservation) string {
+	token := signedlink.Sign(m.App.LinkSecret, manageSubject+strconv.Itoa(res.ID), res.EndDate.AddDate(0, 0, 1))
+
+	return "/manage/" + token
+}
+
+// reservationFromLink returns the reservation the token of the request was signed for.
+// When the token is not valid, it redirects home and returns false.
+func (m *Repository) reservationFromLink(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	subject, err := signedlink.Verify(m.App.LinkSecret, chi.URLParam(r, "token"), time.Now())
+	if err == nil && !strings.HasPrefix(subject, manageSubject) {
+		err = signedlink.ErrInvalid
+	}
+
+	var res models.Reservation
+	if err == nil {
+		var id int
+		id, err = strconv.Atoi(strings.TrimPrefix(subject, manageSubject))
+		if err == nil {
+			res, err = m.DB.GetReservationByID(r.Context(), id)
+		}
+	}
+
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This link is invalid or has expired.")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return res, false
+	}
+
+	return res, true
+}
+
+// renderManageReservation displays the manage page of res
+func (m *Repository) renderManageReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, stringMap map[string]string, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	intMap := make(map[string]int)
+	if canChange(res) {
+		intMap["can_change"] = 1
+	}
+
+	stringMap["token"] = chi.URLParam(r, "token")
+
+	render.Template(w, r, "manage-reservation.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Form:      form,
+	})
+}
+
+// canChange reports whether the guest may still change or cancel res
+func canChange(res models.Reservation) bool {
+	return res.Cancelled == 0 && res.StartDate.After(today())
+}
+
+// today returns midnight of the current day, as dates are stored
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// manageStringMap formats the dates of res for the manage page
+func manageStringMap(res models.Reservation) map[string]string {
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	return stringMap
+}
+
+// manageFormStringMap keeps the posted dates when re-displaying the manage page
+func manageFormStringMap(form *forms.Form) map[string]string {
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = form.Get("start_date")
+	stringMap["end_date"] = form.Get("end_date")
+
+	return stringMap
+}
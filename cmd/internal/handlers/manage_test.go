@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/signedlink"
+)
+
+// manageToken signs a manage link token for a reservation id
+func manageToken(id string, expires time.Time) string {
+	return signedlink.Sign(app.LinkSecret, manageSubject+id, expires)
+}
+
+// manageRequest builds a request for path with token as url parameter
+func manageRequest(method, path, token string, postedData url.Values) *http.Request {
+	req, _ := http.NewRequest(method, path, strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("token", token)
+	ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return req
+}
+
+func TestRepository_ManageReservation(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+
+	var tests = []struct {
+		name               string
+		token              string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid", manageToken("1", later), http.StatusOK, "Change Dates"},
+		{"cancelled", manageToken("2", later), http.StatusOK, "has been cancelled"},
+		{"expired", manageToken("1", time.Now().Add(-time.Hour)), http.StatusSeeOther, ""},
+		{"tampered", manageToken("1", later) + "x", http.StatusSeeOther, ""},
+		{"other-subject", signedlink.Sign(app.LinkSecret, "room:1", later), http.StatusSeeOther, ""},
+		{"unknown-reservation", manageToken("1000", later), http.StatusSeeOther, ""},
+	}
+
+	for _, e := range tests {
+		req := manageRequest("GET", "/manage/"+e.token, e.token, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.ManageReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_PostManageReservation(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+
+	var tests = []struct {
+		name               string
+		id                 string
+		start              string
+		end                string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"valid", "1", "2100-02-01", "2100-02-03", http.StatusSeeOther, ""},
+		{"end-before-start", "1", "2100-02-03", "2100-02-01", http.StatusOK, "Departure must be after arrival"},
+		{"in-the-past", "1", "2000-02-01", "2000-02-03", http.StatusOK, "Arrival can&#39;t be in the past"},
+		{"invalid-date", "1", "february", "2100-02-03", http.StatusOK, "Invalid date"},
+		{"room-taken", "4", "2100-02-01", "2100-02-03", http.StatusOK, "not available for these dates"},
+		{"cancelled", "2", "2100-02-01", "2100-02-03", http.StatusSeeOther, ""},
+		{"already-started", "3", "2100-02-01", "2100-02-03", http.StatusSeeOther, ""},
+	}
+
+	for _, e := range tests {
+		token := manageToken(e.id, later)
+		postedData := url.Values{}
+		postedData.Add("start_date", e.start)
+		postedData.Add("end_date", e.end)
+
+		req := manageRequest("POST", "/manage/"+token, token, postedData)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostManageReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+
+		if e.expectedStatusCode == http.StatusSeeOther && e.name != "valid" && session.GetString(req.Context(), "error") == "" {
+			t.Errorf("failed %s: expected an error in the session", e.name)
+		}
+	}
+}
+
+func TestRepository_PostCancelReservation(t *testing.T) {
+	later := time.Now().Add(time.Hour)
+
+	var tests = []struct {
+		name          string
+		id            string
+		expectedError bool
+	}{
+		{"valid", "1", false},
+		{"cancelled", "2", true},
+		{"already-started", "3", true},
+		{"cancelled-meanwhile", "4", true},
+	}
+
+	for _, e := range tests {
+		token := manageToken(e.id, later)
+		req := manageRequest("POST", "/manage/"+token+"/cancel", token, nil)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != "/manage/"+token {
+			t.Errorf("failed %s: expected location /manage/%s, but got %s", e.name, token, actualLoc.String())
+		}
+
+		if hasError := session.GetString(req.Context(), "error") != ""; hasError != e.expectedError {
+			t.Errorf("failed %s: expected error in session to be %t", e.name, e.expectedError)
+		}
+	}
+}
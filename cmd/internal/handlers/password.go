@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+	"github.com/maslow123/bookings/cmd/internal/tokens"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetLifetime is how long a password reset link can be used
+const passwordResetLifetime = time.Hour
+
+// ShowForgotPassword shows the form to ask for a password reset link
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.htm", &config.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword emails a password reset link to the user with the posted email address
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.htm", &config.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	// say the same whether the account exists or not, so the form can't be used to find staff addresses
+	m.App.Session.Put(r.Context(), "flash", "If there is an account for this email address, we sent it a link to reset the password")
+
+	user, err := m.DB.GetUserByEmail(r.Context(), form.Get("email"))
+	if err == nil && user.Active == 0 {
+		err = errors.New("user is deactivated")
+	}
+	if err != nil {
+		m.App.InfoLog.Println("password reset asked for unknown email", form.Get("email"))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendPasswordLink(r.Context(), user, false, passwordResetLifetime)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ShowResetPassword shows the form to choose a new password
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.passwordResetFromLink(w, r); !ok {
+		return
+	}
+
+	m.renderResetPassword(w, r, forms.New(nil))
+}
+
+// PostResetPassword sets the new password of the user the reset link was sent to
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	reset, ok := m.passwordResetFromLink(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "password_confirm")
+	form.MinLength("password", 8)
+	form.Matches("password_confirm", "password")
+
+	if !form.Valid() {
+		m.renderResetPassword(w, r, form)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(form.Get("password")), 12)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.ResetPassword(r.Context(), reset.ID, string(hash))
+	if errors.Is(err, repository.ErrPasswordResetUsed) {
+		m.App.Session.Put(r.Context(), "error", "This password reset link is invalid or has expired.")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been changed, you can now log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordLink emails user a link to choose a password, valid for lifetime. Invitations welcome
+// new users, others are for users who forgot their password.
+func (m *Repository) sendPasswordLink(ctx context.Context, user models.User, invitation bool, lifetime time.Duration) error {
+	token, err := tokens.New()
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(lifetime)
+
+	err = m.DB.InsertPasswordReset(ctx, models.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: tokens.Hash(token),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	msg, err := m.renderMail(user.Email, emails.PasswordLink{
+		User:       user,
+		Invitation: invitation,
+		URL:        fmt.Sprintf("%s/user/reset-password/%s", m.App.BaseURL, token),
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return m.DB.EnqueueEmail(ctx, msg)
+}
+
+// passwordResetFromLink returns the unused, unexpired password reset of the token in the request.
+// When there is none, it redirects to the forgot password page and returns false.
+func (m *Repository) passwordResetFromLink(w http.ResponseWriter, r *http.Request) (models.PasswordReset, bool) {
+	reset, err := m.DB.GetPasswordResetByTokenHash(r.Context(), tokens.Hash(chi.URLParam(r, "token")))
+	if err != nil || reset.Used != 0 || time.Now().After(reset.ExpiresAt) {
+		m.App.Session.Put(r.Context(), "error", "This password reset link is invalid or has expired.")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return reset, false
+	}
+
+	return reset, true
+}
+
+// renderResetPassword displays the form to choose a new password
+func (m *Repository) renderResetPassword(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+
+	render.Template(w, r, "reset-password.page.htm", &config.TemplateData{
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
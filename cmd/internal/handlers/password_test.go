@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		email              string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"known-email", "me@here.ca", http.StatusSeeOther, "/user/login"},
+		{"unknown-email", "omama@getnada.com", http.StatusSeeOther, "/user/login"},
+		{"invalid-email", "boobee", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestRepository_PostResetPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		token              string
+		password           string
+		confirm            string
+		expectedStatusCode int
+		expectedLocation   string
+		expectedHTML       string
+	}{
+		{"valid", "valid-token", "correct horse", "correct horse", http.StatusSeeOther, "/user/login", ""},
+		{"not-matching", "valid-token", "correct horse", "correct horsf", http.StatusOK, "", "The values do not match"},
+		{"too-short", "valid-token", "horse", "horse", http.StatusOK, "", "at least 8 characters"},
+		{"used", "used-token", "correct horse", "correct horse", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"expired", "expired-token", "correct horse", "correct horse", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"unknown", "no-such-token", "correct horse", "correct horse", http.StatusSeeOther, "/user/forgot-password", ""},
+		{"used-meanwhile", "racing-token", "correct horse", "correct horse", http.StatusSeeOther, "/user/forgot-password", ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("password", e.password)
+		postedData.Add("password_confirm", e.confirm)
+
+		req, _ := http.NewRequest("POST", "/user/reset-password/"+e.token, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("token", e.token)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
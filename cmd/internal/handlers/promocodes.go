@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+)
+
+// AdminPromoCodes shows all promo codes in the admin tool
+func (m *Repository) AdminPromoCodes(w http.ResponseWriter, r *http.Request) {
+	codes, err := m.DB.AllPromoCodes(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["codes"] = codes
+
+	render.Template(w, r, "admin-promo-codes.page.htm", &config.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewPromoCode shows the form to create a promo code
+func (m *Repository) AdminNewPromoCode(w http.ResponseWriter, r *http.Request) {
+	today := time.Now()
+	code := models.PromoCode{
+		DiscountType: models.DiscountPercent,
+		ValidFrom:    today,
+		ValidUntil:   today.AddDate(0, 1, 0),
+	}
+
+	m.renderPromoCodeForm(w, r, code, promoCodeStringMap(code), forms.New(nil))
+}
+
+// AdminPostNewPromoCode creates a promo code
+func (m *Repository) AdminPostNewPromoCode(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManagePromoCodes) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	code, form := promoCodeFromForm(r)
+	if !form.Valid() {
+		m.renderPromoCodeForm(w, r, code, promoCodeFormStringMap(form), form)
+		return
+	}
+
+	_, err = m.DB.InsertPromoCode(r.Context(), code)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		form.Errors.Add("code", "Could not save promo code, the code may already be in use")
+		m.renderPromoCodeForm(w, r, code, promoCodeFormStringMap(form), form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code created")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminShowPromoCode shows the form to edit a promo code
+func (m *Repository) AdminShowPromoCode(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	code, err := m.DB.GetPromoCodeByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderPromoCodeForm(w, r, code, promoCodeStringMap(code), forms.New(nil))
+}
+
+// AdminPostShowPromoCode updates a promo code
+func (m *Repository) AdminPostShowPromoCode(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManagePromoCodes) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	code, form := promoCodeFromForm(r)
+	code.ID = id
+	if !form.Valid() {
+		m.renderPromoCodeForm(w, r, code, promoCodeFormStringMap(form), form)
+		return
+	}
+
+	err = m.DB.UpdatePromoCode(r.Context(), code)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		form.Errors.Add("code", "Could not save promo code, the code may already be in use")
+		m.renderPromoCodeForm(w, r, code, promoCodeFormStringMap(form), form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// AdminDeletePromoCode deletes a promo code
+func (m *Repository) AdminDeletePromoCode(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManagePromoCodes) {
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeletePromoCode(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Promo code deleted")
+	http.Redirect(w, r, "/admin/promo-codes", http.StatusSeeOther)
+}
+
+// renderPromoCodeForm displays the promo code form with the rooms it can be restricted to
+func (m *Repository) renderPromoCodeForm(w http.ResponseWriter, r *http.Request, code models.PromoCode, stringMap map[string]string, form *forms.Form) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["code"] = code
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-promo-code.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
+
+// promoCodeFromForm builds a promo code from the posted form and validates it
+func promoCodeFromForm(r *http.Request) (models.PromoCode, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("code", "discount_type", "amount", "valid_from", "valid_until", "max_redemptions")
+	form.IsCode("code")
+	form.IsInt("max_redemptions", 0)
+
+	code := models.PromoCode{
+		Code:         strings.ToUpper(strings.TrimSpace(r.Form.Get("code"))),
+		Description:  r.Form.Get("description"),
+		DiscountType: r.Form.Get("discount_type"),
+	}
+
+	switch code.DiscountType {
+	case models.DiscountPercent:
+		form.IsInt("amount", 1)
+		code.Amount, _ = strconv.Atoi(r.Form.Get("amount"))
+		if code.Amount > 100 {
+			form.Errors.Add("amount", "A percentage can't be more than 100")
+		}
+	case models.DiscountFixed:
+		form.IsPrice("amount")
+		code.Amount, _ = forms.ParsePrice(r.Form.Get("amount"))
+	default:
+		form.Errors.Add("discount_type", "Invalid discount type")
+	}
+
+	layout := "2006-01-02"
+	var err error
+	code.ValidFrom, err = time.Parse(layout, r.Form.Get("valid_from"))
+	if err != nil {
+		form.Errors.Add("valid_from", "Invalid date")
+	}
+
+	code.ValidUntil, err = time.Parse(layout, r.Form.Get("valid_until"))
+	if err != nil {
+		form.Errors.Add("valid_until", "Invalid date")
+	} else if code.ValidUntil.Before(code.ValidFrom) {
+		form.Errors.Add("valid_until", "The last day must not be before the first day")
+	}
+
+	code.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+	code.MaxRedemptions, _ = strconv.Atoi(r.Form.Get("max_redemptions"))
+
+	return code, form
+}
+
+// promoCodeStringMap formats the fields of a stored promo code for its form
+func promoCodeStringMap(code models.PromoCode) map[string]string {
+	stringMap := make(map[string]string)
+	stringMap["valid_from"] = code.ValidFrom.Format("2006-01-02")
+	stringMap["valid_until"] = code.ValidUntil.Format("2006-01-02")
+
+	switch code.DiscountType {
+	case models.DiscountFixed:
+		stringMap["amount"] = render.FormatPrice(code.Amount)
+	default:
+		if code.Amount > 0 {
+			stringMap["amount"] = strconv.Itoa(code.Amount)
+		}
+	}
+
+	return stringMap
+}
+
+// promoCodeFormStringMap keeps the posted values when re-displaying the promo code form
+func promoCodeFormStringMap(form *forms.Form) map[string]string {
+	stringMap := make(map[string]string)
+	stringMap["amount"] = form.Get("amount")
+	stringMap["valid_from"] = form.Get("valid_from")
+	stringMap["valid_until"] = form.Get("valid_until")
+
+	return stringMap
+}
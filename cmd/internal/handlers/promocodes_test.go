@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var postPromoCodeTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{
+		"valid-percent",
+		url.Values{
+			"code":            {"summer21"},
+			"discount_type":   {"percent"},
+			"amount":          {"15"},
+			"valid_from":      {"2021-06-01"},
+			"valid_until":     {"2021-08-31"},
+			"room_id":         {"0"},
+			"max_redemptions": {"0"},
+		},
+		http.StatusSeeOther,
+		"",
+	},
+	{
+		"valid-fixed",
+		url.Values{
+			"code":            {"TENOFF"},
+			"discount_type":   {"fixed"},
+			"amount":          {"10.00"},
+			"valid_from":      {"2021-06-01"},
+			"valid_until":     {"2021-08-31"},
+			"room_id":         {"1"},
+			"max_redemptions": {"100"},
+		},
+		http.StatusSeeOther,
+		"",
+	},
+	{
+		"percent-above-100",
+		url.Values{
+			"code":            {"SUMMER21"},
+			"discount_type":   {"percent"},
+			"amount":          {"150"},
+			"valid_from":      {"2021-06-01"},
+			"valid_until":     {"2021-08-31"},
+			"max_redemptions": {"0"},
+		},
+		http.StatusOK,
+		"can&#39;t be more than 100",
+	},
+	{
+		"invalid-code",
+		url.Values{
+			"code":            {"SUMMER 21"},
+			"discount_type":   {"percent"},
+			"amount":          {"15"},
+			"valid_from":      {"2021-06-01"},
+			"valid_until":     {"2021-08-31"},
+			"max_redemptions": {"0"},
+		},
+		http.StatusOK,
+		"Use 3 to 20 letters and numbers",
+	},
+	{
+		"until-before-from",
+		url.Values{
+			"code":            {"SUMMER21"},
+			"discount_type":   {"percent"},
+			"amount":          {"15"},
+			"valid_from":      {"2021-08-31"},
+			"valid_until":     {"2021-06-01"},
+			"max_redemptions": {"0"},
+		},
+		http.StatusOK,
+		"must not be before",
+	},
+	{
+		"database-error",
+		url.Values{
+			"code":            {"fail"},
+			"discount_type":   {"fixed"},
+			"amount":          {"10"},
+			"valid_from":      {"2021-06-01"},
+			"valid_until":     {"2021-08-31"},
+			"max_redemptions": {"0"},
+		},
+		http.StatusOK,
+		"code may already be in use",
+	},
+}
+
+func TestRepository_AdminPostNewPromoCode(t *testing.T) {
+	for _, e := range postPromoCodeTests {
+		req, _ := http.NewRequest("POST", "/admin/promo-codes/new", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostNewPromoCode)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" {
+			if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
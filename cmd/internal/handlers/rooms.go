@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// Rooms lists all rooms on the public site
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "rooms.page.htm", &config.TemplateData{
+		Data: data,
+	})
+}
+
+// Room renders the public page of a room by its slug
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(r.Context(), chi.URLParam(r, "slug"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	render.Template(w, r, "room.page.htm", &config.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRooms shows all rooms in the admin tool
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// links to subscribe to the occupancy of the rooms in a calendar app
+	feeds := make(map[int]string)
+	for _, room := range rooms {
+		feeds[room.ID] = m.App.BaseURL + m.calendarFeedPath(fmt.Sprintf("rooms/%d", room.ID))
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["feeds"] = feeds
+
+	stringMap := make(map[string]string)
+	stringMap["all_rooms_feed"] = m.App.BaseURL + m.calendarFeedPath("rooms")
+
+	render.Template(w, r, "admin-rooms.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminNewRoom shows the form to create a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]interface{})
+	data["room"] = models.Room{Capacity: 2}
+
+	stringMap := make(map[string]string)
+	stringMap["base_price"] = ""
+
+	render.Template(w, r, "admin-room.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostNewRoom creates a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, form := roomFromForm(r)
+	if !form.Valid() {
+		renderRoomForm(w, r, room, form)
+		return
+	}
+
+	_, err = m.DB.InsertRoom(r.Context(), room)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		form.Errors.Add("slug", "Could not save room, the slug may already be in use")
+		renderRoomForm(w, r, room, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room created")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminShowRoom shows the form to edit a room
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	stringMap := make(map[string]string)
+	stringMap["base_price"] = render.FormatPrice(room.BasePrice)
+
+	render.Template(w, r, "admin-room.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostShowRoom updates a room
+func (m *Repository) AdminPostShowRoom(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, form := roomFromForm(r)
+	room.ID = id
+	if !form.Valid() {
+		renderRoomForm(w, r, room, form)
+		return
+	}
+
+	err = m.DB.UpdateRoom(r.Context(), room)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		form.Errors.Add("slug", "Could not save room, the slug may already be in use")
+		renderRoomForm(w, r, room, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteRoom(r.Context(), id)
+	if errors.Is(err, repository.ErrRoomHasReservations) {
+		m.App.Session.Put(r.Context(), "error", "This room still has reservations and can't be deleted")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminRoomRates shows the seasonal rates of a room
+func (m *Repository) AdminRoomRates(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderRoomRates(w, r, id, forms.New(nil))
+}
+
+// AdminPostRoomRate adds a seasonal rate to a room
+func (m *Repository) AdminPostRoomRate(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "start_date", "end_date", "nightly_price")
+	form.IsPrice("nightly_price")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if endDate.Before(startDate) {
+		form.Errors.Add("end_date", "The last night must not be before the first night")
+	}
+
+	if !form.Valid() {
+		m.renderRoomRates(w, r, id, form)
+		return
+	}
+
+	price, _ := forms.ParsePrice(r.Form.Get("nightly_price"))
+
+	_, err = m.DB.InsertSeasonalRate(r.Context(), models.SeasonalRate{
+		RoomID:       id,
+		Name:         r.Form.Get("name"),
+		StartDate:    startDate,
+		EndDate:      endDate,
+		NightlyPrice: price,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate added")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", id), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomRate deletes a seasonal rate
+func (m *Repository) AdminDeleteRoomRate(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageRooms) {
+		return
+	}
+
+	roomID, _ := strconv.Atoi(chi.URLParam(r, "roomID"))
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.DeleteSeasonalRate(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Seasonal rate deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/rates", roomID), http.StatusSeeOther)
+}
+
+// renderRoomRates displays the seasonal rates of a room with the form to add one
+func (m *Repository) renderRoomRates(w http.ResponseWriter, r *http.Request, roomID int, form *forms.Form) {
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rates, err := m.DB.AllSeasonalRatesForRoom(r.Context(), roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["rates"] = rates
+
+	render.Template(w, r, "admin-room-rates.page.htm", &config.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// roomFromForm builds a room from the posted form and validates it
+func roomFromForm(r *http.Request) (models.Room, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "slug", "capacity", "base_price", "weekend_uplift")
+	form.IsSlug("slug")
+	form.IsInt("capacity", 1)
+	form.IsPrice("base_price")
+	form.IsInt("weekend_uplift", 0)
+
+	capacity, _ := strconv.Atoi(r.Form.Get("capacity"))
+	price, _ := forms.ParsePrice(r.Form.Get("base_price"))
+	uplift, _ := strconv.Atoi(r.Form.Get("weekend_uplift"))
+
+	room := models.Room{
+		RoomName:      r.Form.Get("room_name"),
+		Slug:          r.Form.Get("slug"),
+		Description:   r.Form.Get("description"),
+		Capacity:      capacity,
+		BasePrice:     price,
+		WeekendUplift: uplift,
+	}
+
+	return room, form
+}
+
+// renderRoomForm re-displays the room form with its validation errors
+func renderRoomForm(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	stringMap := make(map[string]string)
+	stringMap["base_price"] = form.Get("base_price")
+
+	render.Template(w, r, "admin-room.page.htm", &config.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      form,
+	})
+}
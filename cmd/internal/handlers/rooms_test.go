@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var postRoomTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		"valid-room",
+		url.Values{
+			"room_name":      {"Colonel's Cabin"},
+			"slug":           {"colonels-cabin"},
+			"capacity":       {"2"},
+			"base_price":     {"150.00"},
+			"weekend_uplift": {"10"},
+		},
+		http.StatusSeeOther,
+		"/admin/rooms",
+		"",
+	},
+	{
+		"invalid-slug",
+		url.Values{
+			"room_name":      {"Colonel's Cabin"},
+			"slug":           {"Colonel's Cabin"},
+			"capacity":       {"2"},
+			"base_price":     {"150.00"},
+			"weekend_uplift": {"10"},
+		},
+		http.StatusOK,
+		"",
+		`action="/admin/rooms/new"`,
+	},
+	{
+		"invalid-price",
+		url.Values{
+			"room_name":      {"Colonel's Cabin"},
+			"slug":           {"colonels-cabin"},
+			"capacity":       {"2"},
+			"base_price":     {"abc"},
+			"weekend_uplift": {"10"},
+		},
+		http.StatusOK,
+		"",
+		"Invalid price",
+	},
+	{
+		"database-error",
+		url.Values{
+			"room_name":      {"Colonel's Cabin"},
+			"slug":           {"fail"},
+			"capacity":       {"2"},
+			"base_price":     {"150.00"},
+			"weekend_uplift": {"10"},
+		},
+		http.StatusOK,
+		"",
+		"slug may already be in use",
+	},
+}
+
+func TestRepository_AdminPostNewRoom(t *testing.T) {
+	for _, e := range postRoomTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/new", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostNewRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminDeleteRoom(t *testing.T) {
+	var tests = []struct {
+		name             string
+		id               string
+		expectedLocation string
+	}{
+		{"delete", "1", "/admin/rooms"},
+		{"has-reservations", "2", "/admin/rooms/2"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/delete-room/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+func TestRepository_AdminPostRoomRate(t *testing.T) {
+	var tests = []struct {
+		name               string
+		postedData         url.Values
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{
+			"valid-rate",
+			url.Values{
+				"name":          {"Summer"},
+				"start_date":    {"2021-07-01"},
+				"end_date":      {"2021-08-31"},
+				"nightly_price": {"150"},
+			},
+			http.StatusSeeOther,
+			"",
+		},
+		{
+			"end-before-start",
+			url.Values{
+				"name":          {"Summer"},
+				"start_date":    {"2021-08-31"},
+				"end_date":      {"2021-07-01"},
+				"nightly_price": {"150"},
+			},
+			http.StatusOK,
+			"must not be before",
+		},
+		{
+			"invalid-date",
+			url.Values{
+				"name":          {"Summer"},
+				"start_date":    {"summer"},
+				"end_date":      {"2021-07-01"},
+				"nightly_price": {"150"},
+			},
+			http.StatusOK,
+			"Invalid date",
+		},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/1/rates", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "1")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
@@ -14,7 +14,10 @@ import (
 	scs "github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/justinas/nosurf"
+	"github.com/maslow123/bookings/cmd/internal/authz"
 	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
 	"github.com/maslow123/bookings/cmd/internal/models"
 	"github.com/maslow123/bookings/cmd/internal/render"
 )
@@ -23,10 +26,12 @@ var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../../templates"
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"formatPrice": render.FormatPrice,
+	"roleName":    authz.RoleName,
 }
 var infoLog *log.Logger
 var errorLog *log.Logger
@@ -41,6 +46,10 @@ func TestMain(m *testing.M) {
 	// change this true when in production
 
 	app.InProduction = false
+	app.BaseURL = "http://localhost:8080"
+	app.LinkSecret = []byte("test secret")
+	app.StaffEmails = []string{"me@here.com"}
+	app.StaffNotify = config.NotifyEach
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -56,11 +65,11 @@ func TestMain(m *testing.M) {
 
 	app.Session = session
 
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	defer close(mailChan)
-
-	listenForMail()
+	renderer, err := emails.New("./../../../email-templates")
+	if err != nil {
+		log.Fatal("Cannot parse email templates", err)
+	}
+	app.Emails = renderer
 
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -74,18 +83,11 @@ func TestMain(m *testing.M) {
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
 
-func listenForMail() {
-	go func() {
-		for {
-			_ = <-app.MailChan
-		}
-	}()
-}
-
 func getRoutes() http.Handler {
 	mux := chi.NewRouter()
 
@@ -93,8 +95,8 @@ func getRoutes() http.Handler {
 	mux.Use(SessionLoad)
 	mux.Get("/", http.HandlerFunc(Repo.Home))
 	mux.Get("/about", http.HandlerFunc(Repo.About))
-	mux.Get("/generals-quarters", http.HandlerFunc(Repo.Generals))
-	mux.Get("/majors-suite", http.HandlerFunc(Repo.Majors))
+	mux.Get("/rooms", http.HandlerFunc(Repo.Rooms))
+	mux.Get("/rooms/{slug}", http.HandlerFunc(Repo.Room))
 	mux.Get("/search-availability", http.HandlerFunc(Repo.Availability))
 	mux.Post("/search-availability", http.HandlerFunc(Repo.PostAvailability))
 	mux.Post("/search-availability-json", http.HandlerFunc(Repo.AvailabilityJSON))
@@ -103,9 +105,32 @@ func getRoutes() http.Handler {
 	mux.Get("/contact", http.HandlerFunc(Repo.Contact))
 	mux.Get("/reservation-summary", http.HandlerFunc(Repo.ReservationSummary))
 
+	mux.Get("/manage/{token}", http.HandlerFunc(Repo.ManageReservation))
+	mux.Post("/manage/{token}", http.HandlerFunc(Repo.PostManageReservation))
+	mux.Post("/manage/{token}/cancel", http.HandlerFunc(Repo.PostCancelReservation))
+
+	mux.Get("/calendar/rooms.ics", http.HandlerFunc(Repo.AllRoomsCalendarFeed))
+	mux.Get("/calendar/rooms/{id}.ics", http.HandlerFunc(Repo.RoomCalendarFeed))
+
 	mux.Get("/user/login", http.HandlerFunc(Repo.ShowLogin))
 	mux.Post("/user/login", http.HandlerFunc(Repo.PostShowLogin))
 	mux.Get("/user/logout", http.HandlerFunc(Repo.Logout))
+	mux.Get("/user/forgot-password", http.HandlerFunc(Repo.ShowForgotPassword))
+	mux.Post("/user/forgot-password", http.HandlerFunc(Repo.PostForgotPassword))
+	mux.Get("/user/reset-password/{token}", http.HandlerFunc(Repo.ShowResetPassword))
+	mux.Post("/user/reset-password/{token}", http.HandlerFunc(Repo.PostResetPassword))
+
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(Repo.APINotFound)
+		mux.MethodNotAllowed(Repo.APIMethodNotAllowed)
+		mux.Get("/rooms", Repo.APIRooms)
+		mux.Get("/availability", Repo.APIAvailability)
+		mux.Post("/reservations", Repo.APIPostReservation)
+		mux.Get("/reservations", Repo.APIReservations)
+		mux.Get("/reservations/{id}", Repo.APIReservation)
+		mux.Post("/reservations/{id}/processed", Repo.APIProcessReservation)
+		mux.Delete("/reservations/{id}", Repo.APIDeleteReservation)
+	})
 
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
 
@@ -113,12 +138,53 @@ func getRoutes() http.Handler {
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Get("/admin/blocks/{id}", Repo.AdminShowBlock)
+	mux.Post("/admin/blocks/{id}", Repo.AdminPostShowBlock)
+	mux.Post("/admin/blocks/{id}/unblock", Repo.AdminUnblockNights)
+	mux.Get("/admin/delete-block/{id}/do", Repo.AdminDeleteBlock)
 	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
 	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
 
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
 
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Post("/admin/rooms/new", Repo.AdminPostNewRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostShowRoom)
+	mux.Get("/admin/delete-room/{id}/do", Repo.AdminDeleteRoom)
+	mux.Get("/admin/rooms/{id}/rates", Repo.AdminRoomRates)
+	mux.Post("/admin/rooms/{id}/rates", Repo.AdminPostRoomRate)
+	mux.Get("/admin/delete-rate/{roomID}/{id}/do", Repo.AdminDeleteRoomRate)
+	mux.Get("/admin/rooms/{id}/calendars", Repo.AdminRoomCalendars)
+	mux.Post("/admin/rooms/{id}/calendars", Repo.AdminPostRoomCalendar)
+	mux.Post("/admin/upload-calendar/{roomID}/{id}", Repo.AdminUploadRoomCalendar)
+	mux.Get("/admin/sync-calendar/{roomID}/{id}/do", Repo.AdminSyncRoomCalendar)
+	mux.Get("/admin/delete-calendar/{roomID}/{id}/do", Repo.AdminDeleteRoomCalendar)
+
+	mux.Get("/admin/promo-codes", Repo.AdminPromoCodes)
+	mux.Get("/admin/promo-codes/new", Repo.AdminNewPromoCode)
+	mux.Post("/admin/promo-codes/new", Repo.AdminPostNewPromoCode)
+	mux.Get("/admin/promo-codes/{id}", Repo.AdminShowPromoCode)
+	mux.Post("/admin/promo-codes/{id}", Repo.AdminPostShowPromoCode)
+	mux.Get("/admin/delete-promo-code/{id}/do", Repo.AdminDeletePromoCode)
+
+	mux.Get("/admin/api-tokens", Repo.AdminAPITokens)
+	mux.Post("/admin/api-tokens", Repo.AdminPostAPIToken)
+	mux.Get("/admin/revoke-api-token/{id}/do", Repo.AdminRevokeAPIToken)
+	mux.Get("/admin/audit-log", Repo.AdminAuditLog)
+	mux.Get("/admin/emails", Repo.AdminEmails)
+	mux.Get("/admin/resend-email/{id}/do", Repo.AdminResendEmail)
+
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Get("/admin/users/new", Repo.AdminNewUser)
+	mux.Post("/admin/users/new", Repo.AdminPostNewUser)
+	mux.Get("/admin/users/{id}", Repo.AdminShowUser)
+	mux.Post("/admin/users/{id}", Repo.AdminPostShowUser)
+	mux.Get("/admin/deactivate-user/{id}/do", Repo.AdminDeactivateUser)
+	mux.Get("/admin/activate-user/{id}/do", Repo.AdminActivateUser)
+
 	fileServer := http.FileServer(http.Dir("./assets/"))
 	mux.Handle("/assets/*", http.StripPrefix("/assets", fileServer))
 
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/forms"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/render"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// inviteLifetime is how long a new user can use the link to choose their password
+const inviteLifetime = 7 * 24 * time.Hour
+
+// lastOwnerMessage explains why a change was refused by ErrLastOwner
+const lastOwnerMessage = "There must always be an active owner. Make someone else owner first."
+
+// AdminUsers shows all staff users
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageUsers) {
+		return
+	}
+
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+
+	render.Template(w, r, "admin-users.page.htm", &config.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminNewUser shows the form to invite a user
+func (m *Repository) AdminNewUser(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageUsers) {
+		return
+	}
+
+	renderUserForm(w, r, models.User{AccessLevel: models.AccessFrontDesk}, forms.New(nil))
+}
+
+// AdminPostNewUser creates a user and emails them a link to choose their password
+func (m *Repository) AdminPostNewUser(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageUsers) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, form := userFromForm(r)
+	if !form.Valid() {
+		renderUserForm(w, r, user, form)
+		return
+	}
+
+	user.ID, err = m.DB.InsertUser(r.Context(), user)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		form.Errors.Add("email", "Could not save user, the email may already be in use")
+		renderUserForm(w, r, user, form)
+		return
+	}
+
+	err = m.sendPasswordLink(r.Context(), user, true, inviteLifetime)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invitation sent to %s", user.Email))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminShowUser shows the form to edit a user
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageUsers) {
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	renderUserForm(w, r, user, forms.New(nil))
+}
+
+// AdminPostShowUser updates the details and access level of a user
+func (m *Repository) AdminPostShowUser(w http.ResponseWriter, r *http.Request) {
+	if !m.allowed(w, r, authz.ManageUsers) {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	posted, form := userFromForm(r)
+	posted.ID = user.ID
+	posted.Active = user.Active
+	if !form.Valid() {
+		renderUserForm(w, r, posted, form)
+		return
+	}
+
+	err = m.DB.UpdateUserDetails(r.Context(), posted)
+	if errors.Is(err, repository.ErrLastOwner) {
+		form.Errors.Add("access_level", lastOwnerMessage)
+		renderUserForm(w, r, posted, form)
+		return
+	}
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		form.Errors.Add("email", "Could not save user, the email may already be in use")
+		renderUserForm(w, r, posted, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDeactivateUser stops a user from logging in
+func (m *Repository) AdminDeactivateUser(w http.ResponseWriter, r *http.Request) {
+	m.setUserActive(w, r, 0, "User deactivated")
+}
+
+// AdminActivateUser lets a deactivated user log in again
+func (m *Repository) AdminActivateUser(w http.ResponseWriter, r *http.Request) {
+	m.setUserActive(w, r, 1, "User activated")
+}
+
+// setUserActive activates or deactivates the user of the request
+func (m *Repository) setUserActive(w http.ResponseWriter, r *http.Request, active int, message string) {
+	if !m.allowed(w, r, authz.ManageUsers) {
+		return
+	}
+
+	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+
+	err := m.DB.SetUserActive(r.Context(), id, active)
+	if errors.Is(err, repository.ErrLastOwner) {
+		m.App.Session.Put(r.Context(), "error", lastOwnerMessage)
+		http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", message)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// userFromForm builds a user from the posted form and validates it
+func userFromForm(r *http.Request) (models.User, *forms.Form) {
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email")
+
+	accessLevel, _ := strconv.Atoi(r.Form.Get("access_level"))
+	if !authz.IsRole(accessLevel) {
+		form.Errors.Add("access_level", "Invalid role")
+	}
+
+	user := models.User{
+		FirstName:   r.Form.Get("first_name"),
+		LastName:    r.Form.Get("last_name"),
+		Email:       r.Form.Get("email"),
+		AccessLevel: accessLevel,
+	}
+
+	return user, form
+}
+
+// renderUserForm displays the user form
+func renderUserForm(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["roles"] = authz.Roles()
+
+	render.Template(w, r, "admin-user.page.htm", &config.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+var postNewUserTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{
+		"valid",
+		url.Values{
+			"first_name":   {"Jane"},
+			"last_name":    {"Doe"},
+			"email":        {"jane@here.ca"},
+			"access_level": {"2"},
+		},
+		http.StatusSeeOther,
+		"",
+	},
+	{
+		"invalid-email",
+		url.Values{
+			"first_name":   {"Jane"},
+			"last_name":    {"Doe"},
+			"email":        {"jane"},
+			"access_level": {"2"},
+		},
+		http.StatusOK,
+		"Invalid email address",
+	},
+	{
+		"invalid-role",
+		url.Values{
+			"first_name":   {"Jane"},
+			"last_name":    {"Doe"},
+			"email":        {"jane@here.ca"},
+			"access_level": {"9"},
+		},
+		http.StatusOK,
+		"Invalid role",
+	},
+	{
+		"email-taken",
+		url.Values{
+			"first_name":   {"Jane"},
+			"last_name":    {"Doe"},
+			"email":        {"taken@here.ca"},
+			"access_level": {"2"},
+		},
+		http.StatusOK,
+		"email may already be in use",
+	},
+}
+
+func TestRepository_AdminPostNewUser(t *testing.T) {
+	for _, e := range postNewUserTests {
+		req, _ := http.NewRequest("POST", "/admin/users/new", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostNewUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" {
+			if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+func TestRepository_AdminUserPages(t *testing.T) {
+	var tests = []struct {
+		name         string
+		url          string
+		handler      http.HandlerFunc
+		expectedHTML string
+	}{
+		{"users", "/admin/users", Repo.AdminUsers, "me@here.ca"},
+		{"new-user", "/admin/users/new", Repo.AdminNewUser, "Front desk"},
+		{"show-user", "/admin/users/2", Repo.AdminShowUser, "Deactivate"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "2")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminPostShowUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		accessLevel        string
+		expectedStatusCode int
+		expectedHTML       string
+	}{
+		{"demote-last-owner", "1", "3", http.StatusOK, "There must always be an active owner"},
+		{"valid", "2", "3", http.StatusSeeOther, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{
+			"first_name":   {"John"},
+			"last_name":    {"Smith"},
+			"email":        {"john@here.ca"},
+			"access_level": {e.accessLevel},
+		}
+
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostShowUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestRepository_AdminDeactivateUser(t *testing.T) {
+	var tests = []struct {
+		name             string
+		id               string
+		expectedLocation string
+		expectedError    bool
+	}{
+		{"last-owner", "1", "/admin/users/1", true},
+		{"valid", "2", "/admin/users", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/deactivate-user/"+e.id+"/do", nil)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeactivateUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusSeeOther, rr.Code)
+		}
+
+		location, _ := rr.Result().Location()
+		if location.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, location.String())
+		}
+
+		if e.expectedError != session.Exists(ctx, "error") {
+			t.Errorf("failed %s: expected error in session to be %t", e.name, e.expectedError)
+		}
+	}
+}
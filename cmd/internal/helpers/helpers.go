@@ -1,13 +1,21 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/models"
 )
 
+// contextKey keys the values helpers put in request contexts
+type contextKey string
+
+// apiTokenKey keys the API token a request was authenticated with
+const apiTokenKey contextKey = "api_token"
+
 var app *config.AppConfig
 
 // NewHelpers sets up app config for helpers
@@ -32,3 +40,19 @@ func IsAuthenticate(r *http.Request) bool {
 
 	return exists
 }
+
+// AccessLevel returns the access level of the logged in user, or 0 when nobody is logged in
+func AccessLevel(r *http.Request) int {
+	return app.Session.GetInt(r.Context(), "access_level")
+}
+
+// WithAPIToken returns a copy of ctx for a request authenticated with token
+func WithAPIToken(ctx context.Context, token models.APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey, token)
+}
+
+// APIToken returns the API token the request was authenticated with, if any
+func APIToken(r *http.Request) (models.APIToken, bool) {
+	token, ok := r.Context().Value(apiTokenKey).(models.APIToken)
+	return token, ok
+}
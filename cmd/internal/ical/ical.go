@@ -0,0 +1,99 @@
+// Package ical reads and writes calendars in the iCalendar format (RFC 5545), so that room occupancy
+// can be followed in calendar apps such as Google Calendar or Outlook, and imported from other booking platforms.
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+)
+
+// maxLineLength is the longest a content line may be, in octets, before it is folded
+const maxLineLength = 75
+
+// Calendar is a named list of events
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Event is an all-day event, from the day of Start up to but not including the day of End
+type Event struct {
+	UID         string // stays the same when the event changes, so calendar apps update it
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	URL         string
+	Status      string // CONFIRMED, TENTATIVE or CANCELLED, empty when not given
+	Updated     time.Time
+}
+
+// Write writes the calendar to w
+func (c Calendar) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	writeLine(bw, "BEGIN:VCALENDAR")
+	writeLine(bw, "VERSION:2.0")
+	writeLine(bw, "PRODID:-//Fort Smythe//Bookings//EN")
+	writeLine(bw, "CALSCALE:GREGORIAN")
+	writeLine(bw, "METHOD:PUBLISH")
+	if c.Name != "" {
+		writeLine(bw, "X-WR-CALNAME:"+escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		stamp := e.Updated
+		if stamp.IsZero() {
+			stamp = time.Now()
+		}
+
+		writeLine(bw, "BEGIN:VEVENT")
+		writeLine(bw, "UID:"+escape(e.UID))
+		writeLine(bw, "DTSTAMP:"+stamp.UTC().Format("20060102T150405Z"))
+		writeLine(bw, "DTSTART;VALUE=DATE:"+e.Start.Format("20060102"))
+		writeLine(bw, "DTEND;VALUE=DATE:"+e.End.Format("20060102"))
+		writeLine(bw, "SUMMARY:"+escape(e.Summary))
+		if e.Description != "" {
+			writeLine(bw, "DESCRIPTION:"+escape(e.Description))
+		}
+		if e.URL != "" {
+			writeLine(bw, "URL:"+e.URL)
+		}
+		if e.Status != "" {
+			writeLine(bw, "STATUS:"+e.Status)
+		}
+		writeLine(bw, "TRANSP:OPAQUE")
+		writeLine(bw, "END:VEVENT")
+	}
+
+	writeLine(bw, "END:VCALENDAR")
+
+	return bw.Flush()
+}
+
+// escape escapes the characters with a meaning in text values
+var escape = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+).Replace
+
+// writeLine writes a content line ended by CRLF, folding it so that no line is longer than maxLineLength octets
+func writeLine(w *bufio.Writer, line string) {
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > maxLineLength {
+			// the folded line starts with a space, which counts towards its length
+			w.WriteString("\r\n ")
+			length = 1
+		}
+		w.WriteRune(r)
+		length += size
+	}
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,205 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendar_Write(t *testing.T) {
+	cal := Calendar{
+		Name: "General's Quarters",
+		Events: []Event{
+			{
+				UID:         "reservation-7@example.com",
+				Start:       time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+				End:         time.Date(2021, 6, 3, 0, 0, 0, 0, time.UTC),
+				Summary:     "Reserved: Smith, John",
+				Description: "Phone: 555; email: john@here.ca",
+				Updated:     time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := cal.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"X-WR-CALNAME:General's Quarters\r\n",
+		"UID:reservation-7@example.com\r\n",
+		"DTSTAMP:20210501T103000Z\r\n",
+		"DTSTART;VALUE=DATE:20210601\r\n",
+		"DTEND;VALUE=DATE:20210603\r\n",
+		"SUMMARY:Reserved: Smith\\, John\r\n",
+		"DESCRIPTION:Phone: 555\\; email: john@here.ca\r\n",
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected to find %q in\n%s", expected, out)
+		}
+	}
+
+	if strings.Count(out, "BEGIN:VEVENT") != 1 || strings.Count(out, "END:VEVENT") != 1 {
+		t.Errorf("expected one event in\n%s", out)
+	}
+}
+
+func TestCalendar_WriteFoldsLongLines(t *testing.T) {
+	cal := Calendar{
+		Events: []Event{
+			{
+				UID:         "block-1@example.com",
+				Start:       time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+				End:         time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC),
+				Summary:     "Blocked",
+				Description: strings.Repeat("é", 100),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := cal.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line of %d octets is too long: %q", len(line), line)
+		}
+	}
+
+	unfolded := strings.ReplaceAll(buf.String(), "\r\n ", "")
+	if !strings.Contains(unfolded, "DESCRIPTION:"+strings.Repeat("é", 100)+"\r\n") {
+		t.Errorf("expected the folded description to unfold to the original in\n%s", buf.String())
+	}
+}
+
+func TestParse(t *testing.T) {
+	data := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Airbnb Inc//Hosting Calendar 0.8.8//EN",
+		"X-WR-CALNAME:Airbnb\\, General's Quarters",
+		"BEGIN:VEVENT",
+		"DTSTAMP:20210501T103000Z",
+		"DTSTART;VALUE=DATE:20210601",
+		"DTEND;VALUE=DATE:20210603",
+		"SUMMARY:Reserved",
+		"UID:1418fb94e984-abc@airbnb.com",
+		"DESCRIPTION:Reservation URL: https://www.airbnb.com/hosting/reservations/",
+		" details/HMABCDEF\\nPhone: 555",
+		"BEGIN:VALARM",
+		"UID:alarm-1",
+		"TRIGGER:-PT15M",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:booking-2@example.com",
+		"DTSTART;TZID=\"Europe/Paris\":20210610T150000",
+		"DTEND;TZID=\"Europe/Paris\":20210612T110000",
+		"STATUS:cancelled",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:booking-3@example.com",
+		"DTSTART:20210620",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:No uid",
+		"DTSTART:20210620",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	cal, err := Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cal.Name != "Airbnb, General's Quarters" {
+		t.Errorf("expected the calendar name to be unescaped, but got %q", cal.Name)
+	}
+
+	day := func(d int) time.Time { return time.Date(2021, 6, d, 0, 0, 0, 0, time.UTC) }
+
+	expected := []Event{
+		{
+			UID:         "1418fb94e984-abc@airbnb.com",
+			Start:       day(1),
+			End:         day(3),
+			Summary:     "Reserved",
+			Description: "Reservation URL: https://www.airbnb.com/hosting/reservations/details/HMABCDEF\nPhone: 555",
+			Updated:     time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{UID: "booking-2@example.com", Start: day(10), End: day(13), Status: "CANCELLED"},
+		{UID: "booking-3@example.com", Start: day(20), End: day(21)},
+	}
+
+	if len(cal.Events) != len(expected) {
+		t.Fatalf("expected %d events, but got %d: %+v", len(expected), len(cal.Events), cal.Events)
+	}
+
+	for i, e := range expected {
+		if cal.Events[i] != e {
+			t.Errorf("event %d: expected %+v, but got %+v", i, e, cal.Events[i])
+		}
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	cal := Calendar{
+		Name: "General's Quarters",
+		Events: []Event{
+			{
+				UID:         "reservation-7@example.com",
+				Start:       time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+				End:         time.Date(2021, 6, 3, 0, 0, 0, 0, time.UTC),
+				Summary:     "Reserved: Smith, John",
+				Description: strings.Repeat("Phone; 555\\", 20),
+				Updated:     time.Date(2021, 5, 1, 10, 30, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	err := cal.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed.Name != cal.Name || len(parsed.Events) != 1 || parsed.Events[0] != cal.Events[0] {
+		t.Errorf("expected %+v, but got %+v", cal, parsed)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	var tests = []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"not-a-calendar", "<html></html>"},
+		{"invalid-date", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART:tomorrow\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"no-start", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"unterminated", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART:20210601\n"},
+	}
+
+	for _, e := range tests {
+		_, err := Parse(strings.NewReader(e.data))
+		if err == nil {
+			t.Errorf("failed %s: expected an error", e.name)
+		}
+	}
+}
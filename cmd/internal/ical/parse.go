@@ -0,0 +1,201 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotCalendar is returned by Parse when the data is not an iCalendar file
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// Parse reads a calendar. Only the events are kept, and each event is turned into an all-day event:
+// times are dropped from the start, and an end with a time is moved to the next day, so that the
+// event covers every night it touches. Events without a uid are skipped, as they can't be followed.
+func Parse(r io.Reader) (Calendar, error) {
+	var cal Calendar
+
+	lines, err := unfold(r)
+	if err != nil {
+		return cal, err
+	}
+
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return cal, ErrNotCalendar
+	}
+
+	// components nest, as with an alarm inside an event; properties belong to the innermost one
+	var components []string
+	var e Event
+	var hasEnd bool
+
+	for n, line := range lines {
+		name, params, value, ok := splitLine(line)
+		if !ok {
+			return cal, fmt.Errorf("line %d: invalid content line %q", n+1, line)
+		}
+
+		switch name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(value))
+			if len(components) == 2 && components[1] == "VEVENT" {
+				e = Event{}
+				hasEnd = false
+			}
+			continue
+		case "END":
+			if len(components) == 0 || components[len(components)-1] != strings.ToUpper(value) {
+				return cal, fmt.Errorf("line %d: unexpected END:%s", n+1, value)
+			}
+			if len(components) == 2 && components[1] == "VEVENT" {
+				if e.Start.IsZero() {
+					return cal, fmt.Errorf("line %d: event %q has no start", n+1, e.UID)
+				}
+				if !hasEnd || !e.End.After(e.Start) {
+					e.End = e.Start.AddDate(0, 0, 1)
+				}
+				if e.UID != "" {
+					cal.Events = append(cal.Events, e)
+				}
+			}
+			components = components[:len(components)-1]
+			continue
+		}
+
+		if len(components) == 1 && name == "X-WR-CALNAME" {
+			cal.Name = unescape(value)
+		}
+
+		if len(components) != 2 || components[1] != "VEVENT" {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			e.UID = unescape(value)
+		case "SUMMARY":
+			e.Summary = unescape(value)
+		case "DESCRIPTION":
+			e.Description = unescape(value)
+		case "URL":
+			e.URL = value
+		case "STATUS":
+			e.Status = strings.ToUpper(value)
+		case "DTSTAMP", "LAST-MODIFIED":
+			if t, err := time.Parse("20060102T150405Z", value); err == nil && t.After(e.Updated) {
+				e.Updated = t
+			}
+		case "DTSTART", "DTEND":
+			day, withTime, err := parseDate(value, params)
+			if err != nil {
+				return cal, fmt.Errorf("line %d: invalid %s %q", n+1, name, value)
+			}
+			if name == "DTSTART" {
+				e.Start = day
+			} else {
+				if withTime {
+					day = day.AddDate(0, 0, 1)
+				}
+				e.End = day
+				hasEnd = true
+			}
+		}
+	}
+
+	if len(components) != 0 {
+		return cal, fmt.Errorf("missing END:%s", components[len(components)-1])
+	}
+
+	return cal, nil
+}
+
+// unfold reads the content lines, joining the lines that were folded
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitLine splits a content line into its upper case name, its parameters and its value
+func splitLine(line string) (string, map[string]string, string, bool) {
+	// the value starts at the first colon that is not inside a quoted parameter value
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon <= 0 {
+		return "", nil, "", false
+	}
+
+	parts := strings.Split(line[:colon], ";")
+	params := make(map[string]string)
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return strings.ToUpper(parts[0]), params, line[colon+1:], true
+}
+
+// parseDate returns the day of a DATE or DATE-TIME value, and whether the value had a time other than midnight.
+// The day of a time is the one in the time zone it was given in.
+func parseDate(value string, params map[string]string) (time.Time, bool, error) {
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.Parse("20060102", value)
+		return t, false, err
+	}
+
+	t, err := time.Parse("20060102T150405", strings.TrimSuffix(value, "Z"))
+	if err != nil {
+		return t, false, err
+	}
+
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	return day, !t.Equal(day), nil
+}
+
+// unescape undoes escape
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
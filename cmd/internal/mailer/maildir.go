@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Maildir delivers email to a maildir, where mail clients and developers can read it without a mail server
+type Maildir struct {
+	Dir string
+}
+
+// deliveries counts the messages delivered by this process, to keep their file names unique
+var deliveries int64
+
+// NewMaildir creates a Mailer delivering to the maildir at dir, creating it when needed
+func NewMaildir(dir string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Maildir{Dir: dir}, nil
+}
+
+// Send writes msg to the new directory of the maildir. The message is written to tmp first,
+// so that readers never see a half written file.
+func (m *Maildir) Send(msg Message) error {
+	email := compose(msg)
+	if email.Error != nil {
+		return email.Error
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	name := fmt.Sprintf("%d.M%dP%dQ%d.%s", time.Now().Unix(), time.Now().Nanosecond()/1000, os.Getpid(), atomic.AddInt64(&deliveries, 1), host)
+
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	err = ioutil.WriteFile(tmp, []byte(email.GetMessage()), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
@@ -0,0 +1,85 @@
+// Package mailer sends email through a pluggable transport: an SMTP server in production,
+// a maildir during development, and memory in tests.
+package mailer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+func init() {
+	// calendar apps offer to add attached events only when they are sent as calendars
+	mime.AddExtensionType(".ics", "text/calendar")
+}
+
+// Message is an email ready to be sent
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	HTML    string
+	Text    string // plain text alternative of HTML, left out when empty
+
+	Attachments []Attachment
+}
+
+// Attachment is a file attached to a message
+type Attachment struct {
+	Name    string // file name, whose extension gives the content type
+	Content []byte
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Config chooses and configures the transport of a Mailer
+type Config struct {
+	Transport  string // smtp or maildir
+	Host       string
+	Port       int
+	Username   string // no authentication when empty
+	Password   string
+	Encryption string // none, ssl or starttls
+	Dir        string // directory of the maildir
+}
+
+// New creates the Mailer configured by c
+func New(c Config) (Mailer, error) {
+	switch c.Transport {
+	case "smtp":
+		return NewSMTP(c.Host, c.Port, c.Username, c.Password, c.Encryption)
+	case "maildir":
+		return NewMaildir(c.Dir)
+	}
+
+	return nil, fmt.Errorf("unknown mail transport %q, expected smtp or maildir", c.Transport)
+}
+
+// compose builds the email of msg
+func compose(msg Message) *mail.Email {
+	email := mail.NewMSG()
+	email.SetFrom(msg.From).AddTo(msg.To).SetSubject(msg.Subject)
+
+	if msg.Text != "" {
+		email.SetBody(mail.TextPlain, msg.Text)
+		email.AddAlternative(mail.TextHTML, msg.HTML)
+	} else {
+		email.SetBody(mail.TextHTML, msg.HTML)
+	}
+
+	for _, a := range msg.Attachments {
+		attach(email, a)
+	}
+
+	return email
+}
+
+// attach adds a to email, whose content type is taken from the extension of its name
+func attach(email *mail.Email, a Attachment) {
+	email.AddAttachmentBase64(base64.StdEncoding.EncodeToString(a.Content), a.Name)
+}
@@ -0,0 +1,267 @@
+package mailer
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	From:    "me@here.com",
+	To:      "john@smith.com",
+	Subject: "Reservation Confirmation",
+	HTML:    "<strong>Reservation Confirmation</strong>",
+	Text:    "Reservation Confirmation",
+}
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		name  string
+		c     Config
+		valid bool
+	}{
+		{"smtp", Config{Transport: "smtp", Host: "localhost", Port: 1025}, true},
+		{"smtp-starttls", Config{Transport: "smtp", Host: "localhost", Port: 587, Encryption: "starttls"}, true},
+		{"maildir", Config{Transport: "maildir", Dir: t.TempDir()}, true},
+		{"unknown-transport", Config{Transport: "pigeon"}, false},
+		{"unknown-encryption", Config{Transport: "smtp", Host: "localhost", Port: 1025, Encryption: "rot13"}, false},
+	}
+
+	for _, e := range tests {
+		_, err := New(e.c)
+		if e.valid && err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("failed %s: expected an error", e.name)
+		}
+	}
+}
+
+func TestMaildir_Send(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := NewMaildir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = m.Send(testMessage)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*"))
+	if len(files) != 2 {
+		t.Fatalf("expected 2 messages in new, but got %d", len(files))
+	}
+
+	tmp, _ := filepath.Glob(filepath.Join(dir, "tmp", "*"))
+	if len(tmp) != 0 {
+		t.Errorf("expected tmp to be empty, but got %v", tmp)
+	}
+
+	data, _ := ioutil.ReadFile(files[0])
+	for _, expected := range []string{"To: <john@smith.com>", "Subject: Reservation Confirmation", "multipart/alternative", "<strong>Reservation Confirmation</strong>"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected to find %q in\n%s", expected, data)
+		}
+	}
+}
+
+func TestMaildir_SendAttachment(t *testing.T) {
+	dir := t.TempDir()
+
+	m, err := NewMaildir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := testMessage
+	msg.Attachments = []Attachment{{Name: "reservation.ics", Content: []byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")}}
+
+	err = m.Send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 message in new, but got %d", len(files))
+	}
+
+	data, _ := ioutil.ReadFile(files[0])
+	for _, expected := range []string{"multipart/mixed", "text/calendar", `filename="reservation.ics"`, "QkVHSU46VkNBTEVOREFS"} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected to find %q in\n%s", expected, data)
+		}
+	}
+}
+
+func TestMemory_Send(t *testing.T) {
+	m := NewMemory()
+
+	err := m.Send(testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sent := m.Messages(); len(sent) != 1 || !reflect.DeepEqual(sent[0], testMessage) {
+		t.Errorf("expected the message to be kept, but got %v", sent)
+	}
+
+	m.Err = errors.New("mail server down")
+	if m.Send(testMessage) == nil {
+		t.Error("expected Send to fail with Err")
+	}
+
+	m.Reset()
+	if len(m.Messages()) != 0 {
+		t.Error("expected no messages after Reset")
+	}
+}
+
+func TestSMTP_Send(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	received := make(chan string, 1)
+	go fakeSMTPServer(l, received)
+
+	port := l.Addr().(*net.TCPAddr).Port
+	m, err := NewSMTP("127.0.0.1", port, "", "", "none")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send(testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "Subject: Reservation Confirmation") {
+		t.Errorf("expected the message to reach the server, but got\n%s", data)
+	}
+}
+
+func TestSMTP_SendConnectionRefused(t *testing.T) {
+	// take a free port, and close it so that nothing listens there
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	m, _ := NewSMTP("127.0.0.1", port, "", "", "none")
+
+	if m.Send(testMessage) == nil {
+		t.Error("expected an error when the server can't be reached")
+	}
+}
+
+func TestSMTP_SendClosesConnection(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server refuses the sender, and reports when the client hangs up
+	closed := make(chan struct{})
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(closed)
+				return
+			}
+			if strings.HasPrefix(strings.ToUpper(line), "MAIL") {
+				conn.Write([]byte("550 Sender refused\r\n"))
+				continue
+			}
+			conn.Write([]byte("250 OK\r\n"))
+		}
+	}()
+
+	port := l.Addr().(*net.TCPAddr).Port
+	m, _ := NewSMTP("127.0.0.1", port, "", "", "none")
+
+	if m.Send(testMessage) == nil {
+		t.Error("expected an error when the server refuses the message")
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the connection to be closed after sending")
+	}
+}
+
+// fakeSMTPServer accepts one connection on l, plays just enough SMTP to take a message,
+// and sends the message to received
+func fakeSMTPServer(l net.Listener, received chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				received <- data.String()
+				reply("250 OK")
+				continue
+			}
+			data.WriteString(line)
+			continue
+		}
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			inData = true
+			reply("354 End data with <CR><LF>.<CR><LF>")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
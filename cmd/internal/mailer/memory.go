@@ -0,0 +1,47 @@
+package mailer
+
+import "sync"
+
+// Memory keeps the messages it is given, for tests to look at
+type Memory struct {
+	// Err is returned by Send when set, without keeping the message
+	Err error
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemory creates a Mailer keeping its messages in memory
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Send keeps msg, or fails with m.Err
+func (m *Memory) Send(msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.messages = append(m.messages, msg)
+
+	return nil
+}
+
+// Messages returns the messages sent so far
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+// Reset forgets the messages sent so far
+func (m *Memory) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = nil
+}
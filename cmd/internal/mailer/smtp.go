@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"fmt"
+	"time"
+
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// SMTP sends email through an SMTP server, with a new connection for each message
+type SMTP struct {
+	server *mail.SMTPServer
+}
+
+// NewSMTP creates a Mailer sending through the SMTP server at host and port. Username and password
+// are only sent when username is set; encryption is none, ssl or starttls.
+func NewSMTP(host string, port int, username, password, encryption string) (*SMTP, error) {
+	server := mail.NewSMTPClient()
+	server.Host = host
+	server.Port = port
+	server.Username = username
+	server.Password = password
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	switch encryption {
+	case "", "none":
+		server.Encryption = mail.EncryptionNone
+	case "ssl":
+		server.Encryption = mail.EncryptionSSLTLS
+	case "starttls":
+		server.Encryption = mail.EncryptionSTARTTLS
+	default:
+		return nil, fmt.Errorf("unknown SMTP encryption %q, expected none, ssl or starttls", encryption)
+	}
+
+	return &SMTP{server: server}, nil
+}
+
+// Send sends msg through the SMTP server, closing the connection afterwards
+func (s *SMTP) Send(msg Message) error {
+	email := compose(msg)
+	if email.Error != nil {
+		return email.Error
+	}
+
+	client, err := s.server.Connect()
+	if err != nil {
+		return err
+	}
+	// the library already closes the connection after sending, but not when it fails before. The error
+	// of closing a connection that is already closed is ignored.
+	defer client.Close()
+
+	return email.Send(client)
+}
@@ -0,0 +1,316 @@
+// Package migrate applies the SQL migrations embedded in the binary, recording the versions applied
+// in the schema_migrations table
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/maslow123/bookings/migrations"
+)
+
+// Migration is a change of the schema, with the statements undoing it
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration, and when it was applied
+type Status struct {
+	Migration
+	AppliedAt time.Time // zero when not applied
+}
+
+// Migrator migrates a database
+type Migrator struct {
+	DB         *sql.DB
+	Driver     string      // postgres or sqlite
+	Migrations []Migration // ordered by version
+}
+
+// fileName matches the files of migrations, such as 20211024090000_create_email_outbox_table.up.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// New returns a migrator of db with the embedded migrations of driver, postgres or sqlite
+func New(db *sql.DB, driver string) (*Migrator, error) {
+	if driver != "postgres" && driver != "sqlite" {
+		return nil, fmt.Errorf("no migrations for %q, expected postgres or sqlite", driver)
+	}
+
+	list, err := Load(migrations.FS, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Driver: driver, Migrations: list}, nil
+}
+
+// Load reads the migrations in dir of fsys, ordered by version. Every migration must have both its
+// up and down files, even if the down one has nothing to undo.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	files, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[string]*Migration{}
+
+	for _, f := range files {
+		match := fileName.FindStringSubmatch(f.Name())
+		if match == nil {
+			return nil, fmt.Errorf("%s is not named <version>_<name>.up.sql or <version>_<name>.down.sql", f.Name())
+		}
+		version, name, direction := match[1], match[2], match[3]
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %s is named both %s and %s", version, m.Name, name)
+		}
+
+		if direction == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	var list []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %s_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		list = append(list, *m)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Version < list[j].Version
+	})
+
+	return list, nil
+}
+
+// Up applies the migrations that were not applied yet, oldest first, and returns them. Each one is
+// applied in its own transaction, so a failing migration leaves the database as it was before it.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+
+	for _, mig := range m.Migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+
+		ran, err := m.run(ctx, mig, mig.Up, func(tx *sql.Tx) (sql.Result, error) {
+			return tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, mig.Version, time.Now().UTC())
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %s_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		if ran {
+			done = append(done, mig)
+		}
+	}
+
+	return done, nil
+}
+
+// Down rolls back the latest steps migrations that were applied, newest first, and returns them
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+
+	var done []Migration
+
+	for _, v := range versions {
+		if len(done) == steps {
+			break
+		}
+
+		mig, ok := m.find(v)
+		if !ok {
+			return done, fmt.Errorf("version %s is applied, but this binary has no migration to roll it back", v)
+		}
+
+		ran, err := m.run(ctx, mig, mig.Down, func(tx *sql.Tx) (sql.Result, error) {
+			return tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+		})
+		if err != nil {
+			return done, fmt.Errorf("rolling back migration %s_%s: %w", mig.Version, mig.Name, err)
+		}
+
+		if ran {
+			done = append(done, mig)
+		}
+	}
+
+	return done, nil
+}
+
+// Status returns every migration, with when it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, mig := range m.Migrations {
+		statuses = append(statuses, Status{Migration: mig, AppliedAt: applied[mig.Version]})
+	}
+
+	return statuses, nil
+}
+
+// find returns the migration of version
+func (m *Migrator) find(version string) (Migration, bool) {
+	for _, mig := range m.Migrations {
+		if mig.Version == version {
+			return mig, true
+		}
+	}
+
+	return Migration{}, false
+}
+
+// run executes stmts of mig and record, which adds or removes mig in schema_migrations, in a single
+// transaction. It reports false when record changed nothing, because another instance of the
+// application migrated the database first.
+func (m *Migrator) run(ctx context.Context, mig Migration, stmts string, record func(tx *sql.Tx) (sql.Result, error)) (bool, error) {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if m.Driver == "postgres" {
+		// SQLite transactions take the write lock of the database when they begin, Postgres ones
+		// must wait for other instances migrating at the same time
+		_, err = tx.ExecContext(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	result, err := record(tx)
+	if err != nil {
+		// the version is already there when another instance applied it first
+		if applied, _ := m.isApplied(ctx, tx, mig.Version); applied {
+			return false, nil
+		}
+		return false, err
+	}
+
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if changed == 0 {
+		return false, nil
+	}
+
+	_, err = tx.ExecContext(ctx, stmts)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// isApplied reports whether version is in schema_migrations
+func (m *Migrator) isApplied(ctx context.Context, tx *sql.Tx, version string) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations WHERE version = $1`, version).Scan(&count)
+
+	return count > 0, err
+}
+
+// applied returns when each version in schema_migrations was applied, creating the table if needed
+func (m *Migrator) applied(ctx context.Context) (map[string]time.Time, error) {
+	err := m.createTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[string]time.Time{}
+
+	for rows.Next() {
+		var version string
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+
+	return applied, rows.Err()
+}
+
+// createTable creates schema_migrations. On Postgres, databases that were migrated with soda before
+// the migrations were embedded keep the versions recorded in its schema_migration table.
+func (m *Migrator) createTable(ctx context.Context) error {
+	_, err := m.DB.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version VARCHAR(14) PRIMARY KEY,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	if m.Driver != "postgres" {
+		return nil
+	}
+
+	var soda bool
+	err = m.DB.QueryRowContext(ctx, `SELECT to_regclass('schema_migration') IS NOT NULL`).Scan(&soda)
+	if err != nil || !soda {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `
+		INSERT INTO schema_migrations (version, applied_at)
+		SELECT version, $1 FROM schema_migration
+		WHERE NOT EXISTS (SELECT 1 FROM schema_migrations)
+	`, time.Now().UTC())
+
+	return err
+}
@@ -0,0 +1,174 @@
+package migrate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/maslow123/bookings/cmd/internal/driver"
+	"github.com/maslow123/bookings/migrations"
+)
+
+// newMigrator returns a migrator of the embedded SQLite migrations on an empty database
+func newMigrator(t *testing.T) *Migrator {
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "bookings.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+
+	m, err := New(db.SQL, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestLoad(t *testing.T) {
+	for _, dir := range []string{"postgres", "sqlite"} {
+		list, err := Load(migrations.FS, dir)
+		if err != nil {
+			t.Errorf("%s: %v", dir, err)
+			continue
+		}
+
+		if len(list) == 0 {
+			t.Errorf("%s: no migrations", dir)
+		}
+
+		for i := 1; i < len(list); i++ {
+			if list[i-1].Version >= list[i].Version {
+				t.Errorf("%s: %s is before %s", dir, list[i-1].Version, list[i].Version)
+			}
+		}
+	}
+
+	badFiles := []fstest.MapFS{
+		{"m/20211101090000_rooms.up.sql": {Data: []byte("SELECT 1")}},
+		{"m/rooms.up.sql": {Data: []byte("SELECT 1")}, "m/rooms.down.sql": {Data: []byte("SELECT 1")}},
+		{"m/20211101090000_rooms.up.sql": {Data: []byte("SELECT 1")}, "m/20211101090000_users.down.sql": {Data: []byte("SELECT 1")}},
+	}
+
+	for i, fsys := range badFiles {
+		if _, err := Load(fsys, "m"); err == nil {
+			t.Errorf("bad files %d: expected an error", i)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(nil, "memory"); err == nil {
+		t.Error("expected an error for a driver without migrations")
+	}
+}
+
+func TestMigrator_UpDownStatus(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t)
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(m.Migrations) {
+		t.Errorf("applied %d migrations, expected %d", len(done), len(m.Migrations))
+	}
+
+	var rooms int
+	if err := m.DB.QueryRow(`SELECT COUNT(*) FROM rooms`).Scan(&rooms); err != nil || rooms == 0 {
+		t.Errorf("expected the seeded rooms, got %d, %v", rooms, err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt.IsZero() {
+			t.Errorf("%s is not applied", s.Version)
+		}
+	}
+
+	done, err = m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Errorf("applied %d migrations twice", len(done))
+	}
+
+	done, err = m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := m.Migrations[len(m.Migrations)-1]
+	if len(done) != 1 || done[0].Version != last.Version {
+		t.Fatalf("rolled back %v, expected %s", done, last.Version)
+	}
+
+	statuses, err = m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statuses[len(statuses)-1].AppliedAt.IsZero() {
+		t.Error("the rolled back migration is still applied")
+	}
+
+	done, err = m.Down(ctx, len(m.Migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(m.Migrations)-1 {
+		t.Errorf("rolled back %d more migrations, expected %d", len(done), len(m.Migrations)-1)
+	}
+
+	if _, err := m.DB.Exec(`SELECT COUNT(*) FROM rooms`); err == nil {
+		t.Error("expected rooms to be dropped")
+	}
+}
+
+func TestMigrator_FailingMigration(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t)
+	m.Migrations = []Migration{
+		{Version: "1", Name: "create_things", Up: `CREATE TABLE things (id INTEGER)`, Down: `DROP TABLE things`},
+		{Version: "2", Name: "broken", Up: `CREATE TABLE others (id INTEGER); INSERT INTO nowhere VALUES (1)`, Down: `DROP TABLE others`},
+	}
+
+	done, err := m.Up(ctx)
+	if err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+	if len(done) != 1 || done[0].Version != "1" {
+		t.Errorf("applied %v, expected only version 1", done)
+	}
+
+	if _, err := m.DB.Exec(`SELECT COUNT(*) FROM others`); err == nil {
+		t.Error("expected the broken migration to be rolled back")
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].AppliedAt.IsZero() || !statuses[1].AppliedAt.IsZero() {
+		t.Errorf("expected only version 1 to be recorded, got %v", statuses)
+	}
+}
+
+func TestMigrator_DownUnknownVersion(t *testing.T) {
+	ctx := context.Background()
+	m := newMigrator(t)
+
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m.Migrations = m.Migrations[:len(m.Migrations)-1]
+
+	if _, err := m.Down(ctx, 1); err == nil {
+		t.Error("expected an error rolling back a version without migration")
+	}
+}
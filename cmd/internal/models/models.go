@@ -4,6 +4,14 @@ import (
 	"time"
 )
 
+// Access levels of staff users, each one including everything the ones below it may do
+const (
+	AccessReadOnly  = 1
+	AccessFrontDesk = 2
+	AccessManager   = 3
+	AccessOwner     = 4
+)
+
 // User is the user model
 type User struct {
 	ID          int
@@ -12,18 +20,62 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
+	Active      int
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
-// Room is the room model
-type Room struct {
+// PasswordReset is a request to reset the password of a user, stored by the hash of its token
+type PasswordReset struct {
 	ID        int
-	RoomName  string
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	Used      int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// APIToken lets a machine client act as its user through the API, limited to its scopes.
+// Only the hash of the token is stored.
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	TokenHash  string
+	Scopes     []string
+	LastUsedAt time.Time // zero when never used
+	Revoked    int
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
+}
+
+// AuditEntry records a change made by a user, and the API token they made it with, if any
+type AuditEntry struct {
+	ID         int
+	UserID     int
+	APITokenID int
+	Action     string
+	Details    string
+	CreatedAt  time.Time
+	User       User
+	APIToken   APIToken
+}
+
+// Room is the room model
+type Room struct {
+	ID            int
+	RoomName      string
+	Slug          string
+	Description   string
+	Capacity      int
+	BasePrice     int // nightly price in cents
+	WeekendUplift int // percentage added to Friday and Saturday nights
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
 // Restriction is the restriction model
 type Restriction struct {
 	ID              int
@@ -34,20 +86,31 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
-	Processed int
+	ID          int
+	FirstName   string
+	LastName    string
+	Email       string
+	Phone       string
+	StartDate   time.Time
+	EndDate     time.Time
+	RoomID      int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Processed   int
+	TotalPrice  int // in cents, after any discount
+	PromoCodeID int
+	Discount    int // in cents
+	Cancelled   int
 }
 
+// Kinds of room restrictions, by restriction id
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionExternal    = 3 // imported from the calendar of another booking platform
+)
+
 // RoomRestriction is the room restriction model
 type RoomRestriction struct {
 	ID            int
@@ -56,18 +119,119 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	SourceID      int    // calendar source of external restrictions
+	ExternalUID   string // uid of the event an external restriction was imported from
+	Note          string // why the owner blocked the nights
+	CreatedBy     int    // user who blocked the nights, 0 when unknown
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
 	Reservation   Reservation
 	Restriction   Restriction
+	Creator       User
+}
+
+// CalendarSource is the iCalendar feed of a room on another booking platform, imported as external restrictions
+type CalendarSource struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string    // empty for sources that are only uploaded
+	LastSyncedAt time.Time // zero when never synced
+	LastError    string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// SeasonalRate overrides the base price of a room for the nights from StartDate to EndDate, inclusive
+type SeasonalRate struct {
+	ID           int
+	RoomID       int
+	Name         string
+	StartDate    time.Time
+	EndDate      time.Time
+	NightlyPrice int // in cents
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// NightlyRate is the price of a single night of a stay
+type NightlyRate struct {
+	Date    time.Time
+	Price   int // in cents
+	Season  string
+	Weekend bool
+}
+
+// Quote is the price of a stay, night by night
+type Quote struct {
+	RoomID    int
+	StartDate time.Time
+	EndDate   time.Time
+	Nights    []NightlyRate
+	Total     int // in cents
+}
+
+// Promo code discount types
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// PromoCode is a discount code guests can enter when making a reservation
+type PromoCode struct {
+	ID             int
+	Code           string
+	Description    string
+	DiscountType   string // DiscountPercent or DiscountFixed
+	Amount         int    // a percentage, or cents for fixed discounts
+	ValidFrom      time.Time
+	ValidUntil     time.Time
+	RoomID         int // 0 applies to every room
+	MaxRedemptions int // 0 is unlimited
+	Redemptions    int
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
 
 // MailData holds an email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string // html
+	Text        string // plain text alternative of Content
+	Attachments []Attachment
 }
+
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name    string // file name, whose extension gives the content type
+	Content []byte
+}
+
+// Statuses of the emails in the outbox
+const (
+	EmailPending = "pending"
+	EmailSent    = "sent"
+	EmailDead    = "dead" // given up on after too many failed attempts
+)
+
+// OutboxEmail is an email stored to be sent by the outbox workers, kept once sent
+type OutboxEmail struct {
+	ID int
+	MailData
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	SentAt        time.Time // zero until sent
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Kinds of the emails sent to guests on a schedule around their stay, each at most once per reservation
+const (
+	ScheduledPreArrival = "pre-arrival" // reminder some days before the guest arrives
+	ScheduledPostStay   = "post-stay"   // thanks and a review request some days after the guest leaves
+)
@@ -0,0 +1,169 @@
+// Package outbox sends the emails stored in the outbox table with a pool of workers, retrying
+// the ones that fail with exponential backoff until they are given up on.
+package outbox
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/mailer"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+const (
+	// DefaultWorkers is how many emails are sent at once when config.AppConfig.MailWorkers is not set
+	DefaultWorkers = 2
+	// DefaultMaxAttempts is how many times an email is tried when config.AppConfig.MailMaxAttempts is not set
+	DefaultMaxAttempts = 8
+
+	firstRetry = time.Minute
+	maxRetry   = 6 * time.Hour
+
+	// lease is how long a claimed email is left to its worker before another one may try it
+	lease = 5 * time.Minute
+
+	// batchPerWorker is how many emails are claimed at once for each worker
+	batchPerWorker = 10
+)
+
+// Sender sends the emails of the outbox
+type Sender struct {
+	App         *config.AppConfig
+	DB          repository.DatabaseRepo
+	Mailer      mailer.Mailer
+	Workers     int
+	MaxAttempts int
+}
+
+// New creates a Sender using the mailer and the settings of a
+func New(db repository.DatabaseRepo, a *config.AppConfig) *Sender {
+	s := &Sender{
+		App:         a,
+		DB:          db,
+		Mailer:      a.Mailer,
+		Workers:     a.MailWorkers,
+		MaxAttempts: a.MailMaxAttempts,
+	}
+
+	if s.Workers <= 0 {
+		s.Workers = DefaultWorkers
+	}
+	if s.MaxAttempts <= 0 {
+		s.MaxAttempts = DefaultMaxAttempts
+	}
+
+	return s
+}
+
+// Run sends the emails that are due every interval, until stop is closed.
+// While there are more due emails than a batch, it goes on without waiting.
+func (s *Sender) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+
+	for {
+		if s.SendDue(ctx) == s.Workers*batchPerWorker {
+			select {
+			case <-stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// SendDue claims a batch of the emails that are due and sends them with the pool of workers,
+// returning how many it claimed
+func (s *Sender) SendDue(ctx context.Context) int {
+	emails, err := s.DB.ClaimEmails(ctx, s.Workers*batchPerWorker, lease)
+	if err != nil {
+		s.App.ErrorLog.Println(err)
+		return 0
+	}
+
+	jobs := make(chan models.OutboxEmail)
+	var wg sync.WaitGroup
+
+	for i := 0; i < s.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range jobs {
+				s.send(ctx, e)
+			}
+		}()
+	}
+
+	for _, e := range emails {
+		jobs <- e
+	}
+	close(jobs)
+	wg.Wait()
+
+	return len(emails)
+}
+
+// send sends an email of the outbox and records how it went: sent, to be retried later,
+// or given up on once it has been tried MaxAttempts times
+func (s *Sender) send(ctx context.Context, e models.OutboxEmail) {
+	err := s.Mailer.Send(Compose(e.MailData))
+
+	if err == nil {
+		s.App.InfoLog.Printf("Email %q sent to %s", e.Subject, e.To)
+		err = s.DB.MarkEmailSent(ctx, e.ID)
+	} else if e.Attempts >= s.MaxAttempts {
+		s.App.ErrorLog.Printf("giving up on email %d %q to %s after %d attempts: %s", e.ID, e.Subject, e.To, e.Attempts, err)
+		err = s.DB.MarkEmailDead(ctx, e.ID, err.Error())
+	} else {
+		retry := Backoff(e.Attempts)
+		s.App.ErrorLog.Printf("sending email %d %q to %s, retrying in %s: %s", e.ID, e.Subject, e.To, retry, err)
+		err = s.DB.RetryEmail(ctx, e.ID, time.Now().Add(retry), err.Error())
+	}
+
+	if err != nil {
+		s.App.ErrorLog.Println(err)
+	}
+}
+
+// Backoff returns how long to wait before trying an email again after attempts failed attempts,
+// doubling from a minute up to six hours
+func Backoff(attempts int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= maxRetry {
+			return maxRetry
+		}
+	}
+
+	return wait
+}
+
+// Compose makes the message of msg, whose content was rendered by package emails
+func Compose(msg models.MailData) mailer.Message {
+	m := mailer.Message{
+		From:    msg.From,
+		To:      msg.To,
+		Subject: msg.Subject,
+		HTML:    msg.Content,
+		Text:    msg.Text,
+	}
+
+	for _, a := range msg.Attachments {
+		m.Attachments = append(m.Attachments, mailer.Attachment{Name: a.Name, Content: a.Content})
+	}
+
+	return m
+}
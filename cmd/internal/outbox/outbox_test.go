@@ -0,0 +1,248 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/mailer"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// fakeOutbox keeps the outbox in memory, implementing the outbox methods of the database repository
+type fakeOutbox struct {
+	repository.DatabaseRepo
+	mu     sync.Mutex
+	emails map[int]*models.OutboxEmail
+}
+
+func newFakeOutbox(emails ...models.OutboxEmail) *fakeOutbox {
+	f := &fakeOutbox{emails: make(map[int]*models.OutboxEmail)}
+	for i := range emails {
+		e := emails[i]
+		e.Status = models.EmailPending
+		f.emails[e.ID] = &e
+	}
+
+	return f
+}
+
+func (f *fakeOutbox) ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var claimed []models.OutboxEmail
+	for _, e := range f.emails {
+		if len(claimed) == limit {
+			break
+		}
+		if e.Status != models.EmailPending || e.NextAttemptAt.After(time.Now()) {
+			continue
+		}
+		e.Attempts++
+		e.NextAttemptAt = time.Now().Add(lease)
+		claimed = append(claimed, *e)
+	}
+
+	return claimed, nil
+}
+
+func (f *fakeOutbox) MarkEmailSent(ctx context.Context, id int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.emails[id].Status = models.EmailSent
+	return nil
+}
+
+func (f *fakeOutbox) RetryEmail(ctx context.Context, id int, at time.Time, lastError string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.emails[id].NextAttemptAt = at
+	f.emails[id].LastError = lastError
+	return nil
+}
+
+func (f *fakeOutbox) MarkEmailDead(ctx context.Context, id int, lastError string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.emails[id].Status = models.EmailDead
+	f.emails[id].LastError = lastError
+	return nil
+}
+
+func newTestSender(db repository.DatabaseRepo, m mailer.Mailer) *Sender {
+	app := &config.AppConfig{
+		InfoLog:         log.New(ioutil.Discard, "", 0),
+		ErrorLog:        log.New(ioutil.Discard, "", 0),
+		Mailer:          m,
+		MailMaxAttempts: 3,
+	}
+
+	return New(db, app)
+}
+
+// testMail renders the confirmation of a reservation, as the handlers put it in the outbox
+func testMail(t *testing.T) models.MailData {
+	renderer, err := emails.New("./../../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	email, err := renderer.Render(emails.Confirmation{
+		Reservation: models.Reservation{
+			ID:        1,
+			FirstName: "John",
+			LastName:  "Smith",
+			StartDate: time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2100, 6, 3, 0, 0, 0, 0, time.UTC),
+			Room:      models.Room{RoomName: "General's Quarters"},
+		},
+		ManageURL: "http://localhost:8080/reservations/manage",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return models.MailData{
+		To:      "john@smith.com",
+		From:    "me@here.com",
+		Subject: email.Subject,
+		Content: email.HTML,
+		Text:    email.Text,
+	}
+}
+
+func testEmail(t *testing.T, id int) models.OutboxEmail {
+	return models.OutboxEmail{ID: id, MailData: testMail(t)}
+}
+
+func TestSender_SendDue(t *testing.T) {
+	db := newFakeOutbox(testEmail(t, 1), testEmail(t, 2), testEmail(t, 3))
+	m := mailer.NewMemory()
+	s := newTestSender(db, m)
+
+	if n := s.SendDue(context.Background()); n != 3 {
+		t.Errorf("expected 3 emails to be claimed, but got %d", n)
+	}
+
+	if sent := m.Messages(); len(sent) != 3 {
+		t.Errorf("expected 3 emails to be sent, but got %d", len(sent))
+	}
+
+	for id, e := range db.emails {
+		if e.Status != models.EmailSent {
+			t.Errorf("expected email %d to be sent, but it is %s", id, e.Status)
+		}
+	}
+
+	if n := s.SendDue(context.Background()); n != 0 {
+		t.Errorf("expected sent emails not to be claimed again, but got %d", n)
+	}
+}
+
+func TestSender_SendDueRetries(t *testing.T) {
+	db := newFakeOutbox(testEmail(t, 1))
+	m := mailer.NewMemory()
+	m.Err = errors.New("mail server down")
+	s := newTestSender(db, m)
+
+	before := time.Now()
+	s.SendDue(context.Background())
+
+	e := db.emails[1]
+	if e.Status != models.EmailPending || e.LastError != "mail server down" {
+		t.Errorf("expected the email to wait for a retry with its error, but got %+v", e)
+	}
+	if e.NextAttemptAt.Before(before.Add(Backoff(1))) {
+		t.Errorf("expected the retry to wait %s, but it is due at %s", Backoff(1), e.NextAttemptAt)
+	}
+
+	// not due yet
+	if n := s.SendDue(context.Background()); n != 0 {
+		t.Errorf("expected the email to wait, but %d were claimed", n)
+	}
+
+	// the last of the 3 attempts gives up
+	for i := 0; i < 2; i++ {
+		e.NextAttemptAt = time.Time{}
+		s.SendDue(context.Background())
+	}
+
+	if e.Status != models.EmailDead || e.Attempts != 3 {
+		t.Errorf("expected the email to be given up on after 3 attempts, but got %+v", e)
+	}
+
+	// the server is back, the email stays dead until it is resent
+	m.Err = nil
+	e.NextAttemptAt = time.Time{}
+	if n := s.SendDue(context.Background()); n != 0 || len(m.Messages()) != 0 {
+		t.Errorf("expected dead emails not to be sent")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{8, 128 * time.Minute},
+		{9, 256 * time.Minute},
+		{10, 6 * time.Hour},
+		{50, 6 * time.Hour},
+	}
+
+	for _, e := range tests {
+		if result := Backoff(e.attempts); result != e.expected {
+			t.Errorf("failed %d attempts: expected %s, but got %s", e.attempts, e.expected, result)
+		}
+	}
+}
+
+func TestCompose(t *testing.T) {
+	confirmation := testMail(t)
+
+	withAttachment := testMail(t)
+	withAttachment.Attachments = []models.Attachment{{Name: "reservation.ics", Content: []byte("BEGIN:VCALENDAR")}}
+
+	var tests = []struct {
+		name string
+		msg  models.MailData
+	}{
+		{"confirmation", confirmation},
+		{"attachment", withAttachment},
+	}
+
+	for _, e := range tests {
+		msg := Compose(e.msg)
+
+		if msg.To != e.msg.To || msg.From != e.msg.From || msg.Subject != e.msg.Subject {
+			t.Errorf("failed %s: expected the addresses and subject in the message, but got %v", e.name, msg)
+		}
+		if msg.HTML != e.msg.Content || msg.Text != e.msg.Text || !strings.Contains(msg.HTML, "John") {
+			t.Errorf("failed %s: expected the rendered content in the message, but got %v", e.name, msg)
+		}
+
+		if len(msg.Attachments) != len(e.msg.Attachments) {
+			t.Errorf("failed %s: expected %d attachments, but got %d", e.name, len(e.msg.Attachments), len(msg.Attachments))
+		}
+		for i, a := range msg.Attachments {
+			if a.Name != e.msg.Attachments[i].Name || string(a.Content) != string(e.msg.Attachments[i].Content) {
+				t.Errorf("failed %s: expected attachment %s, but got %s", e.name, e.msg.Attachments[i].Name, a.Name)
+			}
+		}
+	}
+}
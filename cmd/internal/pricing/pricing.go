@@ -0,0 +1,122 @@
+package pricing
+
+import (
+	"errors"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+// ErrInvalidStay is returned when the departure is not after the arrival
+var ErrInvalidStay = errors.New("departure must be after arrival")
+
+// Quote prices a stay in room from start to end, night by night.
+// Each night costs the room's base price, unless a seasonal rate covers it; when
+// several seasonal rates overlap, the one starting last wins. Friday and Saturday
+// nights then get the room's weekend uplift on top.
+func Quote(room models.Room, rates []models.SeasonalRate, start, end time.Time) (models.Quote, error) {
+	quote := models.Quote{
+		RoomID:    room.ID,
+		StartDate: start,
+		EndDate:   end,
+	}
+
+	if !end.After(start) {
+		return quote, ErrInvalidStay
+	}
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		night := models.NightlyRate{
+			Date:  d,
+			Price: room.BasePrice,
+		}
+
+		if rate, ok := rateForNight(rates, d); ok {
+			night.Price = rate.NightlyPrice
+			night.Season = rate.Name
+		}
+
+		if d.Weekday() == time.Friday || d.Weekday() == time.Saturday {
+			night.Weekend = true
+			night.Price += percentOf(night.Price, room.WeekendUplift)
+		}
+
+		quote.Nights = append(quote.Nights, night)
+		quote.Total += night.Price
+	}
+
+	return quote, nil
+}
+
+// rateForNight finds the seasonal rate covering the night of d
+func rateForNight(rates []models.SeasonalRate, d time.Time) (models.SeasonalRate, bool) {
+	var found models.SeasonalRate
+	ok := false
+
+	for _, r := range rates {
+		if d.Before(r.StartDate) || d.After(r.EndDate) {
+			continue
+		}
+
+		if !ok || r.StartDate.After(found.StartDate) {
+			found = r
+			ok = true
+		}
+	}
+
+	return found, ok
+}
+
+// percentOf returns percent of price, rounded to the nearest cent
+func percentOf(price, percent int) int {
+	return (price*percent + 50) / 100
+}
+
+// Reasons a promo code can't be used
+var (
+	ErrPromoNotYetValid = errors.New("promo code is not valid yet")
+	ErrPromoExpired     = errors.New("promo code has expired")
+	ErrPromoWrongRoom   = errors.New("promo code is for another room")
+	ErrPromoExhausted   = errors.New("promo code has been fully redeemed")
+)
+
+// CheckPromoCode returns why code can't be used to book roomID on the day of now, or nil if it can
+func CheckPromoCode(code models.PromoCode, roomID int, now time.Time) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if today.Before(code.ValidFrom) {
+		return ErrPromoNotYetValid
+	}
+
+	if today.After(code.ValidUntil) {
+		return ErrPromoExpired
+	}
+
+	if code.RoomID > 0 && code.RoomID != roomID {
+		return ErrPromoWrongRoom
+	}
+
+	if code.MaxRedemptions > 0 && code.Redemptions >= code.MaxRedemptions {
+		return ErrPromoExhausted
+	}
+
+	return nil
+}
+
+// Discount returns the discount code gives on total, which is never more than total
+func Discount(code models.PromoCode, total int) int {
+	var discount int
+
+	switch code.DiscountType {
+	case models.DiscountPercent:
+		discount = percentOf(total, code.Amount)
+	case models.DiscountFixed:
+		discount = code.Amount
+	}
+
+	if discount > total {
+		return total
+	}
+
+	return discount
+}
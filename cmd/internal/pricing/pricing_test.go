@@ -0,0 +1,125 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestQuote(t *testing.T) {
+	room := models.Room{
+		ID:            1,
+		BasePrice:     10000,
+		WeekendUplift: 20,
+	}
+
+	rates := []models.SeasonalRate{
+		{Name: "Summer", StartDate: date("2021-07-01"), EndDate: date("2021-08-31"), NightlyPrice: 15000},
+		{Name: "Festival", StartDate: date("2021-07-14"), EndDate: date("2021-07-15"), NightlyPrice: 30000},
+	}
+
+	var tests = []struct {
+		name     string
+		start    string
+		end      string
+		expected []int
+	}{
+		// 2021-06-01 is a Tuesday
+		{"base-weekdays", "2021-06-01", "2021-06-03", []int{10000, 10000}},
+		// 2021-06-04 is a Friday
+		{"base-weekend", "2021-06-03", "2021-06-06", []int{10000, 12000, 12000}},
+		{"into-season", "2021-06-29", "2021-07-02", []int{10000, 10000, 15000}},
+		// 2021-07-16 is a Friday
+		{"overlapping-seasons", "2021-07-13", "2021-07-17", []int{15000, 30000, 30000, 18000}},
+	}
+
+	for _, e := range tests {
+		q, err := Quote(room, rates, date(e.start), date(e.end))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		if len(q.Nights) != len(e.expected) {
+			t.Errorf("%s: expected %d nights but got %d", e.name, len(e.expected), len(q.Nights))
+			continue
+		}
+
+		total := 0
+		for i, n := range q.Nights {
+			if n.Price != e.expected[i] {
+				t.Errorf("%s: night %s expected %d but got %d", e.name, n.Date.Format("2006-01-02"), e.expected[i], n.Price)
+			}
+			total += e.expected[i]
+		}
+
+		if q.Total != total {
+			t.Errorf("%s: expected total %d but got %d", e.name, total, q.Total)
+		}
+	}
+}
+
+func TestQuote_InvalidStay(t *testing.T) {
+	_, err := Quote(models.Room{}, nil, date("2021-06-02"), date("2021-06-02"))
+	if err != ErrInvalidStay {
+		t.Errorf("expected ErrInvalidStay but got %v", err)
+	}
+}
+
+func TestCheckPromoCode(t *testing.T) {
+	code := models.PromoCode{
+		ValidFrom:      date("2021-06-01"),
+		ValidUntil:     date("2021-06-30"),
+		RoomID:         1,
+		MaxRedemptions: 10,
+		Redemptions:    9,
+	}
+
+	var tests = []struct {
+		name     string
+		roomID   int
+		now      time.Time
+		used     int
+		expected error
+	}{
+		{"valid", 1, date("2021-06-15"), 9, nil},
+		{"first-day", 1, date("2021-06-01"), 9, nil},
+		{"last-day", 1, date("2021-06-30").Add(23 * time.Hour), 9, nil},
+		{"not-yet-valid", 1, date("2021-05-31"), 9, ErrPromoNotYetValid},
+		{"expired", 1, date("2021-07-01"), 9, ErrPromoExpired},
+		{"wrong-room", 2, date("2021-06-15"), 9, ErrPromoWrongRoom},
+		{"exhausted", 1, date("2021-06-15"), 10, ErrPromoExhausted},
+	}
+
+	for _, e := range tests {
+		code.Redemptions = e.used
+		if err := CheckPromoCode(code, e.roomID, e.now); err != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestDiscount(t *testing.T) {
+	var tests = []struct {
+		name     string
+		code     models.PromoCode
+		total    int
+		expected int
+	}{
+		{"percent", models.PromoCode{DiscountType: models.DiscountPercent, Amount: 10}, 34000, 3400},
+		{"fixed", models.PromoCode{DiscountType: models.DiscountFixed, Amount: 5000}, 34000, 5000},
+		{"fixed-above-total", models.PromoCode{DiscountType: models.DiscountFixed, Amount: 5000}, 3000, 3000},
+	}
+
+	for _, e := range tests {
+		if result := Discount(e.code, e.total); result != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, result)
+		}
+	}
+}
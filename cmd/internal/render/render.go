@@ -10,14 +10,17 @@ import (
 	"time"
 
 	"github.com/justinas/nosurf"
+	"github.com/maslow123/bookings/cmd/internal/authz"
 	"github.com/maslow123/bookings/cmd/internal/config"
 )
 
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"add":         Add,
+	"formatPrice": FormatPrice,
+	"roleName":    authz.RoleName,
 }
 
 var app *config.AppConfig
@@ -28,6 +31,11 @@ func NewRenderer(a *config.AppConfig) {
 	app = a
 }
 
+// SetTemplatePath sets the directory the page templates are read from
+func SetTemplatePath(path string) {
+	pathToTemplates = path
+}
+
 // HumanDate returns time in YYYY-MM-DD
 func HumanDate(t time.Time) string {
 	return t.Format("2006-01-02")
@@ -53,6 +61,11 @@ func Add(a, b int) int {
 	return a + b
 }
 
+// FormatPrice formats an amount in cents as 99.50
+func FormatPrice(cents int) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
+
 // AddDefaultData adds data for all templates
 func AddDefaultData(td *config.TemplateData, r *http.Request) *config.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -62,6 +75,7 @@ func AddDefaultData(td *config.TemplateData, r *http.Request) *config.TemplateDa
 
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
+		td.AccessLevel = app.Session.GetInt(r.Context(), "access_level")
 	}
 	return td
 }
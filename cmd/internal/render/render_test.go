@@ -37,3 +37,20 @@ func getSession() (*http.Request, error) {
 
 	return r, nil
 }
+
+func TestFormatPrice(t *testing.T) {
+	var tests = []struct {
+		cents    int
+		expected string
+	}{
+		{12000, "120.00"},
+		{9950, "99.50"},
+		{5, "0.05"},
+	}
+
+	for _, e := range tests {
+		if result := FormatPrice(e.cents); result != e.expected {
+			t.Errorf("FormatPrice(%d): expected %s but got %s", e.cents, e.expected, result)
+		}
+	}
+}
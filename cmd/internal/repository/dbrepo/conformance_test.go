@@ -0,0 +1,60 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/driver"
+	"github.com/maslow123/bookings/cmd/internal/migrate"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+	"github.com/maslow123/bookings/cmd/internal/repository/repotest"
+)
+
+func TestMemoryRepo_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewMemoryRepo(&config.AppConfig{})
+	})
+}
+
+func TestSQLiteRepo_Conformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "bookings.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.SQL.Close() })
+
+		m, err := migrate.New(db.SQL, "sqlite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.Up(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		return NewSQLiteRepo(db.SQL, &config.AppConfig{})
+	})
+}
+
+// TestPostgresRepo_Conformance runs against the migrated database in BOOKINGS_TEST_POSTGRES, a
+// connection string such as "host=localhost dbname=bookings_test user=postgres"
+func TestPostgresRepo_Conformance(t *testing.T) {
+	dsn := os.Getenv("BOOKINGS_TEST_POSTGRES")
+	if dsn == "" {
+		t.Skip("BOOKINGS_TEST_POSTGRES is not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repotest.Run(t, func(t *testing.T) repository.DatabaseRepo {
+		return NewPostgresRepo(db, &config.AppConfig{})
+	})
+}
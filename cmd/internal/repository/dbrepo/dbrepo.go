@@ -2,14 +2,36 @@ package dbrepo
 
 import (
 	"database/sql"
+	"errors"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/models"
 	"github.com/maslow123/bookings/cmd/internal/repository"
+	"github.com/mattn/go-sqlite3"
 )
 
+// pgExclusionViolation is the Postgres error code raised by an EXCLUDE constraint
+const pgExclusionViolation = "23P01"
+
+// sqliteNoOverlap is the message of the SQLite triggers refusing overlapping room restrictions
+const sqliteNoOverlap = "room_restrictions_no_overlap"
+
+// DefaultTimeout is how long a query may take when config.AppConfig.DBTimeout is not set
+const DefaultTimeout = 3 * time.Second
+
 type postgresDBRepo struct {
-	App *config.AppConfig
-	DB  *sql.DB
+	App     *config.AppConfig
+	DB      *sql.DB
+	Timeout time.Duration // how long each query may take, within the deadline of its context
+}
+
+type sqliteDBRepo struct {
+	App     *config.AppConfig
+	DB      *sql.DB
+	Timeout time.Duration // how long each query may take, within the deadline of its context
 }
 
 type testDBRepo struct {
@@ -18,9 +40,30 @@ type testDBRepo struct {
 }
 
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	timeout := a.DBTimeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
 	return &postgresDBRepo{
-		App: a,
-		DB:  conn,
+		App:     a,
+		DB:      conn,
+		Timeout: timeout,
+	}
+}
+
+// NewSQLiteRepo returns a repository on a SQLite database opened by driver.ConnectSQLite,
+// with the schema of migrations/sqlite
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	timeout := a.DBTimeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	return &sqliteDBRepo{
+		App:     a,
+		DB:      conn,
+		Timeout: timeout,
 	}
 }
 
@@ -29,3 +72,44 @@ func NewTestingsRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// restrictionError maps an overlapping room restriction, refused by Postgres or SQLite, to
+// repository.ErrRoomUnavailable
+func restrictionError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgExclusionViolation {
+		return repository.ErrRoomUnavailable
+	}
+
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint && strings.Contains(sqliteErr.Error(), sqliteNoOverlap) {
+		return repository.ErrRoomUnavailable
+	}
+
+	return err
+}
+
+// splitBlock returns what remains of block once the nights from start up to end are freed:
+// nothing, a shorter block, or two blocks on either side of the freed nights
+func splitBlock(block models.RoomRestriction, start, end time.Time) []models.RoomRestriction {
+	// freeing nights outside the block leaves it as it is
+	if !start.Before(block.EndDate) || !end.After(block.StartDate) {
+		return []models.RoomRestriction{block}
+	}
+
+	var pieces []models.RoomRestriction
+
+	if block.StartDate.Before(start) {
+		before := block
+		before.EndDate = start
+		pieces = append(pieces, before)
+	}
+
+	if end.Before(block.EndDate) {
+		after := block
+		after.StartDate = end
+		pieces = append(pieces, after)
+	}
+
+	return pieces
+}
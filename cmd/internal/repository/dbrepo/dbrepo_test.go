@@ -0,0 +1,89 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/models"
+)
+
+func TestSplitBlock(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2100, 6, d, 0, 0, 0, 0, time.UTC) }
+
+	// the block covers the nights of 1 to 9 June
+	block := models.RoomRestriction{ID: 1, RoomID: 1, StartDate: day(1), EndDate: day(10), Note: "Painting"}
+
+	var tests = []struct {
+		name     string
+		start    time.Time
+		end      time.Time
+		expected [][2]time.Time
+	}{
+		{"whole-block", day(1), day(10), nil},
+		{"more-than-the-block", day(1).AddDate(0, 0, -5), day(15), nil},
+		{"first-nights", day(1), day(4), [][2]time.Time{{day(4), day(10)}}},
+		{"last-nights", day(8), day(10), [][2]time.Time{{day(1), day(8)}}},
+		{"middle", day(4), day(6), [][2]time.Time{{day(1), day(4)}, {day(6), day(10)}}},
+		{"outside", day(12), day(14), [][2]time.Time{{day(1), day(10)}}},
+	}
+
+	for _, e := range tests {
+		pieces := splitBlock(block, e.start, e.end)
+
+		if len(pieces) != len(e.expected) {
+			t.Errorf("failed %s: expected %d pieces, but got %d", e.name, len(e.expected), len(pieces))
+			continue
+		}
+
+		for i, p := range pieces {
+			if !p.StartDate.Equal(e.expected[i][0]) || !p.EndDate.Equal(e.expected[i][1]) {
+				t.Errorf("failed %s: expected piece %d to be %v, but got %s to %s", e.name, i, e.expected[i], p.StartDate, p.EndDate)
+			}
+			if p.Note != block.Note || p.RoomID != block.RoomID {
+				t.Errorf("failed %s: expected piece %d to keep the room and note of the block", e.name, i)
+			}
+		}
+	}
+}
+
+func TestNewPostgresRepo_Timeout(t *testing.T) {
+	var tests = []struct {
+		name     string
+		timeout  time.Duration
+		expected time.Duration
+	}{
+		{"default", 0, DefaultTimeout},
+		{"configured", 10 * time.Second, 10 * time.Second},
+	}
+
+	for _, e := range tests {
+		repo := NewPostgresRepo(nil, &config.AppConfig{DBTimeout: e.timeout}).(*postgresDBRepo)
+		if repo.Timeout != e.expected {
+			t.Errorf("failed %s: expected a timeout of %s, but got %s", e.name, e.expected, repo.Timeout)
+		}
+	}
+}
+
+func TestPostgresRepo_CancelledContext(t *testing.T) {
+	// nothing listens there, the query must give up before it tries to connect
+	db, err := sql.Open("pgx", "host=127.0.0.1 port=1 dbname=bookings user=nobody connect_timeout=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := NewPostgresRepo(db, &config.AppConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = repo.AllRooms(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the query to stop with its request, but got %v", err)
+	}
+}
@@ -0,0 +1,2110 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/pricing"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MemoryDBRepo keeps the whole database in memory, following the same rules as Postgres: overlapping
+// room restrictions are refused, deletes cascade and missing rows are sql.ErrNoRows. Nothing survives
+// a restart. Faults can be injected with Fail.
+type MemoryDBRepo struct {
+	App *config.AppConfig
+
+	mu     sync.Mutex
+	faults map[string]error // by method name
+	ids    map[string]int   // last id given, by table
+
+	users           map[int]models.User
+	passwordResets  map[int]models.PasswordReset
+	apiTokens       map[int]models.APIToken
+	auditLog        map[int]models.AuditEntry
+	rooms           map[int]models.Room
+	reservations    map[int]models.Reservation
+	restrictions    map[int]models.RoomRestriction
+	seasonalRates   map[int]models.SeasonalRate
+	promoCodes      map[int]models.PromoCode
+	calendarSources map[int]models.CalendarSource
+	outbox          map[int]models.OutboxEmail
+	scheduledEmails map[scheduledEmail]bool
+}
+
+// scheduledEmail is a scheduled email kind sent for a reservation
+type scheduledEmail struct {
+	reservationID int
+	kind          string
+}
+
+// NewMemoryRepo creates an in-memory database holding the rooms seeded by the migrations
+func NewMemoryRepo(a *config.AppConfig) *MemoryDBRepo {
+	m := &MemoryDBRepo{
+		App:             a,
+		faults:          make(map[string]error),
+		ids:             make(map[string]int),
+		users:           make(map[int]models.User),
+		passwordResets:  make(map[int]models.PasswordReset),
+		apiTokens:       make(map[int]models.APIToken),
+		auditLog:        make(map[int]models.AuditEntry),
+		rooms:           make(map[int]models.Room),
+		reservations:    make(map[int]models.Reservation),
+		restrictions:    make(map[int]models.RoomRestriction),
+		seasonalRates:   make(map[int]models.SeasonalRate),
+		promoCodes:      make(map[int]models.PromoCode),
+		calendarSources: make(map[int]models.CalendarSource),
+		outbox:          make(map[int]models.OutboxEmail),
+		scheduledEmails: make(map[scheduledEmail]bool),
+	}
+
+	description := "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember."
+	for _, r := range []models.Room{
+		{RoomName: "General's Quarters", Slug: "generals-quarters"},
+		{RoomName: "Major's Suite", Slug: "majors-suite"},
+	} {
+		r.ID = m.nextID("rooms")
+		r.Description = description
+		r.Capacity = 2
+		r.BasePrice = 10000
+		r.WeekendUplift = 20
+		r.CreatedAt = time.Now()
+		r.UpdatedAt = time.Now()
+		m.rooms[r.ID] = r
+	}
+
+	return m
+}
+
+// Fail makes every call of the method named method return err, until Fail is called again for it
+// with a nil err
+func (m *MemoryDBRepo) Fail(method string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err == nil {
+		delete(m.faults, method)
+		return
+	}
+
+	m.faults[method] = err
+}
+
+// SeedUser inserts an active user who logs in with password, and returns its id
+func (m *MemoryDBRepo) SeedUser(u models.User, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.uniqueUserEmail(u.Email, 0); err != nil {
+		return 0, err
+	}
+
+	u.ID = m.nextID("users")
+	u.Password = string(hashedPassword)
+	u.Active = 1
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = time.Now()
+	m.users[u.ID] = u
+
+	return u.ID, nil
+}
+
+// check returns why the call of method must fail: its context is done, or a fault was injected for it
+func (m *MemoryDBRepo) check(ctx context.Context, method string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.faults[method]
+}
+
+// nextID returns the id of a new row of table
+func (m *MemoryDBRepo) nextID(table string) int {
+	m.ids[table]++
+	return m.ids[table]
+}
+
+// duplicateError is the error of a row breaking the unique index of a table
+func duplicateError(index string) error {
+	return fmt.Errorf("duplicate key value violates unique constraint %q", index)
+}
+
+// overlaps reports whether the nights from start up to end overlap restriction r
+func overlaps(start, end time.Time, r models.RoomRestriction) bool {
+	return start.Before(r.EndDate) && end.After(r.StartDate)
+}
+
+// restrictionConflict returns repository.ErrRoomUnavailable when r overlaps another restriction of its
+// room, ignoring those ignore reports true for, as the exclusion constraint of room_restrictions does
+func (m *MemoryDBRepo) restrictionConflict(r models.RoomRestriction, ignore func(other models.RoomRestriction) bool) error {
+	// an empty range overlaps nothing
+	if !r.StartDate.Before(r.EndDate) {
+		return nil
+	}
+
+	for _, other := range m.restrictions {
+		if other.ID == r.ID || other.RoomID != r.RoomID || (ignore != nil && ignore(other)) {
+			continue
+		}
+		if overlaps(r.StartDate, r.EndDate, other) {
+			return repository.ErrRoomUnavailable
+		}
+	}
+
+	return nil
+}
+
+// roomAvailable reports whether no restriction of room roomID overlaps the nights from start up to end
+func (m *MemoryDBRepo) roomAvailable(roomID int, start, end time.Time) bool {
+	for _, r := range m.restrictions {
+		if r.RoomID == roomID && overlaps(start, end, r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// insertRestriction stores r as a new restriction, unless it overlaps another one
+func (m *MemoryDBRepo) insertRestriction(r models.RoomRestriction) (int, error) {
+	if err := m.restrictionConflict(r, nil); err != nil {
+		return 0, err
+	}
+
+	r.ID = m.nextID("room_restrictions")
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.restrictions[r.ID] = r
+
+	return r.ID, nil
+}
+
+// deleteRestrictions deletes the restrictions that match reports true for
+func (m *MemoryDBRepo) deleteRestrictions(match func(r models.RoomRestriction) bool) {
+	for id, r := range m.restrictions {
+		if match(r) {
+			delete(m.restrictions, id)
+		}
+	}
+}
+
+// withRoom returns res with the id and name of its room, as joined by the reservation queries
+func (m *MemoryDBRepo) withRoom(res models.Reservation) models.Reservation {
+	room := m.rooms[res.RoomID]
+	res.Room = models.Room{ID: room.ID, RoomName: room.RoomName}
+
+	return res
+}
+
+// sortedReservations returns the reservations that match reports true for, by start date
+func (m *MemoryDBRepo) sortedReservations(match func(res models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+	for _, res := range m.reservations {
+		if match(res) {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+
+	sort.Slice(reservations, func(i, j int) bool {
+		a, b := reservations[i], reservations[j]
+		if !a.StartDate.Equal(b.StartDate) {
+			return a.StartDate.Before(b.StartDate)
+		}
+		return a.ID < b.ID
+	})
+
+	return reservations
+}
+
+// enqueue puts mail in the outbox
+func (m *MemoryDBRepo) enqueue(mail []models.MailData) {
+	for _, msg := range mail {
+		e := models.OutboxEmail{
+			ID:            m.nextID("email_outbox"),
+			MailData:      copyMail(msg),
+			Status:        models.EmailPending,
+			NextAttemptAt: time.Now(),
+			CreatedAt:     time.Now(),
+			UpdatedAt:     time.Now(),
+		}
+		m.outbox[e.ID] = e
+	}
+}
+
+// copyMail returns msg with its own copy of the attachments, so that callers can't change stored emails
+func copyMail(msg models.MailData) models.MailData {
+	if msg.Attachments != nil {
+		msg.Attachments = append([]models.Attachment(nil), msg.Attachments...)
+	}
+
+	return msg
+}
+
+// copyAPIToken returns t with its own copy of the scopes, so that callers can't change stored tokens
+func copyAPIToken(t models.APIToken) models.APIToken {
+	if len(t.Scopes) == 0 {
+		t.Scopes = nil
+	} else {
+		t.Scopes = append([]string(nil), t.Scopes...)
+	}
+
+	return t
+}
+
+// AllUsers returns all staff users
+func (m *MemoryDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllUsers"); err != nil {
+		return nil, err
+	}
+
+	var users []models.User
+	for _, u := range m.users {
+		u.Password = ""
+		users = append(users, u)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if a.LastName != b.LastName {
+			return a.LastName < b.LastName
+		}
+		if a.FirstName != b.FirstName {
+			return a.FirstName < b.FirstName
+		}
+		return a.ID < b.ID
+	})
+
+	return users, nil
+}
+
+// InsertReservation inserts a reservation into the database
+func (m *MemoryDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertReservation"); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	stored := models.Reservation{
+		ID:        m.nextID("reservations"),
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+		RoomID:    res.RoomID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	m.reservations[stored.ID] = stored
+
+	return stored.ID, nil
+}
+
+// InsertRoomRestriction inserts a room restriction into the database
+func (m *MemoryDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertRoomRestriction"); err != nil {
+		return err
+	}
+
+	_, err := m.insertRestriction(models.RoomRestriction{
+		StartDate:     r.StartDate,
+		EndDate:       r.EndDate,
+		RoomID:        r.RoomID,
+		ReservationID: r.ReservationID,
+		RestrictionID: r.RestrictionID,
+	})
+
+	return err
+}
+
+// CreateBooking inserts a reservation and its room restriction, redeems its promo code and puts the
+// emails returned by mail for the id of the new reservation in the outbox, all or nothing
+func (m *MemoryDBRepo) CreateBooking(ctx context.Context, res models.Reservation, mail func(id int) ([]models.MailData, error)) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "CreateBooking"); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	if !m.roomAvailable(res.RoomID, res.StartDate, res.EndDate) {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	var promo models.PromoCode
+	if res.PromoCodeID > 0 {
+		var ok bool
+		promo, ok = m.promoCodes[res.PromoCodeID]
+		if !ok || (promo.MaxRedemptions > 0 && promo.Redemptions >= promo.MaxRedemptions) {
+			return 0, repository.ErrPromoCodeExhausted
+		}
+	}
+
+	// nothing is stored until every step succeeded
+	newID := m.ids["reservations"] + 1
+
+	var emails []models.MailData
+	if mail != nil {
+		var err error
+		emails, err = mail(newID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	m.nextID("reservations")
+	m.reservations[newID] = models.Reservation{
+		ID:          newID,
+		FirstName:   res.FirstName,
+		LastName:    res.LastName,
+		Email:       res.Email,
+		Phone:       res.Phone,
+		StartDate:   res.StartDate,
+		EndDate:     res.EndDate,
+		RoomID:      res.RoomID,
+		TotalPrice:  res.TotalPrice,
+		PromoCodeID: res.PromoCodeID,
+		Discount:    res.Discount,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+
+	if res.PromoCodeID > 0 {
+		promo.Redemptions++
+		promo.UpdatedAt = time.Now()
+		m.promoCodes[promo.ID] = promo
+	}
+
+	_, err := m.insertRestriction(models.RoomRestriction{
+		StartDate:     res.StartDate,
+		EndDate:       res.EndDate,
+		RoomID:        res.RoomID,
+		ReservationID: newID,
+		RestrictionID: models.RestrictionReservation,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	m.enqueue(emails)
+
+	return newID, nil
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability exists
+func (m *MemoryDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "SearchAvailabilityByDatesByRoomID"); err != nil {
+		return false, err
+	}
+
+	return m.roomAvailable(roomID, start, end), nil
+}
+
+// SearchAvailabilityForAllRooms return a slice of available rooms, if any. For given date range
+func (m *MemoryDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "SearchAvailabilityForAllRooms"); err != nil {
+		return nil, err
+	}
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if m.roomAvailable(room.ID, start, end) {
+			rooms = append(rooms, models.Room{ID: room.ID, RoomName: room.RoomName})
+		}
+	}
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	return rooms, nil
+}
+
+// GetRoomByID return room data
+func (m *MemoryDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetRoomByID"); err != nil {
+		return models.Room{}, err
+	}
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return room, sql.ErrNoRows
+	}
+
+	return room, nil
+}
+
+// GetUserByID return user by id
+func (m *MemoryDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetUserByID"); err != nil {
+		return models.User{}, err
+	}
+
+	u, ok := m.users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+
+	return u, nil
+}
+
+// GetUserByEmail return user by email
+func (m *MemoryDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetUserByEmail"); err != nil {
+		return models.User{}, err
+	}
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+
+	return models.User{}, sql.ErrNoRows
+}
+
+// uniqueUserEmail returns an error when a user other than id already has email
+func (m *MemoryDBRepo) uniqueUserEmail(email string, id int) error {
+	for _, u := range m.users {
+		if u.Email == email && u.ID != id {
+			return duplicateError("users_email_idx")
+		}
+	}
+
+	return nil
+}
+
+// UpdateUser updates a user in the database. u.Password must already be hashed with bcrypt. The access
+// level is left as it is, UpdateUserDetails changes it keeping an owner.
+func (m *MemoryDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateUser"); err != nil {
+		return err
+	}
+
+	stored, ok := m.users[u.ID]
+	if !ok {
+		return nil
+	}
+
+	if err := m.uniqueUserEmail(u.Email, u.ID); err != nil {
+		return err
+	}
+
+	stored.FirstName = u.FirstName
+	stored.LastName = u.LastName
+	stored.Email = u.Email
+	stored.Password = u.Password
+	stored.UpdatedAt = time.Now()
+	m.users[u.ID] = stored
+
+	return nil
+}
+
+// Authenticate autheticates a user
+func (m *MemoryDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "Authenticate"); err != nil {
+		return 0, "", err
+	}
+
+	for _, u := range m.users {
+		if u.Email != email || u.Active != 1 {
+			continue
+		}
+
+		err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(testPassword))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return 0, "", errors.New("Incorrect password")
+		} else if err != nil {
+			return u.ID, "", err
+		}
+
+		return u.ID, u.Password, nil
+	}
+
+	return 0, "", sql.ErrNoRows
+}
+
+// AllReservations returns a slice of all reservations
+func (m *MemoryDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllReservations"); err != nil {
+		return nil, err
+	}
+
+	return m.sortedReservations(func(res models.Reservation) bool { return true }), nil
+}
+
+// AllNewReservations returns a slice of the reservations not processed yet
+func (m *MemoryDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllNewReservations"); err != nil {
+		return nil, err
+	}
+
+	return m.sortedReservations(func(res models.Reservation) bool { return res.Processed == 0 }), nil
+}
+
+// GetReservationByID returns one reservation by ID
+func (m *MemoryDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetReservationByID"); err != nil {
+		return models.Reservation{}, err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return res, sql.ErrNoRows
+	}
+
+	return m.withRoom(res), nil
+}
+
+// UpdateReservation updates the guest details of a reservation
+func (m *MemoryDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateReservation"); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[r.ID]
+	if !ok {
+		return nil
+	}
+
+	res.FirstName = r.FirstName
+	res.LastName = r.LastName
+	res.Email = r.Email
+	res.Phone = r.Phone
+	res.UpdatedAt = time.Now()
+	m.reservations[r.ID] = res
+
+	return nil
+}
+
+// DeleteReservation deletes one reservations by id, with its room restriction and scheduled emails
+func (m *MemoryDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "DeleteReservation"); err != nil {
+		return err
+	}
+
+	m.deleteReservation(id)
+
+	return nil
+}
+
+// deleteReservation deletes reservation id and the rows cascading from it
+func (m *MemoryDBRepo) deleteReservation(id int) {
+	delete(m.reservations, id)
+	m.deleteRestrictions(func(r models.RoomRestriction) bool { return r.ReservationID == id })
+
+	for s := range m.scheduledEmails {
+		if s.reservationID == id {
+			delete(m.scheduledEmails, s)
+		}
+	}
+}
+
+// UpdateProcessedForReservation updates processed for a reservation by id
+func (m *MemoryDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateProcessedForReservation"); err != nil {
+		return err
+	}
+
+	if res, ok := m.reservations[id]; ok {
+		res.Processed = processed
+		m.reservations[id] = res
+	}
+
+	return nil
+}
+
+// AllRooms get all rooms
+func (m *MemoryDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllRooms"); err != nil {
+		return nil, err
+	}
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		if rooms[i].RoomName != rooms[j].RoomName {
+			return rooms[i].RoomName < rooms[j].RoomName
+		}
+		return rooms[i].ID < rooms[j].ID
+	})
+
+	return rooms, nil
+}
+
+// GetRoomBySlug returns a room by its slug
+func (m *MemoryDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetRoomBySlug"); err != nil {
+		return models.Room{}, err
+	}
+
+	for _, room := range m.rooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+
+	return models.Room{}, sql.ErrNoRows
+}
+
+// uniqueRoomSlug returns an error when a room other than id already has slug
+func (m *MemoryDBRepo) uniqueRoomSlug(slug string, id int) error {
+	for _, room := range m.rooms {
+		if room.Slug == slug && room.ID != id {
+			return duplicateError("rooms_slug_idx")
+		}
+	}
+
+	return nil
+}
+
+// InsertRoom inserts a room into the database
+func (m *MemoryDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertRoom"); err != nil {
+		return 0, err
+	}
+
+	if err := m.uniqueRoomSlug(r.Slug, 0); err != nil {
+		return 0, err
+	}
+
+	r.ID = m.nextID("rooms")
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.rooms[r.ID] = r
+
+	return r.ID, nil
+}
+
+// UpdateRoom updates a room in the database
+func (m *MemoryDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateRoom"); err != nil {
+		return err
+	}
+
+	stored, ok := m.rooms[r.ID]
+	if !ok {
+		return nil
+	}
+
+	if err := m.uniqueRoomSlug(r.Slug, r.ID); err != nil {
+		return err
+	}
+
+	r.CreatedAt = stored.CreatedAt
+	r.UpdatedAt = time.Now()
+	m.rooms[r.ID] = r
+
+	return nil
+}
+
+// DeleteRoom deletes a room by id, with its restrictions, rates, promo codes and calendars, refusing
+// to do so while it still has reservations
+func (m *MemoryDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "DeleteRoom"); err != nil {
+		return err
+	}
+
+	for _, res := range m.reservations {
+		if res.RoomID == id {
+			return repository.ErrRoomHasReservations
+		}
+	}
+
+	delete(m.rooms, id)
+	m.deleteRestrictions(func(r models.RoomRestriction) bool { return r.RoomID == id })
+
+	for rateID, r := range m.seasonalRates {
+		if r.RoomID == id {
+			delete(m.seasonalRates, rateID)
+		}
+	}
+
+	for promoID, p := range m.promoCodes {
+		if p.RoomID == id {
+			delete(m.promoCodes, promoID)
+		}
+	}
+
+	for sourceID, s := range m.calendarSources {
+		if s.RoomID == id {
+			delete(m.calendarSources, sourceID)
+		}
+	}
+
+	return nil
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room by date range
+func (m *MemoryDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetRestrictionsForRoomByDate"); err != nil {
+		return nil, err
+	}
+
+	var restrictions []models.RoomRestriction
+	for _, r := range m.restrictions {
+		if r.RoomID != roomID || !start.Before(r.EndDate) || end.Before(r.StartDate) {
+			continue
+		}
+
+		res := m.reservations[r.ReservationID]
+		r.Reservation = models.Reservation{ID: r.ReservationID, FirstName: res.FirstName, LastName: res.LastName}
+		restrictions = append(restrictions, r)
+	}
+
+	sortRestrictions(restrictions)
+
+	return restrictions, nil
+}
+
+// sortRestrictions sorts restrictions by start date
+func sortRestrictions(restrictions []models.RoomRestriction) {
+	sort.Slice(restrictions, func(i, j int) bool {
+		a, b := restrictions[i], restrictions[j]
+		if !a.StartDate.Equal(b.StartDate) {
+			return a.StartDate.Before(b.StartDate)
+		}
+		return a.ID < b.ID
+	})
+}
+
+// GetBlockByID returns an owner block, with the user who created it
+func (m *MemoryDBRepo) GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetBlockByID"); err != nil {
+		return models.RoomRestriction{}, err
+	}
+
+	r, ok := m.restrictions[id]
+	room, roomOK := m.rooms[r.RoomID]
+	if !ok || !roomOK || r.RestrictionID != models.RestrictionOwnerBlock {
+		return models.RoomRestriction{}, sql.ErrNoRows
+	}
+
+	creator := m.users[r.CreatedBy]
+	r.Room = models.Room{ID: room.ID, RoomName: room.RoomName}
+	r.Creator = models.User{ID: r.CreatedBy, FirstName: creator.FirstName, LastName: creator.LastName}
+
+	return r, nil
+}
+
+// InsertBlock blocks the nights of a room from r.StartDate up to r.EndDate
+func (m *MemoryDBRepo) InsertBlock(ctx context.Context, r models.RoomRestriction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertBlock"); err != nil {
+		return 0, err
+	}
+
+	return m.insertRestriction(models.RoomRestriction{
+		StartDate:     r.StartDate,
+		EndDate:       r.EndDate,
+		RoomID:        r.RoomID,
+		RestrictionID: models.RestrictionOwnerBlock,
+		Note:          r.Note,
+		CreatedBy:     r.CreatedBy,
+	})
+}
+
+// UpdateBlock changes the dates and the note of an owner block
+func (m *MemoryDBRepo) UpdateBlock(ctx context.Context, r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateBlock"); err != nil {
+		return err
+	}
+
+	block, ok := m.restrictions[r.ID]
+	if !ok || block.RestrictionID != models.RestrictionOwnerBlock {
+		return nil
+	}
+
+	block.StartDate = r.StartDate
+	block.EndDate = r.EndDate
+	block.Note = r.Note
+	if err := m.restrictionConflict(block, nil); err != nil {
+		return err
+	}
+
+	block.UpdatedAt = time.Now()
+	m.restrictions[block.ID] = block
+
+	return nil
+}
+
+// UnblockNights frees the nights from start up to end of an owner block. The block shrinks, disappears
+// when all its nights are freed, or is split in two when the nights are in its middle.
+func (m *MemoryDBRepo) UnblockNights(ctx context.Context, id int, start, end time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UnblockNights"); err != nil {
+		return err
+	}
+
+	block, ok := m.restrictions[id]
+	if !ok || block.RestrictionID != models.RestrictionOwnerBlock {
+		return sql.ErrNoRows
+	}
+
+	pieces := splitBlock(block, start, end)
+
+	if len(pieces) == 0 {
+		delete(m.restrictions, id)
+		return nil
+	}
+
+	// the block keeps its first piece, and the rest of it becomes a new block
+	block.StartDate = pieces[0].StartDate
+	block.EndDate = pieces[0].EndDate
+	block.UpdatedAt = time.Now()
+	m.restrictions[id] = block
+
+	for _, p := range pieces[1:] {
+		_, err := m.insertRestriction(models.RoomRestriction{
+			StartDate:     p.StartDate,
+			EndDate:       p.EndDate,
+			RoomID:        p.RoomID,
+			RestrictionID: models.RestrictionOwnerBlock,
+			Note:          p.Note,
+			CreatedBy:     p.CreatedBy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteBlock deletes an owner block
+func (m *MemoryDBRepo) DeleteBlock(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "DeleteBlock"); err != nil {
+		return err
+	}
+
+	if m.restrictions[id].RestrictionID == models.RestrictionOwnerBlock {
+		delete(m.restrictions, id)
+	}
+
+	return nil
+}
+
+// DeleteExternalRestriction deletes a restriction imported from an external calendar
+func (m *MemoryDBRepo) DeleteExternalRestriction(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "DeleteExternalRestriction"); err != nil {
+		return err
+	}
+
+	if m.restrictions[id].RestrictionID == models.RestrictionExternal {
+		delete(m.restrictions, id)
+	}
+
+	return nil
+}
+
+// QuoteStay prices a stay in a room night by night
+func (m *MemoryDBRepo) QuoteStay(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "QuoteStay"); err != nil {
+		return models.Quote{}, err
+	}
+
+	room, ok := m.rooms[roomID]
+	if !ok {
+		return models.Quote{}, sql.ErrNoRows
+	}
+
+	var rates []models.SeasonalRate
+	for _, r := range m.seasonalRates {
+		if r.RoomID == roomID && r.StartDate.Before(end) && !r.EndDate.Before(start) {
+			rates = append(rates, r)
+		}
+	}
+
+	sort.Slice(rates, func(i, j int) bool { return rates[i].ID < rates[j].ID })
+
+	return pricing.Quote(room, rates, start, end)
+}
+
+// AllSeasonalRatesForRoom returns the seasonal rates of a room
+func (m *MemoryDBRepo) AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllSeasonalRatesForRoom"); err != nil {
+		return nil, err
+	}
+
+	var rates []models.SeasonalRate
+	for _, r := range m.seasonalRates {
+		if r.RoomID == roomID {
+			rates = append(rates, r)
+		}
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if !rates[i].StartDate.Equal(rates[j].StartDate) {
+			return rates[i].StartDate.Before(rates[j].StartDate)
+		}
+		return rates[i].ID < rates[j].ID
+	})
+
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate for a room
+func (m *MemoryDBRepo) InsertSeasonalRate(ctx context.Context, r models.SeasonalRate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertSeasonalRate"); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.rooms[r.RoomID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	r.ID = m.nextID("seasonal_rates")
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.seasonalRates[r.ID] = r
+
+	return r.ID, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate by id
+func (m *MemoryDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "DeleteSeasonalRate"); err != nil {
+		return err
+	}
+
+	delete(m.seasonalRates, id)
+
+	return nil
+}
+
+// AllPromoCodes returns all promo codes
+func (m *MemoryDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllPromoCodes"); err != nil {
+		return nil, err
+	}
+
+	var codes []models.PromoCode
+	for _, p := range m.promoCodes {
+		codes = append(codes, p)
+	}
+
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+
+	return codes, nil
+}
+
+// GetPromoCodeByID returns a promo code by id
+func (m *MemoryDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetPromoCodeByID"); err != nil {
+		return models.PromoCode{}, err
+	}
+
+	p, ok := m.promoCodes[id]
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+
+	return p, nil
+}
+
+// GetPromoCodeByCode returns a promo code by its code
+func (m *MemoryDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetPromoCodeByCode"); err != nil {
+		return models.PromoCode{}, err
+	}
+
+	for _, p := range m.promoCodes {
+		if p.Code == code {
+			return p, nil
+		}
+	}
+
+	return models.PromoCode{}, sql.ErrNoRows
+}
+
+// uniquePromoCode returns an error when a promo code other than id already has code
+func (m *MemoryDBRepo) uniquePromoCode(code string, id int) error {
+	for _, p := range m.promoCodes {
+		if p.Code == code && p.ID != id {
+			return duplicateError("promo_codes_code_idx")
+		}
+	}
+
+	return nil
+}
+
+// InsertPromoCode inserts a promo code
+func (m *MemoryDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertPromoCode"); err != nil {
+		return 0, err
+	}
+
+	if err := m.uniquePromoCode(p.Code, 0); err != nil {
+		return 0, err
+	}
+
+	p.ID = m.nextID("promo_codes")
+	p.Redemptions = 0
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.promoCodes[p.ID] = p
+
+	return p.ID, nil
+}
+
+// UpdatePromoCode updates a promo code, leaving its redemption count alone
+func (m *MemoryDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdatePromoCode"); err != nil {
+		return err
+	}
+
+	stored, ok := m.promoCodes[p.ID]
+	if !ok {
+		return nil
+	}
+
+	if err := m.uniquePromoCode(p.Code, p.ID); err != nil {
+		return err
+	}
+
+	p.Redemptions = stored.Redemptions
+	p.CreatedAt = stored.CreatedAt
+	p.UpdatedAt = time.Now()
+	m.promoCodes[p.ID] = p
+
+	return nil
+}
+
+// DeletePromoCode deletes a promo code by id, leaving the reservations it discounted without one
+func (m *MemoryDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "DeletePromoCode"); err != nil {
+		return err
+	}
+
+	delete(m.promoCodes, id)
+
+	for resID, res := range m.reservations {
+		if res.PromoCodeID == id {
+			res.PromoCodeID = 0
+			m.reservations[resID] = res
+		}
+	}
+
+	return nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to res.StartDate and res.EndDate,
+// storing the new price and putting mail in the outbox. The room must be free for the new dates,
+// ignoring the reservation itself.
+func (m *MemoryDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "ChangeReservationDates"); err != nil {
+		return err
+	}
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	moved := models.RoomRestriction{RoomID: res.RoomID, StartDate: res.StartDate, EndDate: res.EndDate}
+	err := m.restrictionConflict(moved, func(other models.RoomRestriction) bool { return other.ReservationID == res.ID })
+	if err != nil {
+		return err
+	}
+
+	stored, ok := m.reservations[res.ID]
+	if !ok || stored.Cancelled != 0 {
+		return sql.ErrNoRows
+	}
+
+	stored.StartDate = res.StartDate
+	stored.EndDate = res.EndDate
+	stored.TotalPrice = res.TotalPrice
+	stored.Discount = res.Discount
+	stored.UpdatedAt = time.Now()
+	m.reservations[res.ID] = stored
+
+	for id, r := range m.restrictions {
+		if r.ReservationID == res.ID {
+			r.StartDate = res.StartDate
+			r.EndDate = res.EndDate
+			r.UpdatedAt = time.Now()
+			m.restrictions[id] = r
+		}
+	}
+
+	m.enqueue(mail)
+
+	return nil
+}
+
+// CancelReservation marks a reservation as cancelled, frees its room restriction and puts mail in the outbox.
+// It returns repository.ErrReservationCancelled when the reservation was already cancelled.
+func (m *MemoryDBRepo) CancelReservation(ctx context.Context, id int, mail []models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "CancelReservation"); err != nil {
+		return err
+	}
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	if res.Cancelled == 1 {
+		return repository.ErrReservationCancelled
+	}
+
+	res.Cancelled = 1
+	res.UpdatedAt = time.Now()
+	m.reservations[id] = res
+
+	m.deleteRestrictions(func(r models.RoomRestriction) bool { return r.ReservationID == id })
+	m.enqueue(mail)
+
+	return nil
+}
+
+// InsertPasswordReset stores a password reset request
+func (m *MemoryDBRepo) InsertPasswordReset(ctx context.Context, r models.PasswordReset) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertPasswordReset"); err != nil {
+		return err
+	}
+
+	if _, ok := m.users[r.UserID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	for _, other := range m.passwordResets {
+		if other.TokenHash == r.TokenHash {
+			return duplicateError("password_resets_token_hash_idx")
+		}
+	}
+
+	r.ID = m.nextID("password_resets")
+	r.Used = 0
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.passwordResets[r.ID] = r
+
+	return nil
+}
+
+// GetPasswordResetByTokenHash returns the password reset request stored under hash
+func (m *MemoryDBRepo) GetPasswordResetByTokenHash(ctx context.Context, hash string) (models.PasswordReset, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetPasswordResetByTokenHash"); err != nil {
+		return models.PasswordReset{}, err
+	}
+
+	for _, r := range m.passwordResets {
+		if r.TokenHash == hash {
+			return r, nil
+		}
+	}
+
+	return models.PasswordReset{}, sql.ErrNoRows
+}
+
+// ResetPassword marks a password reset request as used and sets hash as the password of its user.
+// It returns repository.ErrPasswordResetUsed when the request was already used.
+func (m *MemoryDBRepo) ResetPassword(ctx context.Context, id int, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "ResetPassword"); err != nil {
+		return err
+	}
+
+	r, ok := m.passwordResets[id]
+	if !ok || r.Used != 0 {
+		return repository.ErrPasswordResetUsed
+	}
+
+	u, ok := m.users[r.UserID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	r.Used = 1
+	r.UpdatedAt = time.Now()
+	m.passwordResets[id] = r
+
+	u.Password = hash
+	u.UpdatedAt = r.UpdatedAt
+	m.users[u.ID] = u
+
+	return nil
+}
+
+// InsertUser inserts a user without a password, who has to choose one before logging in
+func (m *MemoryDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertUser"); err != nil {
+		return 0, err
+	}
+
+	if err := m.uniqueUserEmail(u.Email, 0); err != nil {
+		return 0, err
+	}
+
+	stored := models.User{
+		ID:          m.nextID("users"),
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Email:       u.Email,
+		AccessLevel: u.AccessLevel,
+		Active:      1,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	m.users[stored.ID] = stored
+
+	return stored.ID, nil
+}
+
+// UpdateUserDetails changes the name, email and access level of a user
+func (m *MemoryDBRepo) UpdateUserDetails(ctx context.Context, u models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateUserDetails"); err != nil {
+		return err
+	}
+
+	if err := m.uniqueUserEmail(u.Email, u.ID); err != nil {
+		return err
+	}
+
+	return m.updateUserKeepingAnOwner(u.ID, func(stored *models.User) {
+		stored.FirstName = u.FirstName
+		stored.LastName = u.LastName
+		stored.Email = u.Email
+		stored.AccessLevel = u.AccessLevel
+	})
+}
+
+// SetUserActive activates (1) or deactivates (0) a user
+func (m *MemoryDBRepo) SetUserActive(ctx context.Context, id, active int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "SetUserActive"); err != nil {
+		return err
+	}
+
+	return m.updateUserKeepingAnOwner(id, func(u *models.User) { u.Active = active })
+}
+
+// updateUserKeepingAnOwner applies change to user id, unless no active owner would be left
+func (m *MemoryDBRepo) updateUserKeepingAnOwner(id int, change func(u *models.User)) error {
+	u, ok := m.users[id]
+	if !ok {
+		return nil
+	}
+
+	changed := u
+	change(&changed)
+	changed.UpdatedAt = time.Now()
+	m.users[id] = changed
+
+	for _, other := range m.users {
+		if other.AccessLevel == models.AccessOwner && other.Active == 1 {
+			return nil
+		}
+	}
+
+	m.users[id] = u
+
+	return repository.ErrLastOwner
+}
+
+// InsertAPIToken stores an API token by its hash, and returns its id
+func (m *MemoryDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertAPIToken"); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.users[t.UserID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	for _, other := range m.apiTokens {
+		if other.TokenHash == t.TokenHash {
+			return 0, duplicateError("api_tokens_token_hash_idx")
+		}
+	}
+
+	stored := copyAPIToken(models.APIToken{
+		ID:        m.nextID("api_tokens"),
+		UserID:    t.UserID,
+		Name:      t.Name,
+		TokenHash: t.TokenHash,
+		Scopes:    t.Scopes,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	})
+	m.apiTokens[stored.ID] = stored
+
+	return stored.ID, nil
+}
+
+// withUser returns a copy of t with its user, as joined by the API token queries
+func (m *MemoryDBRepo) withUser(t models.APIToken) models.APIToken {
+	u := m.users[t.UserID]
+	t = copyAPIToken(t)
+	t.User = models.User{
+		ID:          u.ID,
+		FirstName:   u.FirstName,
+		LastName:    u.LastName,
+		Email:       u.Email,
+		AccessLevel: u.AccessLevel,
+		Active:      u.Active,
+	}
+
+	return t
+}
+
+// AllAPITokensForUser returns the API tokens of a user, revoked ones included
+func (m *MemoryDBRepo) AllAPITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllAPITokensForUser"); err != nil {
+		return nil, err
+	}
+
+	var apiTokens []models.APIToken
+	for _, t := range m.apiTokens {
+		if t.UserID == userID {
+			apiTokens = append(apiTokens, m.withUser(t))
+		}
+	}
+
+	sort.Slice(apiTokens, func(i, j int) bool {
+		a, b := apiTokens[i], apiTokens[j]
+		if a.Revoked != b.Revoked {
+			return a.Revoked < b.Revoked
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	return apiTokens, nil
+}
+
+// GetAPITokenByID returns an API token with its user
+func (m *MemoryDBRepo) GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetAPITokenByID"); err != nil {
+		return models.APIToken{}, err
+	}
+
+	t, ok := m.apiTokens[id]
+	if !ok {
+		return models.APIToken{}, sql.ErrNoRows
+	}
+
+	return m.withUser(t), nil
+}
+
+// GetAPITokenByHash returns the API token stored under hash, with its user
+func (m *MemoryDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetAPITokenByHash"); err != nil {
+		return models.APIToken{}, err
+	}
+
+	for _, t := range m.apiTokens {
+		if t.TokenHash == hash {
+			return m.withUser(t), nil
+		}
+	}
+
+	return models.APIToken{}, sql.ErrNoRows
+}
+
+// TouchAPIToken records that an API token has just been used
+func (m *MemoryDBRepo) TouchAPIToken(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "TouchAPIToken"); err != nil {
+		return err
+	}
+
+	if t, ok := m.apiTokens[id]; ok {
+		t.LastUsedAt = time.Now()
+		m.apiTokens[id] = t
+	}
+
+	return nil
+}
+
+// RevokeAPIToken stops an API token from being used again
+func (m *MemoryDBRepo) RevokeAPIToken(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "RevokeAPIToken"); err != nil {
+		return err
+	}
+
+	if t, ok := m.apiTokens[id]; ok {
+		t.Revoked = 1
+		t.UpdatedAt = time.Now()
+		m.apiTokens[id] = t
+	}
+
+	return nil
+}
+
+// InsertAuditEntry records a change in the audit log
+func (m *MemoryDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertAuditEntry"); err != nil {
+		return err
+	}
+
+	if _, ok := m.users[e.UserID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	stored := models.AuditEntry{
+		ID:         m.nextID("audit_log"),
+		UserID:     e.UserID,
+		APITokenID: e.APITokenID,
+		Action:     e.Action,
+		Details:    e.Details,
+		CreatedAt:  time.Now(),
+	}
+	m.auditLog[stored.ID] = stored
+
+	return nil
+}
+
+// AllAuditEntries returns the latest limit entries of the audit log, newest first
+func (m *MemoryDBRepo) AllAuditEntries(ctx context.Context, limit int) ([]models.AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllAuditEntries"); err != nil {
+		return nil, err
+	}
+
+	var entries []models.AuditEntry
+	for _, e := range m.auditLog {
+		u := m.users[e.UserID]
+		e.User = models.User{ID: e.UserID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email}
+		e.APIToken = models.APIToken{ID: e.APITokenID, Name: m.apiTokens[e.APITokenID].Name}
+		entries = append(entries, e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	if limit >= 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	return entries, nil
+}
+
+// AllCalendarSources returns the external calendars of every room
+func (m *MemoryDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllCalendarSources"); err != nil {
+		return nil, err
+	}
+
+	return m.sortedCalendarSources(func(s models.CalendarSource) bool { return true }), nil
+}
+
+// AllCalendarSourcesForRoom returns the external calendars of a room
+func (m *MemoryDBRepo) AllCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllCalendarSourcesForRoom"); err != nil {
+		return nil, err
+	}
+
+	return m.sortedCalendarSources(func(s models.CalendarSource) bool { return s.RoomID == roomID }), nil
+}
+
+// sortedCalendarSources returns the calendar sources that match reports true for, by room then name
+func (m *MemoryDBRepo) sortedCalendarSources(match func(s models.CalendarSource) bool) []models.CalendarSource {
+	var sources []models.CalendarSource
+	for _, s := range m.calendarSources {
+		if match(s) {
+			sources = append(sources, s)
+		}
+	}
+
+	sort.Slice(sources, func(i, j int) bool {
+		a, b := sources[i], sources[j]
+		if a.RoomID != b.RoomID {
+			return a.RoomID < b.RoomID
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID < b.ID
+	})
+
+	return sources
+}
+
+// GetCalendarSourceByID returns a calendar source by id
+func (m *MemoryDBRepo) GetCalendarSourceByID(ctx context.Context, id int) (models.CalendarSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetCalendarSourceByID"); err != nil {
+		return models.CalendarSource{}, err
+	}
+
+	s, ok := m.calendarSources[id]
+	if !ok {
+		return s, sql.ErrNoRows
+	}
+
+	return s, nil
+}
+
+// InsertCalendarSource adds an external calendar to a room
+func (m *MemoryDBRepo) InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertCalendarSource"); err != nil {
+		return 0, err
+	}
+
+	if _, ok := m.rooms[s.RoomID]; !ok {
+		return 0, sql.ErrNoRows
+	}
+
+	stored := models.CalendarSource{
+		ID:        m.nextID("calendar_sources"),
+		RoomID:    s.RoomID,
+		Name:      s.Name,
+		URL:       s.URL,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	m.calendarSources[stored.ID] = stored
+
+	return stored.ID, nil
+}
+
+// DeleteCalendarSource deletes an external calendar, with the restrictions imported from it
+func (m *MemoryDBRepo) DeleteCalendarSource(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "DeleteCalendarSource"); err != nil {
+		return err
+	}
+
+	delete(m.calendarSources, id)
+	m.deleteRestrictions(func(r models.RoomRestriction) bool { return r.SourceID == id })
+
+	return nil
+}
+
+// UpdateCalendarSourceStatus records when an external calendar was last synced, and why it failed if it did
+func (m *MemoryDBRepo) UpdateCalendarSourceStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateCalendarSourceStatus"); err != nil {
+		return err
+	}
+
+	if s, ok := m.calendarSources[id]; ok {
+		s.LastSyncedAt = syncedAt
+		s.LastError = lastError
+		s.UpdatedAt = time.Now()
+		m.calendarSources[id] = s
+	}
+
+	return nil
+}
+
+// GetRestrictionsForSource returns the restrictions imported from an external calendar
+func (m *MemoryDBRepo) GetRestrictionsForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "GetRestrictionsForSource"); err != nil {
+		return nil, err
+	}
+
+	var restrictions []models.RoomRestriction
+	for _, r := range m.restrictions {
+		if r.SourceID == sourceID {
+			restrictions = append(restrictions, r)
+		}
+	}
+
+	sortRestrictions(restrictions)
+
+	return restrictions, nil
+}
+
+// InsertExternalRestriction inserts a restriction imported from an external calendar
+func (m *MemoryDBRepo) InsertExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "InsertExternalRestriction"); err != nil {
+		return err
+	}
+
+	if _, ok := m.calendarSources[r.SourceID]; !ok {
+		return sql.ErrNoRows
+	}
+
+	for _, other := range m.restrictions {
+		if other.SourceID == r.SourceID && other.ExternalUID == r.ExternalUID {
+			return duplicateError("room_restrictions_source_id_external_uid_idx")
+		}
+	}
+
+	_, err := m.insertRestriction(models.RoomRestriction{
+		StartDate:     r.StartDate,
+		EndDate:       r.EndDate,
+		RoomID:        r.RoomID,
+		RestrictionID: models.RestrictionExternal,
+		SourceID:      r.SourceID,
+		ExternalUID:   r.ExternalUID,
+	})
+
+	return err
+}
+
+// UpdateExternalRestriction moves a restriction imported from an external calendar to new dates
+func (m *MemoryDBRepo) UpdateExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "UpdateExternalRestriction"); err != nil {
+		return err
+	}
+
+	stored, ok := m.restrictions[r.ID]
+	if !ok || stored.RestrictionID != models.RestrictionExternal {
+		return nil
+	}
+
+	stored.StartDate = r.StartDate
+	stored.EndDate = r.EndDate
+	if err := m.restrictionConflict(stored, nil); err != nil {
+		return err
+	}
+
+	stored.UpdatedAt = time.Now()
+	m.restrictions[r.ID] = stored
+
+	return nil
+}
+
+// EnqueueEmail puts an email in the outbox
+func (m *MemoryDBRepo) EnqueueEmail(ctx context.Context, msg models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "EnqueueEmail"); err != nil {
+		return err
+	}
+
+	m.enqueue([]models.MailData{msg})
+
+	return nil
+}
+
+// ClaimEmails takes up to limit pending emails that are due, oldest first, counting an attempt for each.
+// They are not due again until lease has passed.
+func (m *MemoryDBRepo) ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "ClaimEmails"); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var due []models.OutboxEmail
+	for _, e := range m.outbox {
+		if e.Status == models.EmailPending && !e.NextAttemptAt.After(now) {
+			due = append(due, e)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		a, b := due[i], due[j]
+		if !a.NextAttemptAt.Equal(b.NextAttemptAt) {
+			return a.NextAttemptAt.Before(b.NextAttemptAt)
+		}
+		return a.ID < b.ID
+	})
+
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var emails []models.OutboxEmail
+	for _, e := range due {
+		e.Attempts++
+		e.NextAttemptAt = now.Add(lease)
+		e.UpdatedAt = now
+		m.outbox[e.ID] = e
+
+		e.MailData = copyMail(e.MailData)
+		emails = append(emails, e)
+	}
+
+	return emails, nil
+}
+
+// MarkEmailSent records that an email of the outbox was sent
+func (m *MemoryDBRepo) MarkEmailSent(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "MarkEmailSent"); err != nil {
+		return err
+	}
+
+	if e, ok := m.outbox[id]; ok {
+		e.Status = models.EmailSent
+		e.LastError = ""
+		e.SentAt = time.Now()
+		e.UpdatedAt = e.SentAt
+		m.outbox[id] = e
+	}
+
+	return nil
+}
+
+// RetryEmail records why sending an email of the outbox failed, and when to try again
+func (m *MemoryDBRepo) RetryEmail(ctx context.Context, id int, at time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "RetryEmail"); err != nil {
+		return err
+	}
+
+	if e, ok := m.outbox[id]; ok {
+		e.NextAttemptAt = at
+		e.LastError = lastError
+		e.UpdatedAt = time.Now()
+		m.outbox[id] = e
+	}
+
+	return nil
+}
+
+// MarkEmailDead gives up on an email of the outbox, recording why its last attempt failed
+func (m *MemoryDBRepo) MarkEmailDead(ctx context.Context, id int, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "MarkEmailDead"); err != nil {
+		return err
+	}
+
+	if e, ok := m.outbox[id]; ok {
+		e.Status = models.EmailDead
+		e.LastError = lastError
+		e.UpdatedAt = time.Now()
+		m.outbox[id] = e
+	}
+
+	return nil
+}
+
+// AllOutboxEmails returns the latest limit emails of the outbox with status, or of any status when
+// status is empty, newest first
+func (m *MemoryDBRepo) AllOutboxEmails(ctx context.Context, status string, limit int) ([]models.OutboxEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "AllOutboxEmails"); err != nil {
+		return nil, err
+	}
+
+	var emails []models.OutboxEmail
+	for _, e := range m.outbox {
+		if status == "" || e.Status == status {
+			e.MailData = copyMail(e.MailData)
+			emails = append(emails, e)
+		}
+	}
+
+	sort.Slice(emails, func(i, j int) bool {
+		a, b := emails[i], emails[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	if limit >= 0 && len(emails) > limit {
+		emails = emails[:limit]
+	}
+
+	return emails, nil
+}
+
+// ResendEmail puts an email of the outbox that was given up on back in the queue, with its attempts reset.
+// It returns sql.ErrNoRows when there is no such email.
+func (m *MemoryDBRepo) ResendEmail(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "ResendEmail"); err != nil {
+		return err
+	}
+
+	e, ok := m.outbox[id]
+	if !ok || e.Status != models.EmailDead {
+		return sql.ErrNoRows
+	}
+
+	e.Status = models.EmailPending
+	e.Attempts = 0
+	e.NextAttemptAt = time.Now()
+	e.UpdatedAt = e.NextAttemptAt
+	m.outbox[id] = e
+
+	return nil
+}
+
+// ReservationsArriving returns the reservations, not cancelled, starting from from to to, that the
+// scheduled email kind was not sent for
+func (m *MemoryDBRepo) ReservationsArriving(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "ReservationsArriving"); err != nil {
+		return nil, err
+	}
+
+	return m.reservationsWithoutScheduledEmail(from, to, kind, func(res models.Reservation) time.Time { return res.StartDate }), nil
+}
+
+// ReservationsDeparted returns the reservations, not cancelled, ending from from to to, that the
+// scheduled email kind was not sent for
+func (m *MemoryDBRepo) ReservationsDeparted(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "ReservationsDeparted"); err != nil {
+		return nil, err
+	}
+
+	return m.reservationsWithoutScheduledEmail(from, to, kind, func(res models.Reservation) time.Time { return res.EndDate }), nil
+}
+
+// reservationsWithoutScheduledEmail returns the reservations, not cancelled, whose date is from from
+// to to, that the scheduled email kind was not sent for, by id
+func (m *MemoryDBRepo) reservationsWithoutScheduledEmail(from, to time.Time, kind string, date func(res models.Reservation) time.Time) []models.Reservation {
+	var reservations []models.Reservation
+	for _, res := range m.reservations {
+		d := date(res)
+		if res.Cancelled == 0 && !d.Before(from) && !d.After(to) && !m.scheduledEmails[scheduledEmail{res.ID, kind}] {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].ID < reservations[j].ID })
+
+	return reservations
+}
+
+// EnqueueScheduledEmail puts mail in the outbox and records that the scheduled email kind was sent for
+// the reservation, unless it already was. It reports whether mail was put in the outbox.
+func (m *MemoryDBRepo) EnqueueScheduledEmail(ctx context.Context, reservationID int, kind string, mail []models.MailData) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.check(ctx, "EnqueueScheduledEmail"); err != nil {
+		return false, err
+	}
+
+	if _, ok := m.reservations[reservationID]; !ok {
+		return false, sql.ErrNoRows
+	}
+
+	key := scheduledEmail{reservationID, kind}
+	if m.scheduledEmails[key] {
+		return false, nil
+	}
+
+	m.scheduledEmails[key] = true
+	m.enqueue(mail)
+
+	return true, nil
+}
@@ -0,0 +1,215 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+func day(d int) time.Time {
+	return time.Date(2100, 6, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMemoryRepo_CreateBooking(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	// room 1 is booked for the nights of 10 to 12 June
+	id, err := repo.CreateBooking(ctx, models.Reservation{RoomID: 1, StartDate: day(10), EndDate: day(13)}, func(id int) ([]models.MailData, error) {
+		return []models.MailData{{To: "john@smith.com", Subject: "Reservation confirmation"}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		roomID   int
+		start    time.Time
+		end      time.Time
+		expected error
+	}{
+		{"same-nights", 1, day(10), day(13), repository.ErrRoomUnavailable},
+		{"first-night", 1, day(8), day(11), repository.ErrRoomUnavailable},
+		{"last-night", 1, day(12), day(14), repository.ErrRoomUnavailable},
+		{"inside", 1, day(11), day(12), repository.ErrRoomUnavailable},
+		{"leaving-on-arrival", 1, day(8), day(10), nil},
+		{"arriving-on-departure", 1, day(13), day(15), nil},
+		{"other-room", 2, day(10), day(13), nil},
+		{"no-such-room", 3, day(20), day(21), sql.ErrNoRows},
+	}
+
+	for _, e := range tests {
+		_, err := repo.CreateBooking(ctx, models.Reservation{RoomID: e.roomID, StartDate: e.start, EndDate: e.end}, nil)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("failed %s: expected %v, but got %v", e.name, e.expected, err)
+		}
+	}
+
+	res, err := repo.GetReservationByID(ctx, id)
+	if err != nil || res.Room.RoomName != "General's Quarters" {
+		t.Errorf("expected the reservation with its room, but got %v (%v)", res, err)
+	}
+
+	emails, _ := repo.AllOutboxEmails(ctx, models.EmailPending, 10)
+	if len(emails) != 1 || emails[0].To != "john@smith.com" {
+		t.Errorf("expected the confirmation in the outbox, but got %v", emails)
+	}
+
+	rooms, _ := repo.SearchAvailabilityForAllRooms(ctx, day(11), day(12))
+	if len(rooms) != 0 {
+		t.Errorf("expected no room left for the night of 11 June, but got %v", rooms)
+	}
+
+	// deleting the reservation frees its nights
+	if err := repo.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, day(10), day(13), 1); !ok {
+		t.Error("expected room 1 to be free once its reservation is deleted")
+	}
+}
+
+func TestMemoryRepo_CreateBookingAllOrNothing(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	promoID, err := repo.InsertPromoCode(ctx, models.PromoCode{Code: "ONCE", MaxRedemptions: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rendering the emails fails, so nothing is booked
+	_, err = repo.CreateBooking(ctx, models.Reservation{RoomID: 1, StartDate: day(1), EndDate: day(3), PromoCodeID: promoID}, func(id int) ([]models.MailData, error) {
+		return nil, errors.New("can't render")
+	})
+	if err == nil {
+		t.Fatal("expected the error of mail")
+	}
+
+	if all, _ := repo.AllReservations(ctx); len(all) != 0 {
+		t.Errorf("expected no reservation, but got %v", all)
+	}
+
+	if _, err := repo.CreateBooking(ctx, models.Reservation{RoomID: 1, StartDate: day(1), EndDate: day(3), PromoCodeID: promoID}, nil); err != nil {
+		t.Fatalf("expected the code to be redeemed once, but got %v", err)
+	}
+
+	_, err = repo.CreateBooking(ctx, models.Reservation{RoomID: 2, StartDate: day(1), EndDate: day(3), PromoCodeID: promoID}, nil)
+	if !errors.Is(err, repository.ErrPromoCodeExhausted) {
+		t.Errorf("expected the code to be exhausted, but got %v", err)
+	}
+}
+
+func TestMemoryRepo_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	id, err := repo.SeedUser(models.User{Email: "me@here.ca", AccessLevel: models.AccessOwner}, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.SeedUser(models.User{Email: "me@here.ca"}, "password"); err == nil {
+		t.Error("expected the address to be taken")
+	}
+
+	deskID, _ := repo.InsertUser(ctx, models.User{Email: "desk@here.ca", AccessLevel: models.AccessFrontDesk})
+
+	var tests = []struct {
+		name       string
+		email      string
+		password   string
+		expectedID int // 0 when the user can't log in
+	}{
+		{"valid", "me@here.ca", "password", id},
+		{"wrong-password", "me@here.ca", "secret", 0},
+		{"unknown", "nobody@here.ca", "password", 0},
+		{"no-password-yet", "desk@here.ca", "", 0},
+	}
+
+	for _, e := range tests {
+		got, _, err := repo.Authenticate(ctx, e.email, e.password)
+		if e.expectedID == 0 && err == nil {
+			t.Errorf("failed %s: expected an error, but user %d logged in", e.name, got)
+		}
+		if e.expectedID != 0 && (got != e.expectedID || err != nil) {
+			t.Errorf("failed %s: expected user %d, but got %d (%v)", e.name, e.expectedID, got, err)
+		}
+	}
+
+	// deactivated users can't log in, and the last owner can't be deactivated
+	if err := repo.SetUserActive(ctx, id, 0); !errors.Is(err, repository.ErrLastOwner) {
+		t.Errorf("expected the last owner to stay active, but got %v", err)
+	}
+
+	if err := repo.SetUserActive(ctx, deskID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := repo.Authenticate(ctx, "me@here.ca", "password"); err != nil {
+		t.Errorf("expected the owner to still log in, but got %v", err)
+	}
+}
+
+func TestMemoryRepo_Fail(t *testing.T) {
+	repo := NewMemoryRepo(&config.AppConfig{})
+	down := errors.New("database down")
+
+	repo.Fail("AllRooms", down)
+
+	if _, err := repo.AllRooms(context.Background()); err != down {
+		t.Errorf("expected the injected fault, but got %v", err)
+	}
+
+	if _, err := repo.GetRoomByID(context.Background(), 1); err != nil {
+		t.Errorf("expected other methods to work, but got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := repo.GetRoomByID(ctx, 1); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the call to stop with its context, but got %v", err)
+	}
+
+	repo.Fail("AllRooms", nil)
+
+	if rooms, err := repo.AllRooms(context.Background()); err != nil || len(rooms) != 2 {
+		t.Errorf("expected the seeded rooms once the fault is cleared, but got %v (%v)", rooms, err)
+	}
+}
+
+func TestMemoryRepo_UnblockNights(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo(&config.AppConfig{})
+
+	id, err := repo.InsertBlock(ctx, models.RoomRestriction{RoomID: 1, StartDate: day(1), EndDate: day(10), Note: "Painting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repo.InsertBlock(ctx, models.RoomRestriction{RoomID: 1, StartDate: day(9), EndDate: day(12)}); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected overlapping blocks to be refused, but got %v", err)
+	}
+
+	if err := repo.UnblockNights(ctx, id, day(4), day(6)); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, _ := repo.GetRestrictionsForRoomByDate(ctx, 1, day(1), day(10))
+	if len(blocks) != 2 || !blocks[0].EndDate.Equal(day(4)) || !blocks[1].StartDate.Equal(day(6)) || blocks[1].Note != "Painting" {
+		t.Errorf("expected the block to be split around the freed nights, but got %v", blocks)
+	}
+
+	if ok, _ := repo.SearchAvailabilityByDatesByRoomID(ctx, day(4), day(6), 1); !ok {
+		t.Error("expected the freed nights to be available")
+	}
+}
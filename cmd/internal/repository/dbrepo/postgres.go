@@ -2,22 +2,68 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/pricing"
+	"github.com/maslow123/bookings/cmd/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
+// AllUsers returns all staff users
+func (m *postgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var users []models.User
+
+	query := `
+		SELECT 
+			id, first_name, last_name, email, access_level, active, created_at, updated_at
+		FROM users
+		ORDER BY last_name, first_name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.Active,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
 
-	return true
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
 }
 
 // InsertReservation inserts a reservation into the database
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 	var newID int
 
@@ -50,8 +96,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 }
 
 // InserRoomRestriction inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	stmt := `
@@ -72,15 +118,154 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	)
 
 	if err != nil {
-		return err
+		return restrictionError(err)
 	}
 
 	return nil
 }
 
+// CreateBooking inserts a reservation and its room restriction in a single transaction.
+// The room row is locked while availability is re-checked, so two concurrent bookings
+// for the same room cannot both succeed. The emails returned by mail for the id of the
+// new reservation are put in the outbox by the same transaction.
+func (m *postgresDBRepo) CreateBooking(ctx context.Context, res models.Reservation, mail func(id int) ([]models.MailData, error)) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the room so that other bookings for it wait until we commit
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+	query := `
+		SELECT
+			COUNT(id)
+		FROM
+			room_restrictions
+		WHERE
+			room_id = $1 AND
+			$2 < end_date AND $3 > start_date
+	`
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	var newID int
+	stmt := `
+		INSERT INTO reservations (first_name, last_name, email, phone, start_date, end_date, room_id, total_price,
+			promo_code_id, discount, created_at, updated_at)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0), $10, $11, $12)
+		RETURNING id
+	`
+	err = tx.QueryRowContext(
+		ctx,
+		stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.TotalPrice,
+		res.PromoCodeID,
+		res.Discount,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.PromoCodeID > 0 {
+		// the row lock taken by the update serializes concurrent redemptions of the same code
+		stmt = `
+			UPDATE promo_codes
+			SET redemptions = redemptions + 1, updated_at = $2
+			WHERE id = $1 AND (max_redemptions = 0 OR redemptions < max_redemptions)
+		`
+		result, err := tx.ExecContext(ctx, stmt, res.PromoCodeID, time.Now())
+		if err != nil {
+			return 0, err
+		}
+
+		redeemed, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+
+		if redeemed == 0 {
+			return 0, repository.ErrPromoCodeExhausted
+		}
+
+		stmt = `
+			INSERT INTO promo_redemptions (promo_code_id, reservation_id, created_at, updated_at)
+			VALUES
+			($1, $2, $3, $4)
+		`
+		_, err = tx.ExecContext(ctx, stmt, res.PromoCodeID, newID, time.Now(), time.Now())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	stmt = `
+		INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err = tx.ExecContext(
+		ctx,
+		stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		time.Now(),
+		time.Now(),
+		models.RestrictionReservation,
+	)
+	if err != nil {
+		return 0, restrictionError(err)
+	}
+
+	if mail != nil {
+		emails, err := mail(newID)
+		if err != nil {
+			return 0, err
+		}
+
+		err = insertEmails(ctx, tx, emails)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability exists
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var numRows int
@@ -110,8 +295,8 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 }
 
 // SearchAvailabilityForAllRooms return a slice of available rooms, if any. For given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var rooms []models.Room
@@ -159,15 +344,15 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 }
 
 // GetRoomByID return room data
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var room models.Room
 
 	query := `
 		SELECT
-			id, room_name, created_at, updated_at
+			id, room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at
 		FROM rooms
 		WHERE
 			id = $1
@@ -176,6 +361,11 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.BasePrice,
+		&room.WeekendUplift,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -188,15 +378,15 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 }
 
 // GetUserByID return user by id
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var user models.User
 
 	query := `
 		SELECT 
-			id, first_name, last_name, email, password, access_level, created_at, updated_at
+			id, first_name, last_name, email, password, access_level, active, created_at, updated_at
 		FROM users
 		WHERE id = $1
 	`
@@ -209,6 +399,41 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&user.Email,
 		&user.Password,
 		&user.AccessLevel,
+		&user.Active,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail return user by email
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var user models.User
+
+	query := `
+		SELECT 
+			id, first_name, last_name, email, password, access_level, active, created_at, updated_at
+		FROM users
+		WHERE email = $1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.Active,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -220,9 +445,10 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	return user, nil
 }
 
-// UpdateUser updates a user in the database
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateUser updates a user in the database. u.Password must already be hashed with bcrypt. The access
+// level is left as it is, UpdateUserDetails changes it keeping an owner.
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	query := `
@@ -231,7 +457,7 @@ func (m *postgresDBRepo) UpdateUser(u models.User) error {
 			first_name = $1,
 			last_name = $2,
 			email = $3,
-			access_level = $4,
+			password = $4,
 			updated_at = $5
 		WHERE id = $6
 	`
@@ -240,8 +466,9 @@ func (m *postgresDBRepo) UpdateUser(u models.User) error {
 		u.FirstName,
 		u.LastName,
 		u.Email,
-		u.AccessLevel,
+		u.Password,
 		time.Now(),
+		u.ID,
 	)
 	if err != nil {
 		return err
@@ -250,8 +477,8 @@ func (m *postgresDBRepo) UpdateUser(u models.User) error {
 }
 
 // Authenticate autheticates a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var id int
@@ -260,7 +487,7 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	query := `
 		SELECT id, password
 		FROM users
-		WHERE email = $1
+		WHERE email = $1 AND active = 1
 	`
 	row := m.DB.QueryRowContext(ctx, query, email)
 	err := row.Scan(&id, &hashedPassword)
@@ -281,8 +508,8 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 }
 
 // AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var reservations []models.Reservation
@@ -290,7 +517,7 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	query := `
 		SELECT 
 			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-			r.end_date, r.room_id, r.created_at, r.updated_at,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.total_price, r.cancelled,
 			
 			rm.id, rm.room_name
 		FROM reservations r
@@ -319,6 +546,8 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 			&i.RoomID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.TotalPrice,
+			&i.Cancelled,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -339,8 +568,8 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 }
 
 // AllNewReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var reservations []models.Reservation
@@ -348,7 +577,7 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	query := `
 		SELECT 
 			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.total_price, r.cancelled,
 			
 			rm.id, rm.room_name
 		FROM reservations r
@@ -379,6 +608,8 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Processed,
+			&i.TotalPrice,
+			&i.Cancelled,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -399,8 +630,8 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 }
 
 // GetReservationByID returns one reservation by ID
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var res models.Reservation
@@ -408,7 +639,8 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 	query := `
 		SELECT 
 			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
-			r.room_id, r.created_at, r.updated_at, r.processed,
+			r.room_id, r.created_at, r.updated_at, r.processed, r.total_price,
+			COALESCE(r.promo_code_id, 0), r.discount, r.cancelled,
 
 			rm.id, rm.room_name
 		FROM reservations r
@@ -429,6 +661,10 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.CreatedAt,
 		&res.UpdatedAt,
 		&res.Processed,
+		&res.TotalPrice,
+		&res.PromoCodeID,
+		&res.Discount,
+		&res.Cancelled,
 		&res.Room.ID,
 		&res.Room.RoomName,
 	)
@@ -441,8 +677,8 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 }
 
 // UpdateReservation updates a reservation in the database
-func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	query := `
@@ -471,8 +707,8 @@ func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
 }
 
 // DeleteReservation deletes one reservations by id
-func (m *postgresDBRepo) DeleteReservation(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	query := `DELETE FROM reservations WHERE id = $1`
@@ -485,8 +721,8 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 }
 
 // UpdateProcessedForReservation updates processed for a reservation by id
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	query := `
@@ -506,15 +742,15 @@ func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error
 }
 
 // AllRooms get all rooms
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var rooms []models.Room
 
 	query := `
 		SELECT 
-			id, room_name, created_at, updated_at 
+			id, room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at 
 		FROM rooms
 		ORDER BY room_name
 	`
@@ -532,6 +768,11 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 		err := rows.Scan(
 			&rm.ID,
 			&rm.RoomName,
+			&rm.Slug,
+			&rm.Description,
+			&rm.Capacity,
+			&rm.BasePrice,
+			&rm.WeekendUplift,
 			&rm.CreatedAt,
 			&rm.UpdatedAt,
 		)
@@ -550,18 +791,149 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	return rooms, nil
 }
 
+// GetRoomBySlug returns a room by its slug
+func (m *postgresDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var room models.Room
+
+	query := `
+		SELECT
+			id, room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at
+		FROM rooms
+		WHERE
+			slug = $1
+	`
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.BasePrice,
+		&room.WeekendUplift,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// InsertRoom inserts a room into the database
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO rooms (room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Capacity,
+		r.BasePrice,
+		r.WeekendUplift,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates a room in the database
+func (m *postgresDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE rooms
+		SET
+			room_name = $1,
+			slug = $2,
+			description = $3,
+			capacity = $4,
+			base_price = $5,
+			weekend_uplift = $6,
+			updated_at = $7
+		WHERE id = $8
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Capacity,
+		r.BasePrice,
+		r.WeekendUplift,
+		time.Now(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room by id, refusing to do so while it still has reservations
+func (m *postgresDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(id) FROM reservations WHERE room_id = $1`, id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return repository.ErrRoomHasReservations
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM rooms WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 // GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
 	var restrictions []models.RoomRestriction
 
 	query := `
-		SELECT 
-			id, COALESCE(reservation_id, 0), restriction_id, room_id, start_date, end_date
-		FROM room_restrictions 
-		WHERE $1 < end_date AND $2 >= start_date AND room_id = $3
+		SELECT
+			rr.id, COALESCE(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+			rr.note, rr.updated_at, COALESCE(r.first_name, ''), COALESCE(r.last_name, '')
+		FROM room_restrictions rr
+		LEFT JOIN reservations r ON (rr.reservation_id = r.id)
+		WHERE $1 < rr.end_date AND $2 >= rr.start_date AND rr.room_id = $3
+		ORDER BY rr.start_date
 	`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
@@ -580,10 +952,15 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&r.Note,
+			&r.UpdatedAt,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
 		)
 		if err != nil {
 			return nil, err
 		}
+		r.Reservation.ID = r.ReservationID
 		restrictions = append(restrictions, r)
 	}
 
@@ -594,41 +971,1645 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// GetBlockByID returns an owner block, with the user who created it
+func (m *postgresDBRepo) GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
+	var r models.RoomRestriction
+
 	query := `
+		SELECT rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.note,
+			COALESCE(rr.created_by, 0), rr.created_at, rr.updated_at, rm.room_name,
+			COALESCE(u.first_name, ''), COALESCE(u.last_name, '')
+		FROM room_restrictions rr
+		JOIN rooms rm ON (rr.room_id = rm.id)
+		LEFT JOIN users u ON (rr.created_by = u.id)
+		WHERE rr.id = $1 AND rr.restriction_id = $2
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&r.ID,
+		&r.RoomID,
+		&r.RestrictionID,
+		&r.StartDate,
+		&r.EndDate,
+		&r.Note,
+		&r.CreatedBy,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Room.RoomName,
+		&r.Creator.FirstName,
+		&r.Creator.LastName,
+	)
+	if err != nil {
+		return r, err
+	}
+	r.Room.ID = r.RoomID
+	r.Creator.ID = r.CreatedBy
+
+	return r, nil
+}
+
+// InsertBlock blocks the nights of a room from r.StartDate up to r.EndDate
+func (m *postgresDBRepo) InsertBlock(ctx context.Context, r models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
 		INSERT INTO room_restrictions
-			(start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			(start_date, end_date, room_id, restriction_id, note, created_by, created_at, updated_at)
 		VALUES
-			($1, $2, $3, $4, $5, $6)
+			($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8)
+		RETURNING id
 	`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
-
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		models.RestrictionOwnerBlock,
+		r.Note,
+		r.CreatedBy,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
 	if err != nil {
-		log.Println(err)
-		return err
+		return 0, restrictionError(err)
 	}
 
-	return nil
+	return newID, nil
 }
 
-// DeleteBlockByID delete a room restriction
-func (m *postgresDBRepo) DeleteBlockByID(id int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateBlock changes the dates and the note of an owner block
+func (m *postgresDBRepo) UpdateBlock(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
 	defer cancel()
 
-	query := `DELETE FROM room_restrictions WHERE id = $1`
+	stmt := `
+		UPDATE room_restrictions SET start_date = $1, end_date = $2, note = $3, updated_at = $4
+		WHERE id = $5 AND restriction_id = $6
+	`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err := m.DB.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.Note, time.Now(), r.ID, models.RestrictionOwnerBlock)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	return nil
+}
+
+// UnblockNights frees the nights from start up to end of an owner block. The block shrinks, disappears
+// when all its nights are freed, or is split in two when the nights are in its middle.
+func (m *postgresDBRepo) UnblockNights(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	var block models.RoomRestriction
+	var createdBy sql.NullInt64
+
+	query := `
+		SELECT id, room_id, start_date, end_date, note, created_by
+		FROM room_restrictions
+		WHERE id = $1 AND restriction_id = $2
+		FOR UPDATE
+	`
+	err = tx.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&block.ID,
+		&block.RoomID,
+		&block.StartDate,
+		&block.EndDate,
+		&block.Note,
+		&createdBy,
+	)
+	if err != nil {
+		return err
+	}
+	block.CreatedBy = int(createdBy.Int64)
+
+	pieces := splitBlock(block, start, end)
+
+	if len(pieces) == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE id = $1`, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// the block keeps its first piece, and the rest of it becomes a new block
+	_, err = tx.ExecContext(ctx, `UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3 WHERE id = $4`,
+		pieces[0].StartDate, pieces[0].EndDate, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pieces[1:] {
+		stmt := `
+			INSERT INTO room_restrictions
+				(start_date, end_date, room_id, restriction_id, note, created_by, created_at, updated_at)
+			VALUES
+				($1, $2, $3, $4, $5, NULLIF($6, 0), $7, $8)
+		`
+		_, err = tx.ExecContext(ctx, stmt, p.StartDate, p.EndDate, p.RoomID, models.RestrictionOwnerBlock, p.Note, p.CreatedBy, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBlock deletes an owner block
+func (m *postgresDBRepo) DeleteBlock(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM room_restrictions WHERE id = $1 AND restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionOwnerBlock)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExternalRestriction deletes a restriction imported from an external calendar
+func (m *postgresDBRepo) DeleteExternalRestriction(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM room_restrictions WHERE id = $1 AND restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionExternal)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// QuoteStay prices a stay in a room night by night
+func (m *postgresDBRepo) QuoteStay(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	room, err := m.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `
+		SELECT
+			id, room_id, name, start_date, end_date, nightly_price, created_at, updated_at
+		FROM seasonal_rates
+		WHERE room_id = $1 AND start_date < $3 AND end_date >= $2
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.SeasonalRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyPrice,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return models.Quote{}, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.Quote{}, err
+	}
+
+	return pricing.Quote(room, rates, start, end)
+}
+
+// AllSeasonalRatesForRoom returns the seasonal rates of a room
+func (m *postgresDBRepo) AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `
+		SELECT
+			id, room_id, name, start_date, end_date, nightly_price, created_at, updated_at
+		FROM seasonal_rates
+		WHERE room_id = $1
+		ORDER BY start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.SeasonalRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyPrice,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate for a room
+func (m *postgresDBRepo) InsertSeasonalRate(ctx context.Context, r models.SeasonalRate) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO seasonal_rates (room_id, name, start_date, end_date, nightly_price, created_at, updated_at)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RoomID,
+		r.Name,
+		r.StartDate,
+		r.EndDate,
+		r.NightlyPrice,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate by id
+func (m *postgresDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM seasonal_rates WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllPromoCodes returns all promo codes
+func (m *postgresDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	query := `
+		SELECT
+			id, code, description, discount_type, amount, valid_from, valid_until,
+			COALESCE(room_id, 0), max_redemptions, redemptions, created_at, updated_at
+		FROM promo_codes
+		ORDER BY code
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return codes, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.PromoCode
+		err := rows.Scan(
+			&p.ID,
+			&p.Code,
+			&p.Description,
+			&p.DiscountType,
+			&p.Amount,
+			&p.ValidFrom,
+			&p.ValidUntil,
+			&p.RoomID,
+			&p.MaxRedemptions,
+			&p.Redemptions,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	return codes, nil
+}
+
+// GetPromoCodeByID returns a promo code by id
+func (m *postgresDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	return m.getPromoCode(ctx, "id = $1", id)
+}
+
+// GetPromoCodeByCode returns a promo code by its code
+func (m *postgresDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	return m.getPromoCode(ctx, "code = $1", code)
+}
+
+// getPromoCode returns the promo code matching where
+func (m *postgresDBRepo) getPromoCode(ctx context.Context, where string, arg interface{}) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var p models.PromoCode
+
+	query := `
+		SELECT
+			id, code, description, discount_type, amount, valid_from, valid_until,
+			COALESCE(room_id, 0), max_redemptions, redemptions, created_at, updated_at
+		FROM promo_codes
+		WHERE ` + where
+
+	row := m.DB.QueryRowContext(ctx, query, arg)
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.DiscountType,
+		&p.Amount,
+		&p.ValidFrom,
+		&p.ValidUntil,
+		&p.RoomID,
+		&p.MaxRedemptions,
+		&p.Redemptions,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// InsertPromoCode inserts a promo code
+func (m *postgresDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO promo_codes (code, description, discount_type, amount, valid_from, valid_until,
+			room_id, max_redemptions, created_at, updated_at)
+		VALUES
+		($1, $2, $3, $4, $5, $6, NULLIF($7, 0), $8, $9, $10)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.Code,
+		p.Description,
+		p.DiscountType,
+		p.Amount,
+		p.ValidFrom,
+		p.ValidUntil,
+		p.RoomID,
+		p.MaxRedemptions,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdatePromoCode updates a promo code, leaving its redemption count alone
+func (m *postgresDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE promo_codes
+		SET
+			code = $1,
+			description = $2,
+			discount_type = $3,
+			amount = $4,
+			valid_from = $5,
+			valid_until = $6,
+			room_id = NULLIF($7, 0),
+			max_redemptions = $8,
+			updated_at = $9
+		WHERE id = $10
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		p.Code,
+		p.Description,
+		p.DiscountType,
+		p.Amount,
+		p.ValidFrom,
+		p.ValidUntil,
+		p.RoomID,
+		p.MaxRedemptions,
+		time.Now(),
+		p.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeletePromoCode deletes a promo code by id
+func (m *postgresDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM promo_codes WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to res.StartDate and res.EndDate,
+// storing the new price and putting mail in the outbox. The room must be free for the new dates,
+// ignoring the reservation itself.
+func (m *postgresDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room so that bookings for it wait until we commit
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = $1 FOR UPDATE`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `
+		SELECT
+			COUNT(id)
+		FROM
+			room_restrictions
+		WHERE
+			room_id = $1 AND
+			$2 < end_date AND $3 > start_date AND
+			COALESCE(reservation_id, 0) <> $4
+	`
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, res.ID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	stmt := `
+		UPDATE reservations
+		SET start_date = $1, end_date = $2, total_price = $3, discount = $4, updated_at = $5
+		WHERE id = $6 AND cancelled = 0
+	`
+	result, err := tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, res.TotalPrice, res.Discount, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if changed == 0 {
+		return sql.ErrNoRows
+	}
+
+	stmt = `
+		UPDATE room_restrictions
+		SET start_date = $1, end_date = $2, updated_at = $3
+		WHERE reservation_id = $4
+	`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate, res.EndDate, time.Now(), res.ID)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	err = insertEmails(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation marks a reservation as cancelled, frees its room restriction and puts mail in the outbox.
+// It returns repository.ErrReservationCancelled when the reservation was already cancelled.
+func (m *postgresDBRepo) CancelReservation(ctx context.Context, id int, mail []models.MailData) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// only one of two guests cancelling at once cancels, and sends the emails
+	result, err := tx.ExecContext(ctx, `UPDATE reservations SET cancelled = 1, updated_at = $1 WHERE id = $2 AND cancelled = 0`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	cancelled, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if cancelled == 0 {
+		var count int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM reservations WHERE id = $1`, id).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return sql.ErrNoRows
+		}
+		return repository.ErrReservationCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = insertEmails(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertPasswordReset stores a password reset request
+func (m *postgresDBRepo) InsertPasswordReset(ctx context.Context, r models.PasswordReset) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO password_resets (user_id, token_hash, expires_at, used, created_at, updated_at)
+		VALUES
+		($1, $2, $3, 0, $4, $5)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, r.UserID, r.TokenHash, r.ExpiresAt, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPasswordResetByTokenHash returns the password reset request stored under hash
+func (m *postgresDBRepo) GetPasswordResetByTokenHash(ctx context.Context, hash string) (models.PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var r models.PasswordReset
+
+	query := `
+		SELECT id, user_id, token_hash, expires_at, used, created_at, updated_at
+		FROM password_resets
+		WHERE token_hash = $1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, hash)
+	err := row.Scan(
+		&r.ID,
+		&r.UserID,
+		&r.TokenHash,
+		&r.ExpiresAt,
+		&r.Used,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+
+	if err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// ResetPassword marks a password reset request as used and sets hash as the password of its user.
+// It returns repository.ErrPasswordResetUsed when the request was already used.
+func (m *postgresDBRepo) ResetPassword(ctx context.Context, id int, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// claim the request, so that it can't be used twice at the same time
+	result, err := tx.ExecContext(ctx, `UPDATE password_resets SET used = 1, updated_at = $1 WHERE id = $2 AND used = 0`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	used, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if used == 0 {
+		return repository.ErrPasswordResetUsed
+	}
+
+	result, err = tx.ExecContext(ctx, `
+		UPDATE users SET password = $1, updated_at = $2
+		WHERE id = (SELECT user_id FROM password_resets WHERE id = $3)
+	`, hash, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// InsertUser inserts a user without a password, who has to choose one before logging in
+func (m *postgresDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+		VALUES
+		($1, $2, $3, '', $4, 1, $5, $6)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateUserDetails changes the name, email and access level of a user
+func (m *postgresDBRepo) UpdateUserDetails(ctx context.Context, u models.User) error {
+	stmt := `
+		UPDATE users
+		SET first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at = $5
+		WHERE id = $6
+	`
+
+	return m.updateUserKeepingAnOwner(ctx, stmt, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now(), u.ID)
+}
+
+// SetUserActive activates (1) or deactivates (0) a user
+func (m *postgresDBRepo) SetUserActive(ctx context.Context, id, active int) error {
+	return m.updateUserKeepingAnOwner(ctx, `UPDATE users SET active = $1, updated_at = $2 WHERE id = $3`, active, time.Now(), id)
+}
+
+// updateUserKeepingAnOwner runs stmt with args, and undoes it when no active owner is left
+func (m *postgresDBRepo) updateUserKeepingAnOwner(ctx context.Context, stmt string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the owners so that two of them can't demote each other at the same time
+	_, err = tx.ExecContext(ctx, `SELECT id FROM users WHERE access_level = $1 AND active = 1 FOR UPDATE`, models.AccessOwner)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	var owners int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(id) FROM users WHERE access_level = $1 AND active = 1`, models.AccessOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+
+	if owners == 0 {
+		return repository.ErrLastOwner
+	}
+
+	return tx.Commit()
+}
+
+// InsertAPIToken stores an API token by its hash, and returns its id
+func (m *postgresDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, revoked, created_at, updated_at)
+		VALUES
+		($1, $2, $3, $4, 0, $5, $6)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.Name,
+		t.TokenHash,
+		strings.Join(t.Scopes, ","),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// AllAPITokensForUser returns the API tokens of a user, revoked ones included
+func (m *postgresDBRepo) AllAPITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var apiTokens []models.APIToken
+
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_hash, t.scopes, t.last_used_at, t.revoked, t.created_at, t.updated_at,
+			u.id, u.first_name, u.last_name, u.email, u.access_level, u.active
+		FROM api_tokens t
+		JOIN users u ON (t.user_id = u.id)
+		WHERE t.user_id = $1
+		ORDER BY t.revoked, t.created_at DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return apiTokens, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return apiTokens, err
+		}
+		apiTokens = append(apiTokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return apiTokens, err
+	}
+
+	return apiTokens, nil
+}
+
+// GetAPITokenByID returns an API token with its user
+func (m *postgresDBRepo) GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error) {
+	return m.getAPIToken(ctx, "t.id = $1", id)
+}
+
+// GetAPITokenByHash returns the API token stored under hash, with its user
+func (m *postgresDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	return m.getAPIToken(ctx, "t.token_hash = $1", hash)
+}
+
+// getAPIToken returns the API token matching where, with its user
+func (m *postgresDBRepo) getAPIToken(ctx context.Context, where string, arg interface{}) (models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_hash, t.scopes, t.last_used_at, t.revoked, t.created_at, t.updated_at,
+			u.id, u.first_name, u.last_name, u.email, u.access_level, u.active
+		FROM api_tokens t
+		JOIN users u ON (t.user_id = u.id)
+		WHERE ` + where
+
+	return scanAPIToken(m.DB.QueryRowContext(ctx, query, arg))
+}
+
+// rowScanner is a single row of a query, or the current row of a query returning several
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIToken reads an API token and its user from a row selected by AllAPITokensForUser or getAPIToken
+func scanAPIToken(row rowScanner) (models.APIToken, error) {
+	var t models.APIToken
+	var scopes string
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.TokenHash,
+		&scopes,
+		&lastUsedAt,
+		&t.Revoked,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.User.ID,
+		&t.User.FirstName,
+		&t.User.LastName,
+		&t.User.Email,
+		&t.User.AccessLevel,
+		&t.User.Active,
+	)
+	if err != nil {
+		return t, err
+	}
+
+	if scopes != "" {
+		t.Scopes = strings.Split(scopes, ",")
+	}
+
+	if lastUsedAt.Valid {
+		t.LastUsedAt = lastUsedAt.Time
+	}
+
+	return t, nil
+}
+
+// TouchAPIToken records that an API token has just been used
+func (m *postgresDBRepo) TouchAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = $1 WHERE id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAPIToken stops an API token from being used again
+func (m *postgresDBRepo) RevokeAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE api_tokens SET revoked = 1, updated_at = $1 WHERE id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertAuditEntry records a change in the audit log
+func (m *postgresDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO audit_log (user_id, api_token_id, action, details, created_at, updated_at)
+		VALUES
+		($1, NULLIF($2, 0), $3, $4, $5, $6)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, e.UserID, e.APITokenID, e.Action, e.Details, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllAuditEntries returns the latest limit entries of the audit log, newest first
+func (m *postgresDBRepo) AllAuditEntries(ctx context.Context, limit int) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	query := `
+		SELECT a.id, a.user_id, COALESCE(a.api_token_id, 0), a.action, a.details, a.created_at,
+			u.first_name, u.last_name, u.email, COALESCE(t.name, '')
+		FROM audit_log a
+		JOIN users u ON (a.user_id = u.id)
+		LEFT JOIN api_tokens t ON (a.api_token_id = t.id)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $1
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return entries, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.APITokenID,
+			&e.Action,
+			&e.Details,
+			&e.CreatedAt,
+			&e.User.FirstName,
+			&e.User.LastName,
+			&e.User.Email,
+			&e.APIToken.Name,
+		)
+		if err != nil {
+			return entries, err
+		}
+		e.User.ID = e.UserID
+		e.APIToken.ID = e.APITokenID
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// AllCalendarSources returns the external calendars of every room
+func (m *postgresDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	return m.queryCalendarSources(ctx, `ORDER BY room_id, name`)
+}
+
+// AllCalendarSourcesForRoom returns the external calendars of a room
+func (m *postgresDBRepo) AllCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error) {
+	return m.queryCalendarSources(ctx, `WHERE room_id = $1 ORDER BY name`, roomID)
+}
+
+// queryCalendarSources returns the calendar sources selected by the where and order by clauses in clauses
+func (m *postgresDBRepo) queryCalendarSources(ctx context.Context, clauses string, args ...interface{}) ([]models.CalendarSource, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var sources []models.CalendarSource
+
+	query := `
+		SELECT id, room_id, name, url, last_synced_at, last_error, created_at, updated_at
+		FROM calendar_sources
+	` + clauses
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return sources, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanCalendarSource(rows)
+		if err != nil {
+			return sources, err
+		}
+		sources = append(sources, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sources, err
+	}
+
+	return sources, nil
+}
+
+// GetCalendarSourceByID returns a calendar source by id
+func (m *postgresDBRepo) GetCalendarSourceByID(ctx context.Context, id int) (models.CalendarSource, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, room_id, name, url, last_synced_at, last_error, created_at, updated_at
+		FROM calendar_sources
+		WHERE id = $1
+	`
+
+	return scanCalendarSource(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// scanCalendarSource reads a calendar source from a row
+func scanCalendarSource(row rowScanner) (models.CalendarSource, error) {
+	var s models.CalendarSource
+	var lastSyncedAt sql.NullTime
+
+	err := row.Scan(
+		&s.ID,
+		&s.RoomID,
+		&s.Name,
+		&s.URL,
+		&lastSyncedAt,
+		&s.LastError,
+		&s.CreatedAt,
+		&s.UpdatedAt,
+	)
+	if err != nil {
+		return s, err
+	}
+	s.LastSyncedAt = lastSyncedAt.Time
+
+	return s, nil
+}
+
+// InsertCalendarSource adds an external calendar to a room
+func (m *postgresDBRepo) InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO calendar_sources (room_id, name, url, last_error, created_at, updated_at)
+		VALUES
+		($1, $2, $3, '', $4, $5)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt, s.RoomID, s.Name, s.URL, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteCalendarSource deletes an external calendar, with the restrictions imported from it
+func (m *postgresDBRepo) DeleteCalendarSource(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM calendar_sources WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateCalendarSourceStatus records when an external calendar was last synced, and why it failed if it did
+func (m *postgresDBRepo) UpdateCalendarSourceStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `UPDATE calendar_sources SET last_synced_at = $1, last_error = $2, updated_at = $3 WHERE id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, syncedAt, lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRestrictionsForSource returns the restrictions imported from an external calendar
+func (m *postgresDBRepo) GetRestrictionsForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		SELECT id, room_id, restriction_id, start_date, end_date, source_id, external_uid, created_at, updated_at
+		FROM room_restrictions
+		WHERE source_id = $1
+		ORDER BY start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, sourceID)
+	if err != nil {
+		return restrictions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.SourceID,
+			&r.ExternalUID,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertExternalRestriction inserts a restriction imported from an external calendar
+func (m *postgresDBRepo) InsertExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO room_restrictions
+			(start_date, end_date, room_id, restriction_id, source_id, external_uid, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		models.RestrictionExternal,
+		r.SourceID,
+		r.ExternalUID,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	return nil
+}
+
+// UpdateExternalRestriction moves a restriction imported from an external calendar to new dates
+func (m *postgresDBRepo) UpdateExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE room_restrictions SET start_date = $1, end_date = $2, updated_at = $3
+		WHERE id = $4 AND restriction_id = $5
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, r.StartDate, r.EndDate, time.Now(), r.ID, models.RestrictionExternal)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	return nil
+}
+
+// insertEmails puts mail in the outbox as part of tx, to be sent once tx commits
+func insertEmails(ctx context.Context, tx *sql.Tx, mail []models.MailData) error {
+	stmt := `
+		INSERT INTO email_outbox
+			(to_address, from_address, subject, content, text_content, attachments, status, attempts,
+			next_attempt_at, created_at, updated_at)
+		VALUES
+			($1, $2, $3, $4, $5, $6, $7, 0, $8, $9, $10)
+	`
+
+	for _, msg := range mail {
+		attachments := ""
+		if len(msg.Attachments) > 0 {
+			data, err := json.Marshal(msg.Attachments)
+			if err != nil {
+				return err
+			}
+			attachments = string(data)
+		}
+
+		_, err := tx.ExecContext(ctx, stmt, msg.To, msg.From, msg.Subject, msg.Content, msg.Text, attachments,
+			models.EmailPending, time.Now(), time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnqueueEmail puts an email in the outbox
+func (m *postgresDBRepo) EnqueueEmail(ctx context.Context, msg models.MailData) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertEmails(ctx, tx, []models.MailData{msg})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimEmails takes up to limit pending emails that are due, oldest first, counting an attempt for each.
+// They are not due again until lease has passed, so that other workers leave them alone while they are
+// sent, and they are retried if the worker sending them dies. Rows locked by another worker are skipped.
+func (m *postgresDBRepo) ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var emails []models.OutboxEmail
+
+	query := `
+		UPDATE email_outbox
+		SET attempts = attempts + 1, next_attempt_at = $2, updated_at = $3
+		WHERE id IN (
+			SELECT id
+			FROM email_outbox
+			WHERE status = $4 AND next_attempt_at <= $3
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + outboxEmailColumns
+
+	now := time.Now()
+	rows, err := m.DB.QueryContext(ctx, query, limit, now.Add(lease), now, models.EmailPending)
+	if err != nil {
+		return emails, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+// MarkEmailSent records that an email of the outbox was sent
+func (m *postgresDBRepo) MarkEmailSent(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET status = $1, last_error = '', sent_at = $2, updated_at = $2
+		WHERE id = $3
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.EmailSent, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RetryEmail records why sending an email of the outbox failed, and when to try again
+func (m *postgresDBRepo) RetryEmail(ctx context.Context, id int, at time.Time, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET next_attempt_at = $1, last_error = $2, updated_at = $3
+		WHERE id = $4
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, at, lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkEmailDead gives up on an email of the outbox, recording why its last attempt failed
+func (m *postgresDBRepo) MarkEmailDead(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET status = $1, last_error = $2, updated_at = $3
+		WHERE id = $4
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.EmailDead, lastError, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllOutboxEmails returns the latest limit emails of the outbox with status, or of any status when
+// status is empty, newest first
+func (m *postgresDBRepo) AllOutboxEmails(ctx context.Context, status string, limit int) ([]models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var emails []models.OutboxEmail
+
+	query := `
+		SELECT ` + outboxEmailColumns + `
+		FROM email_outbox
+		WHERE $1 = '' OR status = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return emails, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+// ResendEmail puts an email of the outbox that was given up on back in the queue, with its attempts reset.
+// It returns sql.ErrNoRows when there is no such email.
+func (m *postgresDBRepo) ResendEmail(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET status = $1, attempts = 0, next_attempt_at = $2, updated_at = $2
+		WHERE id = $3 AND status = $4
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt, models.EmailPending, time.Now(), id, models.EmailDead)
+	if err != nil {
+		return err
+	}
+
+	resent, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if resent == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// outboxEmailColumns are the columns of email_outbox read by scanOutboxEmail
+const outboxEmailColumns = `id, to_address, from_address, subject, content, text_content, attachments,
+	status, attempts, next_attempt_at, last_error, sent_at, created_at, updated_at`
+
+// scanOutboxEmail reads an email of the outbox from a row selected with outboxEmailColumns
+func scanOutboxEmail(row rowScanner) (models.OutboxEmail, error) {
+	var e models.OutboxEmail
+	var attachments string
+	var sentAt sql.NullTime
+
+	err := row.Scan(
+		&e.ID,
+		&e.To,
+		&e.From,
+		&e.Subject,
+		&e.Content,
+		&e.Text,
+		&attachments,
+		&e.Status,
+		&e.Attempts,
+		&e.NextAttemptAt,
+		&e.LastError,
+		&sentAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	if err != nil {
+		return e, err
+	}
+	e.SentAt = sentAt.Time
+
+	// attachments are stored as json, empty when there are none
+	if attachments != "" {
+		err = json.Unmarshal([]byte(attachments), &e.Attachments)
+		if err != nil {
+			return e, err
+		}
+	}
+
+	return e, nil
+}
+
+// ReservationsArriving returns the reservations, not cancelled, starting from from to to, that the
+// scheduled email kind was not sent for
+func (m *postgresDBRepo) ReservationsArriving(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsWithoutScheduledEmail(ctx, "r.start_date", from, to, kind)
+}
+
+// ReservationsDeparted returns the reservations, not cancelled, ending from from to to, that the
+// scheduled email kind was not sent for
+func (m *postgresDBRepo) ReservationsDeparted(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsWithoutScheduledEmail(ctx, "r.end_date", from, to, kind)
+}
+
+// reservationsWithoutScheduledEmail returns the reservations, not cancelled, whose date column is from
+// from to to, that the scheduled email kind was not sent for
+func (m *postgresDBRepo) reservationsWithoutScheduledEmail(ctx context.Context, column string, from, to time.Time, kind string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		SELECT
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.total_price, r.cancelled,
+
+			rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm
+		ON r.room_id = rm.id
+		WHERE r.cancelled = 0 AND ` + column + ` BETWEEN $1 AND $2
+		AND NOT EXISTS (
+			SELECT 1 FROM scheduled_emails se WHERE se.reservation_id = r.id AND se.kind = $3
+		)
+		ORDER BY r.id ASC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, from, to, kind)
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.TotalPrice,
+			&i.Cancelled,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// EnqueueScheduledEmail puts mail in the outbox and records that the scheduled email kind was sent for
+// the reservation, unless it already was. It reports whether mail was put in the outbox.
+func (m *postgresDBRepo) EnqueueScheduledEmail(ctx context.Context, reservationID int, kind string, mail []models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		INSERT INTO scheduled_emails (reservation_id, kind, created_at, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (reservation_id, kind) DO NOTHING
+	`
+
+	result, err := tx.ExecContext(ctx, stmt, reservationID, kind, time.Now(), time.Now())
+	if err != nil {
+		return false, err
+	}
+
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if recorded == 0 {
+		return false, nil
+	}
+
+	err = insertEmails(ctx, tx, mail)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
 }
@@ -0,0 +1,2494 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/pricing"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// AllUsers returns all staff users
+func (m *sqliteDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var users []models.User
+
+	query := `
+		SELECT 
+			id, first_name, last_name, email, access_level, active, created_at, updated_at
+		FROM users
+		ORDER BY last_name, first_name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.AccessLevel,
+			&u.Active,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// InsertReservation inserts a reservation into the database
+func (m *sqliteDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+	var newID int
+
+	stmt := `
+		INSERT INTO reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(
+		ctx,
+		stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate.UTC(),
+		res.EndDate.UTC(),
+		res.RoomID,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// InserRoomRestriction inserts a room restriction into the database
+func (m *sqliteDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+		VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, ?7)
+	`
+	_, err := m.DB.ExecContext(
+		ctx,
+		stmt,
+		r.StartDate.UTC(),
+		r.EndDate.UTC(),
+		r.RoomID,
+		r.ReservationID,
+		time.Now().UTC(),
+		time.Now().UTC(),
+		r.RestrictionID,
+	)
+
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	return nil
+}
+
+// CreateBooking inserts a reservation and its room restriction in a single transaction.
+// Transactions take the write lock of the database when they begin, so two concurrent
+// bookings for the same room cannot both succeed. The emails returned by mail for the id of the
+// new reservation are put in the outbox by the same transaction.
+func (m *sqliteDBRepo) CreateBooking(ctx context.Context, res models.Reservation, mail func(id int) ([]models.MailData, error)) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = ?1`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+	query := `
+		SELECT
+			COUNT(id)
+		FROM
+			room_restrictions
+		WHERE
+			room_id = ?1 AND
+			?2 < end_date AND ?3 > start_date
+	`
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate.UTC(), res.EndDate.UTC()).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	var newID int
+	stmt := `
+		INSERT INTO reservations (first_name, last_name, email, phone, start_date, end_date, room_id, total_price,
+			promo_code_id, discount, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, NULLIF(?9, 0), ?10, ?11, ?12)
+		RETURNING id
+	`
+	err = tx.QueryRowContext(
+		ctx,
+		stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate.UTC(),
+		res.EndDate.UTC(),
+		res.RoomID,
+		res.TotalPrice,
+		res.PromoCodeID,
+		res.Discount,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.PromoCodeID > 0 {
+		stmt = `
+			UPDATE promo_codes
+			SET redemptions = redemptions + 1, updated_at = ?2
+			WHERE id = ?1 AND (max_redemptions = 0 OR redemptions < max_redemptions)
+		`
+		result, err := tx.ExecContext(ctx, stmt, res.PromoCodeID, time.Now().UTC())
+		if err != nil {
+			return 0, err
+		}
+
+		redeemed, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+
+		if redeemed == 0 {
+			return 0, repository.ErrPromoCodeExhausted
+		}
+
+		stmt = `
+			INSERT INTO promo_redemptions (promo_code_id, reservation_id, created_at, updated_at)
+			VALUES
+			(?1, ?2, ?3, ?4)
+		`
+		_, err = tx.ExecContext(ctx, stmt, res.PromoCodeID, newID, time.Now().UTC(), time.Now().UTC())
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	stmt = `
+		INSERT INTO room_restrictions (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+		VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, ?7)
+	`
+	_, err = tx.ExecContext(
+		ctx,
+		stmt,
+		res.StartDate.UTC(),
+		res.EndDate.UTC(),
+		res.RoomID,
+		newID,
+		time.Now().UTC(),
+		time.Now().UTC(),
+		models.RestrictionReservation,
+	)
+	if err != nil {
+		return 0, restrictionError(err)
+	}
+
+	if mail != nil {
+		emails, err := mail(newID)
+		if err != nil {
+			return 0, err
+		}
+
+		err = m.insertEmails(ctx, tx, emails)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability exists
+func (m *sqliteDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var numRows int
+
+	query := `
+		SELECT
+			COUNT(id)
+		FROM
+			room_restrictions
+		WHERE
+			room_id = ?1 AND
+			
+			?2 < end_date and ?3 > start_date;
+	`
+	row := m.DB.QueryRowContext(ctx, query, roomID, start.UTC(), end.UTC())
+
+	err := row.Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	if numRows == 0 {
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// SearchAvailabilityForAllRooms return a slice of available rooms, if any. For given date range
+func (m *sqliteDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rooms []models.Room
+	query := `
+		SELECT
+			r.id, r.room_name
+		FROM
+			rooms r
+		WHERE 
+			r.id NOT IN 
+				(
+					SELECT 
+						room_id 
+					FROM 
+						room_restrictions rr 
+					WHERE 
+						?1 < rr.end_date AND
+						?2 > rr.start_date
+				)
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, start.UTC(), end.UTC())
+	if err != nil {
+		return rooms, err
+	}
+
+	for rows.Next() {
+		var room models.Room
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+		)
+		if err != nil {
+			return rooms, err
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// GetRoomByID return room data
+func (m *sqliteDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var room models.Room
+
+	query := `
+		SELECT
+			id, room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at
+		FROM rooms
+		WHERE
+			id = ?1
+	`
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.BasePrice,
+		&room.WeekendUplift,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// GetUserByID return user by id
+func (m *sqliteDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var user models.User
+
+	query := `
+		SELECT 
+			id, first_name, last_name, email, password, access_level, active, created_at, updated_at
+		FROM users
+		WHERE id = ?1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.Active,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// GetUserByEmail return user by email
+func (m *sqliteDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var user models.User
+
+	query := `
+		SELECT 
+			id, first_name, last_name, email, password, access_level, active, created_at, updated_at
+		FROM users
+		WHERE email = ?1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+	err := row.Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+		&user.Password,
+		&user.AccessLevel,
+		&user.Active,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+// UpdateUser updates a user in the database. u.Password must already be hashed with bcrypt. The access
+// level is left as it is, UpdateUserDetails changes it keeping an owner.
+func (m *sqliteDBRepo) UpdateUser(ctx context.Context, u models.User) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE users 
+		SET
+			first_name = ?1,
+			last_name = ?2,
+			email = ?3,
+			password = ?4,
+			updated_at = ?5
+		WHERE id = ?6
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Password,
+		time.Now().UTC(),
+		u.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Authenticate autheticates a user
+func (m *sqliteDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	query := `
+		SELECT id, password
+		FROM users
+		WHERE email = ?1 AND active = 1
+	`
+	row := m.DB.QueryRowContext(ctx, query, email)
+	err := row.Scan(&id, &hashedPassword)
+	if err != nil {
+		return id, "", err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("Incorrect password")
+	}
+	if err != nil {
+		return id, "", err
+	}
+
+	return id, hashedPassword, nil
+
+}
+
+// AllReservations returns a slice of all reservations
+func (m *sqliteDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		SELECT 
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.total_price, r.cancelled,
+			
+			rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm 
+		ON r.room_id = rm.id
+		ORDER BY r.start_date ASC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.TotalPrice,
+			&i.Cancelled,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+
+}
+
+// AllNewReservations returns a slice of all reservations
+func (m *sqliteDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		SELECT 
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.total_price, r.cancelled,
+			
+			rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm 
+		ON r.room_id = rm.id
+		WHERE processed = 0
+		ORDER BY r.start_date ASC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.TotalPrice,
+			&i.Cancelled,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+
+}
+
+// GetReservationByID returns one reservation by ID
+func (m *sqliteDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var res models.Reservation
+
+	query := `
+		SELECT 
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.created_at, r.updated_at, r.processed, r.total_price,
+			COALESCE(r.promo_code_id, 0), r.discount, r.cancelled,
+
+			rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm
+		ON r.room_id = rm.id
+		WHERE r.id = ?1
+	`
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&res.TotalPrice,
+		&res.PromoCodeID,
+		&res.Discount,
+		&res.Cancelled,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+// UpdateReservation updates a reservation in the database
+func (m *sqliteDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE reservations 
+		SET
+			first_name = ?1,
+			last_name = ?2,
+			email = ?3,
+			phone = ?4,
+			updated_at = ?5
+		WHERE id = ?6
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		r.FirstName,
+		r.LastName,
+		r.Email,
+		r.Phone,
+		time.Now().UTC(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteReservation deletes one reservations by id
+func (m *sqliteDBRepo) DeleteReservation(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM reservations WHERE id = ?1`
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateProcessedForReservation updates processed for a reservation by id
+func (m *sqliteDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE reservations 
+		SET processed = ?1
+		WHERE id = ?2
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		processed,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// AllRooms get all rooms
+func (m *sqliteDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rooms []models.Room
+
+	query := `
+		SELECT 
+			id, room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at 
+		FROM rooms
+		ORDER BY room_name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rooms, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var rm models.Room
+
+		err := rows.Scan(
+			&rm.ID,
+			&rm.RoomName,
+			&rm.Slug,
+			&rm.Description,
+			&rm.Capacity,
+			&rm.BasePrice,
+			&rm.WeekendUplift,
+			&rm.CreatedAt,
+			&rm.UpdatedAt,
+		)
+
+		if err != nil {
+			return rooms, err
+		}
+
+		rooms = append(rooms, rm)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// GetRoomBySlug returns a room by its slug
+func (m *sqliteDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var room models.Room
+
+	query := `
+		SELECT
+			id, room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at
+		FROM rooms
+		WHERE
+			slug = ?1
+	`
+	row := m.DB.QueryRowContext(ctx, query, slug)
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&room.BasePrice,
+		&room.WeekendUplift,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+
+	if err != nil {
+		return room, err
+	}
+
+	return room, nil
+}
+
+// InsertRoom inserts a room into the database
+func (m *sqliteDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO rooms (room_name, slug, description, capacity, base_price, weekend_uplift, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Capacity,
+		r.BasePrice,
+		r.WeekendUplift,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates a room in the database
+func (m *sqliteDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE rooms
+		SET
+			room_name = ?1,
+			slug = ?2,
+			description = ?3,
+			capacity = ?4,
+			base_price = ?5,
+			weekend_uplift = ?6,
+			updated_at = ?7
+		WHERE id = ?8
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		r.RoomName,
+		r.Slug,
+		r.Description,
+		r.Capacity,
+		r.BasePrice,
+		r.WeekendUplift,
+		time.Now().UTC(),
+		r.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room by id, refusing to do so while it still has reservations
+func (m *sqliteDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var numRows int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(id) FROM reservations WHERE room_id = ?1`, id).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return repository.ErrRoomHasReservations
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM rooms WHERE id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRestrictionsForRoomByDate returns restrictions for a room by date range
+func (m *sqliteDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		SELECT
+			rr.id, COALESCE(rr.reservation_id, 0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+			rr.note, rr.updated_at, COALESCE(r.first_name, ''), COALESCE(r.last_name, '')
+		FROM room_restrictions rr
+		LEFT JOIN reservations r ON (rr.reservation_id = r.id)
+		WHERE ?1 < rr.end_date AND ?2 >= rr.start_date AND rr.room_id = ?3
+		ORDER BY rr.start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, start.UTC(), end.UTC(), roomID)
+	if err != nil {
+		return restrictions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.Note,
+			&r.UpdatedAt,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		r.Reservation.ID = r.ReservationID
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return restrictions, nil
+}
+
+// GetBlockByID returns an owner block, with the user who created it
+func (m *sqliteDBRepo) GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var r models.RoomRestriction
+
+	query := `
+		SELECT rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.note,
+			COALESCE(rr.created_by, 0), rr.created_at, rr.updated_at, rm.room_name,
+			COALESCE(u.first_name, ''), COALESCE(u.last_name, '')
+		FROM room_restrictions rr
+		JOIN rooms rm ON (rr.room_id = rm.id)
+		LEFT JOIN users u ON (rr.created_by = u.id)
+		WHERE rr.id = ?1 AND rr.restriction_id = ?2
+	`
+
+	err := m.DB.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&r.ID,
+		&r.RoomID,
+		&r.RestrictionID,
+		&r.StartDate,
+		&r.EndDate,
+		&r.Note,
+		&r.CreatedBy,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Room.RoomName,
+		&r.Creator.FirstName,
+		&r.Creator.LastName,
+	)
+	if err != nil {
+		return r, err
+	}
+	r.Room.ID = r.RoomID
+	r.Creator.ID = r.CreatedBy
+
+	return r, nil
+}
+
+// InsertBlock blocks the nights of a room from r.StartDate up to r.EndDate
+func (m *sqliteDBRepo) InsertBlock(ctx context.Context, r models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO room_restrictions
+			(start_date, end_date, room_id, restriction_id, note, created_by, created_at, updated_at)
+		VALUES
+			(?1, ?2, ?3, ?4, ?5, NULLIF(?6, 0), ?7, ?8)
+		RETURNING id
+	`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.StartDate.UTC(),
+		r.EndDate.UTC(),
+		r.RoomID,
+		models.RestrictionOwnerBlock,
+		r.Note,
+		r.CreatedBy,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, restrictionError(err)
+	}
+
+	return newID, nil
+}
+
+// UpdateBlock changes the dates and the note of an owner block
+func (m *sqliteDBRepo) UpdateBlock(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE room_restrictions SET start_date = ?1, end_date = ?2, note = ?3, updated_at = ?4
+		WHERE id = ?5 AND restriction_id = ?6
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, r.StartDate.UTC(), r.EndDate.UTC(), r.Note, time.Now().UTC(), r.ID, models.RestrictionOwnerBlock)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	return nil
+}
+
+// UnblockNights frees the nights from start up to end of an owner block. The block shrinks, disappears
+// when all its nights are freed, or is split in two when the nights are in its middle.
+func (m *sqliteDBRepo) UnblockNights(ctx context.Context, id int, start, end time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var block models.RoomRestriction
+	var createdBy sql.NullInt64
+
+	query := `
+		SELECT id, room_id, start_date, end_date, note, created_by
+		FROM room_restrictions
+		WHERE id = ?1 AND restriction_id = ?2
+	`
+	err = tx.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&block.ID,
+		&block.RoomID,
+		&block.StartDate,
+		&block.EndDate,
+		&block.Note,
+		&createdBy,
+	)
+	if err != nil {
+		return err
+	}
+	block.CreatedBy = int(createdBy.Int64)
+
+	pieces := splitBlock(block, start, end)
+
+	if len(pieces) == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE id = ?1`, id)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// the block keeps its first piece, and the rest of it becomes a new block
+	_, err = tx.ExecContext(ctx, `UPDATE room_restrictions SET start_date = ?1, end_date = ?2, updated_at = ?3 WHERE id = ?4`,
+		pieces[0].StartDate.UTC(), pieces[0].EndDate.UTC(), time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range pieces[1:] {
+		stmt := `
+			INSERT INTO room_restrictions
+				(start_date, end_date, room_id, restriction_id, note, created_by, created_at, updated_at)
+			VALUES
+				(?1, ?2, ?3, ?4, ?5, NULLIF(?6, 0), ?7, ?8)
+		`
+		_, err = tx.ExecContext(ctx, stmt, p.StartDate.UTC(), p.EndDate.UTC(), p.RoomID, models.RestrictionOwnerBlock, p.Note, p.CreatedBy, time.Now().UTC(), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteBlock deletes an owner block
+func (m *sqliteDBRepo) DeleteBlock(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM room_restrictions WHERE id = ?1 AND restriction_id = ?2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionOwnerBlock)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExternalRestriction deletes a restriction imported from an external calendar
+func (m *sqliteDBRepo) DeleteExternalRestriction(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM room_restrictions WHERE id = ?1 AND restriction_id = ?2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionExternal)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// QuoteStay prices a stay in a room night by night
+func (m *sqliteDBRepo) QuoteStay(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	room, err := m.GetRoomByID(ctx, roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `
+		SELECT
+			id, room_id, name, start_date, end_date, nightly_price, created_at, updated_at
+		FROM seasonal_rates
+		WHERE room_id = ?1 AND start_date < ?3 AND end_date >= ?2
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start.UTC(), end.UTC())
+	if err != nil {
+		return models.Quote{}, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.SeasonalRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyPrice,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return models.Quote{}, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return models.Quote{}, err
+	}
+
+	return pricing.Quote(room, rates, start, end)
+}
+
+// AllSeasonalRatesForRoom returns the seasonal rates of a room
+func (m *sqliteDBRepo) AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var rates []models.SeasonalRate
+
+	query := `
+		SELECT
+			id, room_id, name, start_date, end_date, nightly_price, created_at, updated_at
+		FROM seasonal_rates
+		WHERE room_id = ?1
+		ORDER BY start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return rates, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.SeasonalRate
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.Name,
+			&r.StartDate,
+			&r.EndDate,
+			&r.NightlyPrice,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate for a room
+func (m *sqliteDBRepo) InsertSeasonalRate(ctx context.Context, r models.SeasonalRate) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO seasonal_rates (room_id, name, start_date, end_date, nightly_price, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, ?7)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		r.RoomID,
+		r.Name,
+		r.StartDate.UTC(),
+		r.EndDate.UTC(),
+		r.NightlyPrice,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate by id
+func (m *sqliteDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM seasonal_rates WHERE id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllPromoCodes returns all promo codes
+func (m *sqliteDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var codes []models.PromoCode
+
+	query := `
+		SELECT
+			id, code, description, discount_type, amount, valid_from, valid_until,
+			COALESCE(room_id, 0), max_redemptions, redemptions, created_at, updated_at
+		FROM promo_codes
+		ORDER BY code
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return codes, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.PromoCode
+		err := rows.Scan(
+			&p.ID,
+			&p.Code,
+			&p.Description,
+			&p.DiscountType,
+			&p.Amount,
+			&p.ValidFrom,
+			&p.ValidUntil,
+			&p.RoomID,
+			&p.MaxRedemptions,
+			&p.Redemptions,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return codes, err
+		}
+		codes = append(codes, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return codes, err
+	}
+
+	return codes, nil
+}
+
+// GetPromoCodeByID returns a promo code by id
+func (m *sqliteDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	return m.getPromoCode(ctx, "id = ?1", id)
+}
+
+// GetPromoCodeByCode returns a promo code by its code
+func (m *sqliteDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	return m.getPromoCode(ctx, "code = ?1", code)
+}
+
+// getPromoCode returns the promo code matching where
+func (m *sqliteDBRepo) getPromoCode(ctx context.Context, where string, arg interface{}) (models.PromoCode, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var p models.PromoCode
+
+	query := `
+		SELECT
+			id, code, description, discount_type, amount, valid_from, valid_until,
+			COALESCE(room_id, 0), max_redemptions, redemptions, created_at, updated_at
+		FROM promo_codes
+		WHERE ` + where
+
+	row := m.DB.QueryRowContext(ctx, query, arg)
+	err := row.Scan(
+		&p.ID,
+		&p.Code,
+		&p.Description,
+		&p.DiscountType,
+		&p.Amount,
+		&p.ValidFrom,
+		&p.ValidUntil,
+		&p.RoomID,
+		&p.MaxRedemptions,
+		&p.Redemptions,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// InsertPromoCode inserts a promo code
+func (m *sqliteDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO promo_codes (code, description, discount_type, amount, valid_from, valid_until,
+			room_id, max_redemptions, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, NULLIF(?7, 0), ?8, ?9, ?10)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.Code,
+		p.Description,
+		p.DiscountType,
+		p.Amount,
+		p.ValidFrom.UTC(),
+		p.ValidUntil.UTC(),
+		p.RoomID,
+		p.MaxRedemptions,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdatePromoCode updates a promo code, leaving its redemption count alone
+func (m *sqliteDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		UPDATE promo_codes
+		SET
+			code = ?1,
+			description = ?2,
+			discount_type = ?3,
+			amount = ?4,
+			valid_from = ?5,
+			valid_until = ?6,
+			room_id = NULLIF(?7, 0),
+			max_redemptions = ?8,
+			updated_at = ?9
+		WHERE id = ?10
+	`
+
+	_, err := m.DB.ExecContext(ctx, query,
+		p.Code,
+		p.Description,
+		p.DiscountType,
+		p.Amount,
+		p.ValidFrom.UTC(),
+		p.ValidUntil.UTC(),
+		p.RoomID,
+		p.MaxRedemptions,
+		time.Now().UTC(),
+		p.ID,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeletePromoCode deletes a promo code by id
+func (m *sqliteDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM promo_codes WHERE id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to res.StartDate and res.EndDate,
+// storing the new price and putting mail in the outbox. The room must be free for the new dates,
+// ignoring the reservation itself.
+func (m *sqliteDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var roomID int
+	err = tx.QueryRowContext(ctx, `SELECT id FROM rooms WHERE id = ?1`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `
+		SELECT
+			COUNT(id)
+		FROM
+			room_restrictions
+		WHERE
+			room_id = ?1 AND
+			?2 < end_date AND ?3 > start_date AND
+			COALESCE(reservation_id, 0) <> ?4
+	`
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate.UTC(), res.EndDate.UTC(), res.ID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+
+	if numRows > 0 {
+		return repository.ErrRoomUnavailable
+	}
+
+	stmt := `
+		UPDATE reservations
+		SET start_date = ?1, end_date = ?2, total_price = ?3, discount = ?4, updated_at = ?5
+		WHERE id = ?6 AND cancelled = 0
+	`
+	result, err := tx.ExecContext(ctx, stmt, res.StartDate.UTC(), res.EndDate.UTC(), res.TotalPrice, res.Discount, time.Now().UTC(), res.ID)
+	if err != nil {
+		return err
+	}
+
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if changed == 0 {
+		return sql.ErrNoRows
+	}
+
+	stmt = `
+		UPDATE room_restrictions
+		SET start_date = ?1, end_date = ?2, updated_at = ?3
+		WHERE reservation_id = ?4
+	`
+	_, err = tx.ExecContext(ctx, stmt, res.StartDate.UTC(), res.EndDate.UTC(), time.Now().UTC(), res.ID)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	err = m.insertEmails(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CancelReservation marks a reservation as cancelled, frees its room restriction and puts mail in the outbox.
+// It returns repository.ErrReservationCancelled when the reservation was already cancelled.
+func (m *sqliteDBRepo) CancelReservation(ctx context.Context, id int, mail []models.MailData) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// only one of two guests cancelling at once cancels, and sends the emails
+	result, err := tx.ExecContext(ctx, `UPDATE reservations SET cancelled = 1, updated_at = ?1 WHERE id = ?2 AND cancelled = 0`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	cancelled, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if cancelled == 0 {
+		var count int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM reservations WHERE id = ?1`, id).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return sql.ErrNoRows
+		}
+		return repository.ErrReservationCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM room_restrictions WHERE reservation_id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	err = m.insertEmails(ctx, tx, mail)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertPasswordReset stores a password reset request
+func (m *sqliteDBRepo) InsertPasswordReset(ctx context.Context, r models.PasswordReset) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO password_resets (user_id, token_hash, expires_at, used, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, 0, ?4, ?5)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, r.UserID, r.TokenHash, r.ExpiresAt.UTC(), time.Now().UTC(), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPasswordResetByTokenHash returns the password reset request stored under hash
+func (m *sqliteDBRepo) GetPasswordResetByTokenHash(ctx context.Context, hash string) (models.PasswordReset, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var r models.PasswordReset
+
+	query := `
+		SELECT id, user_id, token_hash, expires_at, used, created_at, updated_at
+		FROM password_resets
+		WHERE token_hash = ?1
+	`
+
+	row := m.DB.QueryRowContext(ctx, query, hash)
+	err := row.Scan(
+		&r.ID,
+		&r.UserID,
+		&r.TokenHash,
+		&r.ExpiresAt,
+		&r.Used,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+	)
+
+	if err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// ResetPassword marks a password reset request as used and sets hash as the password of its user.
+// It returns repository.ErrPasswordResetUsed when the request was already used.
+func (m *sqliteDBRepo) ResetPassword(ctx context.Context, id int, hash string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// claim the request, so that it can't be used twice at the same time
+	result, err := tx.ExecContext(ctx, `UPDATE password_resets SET used = 1, updated_at = ?1 WHERE id = ?2 AND used = 0`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	used, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if used == 0 {
+		return repository.ErrPasswordResetUsed
+	}
+
+	result, err = tx.ExecContext(ctx, `
+		UPDATE users SET password = ?1, updated_at = ?2
+		WHERE id = (SELECT user_id FROM password_resets WHERE id = ?3)
+	`, hash, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return tx.Commit()
+}
+
+// InsertUser inserts a user without a password, who has to choose one before logging in
+func (m *sqliteDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, '', ?4, 1, ?5, ?6)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateUserDetails changes the name, email and access level of a user
+func (m *sqliteDBRepo) UpdateUserDetails(ctx context.Context, u models.User) error {
+	stmt := `
+		UPDATE users
+		SET first_name = ?1, last_name = ?2, email = ?3, access_level = ?4, updated_at = ?5
+		WHERE id = ?6
+	`
+
+	return m.updateUserKeepingAnOwner(ctx, stmt, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now().UTC(), u.ID)
+}
+
+// SetUserActive activates (1) or deactivates (0) a user
+func (m *sqliteDBRepo) SetUserActive(ctx context.Context, id, active int) error {
+	return m.updateUserKeepingAnOwner(ctx, `UPDATE users SET active = ?1, updated_at = ?2 WHERE id = ?3`, active, time.Now().UTC(), id)
+}
+
+// updateUserKeepingAnOwner runs stmt with args, and undoes it when no active owner is left
+func (m *sqliteDBRepo) updateUserKeepingAnOwner(ctx context.Context, stmt string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	var owners int
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(id) FROM users WHERE access_level = ?1 AND active = 1`, models.AccessOwner).Scan(&owners)
+	if err != nil {
+		return err
+	}
+
+	if owners == 0 {
+		return repository.ErrLastOwner
+	}
+
+	return tx.Commit()
+}
+
+// InsertAPIToken stores an API token by its hash, and returns its id
+func (m *sqliteDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, revoked, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, ?4, 0, ?5, ?6)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.Name,
+		t.TokenHash,
+		strings.Join(t.Scopes, ","),
+		time.Now().UTC(),
+		time.Now().UTC(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// AllAPITokensForUser returns the API tokens of a user, revoked ones included
+func (m *sqliteDBRepo) AllAPITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var apiTokens []models.APIToken
+
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_hash, t.scopes, t.last_used_at, t.revoked, t.created_at, t.updated_at,
+			u.id, u.first_name, u.last_name, u.email, u.access_level, u.active
+		FROM api_tokens t
+		JOIN users u ON (t.user_id = u.id)
+		WHERE t.user_id = ?1
+		ORDER BY t.revoked, t.created_at DESC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return apiTokens, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return apiTokens, err
+		}
+		apiTokens = append(apiTokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return apiTokens, err
+	}
+
+	return apiTokens, nil
+}
+
+// GetAPITokenByID returns an API token with its user
+func (m *sqliteDBRepo) GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error) {
+	return m.getAPIToken(ctx, "t.id = ?1", id)
+}
+
+// GetAPITokenByHash returns the API token stored under hash, with its user
+func (m *sqliteDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	return m.getAPIToken(ctx, "t.token_hash = ?1", hash)
+}
+
+// getAPIToken returns the API token matching where, with its user
+func (m *sqliteDBRepo) getAPIToken(ctx context.Context, where string, arg interface{}) (models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_hash, t.scopes, t.last_used_at, t.revoked, t.created_at, t.updated_at,
+			u.id, u.first_name, u.last_name, u.email, u.access_level, u.active
+		FROM api_tokens t
+		JOIN users u ON (t.user_id = u.id)
+		WHERE ` + where
+
+	return scanAPIToken(m.DB.QueryRowContext(ctx, query, arg))
+}
+
+// TouchAPIToken records that an API token has just been used
+func (m *sqliteDBRepo) TouchAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ?1 WHERE id = ?2`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RevokeAPIToken stops an API token from being used again
+func (m *sqliteDBRepo) RevokeAPIToken(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE api_tokens SET revoked = 1, updated_at = ?1 WHERE id = ?2`, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertAuditEntry records a change in the audit log
+func (m *sqliteDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO audit_log (user_id, api_token_id, action, details, created_at, updated_at)
+		VALUES
+		(?1, NULLIF(?2, 0), ?3, ?4, ?5, ?6)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, e.UserID, e.APITokenID, e.Action, e.Details, time.Now().UTC(), time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllAuditEntries returns the latest limit entries of the audit log, newest first
+func (m *sqliteDBRepo) AllAuditEntries(ctx context.Context, limit int) ([]models.AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var entries []models.AuditEntry
+
+	query := `
+		SELECT a.id, a.user_id, COALESCE(a.api_token_id, 0), a.action, a.details, a.created_at,
+			u.first_name, u.last_name, u.email, COALESCE(t.name, '')
+		FROM audit_log a
+		JOIN users u ON (a.user_id = u.id)
+		LEFT JOIN api_tokens t ON (a.api_token_id = t.id)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT ?1
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return entries, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.AuditEntry
+		err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.APITokenID,
+			&e.Action,
+			&e.Details,
+			&e.CreatedAt,
+			&e.User.FirstName,
+			&e.User.LastName,
+			&e.User.Email,
+			&e.APIToken.Name,
+		)
+		if err != nil {
+			return entries, err
+		}
+		e.User.ID = e.UserID
+		e.APIToken.ID = e.APITokenID
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// AllCalendarSources returns the external calendars of every room
+func (m *sqliteDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	return m.queryCalendarSources(ctx, `ORDER BY room_id, name`)
+}
+
+// AllCalendarSourcesForRoom returns the external calendars of a room
+func (m *sqliteDBRepo) AllCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error) {
+	return m.queryCalendarSources(ctx, `WHERE room_id = ?1 ORDER BY name`, roomID)
+}
+
+// queryCalendarSources returns the calendar sources selected by the where and order by clauses in clauses
+func (m *sqliteDBRepo) queryCalendarSources(ctx context.Context, clauses string, args ...interface{}) ([]models.CalendarSource, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var sources []models.CalendarSource
+
+	query := `
+		SELECT id, room_id, name, url, last_synced_at, last_error, created_at, updated_at
+		FROM calendar_sources
+	` + clauses
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return sources, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanCalendarSource(rows)
+		if err != nil {
+			return sources, err
+		}
+		sources = append(sources, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return sources, err
+	}
+
+	return sources, nil
+}
+
+// GetCalendarSourceByID returns a calendar source by id
+func (m *sqliteDBRepo) GetCalendarSourceByID(ctx context.Context, id int) (models.CalendarSource, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	query := `
+		SELECT id, room_id, name, url, last_synced_at, last_error, created_at, updated_at
+		FROM calendar_sources
+		WHERE id = ?1
+	`
+
+	return scanCalendarSource(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// InsertCalendarSource adds an external calendar to a room
+func (m *sqliteDBRepo) InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var newID int
+
+	stmt := `
+		INSERT INTO calendar_sources (room_id, name, url, last_error, created_at, updated_at)
+		VALUES
+		(?1, ?2, ?3, '', ?4, ?5)
+		RETURNING id
+	`
+	err := m.DB.QueryRowContext(ctx, stmt, s.RoomID, s.Name, s.URL, time.Now().UTC(), time.Now().UTC()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// DeleteCalendarSource deletes an external calendar, with the restrictions imported from it
+func (m *sqliteDBRepo) DeleteCalendarSource(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM calendar_sources WHERE id = ?1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateCalendarSourceStatus records when an external calendar was last synced, and why it failed if it did
+func (m *sqliteDBRepo) UpdateCalendarSourceStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `UPDATE calendar_sources SET last_synced_at = ?1, last_error = ?2, updated_at = ?3 WHERE id = ?4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, syncedAt.UTC(), lastError, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRestrictionsForSource returns the restrictions imported from an external calendar
+func (m *sqliteDBRepo) GetRestrictionsForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var restrictions []models.RoomRestriction
+
+	query := `
+		SELECT id, room_id, restriction_id, start_date, end_date, source_id, external_uid, created_at, updated_at
+		FROM room_restrictions
+		WHERE source_id = ?1
+		ORDER BY start_date
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, sourceID)
+	if err != nil {
+		return restrictions, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(
+			&r.ID,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.SourceID,
+			&r.ExternalUID,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return restrictions, err
+	}
+
+	return restrictions, nil
+}
+
+// InsertExternalRestriction inserts a restriction imported from an external calendar
+func (m *sqliteDBRepo) InsertExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		INSERT INTO room_restrictions
+			(start_date, end_date, room_id, restriction_id, source_id, external_uid, created_at, updated_at)
+		VALUES
+			(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		r.StartDate.UTC(),
+		r.EndDate.UTC(),
+		r.RoomID,
+		models.RestrictionExternal,
+		r.SourceID,
+		r.ExternalUID,
+		time.Now().UTC(),
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	return nil
+}
+
+// UpdateExternalRestriction moves a restriction imported from an external calendar to new dates
+func (m *sqliteDBRepo) UpdateExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE room_restrictions SET start_date = ?1, end_date = ?2, updated_at = ?3
+		WHERE id = ?4 AND restriction_id = ?5
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, r.StartDate.UTC(), r.EndDate.UTC(), time.Now().UTC(), r.ID, models.RestrictionExternal)
+	if err != nil {
+		return restrictionError(err)
+	}
+
+	return nil
+}
+
+// insertEmails puts mail in the outbox as part of tx, to be sent once tx commits
+func (m *sqliteDBRepo) insertEmails(ctx context.Context, tx *sql.Tx, mail []models.MailData) error {
+	stmt := `
+		INSERT INTO email_outbox
+			(to_address, from_address, subject, content, text_content, attachments, status, attempts,
+			next_attempt_at, created_at, updated_at)
+		VALUES
+			(?1, ?2, ?3, ?4, ?5, ?6, ?7, 0, ?8, ?9, ?10)
+	`
+
+	for _, msg := range mail {
+		attachments := ""
+		if len(msg.Attachments) > 0 {
+			data, err := json.Marshal(msg.Attachments)
+			if err != nil {
+				return err
+			}
+			attachments = string(data)
+		}
+
+		_, err := tx.ExecContext(ctx, stmt, msg.To, msg.From, msg.Subject, msg.Content, msg.Text, attachments,
+			models.EmailPending, time.Now().UTC(), time.Now().UTC(), time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// EnqueueEmail puts an email in the outbox
+func (m *sqliteDBRepo) EnqueueEmail(ctx context.Context, msg models.MailData) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = m.insertEmails(ctx, tx, []models.MailData{msg})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ClaimEmails takes up to limit pending emails that are due, oldest first, counting an attempt for each.
+// They are not due again until lease has passed, so that other workers leave them alone while they are
+// sent, and they are retried if the worker sending them dies.
+func (m *sqliteDBRepo) ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var emails []models.OutboxEmail
+
+	query := `
+		UPDATE email_outbox
+		SET attempts = attempts + 1, next_attempt_at = ?2, updated_at = ?3
+		WHERE id IN (
+			SELECT id
+			FROM email_outbox
+			WHERE status = ?4 AND next_attempt_at <= ?3
+			ORDER BY next_attempt_at
+			LIMIT ?1
+		)
+		RETURNING ` + outboxEmailColumns
+
+	now := time.Now().UTC()
+	rows, err := m.DB.QueryContext(ctx, query, limit, now.Add(lease), now, models.EmailPending)
+	if err != nil {
+		return emails, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+// MarkEmailSent records that an email of the outbox was sent
+func (m *sqliteDBRepo) MarkEmailSent(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET status = ?1, last_error = '', sent_at = ?2, updated_at = ?2
+		WHERE id = ?3
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.EmailSent, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RetryEmail records why sending an email of the outbox failed, and when to try again
+func (m *sqliteDBRepo) RetryEmail(ctx context.Context, id int, at time.Time, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET next_attempt_at = ?1, last_error = ?2, updated_at = ?3
+		WHERE id = ?4
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, at.UTC(), lastError, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// MarkEmailDead gives up on an email of the outbox, recording why its last attempt failed
+func (m *sqliteDBRepo) MarkEmailDead(ctx context.Context, id int, lastError string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET status = ?1, last_error = ?2, updated_at = ?3
+		WHERE id = ?4
+	`
+
+	_, err := m.DB.ExecContext(ctx, stmt, models.EmailDead, lastError, time.Now().UTC(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllOutboxEmails returns the latest limit emails of the outbox with status, or of any status when
+// status is empty, newest first
+func (m *sqliteDBRepo) AllOutboxEmails(ctx context.Context, status string, limit int) ([]models.OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var emails []models.OutboxEmail
+
+	query := `
+		SELECT ` + outboxEmailColumns + `
+		FROM email_outbox
+		WHERE ?1 = '' OR status = ?1
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?2
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, status, limit)
+	if err != nil {
+		return emails, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanOutboxEmail(rows)
+		if err != nil {
+			return emails, err
+		}
+		emails = append(emails, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return emails, err
+	}
+
+	return emails, nil
+}
+
+// ResendEmail puts an email of the outbox that was given up on back in the queue, with its attempts reset.
+// It returns sql.ErrNoRows when there is no such email.
+func (m *sqliteDBRepo) ResendEmail(ctx context.Context, id int) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	stmt := `
+		UPDATE email_outbox SET status = ?1, attempts = 0, next_attempt_at = ?2, updated_at = ?2
+		WHERE id = ?3 AND status = ?4
+	`
+
+	result, err := m.DB.ExecContext(ctx, stmt, models.EmailPending, time.Now().UTC(), id, models.EmailDead)
+	if err != nil {
+		return err
+	}
+
+	resent, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if resent == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReservationsArriving returns the reservations, not cancelled, starting from from to to, that the
+// scheduled email kind was not sent for
+func (m *sqliteDBRepo) ReservationsArriving(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsWithoutScheduledEmail(ctx, "r.start_date", from, to, kind)
+}
+
+// ReservationsDeparted returns the reservations, not cancelled, ending from from to to, that the
+// scheduled email kind was not sent for
+func (m *sqliteDBRepo) ReservationsDeparted(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	return m.reservationsWithoutScheduledEmail(ctx, "r.end_date", from, to, kind)
+}
+
+// reservationsWithoutScheduledEmail returns the reservations, not cancelled, whose date column is from
+// from to to, that the scheduled email kind was not sent for
+func (m *sqliteDBRepo) reservationsWithoutScheduledEmail(ctx context.Context, column string, from, to time.Time, kind string) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		SELECT
+			r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+			r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.total_price, r.cancelled,
+
+			rm.id, rm.room_name
+		FROM reservations r
+		LEFT JOIN rooms rm
+		ON r.room_id = rm.id
+		WHERE r.cancelled = 0 AND ` + column + ` BETWEEN ?1 AND ?2
+		AND NOT EXISTS (
+			SELECT 1 FROM scheduled_emails se WHERE se.reservation_id = r.id AND se.kind = ?3
+		)
+		ORDER BY r.id ASC
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query, from.UTC(), to.UTC(), kind)
+	if err != nil {
+		return reservations, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.TotalPrice,
+			&i.Cancelled,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, err
+		}
+
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// EnqueueScheduledEmail puts mail in the outbox and records that the scheduled email kind was sent for
+// the reservation, unless it already was. It reports whether mail was put in the outbox.
+func (m *sqliteDBRepo) EnqueueScheduledEmail(ctx context.Context, reservationID int, kind string, mail []models.MailData) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		INSERT INTO scheduled_emails (reservation_id, kind, created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4)
+		ON CONFLICT (reservation_id, kind) DO NOTHING
+	`
+
+	result, err := tx.ExecContext(ctx, stmt, reservationID, kind, time.Now().UTC(), time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if recorded == 0 {
+		return false, nil
+	}
+
+	err = m.insertEmails(ctx, tx, mail)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
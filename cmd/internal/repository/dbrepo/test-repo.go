@@ -1,19 +1,29 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"time"
 
 	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/pricing"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+	"github.com/maslow123/bookings/cmd/internal/tokens"
 )
 
-func (m *testDBRepo) AllUsers() bool {
+// AllUsers returns all staff users
+func (m *testDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	users := []models.User{
+		{ID: 1, FirstName: "Admin", LastName: "User", Email: "me@here.ca", AccessLevel: models.AccessOwner, Active: 1},
+		{ID: 2, FirstName: "Desk", LastName: "User", Email: "desk@here.ca", AccessLevel: models.AccessFrontDesk, Active: 1},
+	}
 
-	return true
+	return users, nil
 }
 
 // InsertReservation inserts a reservation into the database
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) InsertReservation(ctx context.Context, res models.Reservation) (int, error) {
 	// if the room id is 2, then fail; otherwise, pass
 	if res.RoomID == 2 {
 		return 0, errors.New("some error")
@@ -22,20 +32,48 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 }
 
 // InserRoomRestriction inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error { // if the room id is 2, then fail; otherwise, pass
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error { // if the room id is 2, then fail; otherwise, pass
 	if r.RoomID == 1000 {
 		return errors.New("some error")
 	}
 	return nil
 }
 
+// CreateBooking inserts a reservation and its room restriction in a single transaction.
+// Room 2 fails inserting the reservation, room 1000 fails inserting the restriction
+// and room 1001 is no longer available when re-checked. Promo code 3 runs out of
+// redemptions while booking.
+func (m *testDBRepo) CreateBooking(ctx context.Context, res models.Reservation, mail func(id int) ([]models.MailData, error)) (int, error) {
+	switch res.RoomID {
+	case 2:
+		return 0, errors.New("some error")
+	case 1000:
+		return 0, errors.New("some error")
+	case 1001:
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	if res.PromoCodeID == 3 {
+		return 0, repository.ErrPromoCodeExhausted
+	}
+
+	if mail != nil {
+		_, err := mail(1)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return 1, nil
+}
+
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability exists
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
 	return false, nil
 }
 
 // SearchAvailabilityForAllRooms return  a slice of available rooms, if any. For given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 
 	var rooms []models.Room
 
@@ -43,30 +81,41 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 }
 
 // GetRoomByID return room data
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 	var room models.Room
 
-	if id > 2 {
+	// ids from 1000 up are valid rooms used to simulate booking failures
+	if id > 2 && id < 1000 {
 		return room, errors.New("Some error")
 	}
 
+	room.ID = id
+
 	return room, nil
 }
 
 // GetUserByID return user data
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
-	var user models.User
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	if id == 1000 {
+		return models.User{}, errors.New("some error")
+	}
+
+	user := models.User{
+		ID:          id,
+		AccessLevel: models.AccessOwner,
+		Active:      1,
+	}
 
 	return user, nil
 }
 
 // UpdateUser updates a user in the database
-func (m *testDBRepo) UpdateUser(u models.User) error {
+func (m *testDBRepo) UpdateUser(ctx context.Context, u models.User) error {
 	return nil
 }
 
 // Authenticate autheticates a user
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
 	if email == "me@here.ca" {
 		return 1, "", nil
 	}
@@ -75,7 +124,7 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, erro
 }
 
 // AllReservations returns a slice of all reservations
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
 	var reservations []models.Reservation
 
 	return reservations, nil
@@ -83,56 +132,671 @@ func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
 }
 
 // AllNewReservations returns a slice of all reservations
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
+func (m *testDBRepo) AllNewReservations(ctx context.Context) ([]models.Reservation, error) {
 
 	var reservations []models.Reservation
 	return reservations, nil
 
 }
 
-// GetReservationByID returns one reservation by ID
-func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
-	var res models.Reservation
+// GetReservationByID returns one reservation by ID.
+// Reservation 1000 does not exist, 2 is cancelled and 3 has already started;
+// any other reservation is for room 1 in January 2100.
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	if id == 1000 {
+		return models.Reservation{}, errors.New("some error")
+	}
+
+	res := models.Reservation{
+		ID:         id,
+		FirstName:  "Omama",
+		LastName:   "Olala",
+		Email:      "omama@getnada.com",
+		StartDate:  time.Date(2100, 1, 4, 0, 0, 0, 0, time.UTC),
+		EndDate:    time.Date(2100, 1, 6, 0, 0, 0, 0, time.UTC),
+		RoomID:     1,
+		Room:       models.Room{ID: 1, RoomName: "General's Quarters"},
+		TotalPrice: 20000,
+	}
+
+	switch id {
+	case 2:
+		res.Cancelled = 1
+	case 3:
+		res.StartDate = time.Date(2000, 1, 4, 0, 0, 0, 0, time.UTC)
+		res.EndDate = time.Date(2000, 1, 6, 0, 0, 0, 0, time.UTC)
+	}
 
 	return res, nil
 }
 
 // UpdateReservation updates a reservation in the database
-func (m *testDBRepo) UpdateReservation(r models.Reservation) error {
+func (m *testDBRepo) UpdateReservation(ctx context.Context, r models.Reservation) error {
 	return nil
 }
 
 // DeleteReservation deletes one reservations by id
-func (m *testDBRepo) DeleteReservation(id int) error {
+func (m *testDBRepo) DeleteReservation(ctx context.Context, id int) error {
 	return nil
 }
 
 // UpdateProcessedForReservation updates processed for a reservation by id
-func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
+func (m *testDBRepo) UpdateProcessedForReservation(ctx context.Context, id, processed int) error {
 	return nil
 }
 
 // AllRooms get all rooms
-func (m *testDBRepo) AllRooms() ([]models.Room, error) {
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
 	var rooms []models.Room
 
 	return rooms, nil
 }
 
+// GetRoomBySlug returns a room by its slug
+func (m *testDBRepo) GetRoomBySlug(ctx context.Context, slug string) (models.Room, error) {
+	var room models.Room
+
+	if slug != "generals-quarters" {
+		return room, errors.New("some error")
+	}
+
+	room.ID = 1
+	room.RoomName = "General's Quarters"
+	room.Slug = slug
+
+	return room, nil
+}
+
+// InsertRoom inserts a room into the database
+func (m *testDBRepo) InsertRoom(ctx context.Context, r models.Room) (int, error) {
+	if r.Slug == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 1, nil
+}
+
+// UpdateRoom updates a room in the database
+func (m *testDBRepo) UpdateRoom(ctx context.Context, r models.Room) error {
+	return nil
+}
+
+// DeleteRoom deletes a room by id; room 2 still has reservations
+func (m *testDBRepo) DeleteRoom(ctx context.Context, id int) error {
+	if id == 2 {
+		return repository.ErrRoomHasReservations
+	}
+
+	return nil
+}
+
 // GetRestrictionsForRoomByDate returns restrictions for a room by date range
-func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// Room 1 has a reservation and an owner block, room 1000 fails.
+func (m *testDBRepo) GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
 
 	var restrictions []models.RoomRestriction
 
+	switch roomID {
+	case 1:
+		restrictions = append(restrictions,
+			models.RoomRestriction{
+				ID:            1,
+				StartDate:     start.AddDate(0, 0, 3),
+				EndDate:       start.AddDate(0, 0, 5),
+				RoomID:        1,
+				ReservationID: 7,
+				RestrictionID: models.RestrictionReservation,
+				Reservation:   models.Reservation{ID: 7, FirstName: "John", LastName: "Smith"},
+			},
+			models.RoomRestriction{
+				ID:            2,
+				StartDate:     start.AddDate(0, 0, 10),
+				EndDate:       start.AddDate(0, 0, 11),
+				RoomID:        1,
+				RestrictionID: models.RestrictionOwnerBlock,
+				Note:          "Painting the walls",
+			},
+		)
+	case 1000:
+		return restrictions, errors.New("some error")
+	}
+
 	return restrictions, nil
 }
 
-// InsertBlockForRoom inserts a room restriction
-func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
+// GetBlockByID returns an owner block; block 2 covers the nights of 1 to 7 June 2100 in room 1
+func (m *testDBRepo) GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error) {
+	if id != 2 {
+		return models.RoomRestriction{}, errors.New("some error")
+	}
+
+	block := models.RoomRestriction{
+		ID:            2,
+		RoomID:        1,
+		RestrictionID: models.RestrictionOwnerBlock,
+		StartDate:     time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2100, 6, 8, 0, 0, 0, 0, time.UTC),
+		Note:          "Painting the walls",
+		CreatedBy:     1,
+		Room:          models.Room{ID: 1, RoomName: "General's Quarters"},
+		Creator:       models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+	}
+
+	return block, nil
+}
+
+// InsertBlock blocks nights of a room; room 1001 is always taken
+func (m *testDBRepo) InsertBlock(ctx context.Context, r models.RoomRestriction) (int, error) {
+	if r.RoomID == 1001 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	return 3, nil
+}
+
+// UpdateBlock changes the dates and the note of an owner block; nights from 2101 on are taken
+func (m *testDBRepo) UpdateBlock(ctx context.Context, r models.RoomRestriction) error {
+	if r.EndDate.Year() > 2100 {
+		return repository.ErrRoomUnavailable
+	}
+
+	return nil
+}
+
+// UnblockNights frees nights of an owner block
+func (m *testDBRepo) UnblockNights(ctx context.Context, id int, start, end time.Time) error {
+	return nil
+}
+
+// DeleteBlock deletes an owner block
+func (m *testDBRepo) DeleteBlock(ctx context.Context, id int) error {
 	return nil
 }
 
-// DeleteBlockByID delete a room restriction
-func (m *testDBRepo) DeleteBlockByID(id int) error {
+// DeleteExternalRestriction deletes a restriction imported from an external calendar
+func (m *testDBRepo) DeleteExternalRestriction(ctx context.Context, id int) error {
 	return nil
 }
+
+// QuoteStay prices a stay in a room night by night, at 100.00 a night plus 20% at weekends
+func (m *testDBRepo) QuoteStay(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error) {
+	room := models.Room{
+		ID:            roomID,
+		BasePrice:     10000,
+		WeekendUplift: 20,
+	}
+
+	return pricing.Quote(room, nil, start, end)
+}
+
+// AllSeasonalRatesForRoom returns the seasonal rates of a room
+func (m *testDBRepo) AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error) {
+	var rates []models.SeasonalRate
+
+	return rates, nil
+}
+
+// InsertSeasonalRate inserts a seasonal rate for a room
+func (m *testDBRepo) InsertSeasonalRate(ctx context.Context, r models.SeasonalRate) (int, error) {
+	return 1, nil
+}
+
+// DeleteSeasonalRate deletes a seasonal rate by id
+func (m *testDBRepo) DeleteSeasonalRate(ctx context.Context, id int) error {
+	return nil
+}
+
+// testPromoCodes are the promo codes known to the test repository
+var testPromoCodes = []models.PromoCode{
+	{ID: 1, Code: "SAVE10", DiscountType: models.DiscountPercent, Amount: 10, ValidFrom: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), ValidUntil: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{ID: 2, Code: "EXPIRED", DiscountType: models.DiscountFixed, Amount: 1000, ValidFrom: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), ValidUntil: time.Date(2000, 12, 31, 0, 0, 0, 0, time.UTC)},
+	{ID: 3, Code: "LASTONE", DiscountType: models.DiscountFixed, Amount: 1000, ValidFrom: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), ValidUntil: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), MaxRedemptions: 1},
+}
+
+// AllPromoCodes returns all promo codes
+func (m *testDBRepo) AllPromoCodes(ctx context.Context) ([]models.PromoCode, error) {
+	return testPromoCodes, nil
+}
+
+// GetPromoCodeByID returns a promo code by id
+func (m *testDBRepo) GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error) {
+	for _, p := range testPromoCodes {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+
+	return models.PromoCode{}, errors.New("some error")
+}
+
+// GetPromoCodeByCode returns a promo code by its code
+func (m *testDBRepo) GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error) {
+	for _, p := range testPromoCodes {
+		if p.Code == code {
+			return p, nil
+		}
+	}
+
+	return models.PromoCode{}, errors.New("some error")
+}
+
+// InsertPromoCode inserts a promo code
+func (m *testDBRepo) InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error) {
+	if p.Code == "FAIL" {
+		return 0, errors.New("some error")
+	}
+
+	return 4, nil
+}
+
+// UpdatePromoCode updates a promo code
+func (m *testDBRepo) UpdatePromoCode(ctx context.Context, p models.PromoCode) error {
+	return nil
+}
+
+// DeletePromoCode deletes a promo code by id
+func (m *testDBRepo) DeletePromoCode(ctx context.Context, id int) error {
+	return nil
+}
+
+// ChangeReservationDates moves a reservation to new dates; reservation 4 can't be moved
+func (m *testDBRepo) ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData) error {
+	if res.ID == 4 {
+		return repository.ErrRoomUnavailable
+	}
+
+	return nil
+}
+
+// CancelReservation cancels a reservation; 4 was cancelled by another request in the meantime
+func (m *testDBRepo) CancelReservation(ctx context.Context, id int, mail []models.MailData) error {
+	if id == 4 {
+		return repository.ErrReservationCancelled
+	}
+	return nil
+}
+
+// GetUserByEmail returns a user by email; only me@here.ca exists
+func (m *testDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if email != "me@here.ca" {
+		return models.User{}, errors.New("some error")
+	}
+
+	return models.User{ID: 1, Email: email, AccessLevel: models.AccessOwner, Active: 1}, nil
+}
+
+// InsertPasswordReset stores a password reset request
+func (m *testDBRepo) InsertPasswordReset(ctx context.Context, r models.PasswordReset) error {
+	return nil
+}
+
+// GetPasswordResetByTokenHash returns the password reset request stored under hash.
+// The known tokens are "valid-token", "used-token", "expired-token" and "racing-token",
+// which another request uses first.
+func (m *testDBRepo) GetPasswordResetByTokenHash(ctx context.Context, hash string) (models.PasswordReset, error) {
+	r := models.PasswordReset{
+		UserID:    1,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	switch hash {
+	case tokens.Hash("valid-token"):
+		r.ID = 1
+	case tokens.Hash("used-token"):
+		r.ID = 2
+		r.Used = 1
+	case tokens.Hash("expired-token"):
+		r.ID = 3
+		r.ExpiresAt = time.Now().Add(-time.Hour)
+	case tokens.Hash("racing-token"):
+		r.ID = 4
+	default:
+		return r, errors.New("some error")
+	}
+
+	return r, nil
+}
+
+// ResetPassword sets the password of the user of a password reset request; 4 was already used
+func (m *testDBRepo) ResetPassword(ctx context.Context, id int, hash string) error {
+	if id == 4 {
+		return repository.ErrPasswordResetUsed
+	}
+
+	return nil
+}
+
+// InsertUser inserts a user; the address taken@here.ca is already in use
+func (m *testDBRepo) InsertUser(ctx context.Context, u models.User) (int, error) {
+	if u.Email == "taken@here.ca" {
+		return 0, errors.New("some error")
+	}
+
+	return 3, nil
+}
+
+// UpdateUserDetails changes the details of a user; user 1 is the last owner
+func (m *testDBRepo) UpdateUserDetails(ctx context.Context, u models.User) error {
+	if u.ID == 1 && u.AccessLevel != models.AccessOwner {
+		return repository.ErrLastOwner
+	}
+
+	return nil
+}
+
+// SetUserActive activates or deactivates a user; user 1 is the last owner
+func (m *testDBRepo) SetUserActive(ctx context.Context, id, active int) error {
+	if id == 1 && active == 0 {
+		return repository.ErrLastOwner
+	}
+
+	return nil
+}
+
+// testAPITokens are the API tokens known to the test repository, by the token they were made from
+var testAPITokens = map[string]models.APIToken{
+	"valid-api-token": {
+		ID:     1,
+		UserID: 1,
+		Name:   "Channel manager",
+		Scopes: []string{"view-reservations", "edit-reservations", "delete-reservations"},
+		User:   models.User{ID: 1, AccessLevel: models.AccessOwner, Active: 1},
+	},
+	"read-only-api-token": {
+		ID:     2,
+		UserID: 2,
+		Name:   "Reports",
+		Scopes: []string{"view-reservations"},
+		User:   models.User{ID: 2, AccessLevel: models.AccessFrontDesk, Active: 1},
+	},
+	"revoked-api-token": {
+		ID:      3,
+		UserID:  1,
+		Name:    "Old script",
+		Scopes:  []string{"view-reservations"},
+		Revoked: 1,
+		User:    models.User{ID: 1, AccessLevel: models.AccessOwner, Active: 1},
+	},
+	"deactivated-user-api-token": {
+		ID:     4,
+		UserID: 3,
+		Name:   "Former staff",
+		Scopes: []string{"view-reservations"},
+		User:   models.User{ID: 3, AccessLevel: models.AccessManager, Active: 0},
+	},
+}
+
+// InsertAPIToken stores an API token
+func (m *testDBRepo) InsertAPIToken(ctx context.Context, t models.APIToken) (int, error) {
+	if t.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 5, nil
+}
+
+// AllAPITokensForUser returns the API tokens of a user
+func (m *testDBRepo) AllAPITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error) {
+	var apiTokens []models.APIToken
+
+	for _, t := range testAPITokens {
+		if t.UserID == userID {
+			apiTokens = append(apiTokens, t)
+		}
+	}
+
+	return apiTokens, nil
+}
+
+// GetAPITokenByID returns an API token with its user. Token 1000 fails.
+func (m *testDBRepo) GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error) {
+	if id == 1000 {
+		return models.APIToken{}, errors.New("some error")
+	}
+
+	for _, t := range testAPITokens {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+
+	return models.APIToken{}, sql.ErrNoRows
+}
+
+// GetAPITokenByHash returns the API token stored under hash, with its user
+func (m *testDBRepo) GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error) {
+	for token, t := range testAPITokens {
+		if tokens.Hash(token) == hash {
+			return t, nil
+		}
+	}
+
+	return models.APIToken{}, errors.New("some error")
+}
+
+// TouchAPIToken records that an API token has just been used
+func (m *testDBRepo) TouchAPIToken(ctx context.Context, id int) error {
+	return nil
+}
+
+// RevokeAPIToken stops an API token from being used again
+func (m *testDBRepo) RevokeAPIToken(ctx context.Context, id int) error {
+	return nil
+}
+
+// InsertAuditEntry records a change in the audit log
+func (m *testDBRepo) InsertAuditEntry(ctx context.Context, e models.AuditEntry) error {
+	return nil
+}
+
+// AllAuditEntries returns the latest entries of the audit log
+func (m *testDBRepo) AllAuditEntries(ctx context.Context, limit int) ([]models.AuditEntry, error) {
+	entries := []models.AuditEntry{
+		{
+			ID:         1,
+			UserID:     1,
+			APITokenID: 1,
+			Action:     "delete-reservation",
+			Details:    "reservation 7",
+			CreatedAt:  time.Now(),
+			User:       models.User{ID: 1, FirstName: "Admin", LastName: "User"},
+			APIToken:   models.APIToken{ID: 1, Name: "Channel manager"},
+		},
+	}
+
+	return entries, nil
+}
+
+// testCalendarSources are the external calendars known to the test repository.
+// Their addresses never resolve, and restrictions can't be imported from source 2, as its room 1001 is always taken.
+var testCalendarSources = []models.CalendarSource{
+	{ID: 1, RoomID: 1, Name: "Airbnb", URL: "https://airbnb.invalid/calendar/ical/1.ics"},
+	{ID: 2, RoomID: 1001, Name: "Booking.com", URL: "https://booking.invalid/hotel/ical/2.ics"},
+	{ID: 3, RoomID: 1, Name: "Uploads"},
+}
+
+// AllCalendarSources returns the external calendars of every room
+func (m *testDBRepo) AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error) {
+	return testCalendarSources, nil
+}
+
+// AllCalendarSourcesForRoom returns the external calendars of a room
+func (m *testDBRepo) AllCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error) {
+	var sources []models.CalendarSource
+	for _, s := range testCalendarSources {
+		if s.RoomID == roomID {
+			sources = append(sources, s)
+		}
+	}
+
+	return sources, nil
+}
+
+// GetCalendarSourceByID returns a calendar source by id
+func (m *testDBRepo) GetCalendarSourceByID(ctx context.Context, id int) (models.CalendarSource, error) {
+	for _, s := range testCalendarSources {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+
+	return models.CalendarSource{}, errors.New("some error")
+}
+
+// InsertCalendarSource adds an external calendar to a room; it fails for sources named "fail"
+func (m *testDBRepo) InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error) {
+	if s.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 4, nil
+}
+
+// DeleteCalendarSource deletes an external calendar
+func (m *testDBRepo) DeleteCalendarSource(ctx context.Context, id int) error {
+	return nil
+}
+
+// UpdateCalendarSourceStatus records when an external calendar was last synced
+func (m *testDBRepo) UpdateCalendarSourceStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error {
+	return nil
+}
+
+// GetRestrictionsForSource returns the restrictions imported from an external calendar.
+// Source 1 has imported the events "kept@example.com" and "gone@example.com", on 1 and 10 June 2100.
+func (m *testDBRepo) GetRestrictionsForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+
+	if sourceID == 1 {
+		restrictions = append(restrictions,
+			models.RoomRestriction{
+				ID:            20,
+				RoomID:        1,
+				RestrictionID: models.RestrictionExternal,
+				StartDate:     time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:       time.Date(2100, 6, 3, 0, 0, 0, 0, time.UTC),
+				SourceID:      1,
+				ExternalUID:   "kept@example.com",
+			},
+			models.RoomRestriction{
+				ID:            21,
+				RoomID:        1,
+				RestrictionID: models.RestrictionExternal,
+				StartDate:     time.Date(2100, 6, 10, 0, 0, 0, 0, time.UTC),
+				EndDate:       time.Date(2100, 6, 12, 0, 0, 0, 0, time.UTC),
+				SourceID:      1,
+				ExternalUID:   "gone@example.com",
+			},
+		)
+	}
+
+	return restrictions, nil
+}
+
+// InsertExternalRestriction inserts a restriction imported from an external calendar
+func (m *testDBRepo) InsertExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	if r.RoomID == 1001 {
+		return repository.ErrRoomUnavailable
+	}
+
+	return nil
+}
+
+// UpdateExternalRestriction moves a restriction imported from an external calendar to new dates
+func (m *testDBRepo) UpdateExternalRestriction(ctx context.Context, r models.RoomRestriction) error {
+	if r.RoomID == 1001 {
+		return repository.ErrRoomUnavailable
+	}
+
+	return nil
+}
+
+// EnqueueEmail puts an email in the outbox
+func (m *testDBRepo) EnqueueEmail(ctx context.Context, msg models.MailData) error {
+	return nil
+}
+
+// ClaimEmails takes the pending emails that are due; there are none
+func (m *testDBRepo) ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error) {
+	return nil, nil
+}
+
+// MarkEmailSent records that an email of the outbox was sent
+func (m *testDBRepo) MarkEmailSent(ctx context.Context, id int) error {
+	return nil
+}
+
+// RetryEmail records why sending an email of the outbox failed, and when to try again
+func (m *testDBRepo) RetryEmail(ctx context.Context, id int, at time.Time, lastError string) error {
+	return nil
+}
+
+// MarkEmailDead gives up on an email of the outbox
+func (m *testDBRepo) MarkEmailDead(ctx context.Context, id int, lastError string) error {
+	return nil
+}
+
+// AllOutboxEmails returns the emails of the outbox with status, or of any status when status is empty.
+// Email 1 was given up on, email 2 was sent.
+func (m *testDBRepo) AllOutboxEmails(ctx context.Context, status string, limit int) ([]models.OutboxEmail, error) {
+	emails := []models.OutboxEmail{
+		{
+			ID:        1,
+			MailData:  models.MailData{To: "john@smith.com", From: "me@here.com", Subject: "Reservation Confirmation"},
+			Status:    models.EmailDead,
+			Attempts:  8,
+			LastError: "dial tcp 127.0.0.1:1025: connection refused",
+		},
+		{
+			ID:       2,
+			MailData: models.MailData{To: "jane@smith.com", From: "me@here.com", Subject: "Reservation Changed"},
+			Status:   models.EmailSent,
+			Attempts: 1,
+			SentAt:   time.Date(2100, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+	}
+
+	var matching []models.OutboxEmail
+	for _, e := range emails {
+		if status == "" || e.Status == status {
+			matching = append(matching, e)
+		}
+	}
+
+	return matching, nil
+}
+
+// ResendEmail puts an email that was given up on back in the queue; only email 1 was given up on
+func (m *testDBRepo) ResendEmail(ctx context.Context, id int) error {
+	if id != 1 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReservationsArriving returns reservation 1, arriving in the year 2100
+func (m *testDBRepo) ReservationsArriving(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	return []models.Reservation{
+		{
+			ID:        1,
+			FirstName: "John",
+			LastName:  "Smith",
+			Email:     "john@smith.com",
+			StartDate: time.Date(2100, 6, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2100, 6, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:    1,
+			Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+	}, nil
+}
+
+func (m *testDBRepo) ReservationsDeparted(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	return reservations, nil
+}
+
+func (m *testDBRepo) EnqueueScheduledEmail(ctx context.Context, reservationID int, kind string, mail []models.MailData) (bool, error) {
+	return true, nil
+}
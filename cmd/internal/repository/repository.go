@@ -1,30 +1,105 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"time"
 
 	"github.com/maslow123/bookings/cmd/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a room restriction would overlap an existing one for the same room
+var ErrRoomUnavailable = errors.New("room is not available for the requested dates")
+
+// ErrRoomHasReservations is returned when deleting a room that still has reservations
+var ErrRoomHasReservations = errors.New("room still has reservations")
+
+// ErrPromoCodeExhausted is returned when a promo code reached its maximum redemptions before it could be redeemed
+var ErrPromoCodeExhausted = errors.New("promo code has been fully redeemed")
+
+// ErrPasswordResetUsed is returned when a password reset token has already been used
+var ErrPasswordResetUsed = errors.New("password reset has already been used")
+
+// ErrReservationCancelled is returned when cancelling a reservation that was already cancelled
+var ErrReservationCancelled = errors.New("reservation is already cancelled")
+
+// ErrLastOwner is returned when a change would leave no active owner to manage the site
+var ErrLastOwner = errors.New("the last owner can't be demoted or deactivated")
+
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(r models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
+	AllUsers(ctx context.Context) ([]models.User, error)
+	InsertReservation(ctx context.Context, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, r models.RoomRestriction) error
+	CreateBooking(ctx context.Context, res models.Reservation, mail func(id int) ([]models.MailData, error)) (int, error)
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvailabilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	UpdateUser(ctx context.Context, u models.User) error
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
 	// Admin
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
-	GetReservationByID(id int) (models.Reservation, error)
-	UpdateReservation(r models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
-	AllRooms() ([]models.Room, error)
-	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoom(id int, startDate time.Time) error
-	DeleteBlockByID(id int) error
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	AllNewReservations(ctx context.Context) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, r models.Reservation) error
+	DeleteReservation(ctx context.Context, id int) error
+	UpdateProcessedForReservation(ctx context.Context, id, processed int) error
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	GetRoomBySlug(ctx context.Context, slug string) (models.Room, error)
+	InsertRoom(ctx context.Context, r models.Room) (int, error)
+	UpdateRoom(ctx context.Context, r models.Room) error
+	DeleteRoom(ctx context.Context, id int) error
+	QuoteStay(ctx context.Context, roomID int, start, end time.Time) (models.Quote, error)
+	AllSeasonalRatesForRoom(ctx context.Context, roomID int) ([]models.SeasonalRate, error)
+	InsertSeasonalRate(ctx context.Context, r models.SeasonalRate) (int, error)
+	DeleteSeasonalRate(ctx context.Context, id int) error
+	AllPromoCodes(ctx context.Context) ([]models.PromoCode, error)
+	GetPromoCodeByID(ctx context.Context, id int) (models.PromoCode, error)
+	GetPromoCodeByCode(ctx context.Context, code string) (models.PromoCode, error)
+	InsertPromoCode(ctx context.Context, p models.PromoCode) (int, error)
+	UpdatePromoCode(ctx context.Context, p models.PromoCode) error
+	DeletePromoCode(ctx context.Context, id int) error
+	ChangeReservationDates(ctx context.Context, res models.Reservation, mail []models.MailData) error
+	CancelReservation(ctx context.Context, id int, mail []models.MailData) error
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	InsertPasswordReset(ctx context.Context, r models.PasswordReset) error
+	GetPasswordResetByTokenHash(ctx context.Context, hash string) (models.PasswordReset, error)
+	ResetPassword(ctx context.Context, id int, hash string) error
+	InsertUser(ctx context.Context, u models.User) (int, error)
+	UpdateUserDetails(ctx context.Context, u models.User) error
+	SetUserActive(ctx context.Context, id, active int) error
+	AllCalendarSources(ctx context.Context) ([]models.CalendarSource, error)
+	AllCalendarSourcesForRoom(ctx context.Context, roomID int) ([]models.CalendarSource, error)
+	GetCalendarSourceByID(ctx context.Context, id int) (models.CalendarSource, error)
+	InsertCalendarSource(ctx context.Context, s models.CalendarSource) (int, error)
+	DeleteCalendarSource(ctx context.Context, id int) error
+	UpdateCalendarSourceStatus(ctx context.Context, id int, syncedAt time.Time, lastError string) error
+	GetRestrictionsForSource(ctx context.Context, sourceID int) ([]models.RoomRestriction, error)
+	InsertExternalRestriction(ctx context.Context, r models.RoomRestriction) error
+	UpdateExternalRestriction(ctx context.Context, r models.RoomRestriction) error
+	InsertAPIToken(ctx context.Context, t models.APIToken) (int, error)
+	AllAPITokensForUser(ctx context.Context, userID int) ([]models.APIToken, error)
+	GetAPITokenByID(ctx context.Context, id int) (models.APIToken, error)
+	GetAPITokenByHash(ctx context.Context, hash string) (models.APIToken, error)
+	TouchAPIToken(ctx context.Context, id int) error
+	RevokeAPIToken(ctx context.Context, id int) error
+	InsertAuditEntry(ctx context.Context, e models.AuditEntry) error
+	AllAuditEntries(ctx context.Context, limit int) ([]models.AuditEntry, error)
+	GetRestrictionsForRoomByDate(ctx context.Context, roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	GetBlockByID(ctx context.Context, id int) (models.RoomRestriction, error)
+	InsertBlock(ctx context.Context, r models.RoomRestriction) (int, error)
+	UpdateBlock(ctx context.Context, r models.RoomRestriction) error
+	UnblockNights(ctx context.Context, id int, start, end time.Time) error
+	DeleteBlock(ctx context.Context, id int) error
+	DeleteExternalRestriction(ctx context.Context, id int) error
+	EnqueueEmail(ctx context.Context, msg models.MailData) error
+	ClaimEmails(ctx context.Context, limit int, lease time.Duration) ([]models.OutboxEmail, error)
+	MarkEmailSent(ctx context.Context, id int) error
+	RetryEmail(ctx context.Context, id int, at time.Time, lastError string) error
+	MarkEmailDead(ctx context.Context, id int, lastError string) error
+	AllOutboxEmails(ctx context.Context, status string, limit int) ([]models.OutboxEmail, error)
+	ResendEmail(ctx context.Context, id int) error
+	ReservationsArriving(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error)
+	ReservationsDeparted(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error)
+	EnqueueScheduledEmail(ctx context.Context, reservationID int, kind string, mail []models.MailData) (bool, error)
 }
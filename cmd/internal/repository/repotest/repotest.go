@@ -0,0 +1,1073 @@
+// Package repotest is the conformance suite of repository.DatabaseRepo. Every implementation must
+// pass it, so that the site behaves the same whichever database it runs on.
+package repotest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Run runs the suite against the repositories returned by newRepo, which is called once per test.
+// The database may hold other data, so the tests only look at the rows they create.
+func Run(t *testing.T, newRepo func(t *testing.T) repository.DatabaseRepo) {
+	var tests = []struct {
+		name string
+		test func(t *testing.T, db repository.DatabaseRepo)
+	}{
+		{"Rooms", testRooms},
+		{"CreateBooking", testCreateBooking},
+		{"ConcurrentBookings", testConcurrentBookings},
+		{"Reservations", testReservations},
+		{"ChangeAndCancel", testChangeAndCancel},
+		{"Blocks", testBlocks},
+		{"Pricing", testPricing},
+		{"PromoCodes", testPromoCodes},
+		{"Users", testUsers},
+		{"PasswordResets", testPasswordResets},
+		{"APITokensAndAudit", testAPITokensAndAudit},
+		{"CalendarSources", testCalendarSources},
+		{"Outbox", testOutbox},
+		{"ScheduledEmails", testScheduledEmails},
+		{"CancelledContext", testCancelledContext},
+	}
+
+	for _, e := range tests {
+		test := e.test
+		t.Run(e.name, func(t *testing.T) {
+			test(t, newRepo(t))
+		})
+	}
+}
+
+// day returns a day of June 2100, far from any real reservation
+func day(d int) time.Time {
+	return time.Date(2100, 6, d, 0, 0, 0, 0, time.UTC)
+}
+
+// unique returns name with a suffix no other test run uses, for columns with a unique index
+func unique(name string) string {
+	return fmt.Sprintf("%s-%d", name, time.Now().UnixNano())
+}
+
+// newRoom inserts a room without any restriction, priced 100.00 a night
+func newRoom(t *testing.T, db repository.DatabaseRepo) int {
+	t.Helper()
+
+	id, err := db.InsertRoom(context.Background(), models.Room{
+		RoomName:  "Test Room",
+		Slug:      unique("test-room"),
+		Capacity:  2,
+		BasePrice: 10000,
+	})
+	if err != nil {
+		t.Fatalf("can't insert a room: %v", err)
+	}
+
+	return id
+}
+
+// newUser inserts an active user with access level and the password "password"
+func newUser(t *testing.T, db repository.DatabaseRepo, access int) models.User {
+	t.Helper()
+	ctx := context.Background()
+
+	u := models.User{FirstName: "Test", LastName: "User", Email: unique("user") + "@here.ca", AccessLevel: access}
+
+	id, err := db.InsertUser(ctx, u)
+	if err != nil {
+		t.Fatalf("can't insert a user: %v", err)
+	}
+	u.ID = id
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Password = string(hash)
+
+	if err := db.UpdateUser(ctx, u); err != nil {
+		t.Fatalf("can't set the password of a user: %v", err)
+	}
+
+	return u
+}
+
+// book books roomID from start up to end, failing the test when it can't
+func book(t *testing.T, db repository.DatabaseRepo, roomID int, start, end time.Time) int {
+	t.Helper()
+
+	id, err := db.CreateBooking(context.Background(), models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		StartDate:  start,
+		EndDate:    end,
+		RoomID:     roomID,
+		TotalPrice: 20000,
+	}, nil)
+	if err != nil {
+		t.Fatalf("can't book room %d: %v", roomID, err)
+	}
+
+	return id
+}
+
+func hasRoom(rooms []models.Room, id int) bool {
+	for _, r := range rooms {
+		if r.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func findReservation(reservations []models.Reservation, id int) (models.Reservation, bool) {
+	for _, r := range reservations {
+		if r.ID == id {
+			return r, true
+		}
+	}
+	return models.Reservation{}, false
+}
+
+func findEmail(emails []models.OutboxEmail, to string) (models.OutboxEmail, bool) {
+	for _, e := range emails {
+		if e.To == to {
+			return e, true
+		}
+	}
+	return models.OutboxEmail{}, false
+}
+
+func testRooms(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+
+	room := models.Room{
+		RoomName:      "Colonel's Cabin",
+		Slug:          unique("colonels-cabin"),
+		Description:   "A cabin",
+		Capacity:      4,
+		BasePrice:     12000,
+		WeekendUplift: 10,
+	}
+
+	id, err := db.InsertRoom(ctx, room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetRoomByID(ctx, id)
+	if err != nil || got.RoomName != room.RoomName || got.Slug != room.Slug || got.Description != room.Description ||
+		got.Capacity != 4 || got.BasePrice != 12000 || got.WeekendUplift != 10 || got.CreatedAt.IsZero() {
+		t.Errorf("expected the room as inserted, but got %v (%v)", got, err)
+	}
+
+	if got, err := db.GetRoomBySlug(ctx, room.Slug); err != nil || got.ID != id {
+		t.Errorf("expected room %d by its slug, but got %d (%v)", id, got.ID, err)
+	}
+
+	if _, err := db.InsertRoom(ctx, room); err == nil {
+		t.Error("expected the slug to be taken")
+	}
+
+	if _, err := db.GetRoomByID(ctx, 0); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no such room, but got %v", err)
+	}
+
+	room.ID = id
+	room.RoomName = "Colonel's Suite"
+	if err := db.UpdateRoom(ctx, room); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := db.GetRoomByID(ctx, id); got.RoomName != "Colonel's Suite" {
+		t.Errorf("expected the room to be renamed, but got %q", got.RoomName)
+	}
+
+	if rooms, _ := db.AllRooms(ctx); !hasRoom(rooms, id) {
+		t.Error("expected the room among all rooms")
+	}
+
+	// rooms with reservations stay
+	resID := book(t, db, id, day(1), day(3))
+
+	if err := db.DeleteRoom(ctx, id); !errors.Is(err, repository.ErrRoomHasReservations) {
+		t.Errorf("expected the room to keep its reservations, but got %v", err)
+	}
+
+	if err := db.DeleteReservation(ctx, resID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DeleteRoom(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetRoomByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the room to be deleted, but got %v", err)
+	}
+}
+
+func testCreateBooking(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+	other := newRoom(t, db)
+	guest := unique("guest") + "@smith.com"
+
+	// the nights of 10 to 12 June are booked
+	id, err := db.CreateBooking(ctx, models.Reservation{
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      guest,
+		Phone:      "555-555-5555",
+		StartDate:  day(10),
+		EndDate:    day(13),
+		RoomID:     room,
+		TotalPrice: 30000,
+	}, func(id int) ([]models.MailData, error) {
+		return []models.MailData{{To: guest, From: "me@here.com", Subject: fmt.Sprintf("Reservation %d", id), Content: "<p>Booked</p>"}}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		roomID   int
+		start    time.Time
+		end      time.Time
+		expected error
+	}{
+		{"same-nights", room, day(10), day(13), repository.ErrRoomUnavailable},
+		{"first-night", room, day(8), day(11), repository.ErrRoomUnavailable},
+		{"last-night", room, day(12), day(14), repository.ErrRoomUnavailable},
+		{"inside", room, day(11), day(12), repository.ErrRoomUnavailable},
+		{"around", room, day(9), day(14), repository.ErrRoomUnavailable},
+		{"leaving-on-arrival", room, day(8), day(10), nil},
+		{"arriving-on-departure", room, day(13), day(15), nil},
+		{"other-room", other, day(10), day(13), nil},
+		{"no-such-room", 0, day(20), day(21), sql.ErrNoRows},
+	}
+
+	for _, e := range tests {
+		_, err := db.CreateBooking(ctx, models.Reservation{Email: "jane@smith.com", RoomID: e.roomID, StartDate: e.start, EndDate: e.end}, nil)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("failed %s: expected %v, but got %v", e.name, e.expected, err)
+		}
+	}
+
+	res, err := db.GetReservationByID(ctx, id)
+	if err != nil || res.Email != guest || res.Phone != "555-555-5555" || res.TotalPrice != 30000 || res.Room.ID != room ||
+		res.Room.RoomName != "Test Room" || !res.StartDate.Equal(day(10)) || !res.EndDate.Equal(day(13)) {
+		t.Errorf("expected the reservation with its room, but got %v (%v)", res, err)
+	}
+
+	emails, _ := db.AllOutboxEmails(ctx, models.EmailPending, 1000)
+	if e, ok := findEmail(emails, guest); !ok || e.Subject != fmt.Sprintf("Reservation %d", id) {
+		t.Errorf("expected the confirmation of reservation %d in the outbox, but got %v", id, e)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(11), day(12), room); ok {
+		t.Error("expected the room to be unavailable for a booked night")
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(15), day(17), room); !ok {
+		t.Error("expected the room to be available for free nights")
+	}
+
+	if rooms, _ := db.SearchAvailabilityForAllRooms(ctx, day(11), day(12)); hasRoom(rooms, room) {
+		t.Error("expected the room not to be found for a booked night")
+	}
+
+	if rooms, _ := db.SearchAvailabilityForAllRooms(ctx, day(20), day(22)); !hasRoom(rooms, room) {
+		t.Error("expected the room to be found for free nights")
+	}
+
+	// nothing is booked when the emails can't be rendered
+	_, err = db.CreateBooking(ctx, models.Reservation{Email: "jane@smith.com", RoomID: room, StartDate: day(20), EndDate: day(22)}, func(id int) ([]models.MailData, error) {
+		return nil, errors.New("can't render")
+	})
+	if err == nil {
+		t.Error("expected the error of mail")
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(20), day(22), room); !ok {
+		t.Error("expected nothing to be booked when mail fails")
+	}
+}
+
+func testConcurrentBookings(t *testing.T, db repository.DatabaseRepo) {
+	room := newRoom(t, db)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.CreateBooking(context.Background(), models.Reservation{Email: "john@smith.com", RoomID: room, StartDate: day(1), EndDate: day(3)}, nil)
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	booked := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			booked++
+		case !errors.Is(err, repository.ErrRoomUnavailable):
+			t.Errorf("expected the room to be unavailable, but got %v", err)
+		}
+	}
+
+	if booked != 1 {
+		t.Errorf("expected exactly one of the bookings to succeed, but %d did", booked)
+	}
+}
+
+func testReservations(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+	id := book(t, db, room, day(1), day(3))
+
+	all, _ := db.AllReservations(ctx)
+	if res, ok := findReservation(all, id); !ok || res.Room.RoomName != "Test Room" {
+		t.Errorf("expected the reservation among all reservations, with its room, but got %v", res)
+	}
+
+	if news, _ := db.AllNewReservations(ctx); !hasReservation(news, id) {
+		t.Error("expected the reservation to be new")
+	}
+
+	if err := db.UpdateProcessedForReservation(ctx, id, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if news, _ := db.AllNewReservations(ctx); hasReservation(news, id) {
+		t.Error("expected the reservation not to be new once processed")
+	}
+
+	if err := db.UpdateReservation(ctx, models.Reservation{ID: id, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Phone: "555"}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := db.GetReservationByID(ctx, id)
+	if err != nil || res.Processed != 1 || res.FirstName != "Jane" || res.LastName != "Doe" || res.Email != "jane@doe.com" || res.Phone != "555" {
+		t.Errorf("expected the reservation to be processed and updated, but got %v (%v)", res, err)
+	}
+
+	if err := db.DeleteReservation(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetReservationByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the reservation to be deleted, but got %v", err)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(1), day(3), room); !ok {
+		t.Error("expected the nights of a deleted reservation to be free")
+	}
+}
+
+func hasReservation(reservations []models.Reservation, id int) bool {
+	_, ok := findReservation(reservations, id)
+	return ok
+}
+
+func testChangeAndCancel(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+	id := book(t, db, room, day(1), day(3))
+	book(t, db, room, day(5), day(7))
+	guest := unique("guest") + "@smith.com"
+
+	res := models.Reservation{ID: id, RoomID: room, StartDate: day(4), EndDate: day(6), TotalPrice: 20000}
+	if err := db.ChangeReservationDates(ctx, res, nil); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected the nights of the other reservation to be taken, but got %v", err)
+	}
+
+	// a reservation can move over its own nights
+	res = models.Reservation{ID: id, RoomID: room, StartDate: day(2), EndDate: day(5), TotalPrice: 30000, Discount: 1000}
+	if err := db.ChangeReservationDates(ctx, res, []models.MailData{{To: guest, Subject: "Changed"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ := db.GetReservationByID(ctx, id)
+	if !got.StartDate.Equal(day(2)) || !got.EndDate.Equal(day(5)) || got.TotalPrice != 30000 || got.Discount != 1000 {
+		t.Errorf("expected the reservation to be moved and repriced, but got %v", got)
+	}
+
+	restrictions, _ := db.GetRestrictionsForRoomByDate(ctx, room, day(1), day(10))
+	if len(restrictions) != 2 || restrictions[0].ReservationID != id || !restrictions[0].StartDate.Equal(day(2)) || !restrictions[0].EndDate.Equal(day(5)) {
+		t.Errorf("expected the restriction to move with the reservation, but got %v", restrictions)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(1), day(2), room); !ok {
+		t.Error("expected the nights left behind to be free")
+	}
+
+	emails, _ := db.AllOutboxEmails(ctx, models.EmailPending, 1000)
+	if _, ok := findEmail(emails, guest); !ok {
+		t.Error("expected the change to be told to the guest")
+	}
+
+	if err := db.CancelReservation(ctx, id, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := db.GetReservationByID(ctx, id); got.Cancelled != 1 {
+		t.Error("expected the reservation to be cancelled")
+	}
+
+	again := unique("again") + "@smith.com"
+	if err := db.CancelReservation(ctx, id, []models.MailData{{To: again, From: "me@here.com", Subject: "Cancelled"}}); !errors.Is(err, repository.ErrReservationCancelled) {
+		t.Errorf("expected cancelling twice to fail, but got %v", err)
+	}
+
+	if emails, _ := db.AllOutboxEmails(ctx, models.EmailPending, 1000); hasEmail(emails, again) {
+		t.Error("expected no email when the reservation was already cancelled")
+	}
+
+	if err := db.CancelReservation(ctx, -1, nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected cancelling a missing reservation to fail, but got %v", err)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(2), day(5), room); !ok {
+		t.Error("expected the nights of a cancelled reservation to be free")
+	}
+
+	if err := db.ChangeReservationDates(ctx, res, nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a cancelled reservation not to move, but got %v", err)
+	}
+}
+
+func testBlocks(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+	owner := newUser(t, db, models.AccessOwner)
+	book(t, db, room, day(20), day(22))
+
+	id, err := db.InsertBlock(ctx, models.RoomRestriction{RoomID: room, StartDate: day(1), EndDate: day(10), Note: "Painting", CreatedBy: owner.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := db.GetBlockByID(ctx, id)
+	if err != nil || block.Note != "Painting" || block.RestrictionID != models.RestrictionOwnerBlock || block.Room.RoomName != "Test Room" ||
+		block.Creator.ID != owner.ID || block.Creator.FirstName != "Test" {
+		t.Errorf("expected the block with its room and creator, but got %v (%v)", block, err)
+	}
+
+	var tests = []struct {
+		name  string
+		start time.Time
+		end   time.Time
+	}{
+		{"over-the-block", day(9), day(12)},
+		{"over-a-reservation", day(21), day(25)},
+	}
+
+	for _, e := range tests {
+		_, err := db.InsertBlock(ctx, models.RoomRestriction{RoomID: room, StartDate: e.start, EndDate: e.end})
+		if !errors.Is(err, repository.ErrRoomUnavailable) {
+			t.Errorf("failed %s: expected the block to be refused, but got %v", e.name, err)
+		}
+	}
+
+	err = db.UpdateBlock(ctx, models.RoomRestriction{ID: id, RoomID: room, StartDate: day(1), EndDate: day(21), Note: "Painting"})
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected the block not to grow over a reservation, but got %v", err)
+	}
+
+	if err := db.UpdateBlock(ctx, models.RoomRestriction{ID: id, RoomID: room, StartDate: day(1), EndDate: day(10), Note: "Painting the walls"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.UnblockNights(ctx, id, day(4), day(6)); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks, _ := db.GetRestrictionsForRoomByDate(ctx, room, day(1), day(10))
+	if len(blocks) != 2 || !blocks[0].EndDate.Equal(day(4)) || !blocks[1].StartDate.Equal(day(6)) || blocks[1].Note != "Painting the walls" {
+		t.Fatalf("expected the block to be split around the freed nights, but got %v", blocks)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(4), day(6), room); !ok {
+		t.Error("expected the freed nights to be available")
+	}
+
+	if got, _ := db.GetBlockByID(ctx, blocks[1].ID); got.Creator.ID != owner.ID {
+		t.Errorf("expected the new block to keep the creator, but got %d", got.Creator.ID)
+	}
+
+	for _, b := range blocks {
+		if err := db.DeleteBlock(ctx, b.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.GetBlockByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the block to be deleted, but got %v", err)
+	}
+}
+
+func testPricing(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+
+	id, err := db.InsertSeasonalRate(ctx, models.SeasonalRate{RoomID: room, Name: "Summer", StartDate: day(1), EndDate: day(10), NightlyPrice: 20000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rates, _ := db.AllSeasonalRatesForRoom(ctx, room)
+	if len(rates) != 1 || rates[0].ID != id || rates[0].Name != "Summer" || !rates[0].StartDate.Equal(day(1)) || rates[0].NightlyPrice != 20000 {
+		t.Errorf("expected the seasonal rate of the room, but got %v", rates)
+	}
+
+	// the nights of 1 and 2 June 2100 are a Tuesday and a Wednesday
+	quote, err := db.QuoteStay(ctx, room, day(1), day(3))
+	if err != nil || quote.Total != 40000 || len(quote.Nights) != 2 || quote.Nights[0].Season != "Summer" {
+		t.Errorf("expected two nights at the seasonal rate, but got %v (%v)", quote, err)
+	}
+
+	if err := db.DeleteSeasonalRate(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if quote, _ := db.QuoteStay(ctx, room, day(1), day(3)); quote.Total != 20000 {
+		t.Errorf("expected two nights at the base price, but got %d", quote.Total)
+	}
+
+	if _, err := db.QuoteStay(ctx, 0, day(1), day(3)); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no such room, but got %v", err)
+	}
+}
+
+func testPromoCodes(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+
+	p := models.PromoCode{
+		Code:           unique("SUMMER"),
+		Description:    "Summer sale",
+		DiscountType:   models.DiscountPercent,
+		Amount:         10,
+		ValidFrom:      day(1),
+		ValidUntil:     day(30),
+		RoomID:         room,
+		MaxRedemptions: 1,
+	}
+
+	id, err := db.InsertPromoCode(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := db.GetPromoCodeByCode(ctx, p.Code)
+	if err != nil || got.ID != id || got.Amount != 10 || got.RoomID != room || !got.ValidUntil.Equal(day(30)) || got.MaxRedemptions != 1 {
+		t.Errorf("expected the promo code by its code, but got %v (%v)", got, err)
+	}
+
+	if _, err := db.InsertPromoCode(ctx, p); err == nil {
+		t.Error("expected the code to be taken")
+	}
+
+	if codes, _ := db.AllPromoCodes(ctx); len(codes) == 0 {
+		t.Error("expected the promo code among all codes")
+	}
+
+	if _, err := db.CreateBooking(ctx, models.Reservation{Email: "john@smith.com", RoomID: room, StartDate: day(1), EndDate: day(3), PromoCodeID: id, Discount: 2000}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.CreateBooking(ctx, models.Reservation{Email: "jane@smith.com", RoomID: room, StartDate: day(5), EndDate: day(7), PromoCodeID: id}, nil)
+	if !errors.Is(err, repository.ErrPromoCodeExhausted) {
+		t.Errorf("expected the code to be exhausted, but got %v", err)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(5), day(7), room); !ok {
+		t.Error("expected nothing to be booked with an exhausted code")
+	}
+
+	// editing a code keeps its redemptions, and codes can apply to every room
+	p.ID = id
+	p.RoomID = 0
+	p.MaxRedemptions = 2
+	if err := db.UpdatePromoCode(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ = db.GetPromoCodeByID(ctx, id)
+	if got.Redemptions != 1 || got.RoomID != 0 || got.MaxRedemptions != 2 {
+		t.Errorf("expected the code to be updated with its redemption, but got %v", got)
+	}
+
+	if err := db.DeletePromoCode(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.GetPromoCodeByID(ctx, id); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the code to be deleted, but got %v", err)
+	}
+}
+
+func testUsers(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	owner := newUser(t, db, models.AccessOwner)
+	desk := newUser(t, db, models.AccessFrontDesk)
+
+	if _, err := db.InsertUser(ctx, models.User{Email: owner.Email}); err == nil {
+		t.Error("expected the address to be taken")
+	}
+
+	got, err := db.GetUserByEmail(ctx, owner.Email)
+	if err != nil || got.ID != owner.ID || got.AccessLevel != models.AccessOwner || got.Active != 1 || got.FirstName != "Test" {
+		t.Errorf("expected the owner by their address, but got %v (%v)", got, err)
+	}
+
+	if got, err := db.GetUserByID(ctx, desk.ID); err != nil || got.Email != desk.Email {
+		t.Errorf("expected the front desk user by id, but got %v (%v)", got, err)
+	}
+
+	var tests = []struct {
+		name       string
+		email      string
+		password   string
+		expectedID int // 0 when the user can't log in
+	}{
+		{"valid", owner.Email, "password", owner.ID},
+		{"wrong-password", owner.Email, "secret", 0},
+		{"unknown", unique("nobody") + "@here.ca", "password", 0},
+	}
+
+	for _, e := range tests {
+		id, _, err := db.Authenticate(ctx, e.email, e.password)
+		if e.expectedID == 0 && err == nil {
+			t.Errorf("failed %s: expected an error, but user %d logged in", e.name, id)
+		}
+		if e.expectedID != 0 && (id != e.expectedID || err != nil) {
+			t.Errorf("failed %s: expected user %d, but got %d (%v)", e.name, e.expectedID, id, err)
+		}
+	}
+
+	// UpdateUser leaves the access level to UpdateUserDetails
+	changed := desk
+	changed.AccessLevel = models.AccessOwner
+	if err := db.UpdateUser(ctx, changed); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := db.GetUserByID(ctx, desk.ID); got.AccessLevel != models.AccessFrontDesk {
+		t.Errorf("expected UpdateUser to keep the access level, but got %d", got.AccessLevel)
+	}
+
+	changed = desk
+	changed.FirstName = "Desk"
+	changed.Email = owner.Email
+	changed.AccessLevel = models.AccessManager
+	if err := db.UpdateUserDetails(ctx, changed); err == nil {
+		t.Error("expected the address to be taken")
+	}
+
+	if got, _ := db.GetUserByID(ctx, desk.ID); got.AccessLevel != models.AccessFrontDesk || got.FirstName != "Test" {
+		t.Errorf("expected a failed change to change nothing, but got %v", got)
+	}
+
+	changed.Email = desk.Email
+	if err := db.UpdateUserDetails(ctx, changed); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetUserActive(ctx, desk.ID, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _ = db.GetUserByID(ctx, desk.ID)
+	if got.AccessLevel != models.AccessManager || got.FirstName != "Desk" || got.Active != 0 {
+		t.Errorf("expected an inactive manager named Desk, but got %v", got)
+	}
+
+	if _, _, err := db.Authenticate(ctx, desk.Email, "password"); err == nil {
+		t.Error("expected inactive users not to log in")
+	}
+
+	users, _ := db.AllUsers(ctx)
+	owners := 0
+	for _, u := range users {
+		if u.AccessLevel == models.AccessOwner && u.Active == 1 {
+			owners++
+		}
+	}
+
+	// other owners may be in the database, the last one can only be checked when they aren't
+	if owners == 1 {
+		if err := db.SetUserActive(ctx, owner.ID, 0); !errors.Is(err, repository.ErrLastOwner) {
+			t.Errorf("expected the last owner to stay active, but got %v", err)
+		}
+
+		demoted := owner
+		demoted.FirstName = "Demoted"
+		demoted.AccessLevel = models.AccessManager
+		if err := db.UpdateUserDetails(ctx, demoted); !errors.Is(err, repository.ErrLastOwner) {
+			t.Errorf("expected the last owner to stay an owner, but got %v", err)
+		}
+
+		if got, _ := db.GetUserByID(ctx, owner.ID); got.AccessLevel != models.AccessOwner || got.Active != 1 || got.FirstName != "Test" {
+			t.Errorf("expected the refused changes to be undone, but got %v", got)
+		}
+	}
+
+	// with a second owner, either can step down
+	newUser(t, db, models.AccessOwner)
+	owner.AccessLevel = models.AccessManager
+	if err := db.UpdateUserDetails(ctx, owner); err != nil {
+		t.Errorf("expected an owner to step down when another is left, but got %v", err)
+	}
+}
+
+func testPasswordResets(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	u := newUser(t, db, models.AccessFrontDesk)
+	hash := unique("hash")
+	expires := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+
+	if err := db.InsertPasswordReset(ctx, models.PasswordReset{UserID: u.ID, TokenHash: hash, ExpiresAt: expires}); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := db.GetPasswordResetByTokenHash(ctx, hash)
+	if err != nil || r.UserID != u.ID || !r.ExpiresAt.Equal(expires) || r.Used != 0 {
+		t.Errorf("expected the unused reset expiring at %s, but got %v (%v)", expires, r, err)
+	}
+
+	if _, err := db.GetPasswordResetByTokenHash(ctx, unique("other")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected no such reset, but got %v", err)
+	}
+
+	password, err := bcrypt.GenerateFromPassword([]byte("new password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.ResetPassword(ctx, r.ID, string(password)); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := db.GetPasswordResetByTokenHash(ctx, hash); got.Used != 1 {
+		t.Error("expected the reset to be used")
+	}
+
+	if id, _, err := db.Authenticate(ctx, u.Email, "new password"); err != nil || id != u.ID {
+		t.Errorf("expected the new password to log in, but got %d (%v)", id, err)
+	}
+
+	if err := db.ResetPassword(ctx, r.ID, "other-hash"); !errors.Is(err, repository.ErrPasswordResetUsed) {
+		t.Errorf("expected the reset to be used only once, but got %v", err)
+	}
+}
+
+func testAPITokensAndAudit(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	u := newUser(t, db, models.AccessManager)
+	hash := unique("hash")
+
+	id, err := db.InsertAPIToken(ctx, models.APIToken{UserID: u.ID, Name: "Channel manager", TokenHash: hash, Scopes: []string{"read", "write"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.InsertAPIToken(ctx, models.APIToken{UserID: u.ID, Name: "Copy", TokenHash: hash}); err == nil {
+		t.Error("expected the hash to be taken")
+	}
+
+	token, err := db.GetAPITokenByHash(ctx, hash)
+	if err != nil || token.ID != id || token.Name != "Channel manager" || len(token.Scopes) != 2 || token.Scopes[1] != "write" ||
+		!token.LastUsedAt.IsZero() || token.User.Email != u.Email || token.User.AccessLevel != models.AccessManager {
+		t.Errorf("expected the unused token with its user, but got %v (%v)", token, err)
+	}
+
+	if err := db.TouchAPIToken(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if token, _ := db.GetAPITokenByID(ctx, id); token.LastUsedAt.IsZero() {
+		t.Error("expected the token to be used")
+	}
+
+	otherID, err := db.InsertAPIToken(ctx, models.APIToken{UserID: u.ID, Name: "Reports", TokenHash: unique("hash")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.RevokeAPIToken(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	// revoked tokens come last
+	tokens, _ := db.AllAPITokensForUser(ctx, u.ID)
+	if len(tokens) != 2 || tokens[0].ID != otherID || tokens[1].ID != id || tokens[1].Revoked != 1 || tokens[0].Scopes != nil {
+		t.Errorf("expected the token without scopes then the revoked one, but got %v", tokens)
+	}
+
+	action := unique("update-room")
+	if err := db.InsertAuditEntry(ctx, models.AuditEntry{UserID: u.ID, APITokenID: otherID, Action: action, Details: "room 1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, _ := db.AllAuditEntries(ctx, 1000)
+	found := false
+	for _, e := range entries {
+		if e.Action == action {
+			found = true
+			if e.Details != "room 1" || e.User.Email != u.Email || e.APIToken.ID != otherID || e.APIToken.Name != "Reports" {
+				t.Errorf("expected the entry with its user and token, but got %v", e)
+			}
+		}
+	}
+
+	if !found {
+		t.Error("expected the entry in the audit log")
+	}
+}
+
+func testCalendarSources(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+	book(t, db, room, day(20), day(22))
+
+	id, err := db.InsertCalendarSource(ctx, models.CalendarSource{RoomID: room, Name: "Airbnb", URL: "https://example.com/cal.ics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := db.GetCalendarSourceByID(ctx, id)
+	if err != nil || s.Name != "Airbnb" || s.URL != "https://example.com/cal.ics" || !s.LastSyncedAt.IsZero() {
+		t.Errorf("expected the never synced source, but got %v (%v)", s, err)
+	}
+
+	if sources, _ := db.AllCalendarSourcesForRoom(ctx, room); len(sources) != 1 || sources[0].ID != id {
+		t.Errorf("expected the source of the room, but got %v", sources)
+	}
+
+	r := models.RoomRestriction{RoomID: room, SourceID: id, ExternalUID: "event-1", StartDate: day(1), EndDate: day(3)}
+	if err := db.InsertExternalRestriction(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name string
+		r    models.RoomRestriction
+	}{
+		{"same-event", models.RoomRestriction{RoomID: room, SourceID: id, ExternalUID: "event-1", StartDate: day(10), EndDate: day(12)}},
+		{"over-a-reservation", models.RoomRestriction{RoomID: room, SourceID: id, ExternalUID: "event-2", StartDate: day(21), EndDate: day(23)}},
+	}
+
+	for _, e := range tests {
+		if err := db.InsertExternalRestriction(ctx, e.r); err == nil {
+			t.Errorf("failed %s: expected the restriction to be refused", e.name)
+		}
+	}
+
+	restrictions, _ := db.GetRestrictionsForSource(ctx, id)
+	if len(restrictions) != 1 || restrictions[0].ExternalUID != "event-1" || restrictions[0].RestrictionID != models.RestrictionExternal {
+		t.Fatalf("expected the imported restriction, but got %v", restrictions)
+	}
+
+	r = restrictions[0]
+	r.StartDate, r.EndDate = day(2), day(5)
+	if err := db.UpdateExternalRestriction(ctx, r); err != nil {
+		t.Fatal(err)
+	}
+
+	r.StartDate, r.EndDate = day(19), day(21)
+	if err := db.UpdateExternalRestriction(ctx, r); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected the restriction not to move over a reservation, but got %v", err)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(4), day(5), room); ok {
+		t.Error("expected the imported nights to be unavailable")
+	}
+
+	syncedAt := time.Now().UTC().Truncate(time.Second)
+	if err := db.UpdateCalendarSourceStatus(ctx, id, syncedAt, "timeout"); err != nil {
+		t.Fatal(err)
+	}
+
+	if s, _ := db.GetCalendarSourceByID(ctx, id); !s.LastSyncedAt.Equal(syncedAt) || s.LastError != "timeout" {
+		t.Errorf("expected the status of the last sync, but got %v", s)
+	}
+
+	// deleting a source frees the nights imported from it
+	if err := db.DeleteCalendarSource(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, _ := db.SearchAvailabilityByDatesByRoomID(ctx, day(2), day(5), room); !ok {
+		t.Error("expected the imported nights to be freed with their source")
+	}
+}
+
+func testOutbox(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	to := unique("guest") + "@smith.com"
+
+	msg := models.MailData{
+		To:          to,
+		From:        "me@here.com",
+		Subject:     "Your stay",
+		Content:     "<p>Hello</p>",
+		Text:        "Hello",
+		Attachments: []models.Attachment{{Name: "stay.ics", Content: []byte("BEGIN:VCALENDAR")}},
+	}
+
+	if err := db.EnqueueEmail(ctx, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	emails, _ := db.AllOutboxEmails(ctx, "", 1000)
+	e, ok := findEmail(emails, to)
+	if !ok || e.Status != models.EmailPending || e.Text != "Hello" || len(e.Attachments) != 1 || !bytes.Equal(e.Attachments[0].Content, msg.Attachments[0].Content) {
+		t.Fatalf("expected the pending email with its attachment, but got %v", e)
+	}
+
+	claimed, err := db.ClaimEmails(ctx, 1000, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e, ok := findEmail(claimed, to); !ok || e.Attempts != 1 || e.Attachments[0].Name != "stay.ics" {
+		t.Fatalf("expected the email to be claimed, but got %v", e)
+	}
+
+	// claimed emails are left alone until their lease ends, or they are retried
+	if claimed, _ := db.ClaimEmails(ctx, 1000, time.Minute); hasEmail(claimed, to) {
+		t.Error("expected the email not to be claimed twice")
+	}
+
+	if err := db.RetryEmail(ctx, e.ID, time.Now().Add(-time.Second), "timeout"); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, _ = db.ClaimEmails(ctx, 1000, time.Minute)
+	if e, ok := findEmail(claimed, to); !ok || e.Attempts != 2 || e.LastError != "timeout" {
+		t.Fatalf("expected the email to be claimed again, but got %v", e)
+	}
+
+	if err := db.MarkEmailDead(ctx, e.ID, "rejected"); err != nil {
+		t.Fatal(err)
+	}
+
+	dead, _ := db.AllOutboxEmails(ctx, models.EmailDead, 1000)
+	if e, ok := findEmail(dead, to); !ok || e.LastError != "rejected" {
+		t.Errorf("expected the email to be given up on, but got %v", e)
+	}
+
+	if err := db.ResendEmail(ctx, e.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.ResendEmail(ctx, e.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected only dead emails to be resent, but got %v", err)
+	}
+
+	claimed, _ = db.ClaimEmails(ctx, 1000, time.Minute)
+	if e, ok := findEmail(claimed, to); !ok || e.Attempts != 1 {
+		t.Fatalf("expected the resent email to be claimed with its attempts reset, but got %v", e)
+	}
+
+	if err := db.MarkEmailSent(ctx, e.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	sent, _ := db.AllOutboxEmails(ctx, models.EmailSent, 1000)
+	if e, ok := findEmail(sent, to); !ok || e.SentAt.IsZero() || e.LastError != "" {
+		t.Errorf("expected the email to be sent, but got %v", e)
+	}
+}
+
+func hasEmail(emails []models.OutboxEmail, to string) bool {
+	_, ok := findEmail(emails, to)
+	return ok
+}
+
+func testScheduledEmails(t *testing.T, db repository.DatabaseRepo) {
+	ctx := context.Background()
+	room := newRoom(t, db)
+	id := book(t, db, room, day(10), day(12))
+	cancelled := book(t, db, room, day(12), day(14))
+	to := unique("guest") + "@smith.com"
+
+	if err := db.CancelReservation(ctx, cancelled, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	arriving, _ := db.ReservationsArriving(ctx, day(10), day(12), models.ScheduledPreArrival)
+	if _, ok := findReservation(arriving, id); !ok || hasReservation(arriving, cancelled) {
+		t.Errorf("expected only the reservation that is not cancelled to be arriving, but got %v", arriving)
+	}
+
+	if arriving, _ := db.ReservationsArriving(ctx, day(11), day(12), models.ScheduledPreArrival); hasReservation(arriving, id) {
+		t.Error("expected the reservation not to arrive after its start")
+	}
+
+	if departed, _ := db.ReservationsDeparted(ctx, day(12), day(12), models.ScheduledPostStay); !hasReservation(departed, id) {
+		t.Error("expected the reservation to depart on its end")
+	}
+
+	sent, err := db.EnqueueScheduledEmail(ctx, id, models.ScheduledPreArrival, []models.MailData{{To: to, Subject: "See you soon"}})
+	if err != nil || !sent {
+		t.Fatalf("expected the reminder to be put in the outbox, but got %v (%v)", sent, err)
+	}
+
+	sent, err = db.EnqueueScheduledEmail(ctx, id, models.ScheduledPreArrival, []models.MailData{{To: to, Subject: "See you soon"}})
+	if err != nil || sent {
+		t.Errorf("expected the reminder to be sent only once, but got %v (%v)", sent, err)
+	}
+
+	emails, _ := db.AllOutboxEmails(ctx, models.EmailPending, 1000)
+	count := 0
+	for _, e := range emails {
+		if e.To == to {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("expected one reminder in the outbox, but got %d", count)
+	}
+
+	if arriving, _ := db.ReservationsArriving(ctx, day(10), day(12), models.ScheduledPreArrival); hasReservation(arriving, id) {
+		t.Error("expected the reminded reservation not to be returned again")
+	}
+
+	if departed, _ := db.ReservationsDeparted(ctx, day(12), day(12), models.ScheduledPostStay); !hasReservation(departed, id) {
+		t.Error("expected the other kind of email to be still due")
+	}
+}
+
+func testCancelledContext(t *testing.T, db repository.DatabaseRepo) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.AllRooms(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the query to stop with its context, but got %v", err)
+	}
+
+	if _, err := db.CreateBooking(ctx, models.Reservation{RoomID: newRoom(t, db), StartDate: day(1), EndDate: day(3)}, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the booking to stop with its context, but got %v", err)
+	}
+}
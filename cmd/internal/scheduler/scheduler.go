@@ -0,0 +1,128 @@
+// Package scheduler emails guests on a schedule around their stay: a reminder before they arrive and
+// thanks after they leave. Each email is put in the outbox at most once per reservation.
+package scheduler
+
+import (
+	"context"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// catchUp is how many days late thanks are still sent, when the scheduler was not running on the day
+// they were due. Reminders are sent late as long as the guest has not arrived.
+const catchUp = 7
+
+// Scheduler puts the scheduled emails in the outbox once they are due
+type Scheduler struct {
+	App       *config.AppConfig
+	DB        repository.DatabaseRepo
+	Now       func() time.Time                    // the clock, which tests replace
+	ManageURL func(res models.Reservation) string // link where the guest can manage res
+}
+
+// New creates a Scheduler
+func New(db repository.DatabaseRepo, a *config.AppConfig, manageURL func(res models.Reservation) string) *Scheduler {
+	return &Scheduler{
+		App:       a,
+		DB:        db,
+		Now:       time.Now,
+		ManageURL: manageURL,
+	}
+}
+
+// Run puts the emails that are due in the outbox each interval, until stop is closed
+func (s *Scheduler) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+
+	for {
+		_, err := s.SendDue(ctx)
+		if err != nil {
+			s.App.ErrorLog.Println("sending scheduled emails:", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// SendDue puts in the outbox the scheduled emails that are due today and were not sent yet, returning
+// how many. Those it could not send are tried again on the next call.
+func (s *Scheduler) SendDue(ctx context.Context) (int, error) {
+	now := s.Now()
+	// reservation dates have no time nor zone
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	sent := 0
+
+	if s.App.PreArrivalDays >= 0 {
+		reservations, err := s.DB.ReservationsArriving(ctx, today, today.AddDate(0, 0, s.App.PreArrivalDays), models.ScheduledPreArrival)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, res := range reservations {
+			ok, err := s.send(ctx, res, models.ScheduledPreArrival, emails.PreArrival{
+				Reservation: res,
+				ManageURL:   s.ManageURL(res),
+			})
+			if err != nil {
+				return sent, err
+			}
+			if ok {
+				sent++
+			}
+		}
+	}
+
+	if s.App.PostStayDays >= 0 {
+		due := today.AddDate(0, 0, -s.App.PostStayDays)
+		reservations, err := s.DB.ReservationsDeparted(ctx, due.AddDate(0, 0, -catchUp), due, models.ScheduledPostStay)
+		if err != nil {
+			return sent, err
+		}
+
+		for _, res := range reservations {
+			ok, err := s.send(ctx, res, models.ScheduledPostStay, emails.PostStay{
+				Reservation: res,
+				ReviewURL:   s.App.ReviewURL,
+			})
+			if err != nil {
+				return sent, err
+			}
+			if ok {
+				sent++
+			}
+		}
+	}
+
+	return sent, nil
+}
+
+// send renders the email d to the guest of res and puts it in the outbox, unless the scheduled email
+// kind was already sent for res. It reports whether it was put in the outbox.
+func (s *Scheduler) send(ctx context.Context, res models.Reservation, kind string, d emails.Data) (bool, error) {
+	e, err := s.App.Emails.Render(d)
+	if err != nil {
+		return false, err
+	}
+
+	return s.DB.EnqueueScheduledEmail(ctx, res.ID, kind, []models.MailData{
+		{
+			To:      res.Email,
+			From:    "me@here.com",
+			Subject: e.Subject,
+			Content: e.HTML,
+			Text:    e.Text,
+		},
+	})
+}
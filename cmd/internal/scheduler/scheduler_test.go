@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/repository"
+)
+
+// fakeDB keeps reservations and the scheduled emails sent for them in memory
+type fakeDB struct {
+	repository.DatabaseRepo
+	reservations []models.Reservation
+	sent         map[string]bool // by kind and reservation id
+	enqueued     []models.MailData
+	err          error
+}
+
+func newFakeDB(reservations ...models.Reservation) *fakeDB {
+	return &fakeDB{reservations: reservations, sent: make(map[string]bool)}
+}
+
+func (f *fakeDB) between(from, to time.Time, kind string, date func(res models.Reservation) time.Time) ([]models.Reservation, error) {
+	var found []models.Reservation
+	for _, res := range f.reservations {
+		d := date(res)
+		if res.Cancelled == 0 && !d.Before(from) && !d.After(to) && !f.sent[fmt.Sprint(kind, res.ID)] {
+			found = append(found, res)
+		}
+	}
+
+	return found, f.err
+}
+
+func (f *fakeDB) ReservationsArriving(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	return f.between(from, to, kind, func(res models.Reservation) time.Time { return res.StartDate })
+}
+
+func (f *fakeDB) ReservationsDeparted(ctx context.Context, from, to time.Time, kind string) ([]models.Reservation, error) {
+	return f.between(from, to, kind, func(res models.Reservation) time.Time { return res.EndDate })
+}
+
+func (f *fakeDB) EnqueueScheduledEmail(ctx context.Context, reservationID int, kind string, mail []models.MailData) (bool, error) {
+	key := fmt.Sprint(kind, reservationID)
+	if f.sent[key] {
+		return false, nil
+	}
+
+	f.sent[key] = true
+	f.enqueued = append(f.enqueued, mail...)
+
+	return true, nil
+}
+
+func date(month time.Month, day int) time.Time {
+	return time.Date(2100, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// newTestScheduler returns a Scheduler whose clock is *now
+func newTestScheduler(t *testing.T, db repository.DatabaseRepo, now *time.Time) *Scheduler {
+	renderer, err := emails.New("./../../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &config.AppConfig{
+		InfoLog:        log.New(ioutil.Discard, "", 0),
+		ErrorLog:       log.New(ioutil.Discard, "", 0),
+		Emails:         renderer,
+		PreArrivalDays: 3,
+		PostStayDays:   1,
+		ReviewURL:      "http://localhost/reviews",
+	}
+
+	s := New(db, app, func(res models.Reservation) string {
+		return fmt.Sprintf("http://localhost/manage/%d", res.ID)
+	})
+	s.Now = func() time.Time { return *now }
+
+	return s
+}
+
+func TestScheduler_SendDue(t *testing.T) {
+	db := newFakeDB(
+		models.Reservation{ID: 1, Email: "john@smith.com", StartDate: date(6, 10), EndDate: date(6, 12)},
+		models.Reservation{ID: 2, Email: "jane@smith.com", StartDate: date(6, 11), EndDate: date(6, 15)},
+		models.Reservation{ID: 3, Email: "jack@smith.com", StartDate: date(6, 10), EndDate: date(6, 12), Cancelled: 1},
+	)
+	now := time.Date(2100, 6, 1, 9, 0, 0, 0, time.UTC)
+	s := newTestScheduler(t, db, &now)
+
+	var tests = []struct {
+		name     string
+		now      time.Time
+		expected []string // who is emailed, and what
+	}{
+		{"too-early", time.Date(2100, 6, 6, 23, 59, 0, 0, time.UTC), nil},
+		{"reminder-due", time.Date(2100, 6, 7, 0, 1, 0, 0, time.UTC), []string{"john@smith.com See you soon"}},
+		{"reminder-sent-once", time.Date(2100, 6, 7, 18, 0, 0, 0, time.UTC), nil},
+		{"next-reminder", time.Date(2100, 6, 8, 9, 0, 0, 0, time.UTC), []string{"jane@smith.com See you soon"}},
+		{"staying", time.Date(2100, 6, 12, 9, 0, 0, 0, time.UTC), nil},
+		{"thanks-due", time.Date(2100, 6, 13, 9, 0, 0, 0, time.UTC), []string{"john@smith.com Thank you"}},
+		{"thanks-caught-up", time.Date(2100, 6, 20, 9, 0, 0, 0, time.UTC), []string{"jane@smith.com Thank you"}},
+		{"all-sent", time.Date(2100, 6, 21, 9, 0, 0, 0, time.UTC), nil},
+	}
+
+	for _, e := range tests {
+		now = e.now
+		db.enqueued = nil
+
+		n, err := s.SendDue(context.Background())
+		if err != nil {
+			t.Errorf("failed %s: unexpected error %s", e.name, err)
+			continue
+		}
+
+		if n != len(e.expected) || len(db.enqueued) != len(e.expected) {
+			t.Errorf("failed %s: expected %d emails, but got %d (%v)", e.name, len(e.expected), n, db.enqueued)
+			continue
+		}
+
+		for i, expected := range e.expected {
+			msg := db.enqueued[i]
+			if got := msg.To + " " + msg.Subject; !strings.HasPrefix(got, expected) {
+				t.Errorf("failed %s: expected %q, but got %q", e.name, expected, got)
+			}
+		}
+	}
+}
+
+func TestScheduler_SendDueLinks(t *testing.T) {
+	db := newFakeDB(
+		models.Reservation{ID: 1, Email: "john@smith.com", StartDate: date(6, 10), EndDate: date(6, 12)},
+		models.Reservation{ID: 2, Email: "jane@smith.com", StartDate: date(6, 1), EndDate: date(6, 9)},
+	)
+	now := time.Date(2100, 6, 10, 9, 0, 0, 0, time.UTC)
+	s := newTestScheduler(t, db, &now)
+
+	if _, err := s.SendDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.enqueued) != 2 {
+		t.Fatalf("expected a reminder and thanks, but got %v", db.enqueued)
+	}
+
+	if !strings.Contains(db.enqueued[0].Text, "http://localhost/manage/1") {
+		t.Errorf("expected the link to manage the reservation in\n%s", db.enqueued[0].Text)
+	}
+
+	if !strings.Contains(db.enqueued[1].Text, "http://localhost/reviews") {
+		t.Errorf("expected the link to review the stay in\n%s", db.enqueued[1].Text)
+	}
+}
+
+func TestScheduler_SendDueTurnedOff(t *testing.T) {
+	db := newFakeDB(
+		models.Reservation{ID: 1, Email: "john@smith.com", StartDate: date(6, 10), EndDate: date(6, 12)},
+		models.Reservation{ID: 2, Email: "jane@smith.com", StartDate: date(6, 1), EndDate: date(6, 9)},
+	)
+	now := time.Date(2100, 6, 10, 9, 0, 0, 0, time.UTC)
+	s := newTestScheduler(t, db, &now)
+	s.App.PreArrivalDays = -1
+
+	if _, err := s.SendDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(db.enqueued) != 1 || db.enqueued[0].To != "jane@smith.com" {
+		t.Errorf("expected only the thanks, but got %v", db.enqueued)
+	}
+
+	s.App.PostStayDays = -1
+	db.sent = make(map[string]bool)
+	db.enqueued = nil
+
+	if n, _ := s.SendDue(context.Background()); n != 0 {
+		t.Errorf("expected no emails, but got %d", n)
+	}
+}
+
+func TestScheduler_SendDueError(t *testing.T) {
+	db := newFakeDB()
+	db.err = errors.New("database down")
+	now := time.Date(2100, 6, 10, 9, 0, 0, 0, time.UTC)
+	s := newTestScheduler(t, db, &now)
+
+	if _, err := s.SendDue(context.Background()); err == nil {
+		t.Error("expected the error of the database")
+	}
+}
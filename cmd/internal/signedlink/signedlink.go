@@ -0,0 +1,68 @@
+// Package signedlink creates and checks tamper-proof, expiring tokens that can be put in urls,
+// so that a link can grant access to a single resource without a login.
+package signedlink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons a token is refused
+var (
+	ErrInvalid = errors.New("invalid link")
+	ErrExpired = errors.New("link has expired")
+)
+
+var encoding = base64.RawURLEncoding
+
+// Sign returns a token for subject that is valid until expires
+func Sign(secret []byte, subject string, expires time.Time) string {
+	payload := encoding.EncodeToString([]byte(subject)) + "." + strconv.FormatInt(expires.Unix(), 10)
+
+	return payload + "." + encoding.EncodeToString(signature(secret, payload))
+}
+
+// Verify checks token was made with secret and has not expired at now, and returns its subject
+func Verify(secret []byte, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", ErrInvalid
+	}
+
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	if !hmac.Equal(sig, signature(secret, parts[0]+"."+parts[1])) {
+		return "", ErrInvalid
+	}
+
+	subject, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", ErrInvalid
+	}
+
+	if now.Unix() > expires {
+		return "", ErrExpired
+	}
+
+	return string(subject), nil
+}
+
+// signature returns the HMAC-SHA256 of payload
+func signature(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,48 @@
+package signedlink
+
+import (
+	"testing"
+	"time"
+)
+
+var secret = []byte("not so secret")
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, "reservation:42", now.Add(time.Hour))
+
+	subject, err := Verify(secret, token, now)
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	if subject != "reservation:42" {
+		t.Errorf("expected subject reservation:42 but got %s", subject)
+	}
+}
+
+func TestVerify_Refused(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, "reservation:42", now.Add(time.Hour))
+	other := Sign(secret, "reservation:43", now.Add(time.Hour))
+
+	var tests = []struct {
+		name     string
+		secret   []byte
+		token    string
+		now      time.Time
+		expected error
+	}{
+		{"expired", secret, token, now.Add(2 * time.Hour), ErrExpired},
+		{"wrong-secret", []byte("another secret"), token, now, ErrInvalid},
+		{"tampered-subject", secret, other[:len(other)-43] + token[len(token)-43:], now, ErrInvalid},
+		{"malformed", secret, "abc", now, ErrInvalid},
+		{"empty", secret, "", now, ErrInvalid},
+	}
+
+	for _, e := range tests {
+		if _, err := Verify(e.secret, e.token, e.now); err != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
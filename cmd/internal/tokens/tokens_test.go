@@ -0,0 +1,33 @@
+package tokens
+
+import "testing"
+
+func TestNew(t *testing.T) {
+	a, err := New()
+	if err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+
+	b, _ := New()
+	if a == b {
+		t.Error("got the same token twice")
+	}
+
+	if len(a) != 43 {
+		t.Errorf("expected a token of 43 characters but got %d", len(a))
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash("token") != Hash("token") {
+		t.Error("hash of the same token differs")
+	}
+
+	if Hash("token") == Hash("other") {
+		t.Error("hash of different tokens is the same")
+	}
+
+	if len(Hash("token")) != 64 {
+		t.Errorf("expected a hash of 64 characters but got %d", len(Hash("token")))
+	}
+}
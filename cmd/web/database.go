@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/driver"
+)
+
+// connect opens the postgres or sqlite database of d
+func connect(d config.DatabaseSettings) (*driver.DB, error) {
+	if d.Driver == "sqlite" {
+		db, err := driver.ConnectSQLite(d.File)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open the sqlite database %s: %w", d.File, err)
+		}
+		return db, nil
+	}
+
+	db, err := driver.ConnectSQL(postgresURL(d))
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to the postgres database %s on %s:%s as %s: %w", d.Name, d.Host, d.Port, d.User, err)
+	}
+
+	return db, nil
+}
+
+// postgresURL returns the postgres:// url of d, leaving out the settings that are empty so that
+// postgres uses its defaults. Every part is escaped, so a password may have any character.
+func postgresURL(d config.DatabaseSettings) string {
+	u := url.URL{
+		Scheme: "postgres",
+		Host:   d.Host,
+		Path:   "/" + d.Name,
+	}
+
+	if d.Port != "" {
+		u.Host = net.JoinHostPort(d.Host, d.Port)
+	}
+
+	if d.Password != "" {
+		u.User = url.UserPassword(d.User, d.Password)
+	} else if d.User != "" {
+		u.User = url.User(d.User)
+	}
+
+	if d.SSL != "" {
+		u.RawQuery = url.Values{"sslmode": {d.SSL}}.Encode()
+	}
+
+	return u.String()
+}
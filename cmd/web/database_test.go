@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/maslow123/bookings/cmd/internal/config"
+)
+
+func TestPostgresURL(t *testing.T) {
+	var tests = []struct {
+		name     string
+		password string
+		ssl      string
+		withTLS  bool
+	}{
+		{"no-password", "", "require", true},
+		{"password-with-space", "two words", "disable", false},
+		{"password-with-quotes", `it's a "\secret"`, "require", true},
+		{"password-with-url-characters", "p@ss:w/rd?#%", "", true},
+	}
+
+	for _, e := range tests {
+		dsn := postgresURL(config.DatabaseSettings{
+			Host:     "db.example.com",
+			Port:     "5433",
+			Name:     "bookings",
+			User:     "postgres",
+			Password: e.password,
+			SSL:      e.ssl,
+		})
+
+		c, err := pgconn.ParseConfig(dsn)
+		if err != nil {
+			t.Errorf("failed %s: %s", e.name, err)
+			continue
+		}
+
+		if c.Host != "db.example.com" || c.Port != 5433 || c.Database != "bookings" || c.User != "postgres" {
+			t.Errorf("failed %s: expected the host, port, database and user, but got %s:%d %s %s", e.name, c.Host, c.Port, c.Database, c.User)
+		}
+
+		if c.Password != e.password {
+			t.Errorf("failed %s: expected the password %q, but got %q", e.name, e.password, c.Password)
+		}
+
+		// the sslmode is only seen in whether the connection is encrypted
+		if (c.TLSConfig != nil) != e.withTLS {
+			t.Errorf("failed %s: expected sslmode %q to be kept, but got TLS %v", e.name, e.ssl, c.TLSConfig != nil)
+		}
+	}
+}
@@ -1,46 +1,79 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	scs "github.com/alexedwards/scs/v2"
+	"github.com/maslow123/bookings/cmd/internal/calsync"
 	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/digest"
 	"github.com/maslow123/bookings/cmd/internal/driver"
+	"github.com/maslow123/bookings/cmd/internal/emails"
 	"github.com/maslow123/bookings/cmd/internal/handlers"
 	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/mailer"
 	"github.com/maslow123/bookings/cmd/internal/models"
+	"github.com/maslow123/bookings/cmd/internal/outbox"
 	"github.com/maslow123/bookings/cmd/internal/render"
+	"github.com/maslow123/bookings/cmd/internal/repository/dbrepo"
+	"github.com/maslow123/bookings/cmd/internal/scheduler"
+	"github.com/maslow123/bookings/cmd/internal/tokens"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
+var settings *config.Settings
 var session *scs.SessionManager
 var infoLog *log.Logger
 var errorLog *log.Logger
 
 // main is the main application function
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := migrateCommand(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	db, err := run()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	defer db.SQL.Close()
-	defer close(app.MailChan)
+	if db != nil {
+		defer db.SQL.Close()
+	}
 
-	fmt.Println("Starting mail listener...")
-	listenForMail()
+	fmt.Println("Starting mail workers...")
+	go outbox.New(handlers.Repo.DB, &app).Run(5*time.Second, nil)
 
-	fmt.Println("Starting application on port", portNumber)
+	if app.StaffNotify == config.NotifyDigest {
+		fmt.Println("Starting the daily digest of new reservations...")
+		go digest.New(handlers.Repo.DB, &app).Run(nil)
+	}
+
+	if app.PreArrivalDays >= 0 || app.PostStayDays >= 0 {
+		fmt.Println("Starting scheduled guest emails...")
+		go scheduler.New(handlers.Repo.DB, &app, handlers.Repo.ManageURL).Run(time.Hour, nil)
+	}
+
+	if app.CalendarSyncInterval > 0 {
+		fmt.Println("Starting calendar sync...")
+		go calsync.New(handlers.Repo.DB, &app).Run(app.CalendarSyncInterval, nil)
+	}
+
+	fmt.Println("Starting application on", settings.HTTP.Addr)
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    settings.HTTP.Addr,
 		Handler: routes(&app),
 	}
 
@@ -58,28 +91,28 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restriction{})
 	gob.Register(map[string]int{})
 
-	// read flags
-	inProduction := flag.Bool("production", true, "Application is in production")
-	useCache := flag.Bool("cache", true, "Use template cache")
-	dbHost := flag.String("dbhost", "localhost", "Database host")
-	dbName := flag.String("dbname", "", "Database name")
-	dbUser := flag.String("dbuser", "", "Database user")
-	dbPass := flag.String("dbpassword", "db", "Database password")
-	dbPort := flag.String("dbport", "5432", "Database port")
-	dbSSL := flag.String("dbssl", "", "Database ssl settings (disable, prefer, require")
-
-	flag.Parse()
-
-	if *dbName == "" || *dbUser == "" {
-		fmt.Println("Missing required flags")
-		os.Exit(1)
+	// read the settings
+	var err error
+	settings, err = config.Load(defaultSettings(), flag.CommandLine, os.Args[1:], os.LookupEnv)
+	if err != nil {
+		return nil, err
 	}
-	mailChan := make(chan models.MailData)
-	app.MailChan = mailChan
-	// change this true when in production
 
-	app.InProduction = *inProduction
-	app.UseCache = *useCache
+	app.InProduction = settings.HTTP.Production
+	app.UseCache = settings.Templates.Cache
+	app.BaseURL = strings.TrimSuffix(settings.HTTP.BaseURL, "/")
+	app.WidgetOrigins = settings.HTTP.WidgetOrigins
+	app.DBTimeout = settings.Database.Timeout
+	app.CalendarSyncInterval = settings.Calendar.SyncInterval
+	app.CalendarFiles = settings.Calendar.Files
+	app.MailWorkers = settings.Mail.Workers
+	app.MailMaxAttempts = settings.Mail.Attempts
+	app.StaffEmails = settings.Mail.StaffEmails
+	app.StaffNotify = settings.Mail.StaffNotify
+	app.DigestHour = settings.Mail.DigestHour
+	app.PreArrivalDays = settings.Mail.PreArrivalDays
+	app.PostStayDays = settings.Mail.PostStayDays
+	app.ReviewURL = settings.Mail.ReviewURL
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -87,23 +120,50 @@ func run() (*driver.DB, error) {
 	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 	app.ErrorLog = errorLog
 
+	if settings.HTTP.LinkSecret == "" {
+		// only outside production, where links sent to guests may stop working when the
+		// application restarts
+		errorLog.Println("No -linksecret given, using a random one until the application restarts")
+		secret := make([]byte, 32)
+		_, err := rand.Read(secret)
+		if err != nil {
+			return nil, err
+		}
+		app.LinkSecret = secret
+	} else {
+		app.LinkSecret = []byte(settings.HTTP.LinkSecret)
+	}
+
+	m, err := mailer.New(mailer.Config{
+		Transport:  settings.Mail.Transport,
+		Host:       settings.Mail.SMTPHost,
+		Port:       settings.Mail.SMTPPort,
+		Username:   settings.Mail.SMTPUser,
+		Password:   settings.Mail.SMTPPassword,
+		Encryption: settings.Mail.SMTPEncryption,
+		Dir:        settings.Mail.Dir,
+	})
+	if err != nil {
+		return nil, err
+	}
+	app.Mailer = m
+
+	renderer, err := emails.New(settings.Templates.EmailDir)
+	if err != nil {
+		return nil, err
+	}
+	app.Emails = renderer
+
 	session = scs.New()
-	session.Lifetime = 24 * time.Hour
+	session.Lifetime = settings.Session.Lifetime
+	session.Cookie.Name = settings.Session.CookieName
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
 	session.Cookie.Secure = app.InProduction
 
 	app.Session = session
 
-	// Connect to database
-	log.Println("Connecting to database ...")
-	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s", *dbHost, *dbPort, *dbName, *dbUser, *dbPass, *dbSSL)
-	db, err := driver.ConnectSQL(connectionString)
-	if err != nil {
-		log.Fatal("Cannot connect to database!")
-	}
-
-	log.Println("Connected to database!")
+	render.SetTemplatePath(settings.Templates.Dir)
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		log.Fatal("Cannot create template cache", err)
@@ -111,12 +171,118 @@ func run() (*driver.DB, error) {
 	}
 
 	app.TemplateCache = tc
-	app.UseCache = false
 
-	repo := handlers.NewRepo(&app, db)
+	var conn *driver.DB
+	var repo *handlers.Repository
+
+	db := settings.Database
+
+	switch db.Driver {
+	case "memory":
+		repo, err = demoRepo()
+		if err != nil {
+			return nil, err
+		}
+	case "sqlite":
+		log.Println("Opening database", db.File, "...")
+		conn, err = connect(db)
+		if err != nil {
+			return nil, err
+		}
+
+		repo = handlers.NewRepoWithDB(&app, dbrepo.NewSQLiteRepo(conn.SQL, &app))
+	default:
+		// Connect to database
+		log.Println("Connecting to database ...")
+		conn, err = connect(db)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Println("Connected to database!")
+		repo = handlers.NewRepo(&app, conn)
+	}
+
+	if db.Migrate && conn != nil {
+		err = migrateUp(conn, db.Driver)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
 
-	return db, nil
+	return conn, nil
+}
+
+// demoRepo returns a repository on an in-memory database, with an owner whose password is logged
+func demoRepo() (*handlers.Repository, error) {
+	db := dbrepo.NewMemoryRepo(&app)
+
+	password, err := tokens.New()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.SeedUser(models.User{
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       "admin@here.com",
+		AccessLevel: models.AccessOwner,
+	}, password)
+	if err != nil {
+		return nil, err
+	}
+
+	infoLog.Println("Using an in-memory database, nothing is kept on exit")
+	infoLog.Printf("Log in as admin@here.com with the password %s", password)
+
+	return handlers.NewRepoWithDB(&app, db), nil
+}
+
+// defaultSettings returns the settings used when neither the config file, the environment nor the
+// flags give them
+func defaultSettings() config.Settings {
+	return config.Settings{
+		HTTP: config.HTTPSettings{
+			Addr:       ":8080",
+			Production: true,
+			BaseURL:    "http://localhost:8080",
+		},
+		Database: config.DatabaseSettings{
+			Driver:  "postgres",
+			File:    "./bookings.db",
+			Host:    "localhost",
+			Port:    "5432",
+			Timeout: dbrepo.DefaultTimeout,
+		},
+		Session: config.SessionSettings{
+			Lifetime:   24 * time.Hour,
+			CookieName: "session",
+		},
+		Mail: config.MailSettings{
+			Transport:      "smtp",
+			SMTPHost:       "localhost",
+			SMTPPort:       1025,
+			SMTPEncryption: "none",
+			Dir:            "./tmp/mail",
+			Workers:        outbox.DefaultWorkers,
+			Attempts:       outbox.DefaultMaxAttempts,
+			StaffEmails:    []string{"me@here.com"},
+			StaffNotify:    config.NotifyEach,
+			DigestHour:     8,
+			PreArrivalDays: 3,
+			PostStayDays:   1,
+		},
+		Templates: config.TemplateSettings{
+			Cache:    true,
+			Dir:      "./templates",
+			EmailDir: "./email-templates",
+		},
+		Calendar: config.CalendarSettings{
+			SyncInterval: 15 * time.Minute,
+		},
+	}
 }
@@ -3,9 +3,13 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/nosurf"
+	"github.com/maslow123/bookings/cmd/internal/authz"
+	"github.com/maslow123/bookings/cmd/internal/handlers"
 	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/tokens"
 )
 
 func WriteToConsole(next http.Handler) http.Handler {
@@ -26,9 +30,80 @@ func NoSurf(next http.Handler) http.Handler {
 		Path:     "/",
 	})
 
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			handlers.Repo.APIForgeable(w, r)
+			return
+		}
+
+		http.Error(w, http.StatusText(nosurf.FailureCode), nosurf.FailureCode)
+	}))
+
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		if !strings.HasPrefix(r.URL.Path, "/api/") {
+			return false
+		}
+
+		// requests with a bearer token carry no cookie a browser could send for another site,
+		// and APIAuth rejects them when the token is not valid
+		if r.Header.Get("Authorization") != "" {
+			return true
+		}
+
+		// guests book from the app, which sends neither an Origin nor cookies, and from the widget on
+		// the sites of app.WidgetOrigins. Pages of the site itself send the CSRF token like any form.
+		if r.Method == http.MethodPost && r.URL.Path == "/api/v1/reservations" {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return len(r.Cookies()) == 0
+			}
+
+			return isWidgetOrigin(origin)
+		}
+
+		return false
+	})
+
 	return csrfHandler
 }
 
+// WidgetCORS lets the sites of app.WidgetOrigins call the API from the browser, for the reservation
+// widget. Credentials are not allowed, so the widget books as a guest and never as a logged in user.
+func WidgetCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !isWidgetOrigin(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		// preflight of the JSON reservation
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isWidgetOrigin reports whether origin is one of app.WidgetOrigins
+func isWidgetOrigin(origin string) bool {
+	for _, allowed := range app.WidgetOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // SessionLoad loads and saves the session on every request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
@@ -45,3 +120,61 @@ func Auth(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireRole lets through only logged in users with at least the access level minimum.
+// The user is read again on every request, so that role changes and deactivations apply at once.
+func RequireRole(minimum int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := handlers.Repo.DB.GetUserByID(r.Context(), session.GetInt(r.Context(), "user_id"))
+			if err != nil || user.Active == 0 {
+				session.Remove(r.Context(), "user_id")
+				session.Remove(r.Context(), "access_level")
+				session.Put(r.Context(), "error", "Your account is not active, log in again")
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			session.Put(r.Context(), "access_level", user.AccessLevel)
+
+			level := helpers.AccessLevel(r)
+			if !authz.IsRole(level) || level < minimum {
+				handlers.Repo.Forbidden(w, r)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIAuth authenticates API requests sending a bearer token, limiting them to the token's scopes.
+// Requests without an Authorization header go through, for the handlers to check their session.
+func APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := strings.TrimPrefix(header, "Bearer ")
+		if token == header || token == "" {
+			handlers.Repo.APIUnauthorized(w, r)
+			return
+		}
+
+		apiToken, err := handlers.Repo.DB.GetAPITokenByHash(r.Context(), tokens.Hash(token))
+		if err != nil || apiToken.Revoked != 0 || apiToken.User.Active == 0 {
+			handlers.Repo.APIUnauthorized(w, r)
+			return
+		}
+
+		err = handlers.Repo.DB.TouchAPIToken(r.Context(), apiToken.ID)
+		if err != nil {
+			app.ErrorLog.Println(err)
+		}
+
+		next.ServeHTTP(w, r.WithContext(helpers.WithAPIToken(r.Context(), apiToken)))
+	})
+}
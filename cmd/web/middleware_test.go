@@ -3,7 +3,12 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/maslow123/bookings/cmd/internal/handlers"
+	"github.com/maslow123/bookings/cmd/internal/helpers"
+	"github.com/maslow123/bookings/cmd/internal/models"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -31,3 +36,59 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestRequireRole(t *testing.T) {
+	var myH myHandler
+
+	h := RequireRole(models.AccessReadOnly)(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
+	}
+}
+
+func TestAPIAuth(t *testing.T) {
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+
+	var tests = []struct {
+		name          string
+		authorization string
+		expected      int
+		expectedToken int
+	}{
+		{"no-token", "", http.StatusOK, 0},
+		{"valid-token", "Bearer valid-api-token", http.StatusOK, 1},
+		{"not-bearer", "Basic dXNlcjpwYXNz", http.StatusUnauthorized, 0},
+		{"unknown-token", "Bearer nothing", http.StatusUnauthorized, 0},
+		{"revoked-token", "Bearer revoked-api-token", http.StatusUnauthorized, 0},
+		{"deactivated-user", "Bearer deactivated-user-api-token", http.StatusUnauthorized, 0},
+	}
+
+	for _, e := range tests {
+		var tokenID int
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token, ok := helpers.APIToken(r); ok {
+				tokenID = token.ID
+			}
+		})
+
+		req, _ := http.NewRequest("GET", "/api/v1/reservations", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+
+		rr := httptest.NewRecorder()
+		APIAuth(next).ServeHTTP(rr, req)
+
+		if rr.Code != e.expected {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expected, rr.Code)
+		}
+
+		if tokenID != e.expectedToken {
+			t.Errorf("failed %s: expected token %d in the request, but got %d", e.name, e.expectedToken, tokenID)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/driver"
+	"github.com/maslow123/bookings/cmd/internal/migrate"
+)
+
+// migrateCommand runs bookings migrate up|down|status on the database of the settings, which are read
+// like those of the site
+func migrateCommand(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: bookings migrate [flags] up|down|status")
+		fmt.Fprintln(fs.Output(), "  up      apply the migrations the database is missing")
+		fmt.Fprintln(fs.Output(), "  down    roll back the latest -steps migrations")
+		fmt.Fprintln(fs.Output(), "  status  list the migrations and when they were applied")
+		fs.PrintDefaults()
+	}
+
+	steps := fs.Int("steps", 1, "How many migrations down rolls back")
+
+	// flags may come before or after the command
+	command := ""
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	s, err := config.Load(defaultSettings(), fs, args, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	db := s.Database
+
+	if command == "" && fs.NArg() > 0 {
+		command = fs.Arg(0)
+	}
+
+	if command != "up" && command != "down" && command != "status" {
+		fs.Usage()
+		return fmt.Errorf("unknown migrate command %q, expected up, down or status", command)
+	}
+
+	if db.Driver == "memory" {
+		return fmt.Errorf("the memory database has nothing to migrate")
+	}
+
+	if command == "down" && *steps < 1 {
+		return fmt.Errorf("-steps %d must be at least 1", *steps)
+	}
+
+	conn, err := connect(db)
+	if err != nil {
+		return err
+	}
+	defer conn.SQL.Close()
+
+	m, err := migrate.New(conn.SQL, db.Driver)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, mig := range done {
+			log.Println("Applied", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			log.Println("The database is up to date")
+		}
+	case "down":
+		done, err := m.Down(ctx, *steps)
+		for _, mig := range done {
+			log.Println("Rolled back", mig.Version, mig.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			log.Println("No migration to roll back")
+		}
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if !s.AppliedAt.IsZero() {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		w.Flush()
+	}
+
+	return nil
+}
+
+// migrateUp applies the migrations conn is missing, when the site starts with -migrate
+func migrateUp(conn *driver.DB, dbDriver string) error {
+	m, err := migrate.New(conn.SQL, dbDriver)
+	if err != nil {
+		return err
+	}
+
+	done, err := m.Up(context.Background())
+	for _, mig := range done {
+		infoLog.Println("Applied migration", mig.Version, mig.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot migrate the database: %w", err)
+	}
+
+	return nil
+}
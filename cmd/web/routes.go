@@ -6,6 +6,7 @@ import (
 	"github.com/go-chi/chi"
 	"github.com/maslow123/bookings/cmd/internal/config"
 	"github.com/maslow123/bookings/cmd/internal/handlers"
+	"github.com/maslow123/bookings/cmd/internal/models"
 )
 
 func routes(app *config.AppConfig) http.Handler {
@@ -15,8 +16,10 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Use(SessionLoad)
 	mux.Get("/", http.HandlerFunc(handlers.Repo.Home))
 	mux.Get("/about", http.HandlerFunc(handlers.Repo.About))
-	mux.Get("/generals-quarters", http.HandlerFunc(handlers.Repo.Generals))
-	mux.Get("/majors-suite", http.HandlerFunc(handlers.Repo.Majors))
+	mux.Get("/rooms", http.HandlerFunc(handlers.Repo.Rooms))
+	mux.Get("/rooms/{slug}", http.HandlerFunc(handlers.Repo.Room))
+	mux.Get("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently).ServeHTTP)
+	mux.Get("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently).ServeHTTP)
 	mux.Get("/search-availability", http.HandlerFunc(handlers.Repo.Availability))
 	mux.Post("/search-availability", http.HandlerFunc(handlers.Repo.PostAvailability))
 	mux.Get("/choose-room/{id}", http.HandlerFunc(handlers.Repo.ChooseRoom))
@@ -28,26 +31,93 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Get("/contact", http.HandlerFunc(handlers.Repo.Contact))
 	mux.Get("/reservation-summary", http.HandlerFunc(handlers.Repo.ReservationSummary))
 
+	mux.Get("/manage/{token}", http.HandlerFunc(handlers.Repo.ManageReservation))
+	mux.Post("/manage/{token}", http.HandlerFunc(handlers.Repo.PostManageReservation))
+	mux.Post("/manage/{token}/cancel", http.HandlerFunc(handlers.Repo.PostCancelReservation))
+
+	mux.Get("/calendar/rooms.ics", http.HandlerFunc(handlers.Repo.AllRoomsCalendarFeed))
+	mux.Get("/calendar/rooms/{id}.ics", http.HandlerFunc(handlers.Repo.RoomCalendarFeed))
+
 	mux.Get("/user/login", http.HandlerFunc(handlers.Repo.ShowLogin))
 	mux.Post("/user/login", http.HandlerFunc(handlers.Repo.PostShowLogin))
 	mux.Get("/user/logout", http.HandlerFunc(handlers.Repo.Logout))
+	mux.Get("/user/forgot-password", http.HandlerFunc(handlers.Repo.ShowForgotPassword))
+	mux.Post("/user/forgot-password", http.HandlerFunc(handlers.Repo.PostForgotPassword))
+	mux.Get("/user/reset-password/{token}", http.HandlerFunc(handlers.Repo.ShowResetPassword))
+	mux.Post("/user/reset-password/{token}", http.HandlerFunc(handlers.Repo.PostResetPassword))
+
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.Use(WidgetCORS)
+		mux.Use(APIAuth)
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+		mux.Post("/reservations", handlers.Repo.APIPostReservation)
+		mux.Get("/reservations", handlers.Repo.APIReservations)
+		mux.Get("/reservations/{id}", handlers.Repo.APIReservation)
+		mux.Post("/reservations/{id}/processed", handlers.Repo.APIProcessReservation)
+		mux.Delete("/reservations/{id}", handlers.Repo.APIDeleteReservation)
+	})
 
 	fileServer := http.FileServer(http.Dir("./assets/"))
 	mux.Handle("/assets/*", http.StripPrefix("/assets", fileServer))
 
 	mux.Route("/admin", func(mux chi.Router) {
-		// mux.Use(Auth)
+		mux.Use(Auth)
+		mux.Use(RequireRole(models.AccessReadOnly))
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
 
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.Get("/blocks/{id}", handlers.Repo.AdminShowBlock)
+		mux.Post("/blocks/{id}", handlers.Repo.AdminPostShowBlock)
+		mux.Post("/blocks/{id}/unblock", handlers.Repo.AdminUnblockNights)
+		mux.Get("/delete-block/{id}/do", handlers.Repo.AdminDeleteBlock)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
 		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/new", handlers.Repo.AdminNewRoom)
+		mux.Post("/rooms/new", handlers.Repo.AdminPostNewRoom)
+		mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.Post("/rooms/{id}", handlers.Repo.AdminPostShowRoom)
+		mux.Get("/delete-room/{id}/do", handlers.Repo.AdminDeleteRoom)
+		mux.Get("/rooms/{id}/rates", handlers.Repo.AdminRoomRates)
+		mux.Post("/rooms/{id}/rates", handlers.Repo.AdminPostRoomRate)
+		mux.Get("/delete-rate/{roomID}/{id}/do", handlers.Repo.AdminDeleteRoomRate)
+		mux.Get("/rooms/{id}/calendars", handlers.Repo.AdminRoomCalendars)
+		mux.Post("/rooms/{id}/calendars", handlers.Repo.AdminPostRoomCalendar)
+		mux.Post("/upload-calendar/{roomID}/{id}", handlers.Repo.AdminUploadRoomCalendar)
+		mux.Get("/sync-calendar/{roomID}/{id}/do", handlers.Repo.AdminSyncRoomCalendar)
+		mux.Get("/delete-calendar/{roomID}/{id}/do", handlers.Repo.AdminDeleteRoomCalendar)
+
+		mux.Get("/promo-codes", handlers.Repo.AdminPromoCodes)
+		mux.Get("/promo-codes/new", handlers.Repo.AdminNewPromoCode)
+		mux.Post("/promo-codes/new", handlers.Repo.AdminPostNewPromoCode)
+		mux.Get("/promo-codes/{id}", handlers.Repo.AdminShowPromoCode)
+		mux.Post("/promo-codes/{id}", handlers.Repo.AdminPostShowPromoCode)
+		mux.Get("/delete-promo-code/{id}/do", handlers.Repo.AdminDeletePromoCode)
+
+		mux.Get("/api-tokens", handlers.Repo.AdminAPITokens)
+		mux.Post("/api-tokens", handlers.Repo.AdminPostAPIToken)
+		mux.Get("/revoke-api-token/{id}/do", handlers.Repo.AdminRevokeAPIToken)
+		mux.Get("/audit-log", handlers.Repo.AdminAuditLog)
+		mux.Get("/emails", handlers.Repo.AdminEmails)
+		mux.Get("/resend-email/{id}/do", handlers.Repo.AdminResendEmail)
+
+		mux.Get("/users", handlers.Repo.AdminUsers)
+		mux.Get("/users/new", handlers.Repo.AdminNewUser)
+		mux.Post("/users/new", handlers.Repo.AdminPostNewUser)
+		mux.Get("/users/{id}", handlers.Repo.AdminShowUser)
+		mux.Post("/users/{id}", handlers.Repo.AdminPostShowUser)
+		mux.Get("/deactivate-user/{id}/do", handlers.Repo.AdminDeactivateUser)
+		mux.Get("/activate-user/{id}/do", handlers.Repo.AdminActivateUser)
 	})
 
 	return mux
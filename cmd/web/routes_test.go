@@ -1,11 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	scs "github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/maslow123/bookings/cmd/internal/config"
+	"github.com/maslow123/bookings/cmd/internal/emails"
+	"github.com/maslow123/bookings/cmd/internal/handlers"
 )
 
 func TestRoutes(t *testing.T) {
@@ -19,3 +28,97 @@ func TestRoutes(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not *chi.Mux, type is %T", v))
 	}
 }
+
+func TestRoutes_PublicReservation(t *testing.T) {
+	renderer, err := emails.New("./../../email-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.InfoLog = log.New(ioutil.Discard, "", 0)
+	app.ErrorLog = log.New(ioutil.Discard, "", 0)
+	app.BaseURL = "http://localhost:8080"
+	app.LinkSecret = []byte("test secret")
+	app.StaffNotify = config.NotifyEach
+	app.Emails = renderer
+	app.WidgetOrigins = []string{"https://hotel.example.com"}
+
+	session = scs.New()
+	app.Session = session
+
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+	mux := routes(&app)
+
+	var tests = []struct {
+		name               string
+		origin             string
+		cookie             bool
+		body               string
+		expectedStatusCode int
+	}{
+		{"app", "", false, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusCreated},
+		{"app-invalid", "", false, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama","phone":"555-555-5555"}`, http.StatusUnprocessableEntity},
+		{"widget", "https://hotel.example.com", false, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusCreated},
+		{"widget-invalid", "https://hotel.example.com", false, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama","phone":"555-555-5555"}`, http.StatusUnprocessableEntity},
+		{"other-site", "https://evil.example.com", false, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusForbidden},
+		{"other-site-with-cookie", "https://evil.example.com", true, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusForbidden},
+		{"no-origin-with-cookie", "", true, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusForbidden},
+		{"site-without-csrf-token", "http://localhost:8080", true, `{"room_id":1,"start_date":"2100-01-04","end_date":"2100-01-06","first_name":"Omama","last_name":"Olala","email":"omama@getnada.com","phone":"555-555-5555"}`, http.StatusForbidden},
+	}
+
+	// no CSRF token, like the app and the widget
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/api/v1/reservations", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		if e.origin != "" {
+			req.Header.Set("Origin", e.origin)
+		}
+		if e.cookie {
+			req.AddCookie(&http.Cookie{Name: "session", Value: "logged-in-session"})
+		}
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d: %s", e.name, e.expectedStatusCode, rr.Code, rr.Body)
+		}
+
+		if rr.Header().Get("Content-Type") != "application/json" || !json.Valid(rr.Body.Bytes()) {
+			t.Errorf("failed %s: expected a JSON response, but got %s", e.name, rr.Body)
+		}
+	}
+}
+
+func TestRoutes_WidgetPreflight(t *testing.T) {
+	app.WidgetOrigins = []string{"https://hotel.example.com"}
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+	session = scs.New()
+	mux := routes(&app)
+
+	var tests = []struct {
+		name          string
+		origin        string
+		expectedAllow string
+	}{
+		{"widget", "https://hotel.example.com", "https://hotel.example.com"},
+		{"other-site", "https://evil.example.com", ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("OPTIONS", "/api/v1/reservations", nil)
+		req.Header.Set("Origin", e.origin)
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "content-type")
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+
+		if allow := rr.Header().Get("Access-Control-Allow-Origin"); allow != e.expectedAllow {
+			t.Errorf("failed %s: expected the origin %q to be allowed, but got %q", e.name, e.expectedAllow, allow)
+		}
+		if rr.Header().Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("failed %s: expected credentials not to be allowed", e.name)
+		}
+	}
+}
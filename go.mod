@@ -3,13 +3,15 @@ module github.com/maslow123/bookings
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/alexedwards/scs/v2 v2.4.0 // indirect
 	github.com/asaskevich/govalidator v0.0.0-20210307081110-f21760c49a8d // indirect
 	github.com/go-chi/chi v1.5.4 // indirect
 	github.com/jackc/pgconn v1.10.0 // indirect
 	github.com/jackc/pgx/v4 v4.13.0 // indirect
 	github.com/justinas/nosurf v1.1.1 // indirect
-	github.com/xhit/go-simple-mail v2.2.2+incompatible // indirect
-	github.com/xhit/go-simple-mail/v2 v2.10.0 // indirect
+	github.com/mattn/go-sqlite3 v1.14.8
+	github.com/xhit/go-simple-mail/v2 v2.10.0
 	golang.org/x/crypto v0.0.0-20210813211128-0a44fdfbc16e // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
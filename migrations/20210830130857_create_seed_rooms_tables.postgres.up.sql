@@ -1,2 +0,0 @@
-INSERT INTO public.rooms (id, room_name, created_at, updated_at) VALUES (1, 'General Quarters', '2021-08-25 19:30:02.590759', '2021-08-25 19:30:02.590759');
-INSERT INTO public.rooms (id, room_name, created_at, updated_at) VALUES (2, 'Majors Suite', '2021-08-25 21:42:38.537129', '2021-08-25 21:42:38.537129');
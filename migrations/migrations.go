@@ -0,0 +1,11 @@
+// Package migrations holds the SQL migrations of the databases the site runs on, embedded in the
+// binary so that it can migrate its own database
+package migrations
+
+import "embed"
+
+// FS holds the migrations of Postgres in postgres/ and of SQLite in sqlite/, each a pair of
+// <version>_<name>.up.sql and <version>_<name>.down.sql files
+//
+//go:embed postgres/*.sql sqlite/*.sql
+var FS embed.FS
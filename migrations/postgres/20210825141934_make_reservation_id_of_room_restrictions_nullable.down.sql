@@ -0,0 +1 @@
+-- reservation_id stays nullable, restrictions without a reservation would break NOT NULL
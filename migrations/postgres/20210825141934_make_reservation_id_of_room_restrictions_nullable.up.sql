@@ -0,0 +1,2 @@
+-- owner blocks and external calendars restrict rooms without a reservation
+ALTER TABLE room_restrictions ALTER COLUMN reservation_id DROP NOT NULL;
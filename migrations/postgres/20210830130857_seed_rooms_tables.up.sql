@@ -0,0 +1,5 @@
+INSERT INTO rooms (id, room_name, created_at, updated_at) VALUES (1, 'General Quarters', '2021-08-25 19:30:02.590759', '2021-08-25 19:30:02.590759');
+INSERT INTO rooms (id, room_name, created_at, updated_at) VALUES (2, 'Majors Suite', '2021-08-25 21:42:38.537129', '2021-08-25 21:42:38.537129');
+
+-- the ids were given, new rooms must not take them
+SELECT setval('rooms_id_seq', (SELECT MAX(id) FROM rooms));
@@ -0,0 +1,4 @@
+INSERT INTO restrictions (id, restriction_name, created_at, updated_at) VALUES (1, 'Reservation', '2020-11-08 00:00:00', '2020-11-09 00:00:00');
+INSERT INTO restrictions (id, restriction_name, created_at, updated_at) VALUES (2, 'Owner Block', '2021-08-25 21:18:22.180484', '2021-08-25 21:18:22.180484');
+
+SELECT setval('restrictions_id_seq', (SELECT MAX(id) FROM restrictions));
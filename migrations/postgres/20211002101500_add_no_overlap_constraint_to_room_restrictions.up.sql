@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS btree_gist;
+
+ALTER TABLE room_restrictions
+    ADD CONSTRAINT room_restrictions_no_overlap
+    EXCLUDE USING gist (room_id WITH =, daterange(start_date, end_date) WITH &&);
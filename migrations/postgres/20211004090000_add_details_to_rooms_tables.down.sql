@@ -0,0 +1,4 @@
+ALTER TABLE rooms DROP COLUMN base_price;
+ALTER TABLE rooms DROP COLUMN capacity;
+ALTER TABLE rooms DROP COLUMN description;
+ALTER TABLE rooms DROP COLUMN slug;
@@ -0,0 +1,4 @@
+ALTER TABLE rooms ADD COLUMN slug VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE rooms ADD COLUMN description TEXT NOT NULL DEFAULT '';
+ALTER TABLE rooms ADD COLUMN capacity INTEGER NOT NULL DEFAULT 2;
+ALTER TABLE rooms ADD COLUMN base_price INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS rooms_slug_idx;
+
+UPDATE rooms SET slug = '', description = '';
@@ -0,0 +1,5 @@
+UPDATE rooms SET slug = 'generals-quarters', description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.' WHERE id = 1;
+UPDATE rooms SET slug = 'majors-suite', description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.' WHERE id = 2;
+UPDATE rooms SET slug = 'room-' || id WHERE slug = '';
+
+CREATE UNIQUE INDEX rooms_slug_idx ON rooms (slug);
@@ -0,0 +1 @@
+DROP TABLE seasonal_rates;
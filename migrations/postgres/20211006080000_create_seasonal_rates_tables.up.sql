@@ -0,0 +1,15 @@
+CREATE TABLE seasonal_rates (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    nightly_price INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE seasonal_rates ADD CONSTRAINT seasonal_rates_rooms_id_fk
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE INDEX seasonal_rates_room_id_start_date_end_date_idx ON seasonal_rates (room_id, start_date, end_date);
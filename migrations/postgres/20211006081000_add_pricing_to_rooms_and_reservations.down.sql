@@ -0,0 +1,2 @@
+ALTER TABLE reservations DROP COLUMN total_price;
+ALTER TABLE rooms DROP COLUMN weekend_uplift;
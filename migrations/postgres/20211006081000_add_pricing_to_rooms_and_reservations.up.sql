@@ -0,0 +1,2 @@
+ALTER TABLE rooms ADD COLUMN weekend_uplift INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE reservations ADD COLUMN total_price INTEGER NOT NULL DEFAULT 0;
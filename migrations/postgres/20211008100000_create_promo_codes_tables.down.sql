@@ -0,0 +1,4 @@
+ALTER TABLE reservations DROP COLUMN discount;
+ALTER TABLE reservations DROP COLUMN promo_code_id;
+DROP TABLE promo_redemptions;
+DROP TABLE promo_codes;
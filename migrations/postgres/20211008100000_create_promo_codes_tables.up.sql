@@ -0,0 +1,39 @@
+CREATE TABLE promo_codes (
+    id SERIAL PRIMARY KEY,
+    code VARCHAR(255) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    discount_type VARCHAR(255) NOT NULL DEFAULT 'percent',
+    amount INTEGER NOT NULL,
+    valid_from DATE NOT NULL,
+    valid_until DATE NOT NULL,
+    room_id INTEGER,
+    max_redemptions INTEGER NOT NULL DEFAULT 0,
+    redemptions INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX promo_codes_code_idx ON promo_codes (code);
+
+ALTER TABLE promo_codes ADD CONSTRAINT promo_codes_rooms_id_fk
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE TABLE promo_redemptions (
+    id SERIAL PRIMARY KEY,
+    promo_code_id INTEGER NOT NULL,
+    reservation_id INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE promo_redemptions ADD CONSTRAINT promo_redemptions_promo_codes_id_fk
+    FOREIGN KEY (promo_code_id) REFERENCES promo_codes (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE promo_redemptions ADD CONSTRAINT promo_redemptions_reservations_id_fk
+    FOREIGN KEY (reservation_id) REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE reservations ADD COLUMN promo_code_id INTEGER;
+ALTER TABLE reservations ADD COLUMN discount INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE reservations ADD CONSTRAINT reservations_promo_codes_id_fk
+    FOREIGN KEY (promo_code_id) REFERENCES promo_codes (id) ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE reservations ADD COLUMN cancelled INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- every staff user could do everything before roles existed, keep it that way
+UPDATE users SET access_level = 4;
@@ -0,0 +1,16 @@
+CREATE TABLE api_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL DEFAULT '',
+    last_used_at TIMESTAMP,
+    revoked INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX api_tokens_token_hash_idx ON api_tokens (token_hash);
+
+ALTER TABLE api_tokens ADD CONSTRAINT api_tokens_users_id_fk
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE;
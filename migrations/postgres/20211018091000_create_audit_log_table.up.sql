@@ -0,0 +1,17 @@
+CREATE TABLE audit_log (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    api_token_id INTEGER,
+    action VARCHAR(255) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+
+ALTER TABLE audit_log ADD CONSTRAINT audit_log_users_id_fk
+    FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE audit_log ADD CONSTRAINT audit_log_api_tokens_id_fk
+    FOREIGN KEY (api_token_id) REFERENCES api_tokens (id) ON DELETE SET NULL ON UPDATE CASCADE;
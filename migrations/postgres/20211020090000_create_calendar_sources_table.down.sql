@@ -0,0 +1,5 @@
+DELETE FROM room_restrictions WHERE restriction_id = 3;
+DELETE FROM restrictions WHERE id = 3;
+ALTER TABLE room_restrictions DROP COLUMN external_uid;
+ALTER TABLE room_restrictions DROP COLUMN source_id;
+DROP TABLE calendar_sources;
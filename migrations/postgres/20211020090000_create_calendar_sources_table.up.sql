@@ -0,0 +1,25 @@
+CREATE TABLE calendar_sources (
+    id SERIAL PRIMARY KEY,
+    room_id INTEGER NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    url VARCHAR(255) NOT NULL DEFAULT '',
+    last_synced_at TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE calendar_sources ADD CONSTRAINT calendar_sources_rooms_id_fk
+    FOREIGN KEY (room_id) REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE room_restrictions ADD COLUMN source_id INTEGER;
+ALTER TABLE room_restrictions ADD COLUMN external_uid VARCHAR(255);
+
+ALTER TABLE room_restrictions ADD CONSTRAINT room_restrictions_calendar_sources_id_fk
+    FOREIGN KEY (source_id) REFERENCES calendar_sources (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE UNIQUE INDEX room_restrictions_source_id_external_uid_idx ON room_restrictions (source_id, external_uid);
+
+INSERT INTO restrictions (id, restriction_name, created_at, updated_at) VALUES (3, 'External Calendar', now(), now());
+
+SELECT setval('restrictions_id_seq', (SELECT MAX(id) FROM restrictions));
@@ -0,0 +1,2 @@
+ALTER TABLE room_restrictions DROP COLUMN created_by;
+ALTER TABLE room_restrictions DROP COLUMN note;
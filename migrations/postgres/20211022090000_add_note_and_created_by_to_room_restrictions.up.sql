@@ -0,0 +1,5 @@
+ALTER TABLE room_restrictions ADD COLUMN note TEXT NOT NULL DEFAULT '';
+ALTER TABLE room_restrictions ADD COLUMN created_by INTEGER;
+
+ALTER TABLE room_restrictions ADD CONSTRAINT room_restrictions_users_id_fk
+    FOREIGN KEY (created_by) REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1,17 @@
+CREATE TABLE email_outbox (
+    id SERIAL PRIMARY KEY,
+    to_address VARCHAR(255) NOT NULL,
+    from_address VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    template VARCHAR(255) NOT NULL DEFAULT '',
+    status VARCHAR(255) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX email_outbox_status_next_attempt_at_idx ON email_outbox (status, next_attempt_at);
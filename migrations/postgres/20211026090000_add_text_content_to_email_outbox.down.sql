@@ -0,0 +1 @@
+ALTER TABLE email_outbox DROP COLUMN text_content;
@@ -0,0 +1 @@
+ALTER TABLE email_outbox ADD COLUMN text_content TEXT NOT NULL DEFAULT '';
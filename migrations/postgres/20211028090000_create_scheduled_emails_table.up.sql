@@ -0,0 +1,12 @@
+CREATE TABLE scheduled_emails (
+    id SERIAL PRIMARY KEY,
+    reservation_id INTEGER NOT NULL,
+    kind VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX scheduled_emails_reservation_id_kind_idx ON scheduled_emails (reservation_id, kind);
+
+ALTER TABLE scheduled_emails ADD CONSTRAINT scheduled_emails_reservations_id_fk
+    FOREIGN KEY (reservation_id) REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE email_outbox ADD COLUMN attachments TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS scheduled_emails;
+DROP TABLE IF EXISTS email_outbox;
+DROP TABLE IF EXISTS audit_log;
+DROP TABLE IF EXISTS api_tokens;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS seasonal_rates;
+DROP TABLE IF EXISTS room_restrictions;
+DROP TABLE IF EXISTS calendar_sources;
+DROP TABLE IF EXISTS promo_redemptions;
+DROP TABLE IF EXISTS reservations;
+DROP TABLE IF EXISTS promo_codes;
+DROP TABLE IF EXISTS restrictions;
+DROP TABLE IF EXISTS rooms;
+DROP TABLE IF EXISTS users;
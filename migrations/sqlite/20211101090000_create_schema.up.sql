@@ -0,0 +1,228 @@
+-- The whole schema of the Postgres migrations, for properties running on SQLite.
+-- Dates and timestamps are stored as text in UTC, so that they compare as strings.
+
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name VARCHAR(255) NOT NULL DEFAULT '',
+    last_name VARCHAR(255) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL,
+    password VARCHAR(60) NOT NULL,
+    access_level INTEGER NOT NULL DEFAULT 1,
+    active INTEGER NOT NULL DEFAULT 1,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX users_email_idx ON users (email);
+
+CREATE TABLE rooms (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_name VARCHAR(255) NOT NULL DEFAULT '',
+    slug VARCHAR(255) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    capacity INTEGER NOT NULL DEFAULT 2,
+    base_price INTEGER NOT NULL DEFAULT 0,
+    weekend_uplift INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX rooms_slug_idx ON rooms (slug);
+
+CREATE TABLE restrictions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    restriction_name VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE promo_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code VARCHAR(255) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    discount_type VARCHAR(255) NOT NULL DEFAULT 'percent',
+    amount INTEGER NOT NULL,
+    valid_from DATE NOT NULL,
+    valid_until DATE NOT NULL,
+    room_id INTEGER REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    max_redemptions INTEGER NOT NULL DEFAULT 0,
+    redemptions INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX promo_codes_code_idx ON promo_codes (code);
+
+CREATE TABLE reservations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    first_name VARCHAR(255) NOT NULL DEFAULT '',
+    last_name VARCHAR(255) NOT NULL DEFAULT '',
+    email VARCHAR(255) NOT NULL,
+    phone VARCHAR(255) NOT NULL DEFAULT '',
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    room_id INTEGER NOT NULL REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    processed INTEGER NOT NULL DEFAULT 0,
+    total_price INTEGER NOT NULL DEFAULT 0,
+    promo_code_id INTEGER REFERENCES promo_codes (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    discount INTEGER NOT NULL DEFAULT 0,
+    cancelled INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX reservations_email_idx ON reservations (email);
+CREATE INDEX reservations_last_name_idx ON reservations (last_name);
+
+CREATE TABLE promo_redemptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    promo_code_id INTEGER NOT NULL REFERENCES promo_codes (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    reservation_id INTEGER NOT NULL REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE calendar_sources (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    url VARCHAR(255) NOT NULL DEFAULT '',
+    last_synced_at TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE room_restrictions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    room_id INTEGER NOT NULL REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    reservation_id INTEGER REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    restriction_id INTEGER NOT NULL REFERENCES restrictions (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    source_id INTEGER REFERENCES calendar_sources (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    external_uid VARCHAR(255),
+    note TEXT NOT NULL DEFAULT '',
+    created_by INTEGER REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX room_restrictions_start_date_end_date_idx ON room_restrictions (start_date, end_date);
+CREATE INDEX room_restrictions_room_id_idx ON room_restrictions (room_id);
+CREATE INDEX room_restrictions_reservation_id_idx ON room_restrictions (reservation_id);
+CREATE UNIQUE INDEX room_restrictions_source_id_external_uid_idx ON room_restrictions (source_id, external_uid);
+
+-- SQLite has no exclusion constraints: a room can't be restricted twice for the same night.
+-- Empty ranges overlap nothing, like the daterange of Postgres.
+CREATE TRIGGER room_restrictions_no_overlap_insert
+BEFORE INSERT ON room_restrictions
+WHEN NEW.start_date < NEW.end_date AND EXISTS (
+    SELECT 1 FROM room_restrictions rr
+    WHERE rr.room_id = NEW.room_id AND rr.start_date < rr.end_date
+    AND NEW.start_date < rr.end_date AND NEW.end_date > rr.start_date
+)
+BEGIN
+    SELECT RAISE(ABORT, 'room_restrictions_no_overlap');
+END;
+
+CREATE TRIGGER room_restrictions_no_overlap_update
+BEFORE UPDATE OF start_date, end_date, room_id ON room_restrictions
+WHEN NEW.start_date < NEW.end_date AND EXISTS (
+    SELECT 1 FROM room_restrictions rr
+    WHERE rr.room_id = NEW.room_id AND rr.id <> NEW.id AND rr.start_date < rr.end_date
+    AND NEW.start_date < rr.end_date AND NEW.end_date > rr.start_date
+)
+BEGIN
+    SELECT RAISE(ABORT, 'room_restrictions_no_overlap');
+END;
+
+CREATE TABLE seasonal_rates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    room_id INTEGER NOT NULL REFERENCES rooms (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    start_date DATE NOT NULL,
+    end_date DATE NOT NULL,
+    nightly_price INTEGER NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX seasonal_rates_room_id_start_date_end_date_idx ON seasonal_rates (room_id, start_date, end_date);
+
+CREATE TABLE password_resets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    token_hash VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX password_resets_token_hash_idx ON password_resets (token_hash);
+
+CREATE TABLE api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(255) NOT NULL,
+    scopes VARCHAR(255) NOT NULL DEFAULT '',
+    last_used_at TIMESTAMP,
+    revoked INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX api_tokens_token_hash_idx ON api_tokens (token_hash);
+
+CREATE TABLE audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    api_token_id INTEGER REFERENCES api_tokens (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    action VARCHAR(255) NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+
+CREATE TABLE email_outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    to_address VARCHAR(255) NOT NULL,
+    from_address VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    content TEXT NOT NULL,
+    text_content TEXT NOT NULL DEFAULT '',
+    template VARCHAR(255) NOT NULL DEFAULT '',
+    attachments TEXT NOT NULL DEFAULT '',
+    status VARCHAR(255) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL,
+    last_error TEXT NOT NULL DEFAULT '',
+    sent_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX email_outbox_status_next_attempt_at_idx ON email_outbox (status, next_attempt_at);
+
+CREATE TABLE scheduled_emails (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    reservation_id INTEGER NOT NULL REFERENCES reservations (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    kind VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX scheduled_emails_reservation_id_kind_idx ON scheduled_emails (reservation_id, kind);
+
+INSERT INTO rooms (id, room_name, slug, description, created_at, updated_at) VALUES
+    (1, 'General Quarters', 'generals-quarters', 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.', '2021-08-25 19:30:02.590759+00:00', '2021-08-25 19:30:02.590759+00:00'),
+    (2, 'Majors Suite', 'majors-suite', 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.', '2021-08-25 21:42:38.537129+00:00', '2021-08-25 21:42:38.537129+00:00');
+
+INSERT INTO restrictions (id, restriction_name, created_at, updated_at) VALUES
+    (1, 'Reservation', '2020-11-08 00:00:00+00:00', '2020-11-09 00:00:00+00:00'),
+    (2, 'Owner Block', '2021-08-25 21:18:22.180484+00:00', '2021-08-25 21:18:22.180484+00:00'),
+    (3, 'External Calendar', '2021-10-20 09:00:00+00:00', '2021-10-20 09:00:00+00:00');
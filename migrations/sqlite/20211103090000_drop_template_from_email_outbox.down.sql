@@ -0,0 +1 @@
+ALTER TABLE email_outbox ADD COLUMN template VARCHAR(255) NOT NULL DEFAULT '';